@@ -125,6 +125,14 @@ var (
 		configFileFlag,
 		utils.IstanbulRequestTimeoutFlag,
 		utils.IstanbulBlockPeriodFlag,
+		utils.IstanbulEmptyBlockPeriodFlag,
+		utils.IstanbulObserverFlag,
+		utils.IstanbulLeaseFileFlag,
+		utils.IstanbulLeaseHolderFlag,
+		utils.IstanbulWALFileFlag,
+		utils.IstanbulTransportFlag,
+		utils.IstanbulTransportPeersFlag,
+		utils.IstanbulJailWindowFlag,
 	}
 
 	rpcFlags = []cli.Flag{
@@ -231,6 +231,14 @@ var AppHelpFlagGroups = []flagGroup{
 		Flags: []cli.Flag{
 			utils.IstanbulRequestTimeoutFlag,
 			utils.IstanbulBlockPeriodFlag,
+			utils.IstanbulEmptyBlockPeriodFlag,
+			utils.IstanbulObserverFlag,
+			utils.IstanbulLeaseFileFlag,
+			utils.IstanbulLeaseHolderFlag,
+			utils.IstanbulWALFileFlag,
+			utils.IstanbulTransportFlag,
+			utils.IstanbulTransportPeersFlag,
+			utils.IstanbulJailWindowFlag,
 		},
 	},
 }
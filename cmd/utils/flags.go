@@ -548,6 +548,40 @@ var (
 		Usage: "Default minimum difference between two consecutive block's timestamps in seconds",
 		Value: eth.DefaultConfig.Istanbul.BlockPeriod,
 	}
+	IstanbulEmptyBlockPeriodFlag = cli.Uint64Flag{
+		Name:  "istanbul.emptyblockperiod",
+		Usage: "Minimum difference between the timestamps of a block and an empty block following it in seconds (0 = block period)",
+		Value: eth.DefaultConfig.Istanbul.EmptyBlockPeriod,
+	}
+	IstanbulObserverFlag = cli.BoolFlag{
+		Name:  "istanbul.observer",
+		Usage: "Verify and import Istanbul blocks without taking part in the consensus",
+	}
+	IstanbulLeaseFileFlag = cli.StringFlag{
+		Name:  "istanbul.leasefile",
+		Usage: "File on storage shared by the redundant instances of the validator holding their signing lease",
+	}
+	IstanbulLeaseHolderFlag = cli.StringFlag{
+		Name:  "istanbul.leaseholder",
+		Usage: "Name of this instance in the signing lease, unique among the redundant instances",
+	}
+	IstanbulWALFileFlag = cli.StringFlag{
+		Name:  "istanbul.walfile",
+		Usage: "File of the write-ahead log of the committed Istanbul blocks, replayed on startup",
+	}
+	IstanbulTransportFlag = cli.StringFlag{
+		Name:  "istanbul.transport",
+		Usage: "Address to listen on for the consensus messages of the validators, instead of the p2p network",
+	}
+	IstanbulTransportPeersFlag = cli.StringFlag{
+		Name:  "istanbul.transportpeers",
+		Usage: "Comma separated endpoints of the other validators on the transport (address@host:port)",
+	}
+	IstanbulJailWindowFlag = cli.Uint64Flag{
+		Name:  "istanbul.jailwindow",
+		Usage: "Number of blocks a validator jailed through the API is skipped as proposer (0 = no jail)",
+		Value: eth.DefaultConfig.Istanbul.JailWindow,
+	}
 )
 
 // MakeDataDir retrieves the currently requested data directory, terminating
@@ -991,6 +1025,30 @@ func setIstanbul(ctx *cli.Context, cfg *eth.Config) {
 	if ctx.GlobalIsSet(IstanbulBlockPeriodFlag.Name) {
 		cfg.Istanbul.BlockPeriod = ctx.GlobalUint64(IstanbulBlockPeriodFlag.Name)
 	}
+	if ctx.GlobalIsSet(IstanbulEmptyBlockPeriodFlag.Name) {
+		cfg.Istanbul.EmptyBlockPeriod = ctx.GlobalUint64(IstanbulEmptyBlockPeriodFlag.Name)
+	}
+	if ctx.GlobalIsSet(IstanbulObserverFlag.Name) {
+		cfg.Istanbul.Observer = ctx.GlobalBool(IstanbulObserverFlag.Name)
+	}
+	if ctx.GlobalIsSet(IstanbulLeaseFileFlag.Name) {
+		cfg.Istanbul.LeaseFile = ctx.GlobalString(IstanbulLeaseFileFlag.Name)
+	}
+	if ctx.GlobalIsSet(IstanbulLeaseHolderFlag.Name) {
+		cfg.Istanbul.LeaseHolder = ctx.GlobalString(IstanbulLeaseHolderFlag.Name)
+	}
+	if ctx.GlobalIsSet(IstanbulWALFileFlag.Name) {
+		cfg.Istanbul.WALFile = ctx.GlobalString(IstanbulWALFileFlag.Name)
+	}
+	if ctx.GlobalIsSet(IstanbulTransportFlag.Name) {
+		cfg.Istanbul.TransportAddr = ctx.GlobalString(IstanbulTransportFlag.Name)
+	}
+	if ctx.GlobalIsSet(IstanbulTransportPeersFlag.Name) {
+		cfg.Istanbul.TransportPeers = strings.Split(ctx.GlobalString(IstanbulTransportPeersFlag.Name), ",")
+	}
+	if ctx.GlobalIsSet(IstanbulJailWindowFlag.Name) {
+		cfg.Istanbul.JailWindow = ctx.GlobalUint64(IstanbulJailWindowFlag.Name)
+	}
 }
 
 // checkExclusive verifies that only a single isntance of the provided flags was
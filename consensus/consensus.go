@@ -111,6 +111,9 @@ type Handler interface {
 
 	// SetBroadcaster sets the broadcaster to send message to peers
 	SetBroadcaster(Broadcaster)
+
+	// PeerConnected handles a peer connection
+	PeerConnected(address common.Address)
 }
 
 // PoW is a consensus engine based on proof-of-work.
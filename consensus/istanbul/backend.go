@@ -41,6 +41,9 @@ type Backend interface {
 	// Gossip sends a message to all validators (exclude self)
 	Gossip(valSet ValidatorSet, payload []byte) error
 
+	// Unicast sends a message to the given validator only
+	Unicast(addr common.Address, payload []byte) error
+
 	// Commit delivers an approved proposal to backend.
 	// The delivered proposal will be put into blockchain.
 	Commit(proposal Proposal, seals [][]byte) error
@@ -52,10 +55,17 @@ type Backend interface {
 	// Sign signs input data with the backend's private key
 	Sign([]byte) ([]byte, error)
 
+	// SignAt signs input data of a message of the given view like Sign,
+	// refusing a view the signing lease forbids, if the backend has one
+	SignAt(view *View, data []byte) ([]byte, error)
+
 	// CheckSignature verifies the signature by checking if it's signed by
 	// the given validator
 	CheckSignature(data []byte, addr common.Address, sig []byte) error
 
+	// RecoverSigner returns the address which signed the data
+	RecoverSigner(data []byte, sig []byte) (common.Address, error)
+
 	// LastProposal retrieves latest committed proposal and the address of proposer
 	LastProposal() (Proposal, common.Address)
 
@@ -70,4 +80,12 @@ type Backend interface {
 
 	// HasBadBlock returns whether the block with the hash is a bad block
 	HasBadProposal(hash common.Hash) bool
+
+	// GetProposal retrieves the committed proposal of the given height and its
+	// committed seals. It returns nil if the proposal is unknown.
+	GetProposal(number uint64) (Proposal, [][]byte)
+
+	// Jailed returns whether the validator is jailed at the given height, so
+	// it's skipped when selecting the proposer
+	Jailed(addr common.Address, number uint64) bool
 }
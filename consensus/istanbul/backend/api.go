@@ -17,12 +17,20 @@
 package backend
 
 import (
+	"context"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	istanbulCore "github.com/ethereum/go-ethereum/consensus/istanbul/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/rpc"
 )
 
+// consensusEventsBuffer is the number of consensus events kept for a
+// subscriber before they're dropped
+const consensusEventsBuffer = 256
+
 // API is a user facing RPC API to dump Istanbul state
 type API struct {
 	chain    consensus.ChainReader
@@ -87,6 +95,148 @@ func (api *API) GetValidatorsAtHash(hash common.Hash) ([]common.Address, error)
 	return snap.validators(), nil
 }
 
+// GetFinalityProof retrieves the addresses and committed seals of the validators
+// that committed the specified block.
+func (api *API) GetFinalityProof(number *rpc.BlockNumber) (*istanbulCore.FinalityProof, error) {
+	// Retrieve the requested block number (or current if none requested)
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	// Ensure we have an actually valid block and build the proof from its seals
+	if header == nil || header.Number.Sign() == 0 {
+		return nil, errUnknownBlock
+	}
+	return istanbulCore.NewFinalityProof(header, api.istanbul.config.SigSchemeAt(header.Number), api.istanbul.config.DigestAt(header.Number))
+}
+
+// GetProposerAt retrieves the address of the proposer that sealed the specified
+// block.
+func (api *API) GetProposerAt(number *rpc.BlockNumber) (common.Address, error) {
+	// Retrieve the requested block number (or current if none requested)
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	// Ensure we have an actually valid block and recover the signer of its seal
+	if header == nil {
+		return common.Address{}, errUnknownBlock
+	}
+	return api.istanbul.Author(header)
+}
+
+// GetMembershipProof retrieves the proof that the validator belongs to the set
+// committed to by the validators root of the last checkpoint block at or before
+// the specified block.
+func (api *API) GetMembershipProof(address common.Address, number *rpc.BlockNumber) (*istanbul.MembershipProof, error) {
+	// Retrieve the requested block number (or current if none requested)
+	var header *types.Header
+	if number == nil || *number == rpc.LatestBlockNumber {
+		header = api.chain.CurrentHeader()
+	} else {
+		header = api.chain.GetHeaderByNumber(uint64(number.Int64()))
+	}
+	if header == nil {
+		return nil, errUnknownBlock
+	}
+	// Ensure the checkpoint carries a root and prove against its validators
+	checkpoint := header.Number.Uint64()
+	checkpoint -= checkpoint % api.istanbul.config.Epoch
+	if header = api.chain.GetHeaderByNumber(checkpoint); header == nil {
+		return nil, errUnknownBlock
+	}
+	extra, err := types.ExtractIstanbulExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	if extra.ValidatorsRoot == (common.Hash{}) {
+		return nil, errInvalidValidatorsRoot
+	}
+	return istanbul.NewMembershipProof(extra.Validators, address)
+}
+
+// GetParticipation retrieves the PREPARE and COMMIT participation of the
+// validators in the recent sequences.
+func (api *API) GetParticipation() map[common.Address]*istanbulCore.Participation {
+	api.istanbul.coreMu.RLock()
+	defer api.istanbul.coreMu.RUnlock()
+
+	return api.istanbul.core.Participation()
+}
+
+// GetMisbehaviorEvidence retrieves the recent evidences of validators signing
+// conflicting PREPARE or COMMIT messages.
+func (api *API) GetMisbehaviorEvidence() []*istanbulCore.Evidence {
+	api.istanbul.coreMu.RLock()
+	defer api.istanbul.coreMu.RUnlock()
+
+	return api.istanbul.core.MisbehaviorEvidence()
+}
+
+// DumpRound retrieves the PREPARE and COMMIT messages of the current round of
+// the consensus, and the validators they're missing from.
+func (api *API) DumpRound() (*istanbulCore.RoundDump, error) {
+	api.istanbul.coreMu.RLock()
+	defer api.istanbul.coreMu.RUnlock()
+
+	return api.istanbul.core.DumpRound()
+}
+
+// Health retrieves whether the consensus committed a block within the health
+// window and isn't stuck changing rounds, for the liveness and readiness probes
+// of an orchestrator.
+func (api *API) Health() (*istanbulCore.Health, error) {
+	api.istanbul.coreMu.RLock()
+	defer api.istanbul.coreMu.RUnlock()
+
+	return api.istanbul.core.Health()
+}
+
+// SetTraceLevel writes the consensus logs up to the given level (1 for error
+// to 5 for trace) to the standard error regardless of the node verbosity, and
+// records the processed consensus messages. 0 stops the tracing.
+func (api *API) SetTraceLevel(level int) error {
+	api.istanbul.coreMu.RLock()
+	defer api.istanbul.coreMu.RUnlock()
+
+	return api.istanbul.core.SetTraceLevel(level)
+}
+
+// TracedMessages retrieves up to the n last consensus messages processed while
+// tracing, oldest first.
+func (api *API) TracedMessages(n int) []*istanbulCore.TracedMessage {
+	api.istanbul.coreMu.RLock()
+	defer api.istanbul.coreMu.RUnlock()
+
+	return api.istanbul.core.TracedMessages(n)
+}
+
+// IsProposer returns whether the local node is the proposer of the current
+// round of the consensus.
+func (api *API) IsProposer() bool {
+	return api.istanbul.IsProposer()
+}
+
+// Pause stops the node from proposing and voting, while it keeps following
+// the chain.
+func (api *API) Pause() {
+	api.istanbul.Pause()
+}
+
+// Resume resumes proposing and voting after Pause.
+func (api *API) Resume() {
+	api.istanbul.Resume()
+}
+
+// Paused returns whether proposing and voting is paused.
+func (api *API) Paused() bool {
+	return api.istanbul.Paused()
+}
+
 // Candidates returns the current candidates the node tries to uphold and vote on.
 func (api *API) Candidates() map[common.Address]bool {
 	api.istanbul.candidatesLock.RLock()
@@ -116,3 +266,52 @@ func (api *API) Discard(address common.Address) {
 
 	delete(api.istanbul.candidates, address)
 }
+
+// Jail makes the node skip the validator when selecting the proposers of the
+// next JailWindow blocks. The validators should all jail it to keep agreeing on
+// the proposers.
+func (api *API) Jail(address common.Address) error {
+	return api.istanbul.jailValidator(address)
+}
+
+// ConsensusEvents streams the steps of the consensus as they happen: the
+// proposals accepted, prepared and committed, and the view changes. The events
+// are dropped for a subscriber that can't keep up, instead of stalling the
+// consensus.
+func (api *API) ConsensusEvents(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	sub := api.istanbul.EventMux().Subscribe(istanbul.ConsensusEvent{})
+	events := make(chan istanbul.ConsensusEvent, consensusEventsBuffer)
+	go func() {
+		defer close(events)
+		for obj := range sub.Chan() {
+			select {
+			case events <- obj.Data.(istanbul.ConsensusEvent):
+			default:
+				api.istanbul.logger.Warn("Drop consensus event for slow subscriber", "id", rpcSub.ID)
+			}
+		}
+	}()
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+				notifier.Notify(rpcSub.ID, ev)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
@@ -28,11 +28,14 @@ import (
 	istanbulCore "github.com/ethereum/go-ethereum/consensus/istanbul/core"
 	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
 	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
 	lru "github.com/hashicorp/golang-lru"
 )
 
@@ -41,17 +44,31 @@ const (
 	fetcherID = "istanbul"
 )
 
-// New creates an Ethereum backend for Istanbul core engine.
+// SignerFn is a signer callback function to request a hash to be signed by a
+// backing account.
+type SignerFn func(common.Address, []byte) ([]byte, error)
+
+// New creates an Ethereum backend for Istanbul core engine. The private key may
+// be nil if the backend is authorized with a signer function before starting.
 func New(config *istanbul.Config, privateKey *ecdsa.PrivateKey, db ethdb.Database) consensus.Istanbul {
 	// Allocate the snapshot caches and create the engine
 	recents, _ := lru.NewARC(inmemorySnapshots)
 	recentMessages, _ := lru.NewARC(inmemoryPeers)
 	knownMessages, _ := lru.NewARC(inmemoryMessages)
+	recentSigners, _ := lru.NewARC(inmemorySigners)
+	verifiedHeaders, _ := lru.NewARC(inmemoryHeaders)
+	peerBuckets, _ := lru.NewARC(inmemoryPeers)
+	var address common.Address
+	if privateKey != nil {
+		address = crypto.PubkeyToAddress(privateKey.PublicKey)
+	}
 	backend := &backend{
 		config:           config,
+		clock:            istanbul.SystemClock,
+		sealRounds:       defaultSealRounds,
 		istanbulEventMux: new(event.TypeMux),
 		privateKey:       privateKey,
-		address:          crypto.PubkeyToAddress(privateKey.PublicKey),
+		address:          address,
 		logger:           log.New(),
 		db:               db,
 		commitCh:         make(chan *types.Block, 1),
@@ -60,6 +77,17 @@ func New(config *istanbul.Config, privateKey *ecdsa.PrivateKey, db ethdb.Databas
 		coreStarted:      false,
 		recentMessages:   recentMessages,
 		knownMessages:    knownMessages,
+		recentSigners:    recentSigners,
+		verifiedHeaders:  verifiedHeaders,
+		peerBuckets:      peerBuckets,
+		commitSubs:       make(map[chan<- *types.Block]*commitSub),
+		rateLimitedMeter: metrics.NewRegisteredMeter("consensus/istanbul/backend/ratelimited", nil),
+	}
+	if config.JailWindow > 0 {
+		backend.jail = NewMemoryJail(config.JailWindow)
+	}
+	if config.LeaseFile != "" {
+		backend.lease, backend.leaseHolder = NewFileLease(config.LeaseFile), config.LeaseHolder
 	}
 	backend.core = istanbulCore.New(backend, backend.config)
 	return backend
@@ -69,9 +97,26 @@ func New(config *istanbul.Config, privateKey *ecdsa.PrivateKey, db ethdb.Databas
 
 type backend struct {
 	config           *istanbul.Config
+	clock            istanbul.Clock // the source of time of the seal timers
+	sealRounds       int            // Number of rounds after which Seal gives up the block
 	istanbulEventMux *event.TypeMux
 	privateKey       *ecdsa.PrivateKey
 	address          common.Address
+	signFn           SignerFn          // Signer function to authorize hashes with
+	rotatedKey       *ecdsa.PrivateKey // Key to switch to once the round in flight is over
+	lease            Lease             // Lease to hold for signing, if the validator runs redundant instances
+	leaseHolder      string            // Name of this instance in the lease
+	leaseActive      bool              // Whether the engine runs and keeps the lease
+	leaseTimer       istanbul.Timer    // Timer of the next lease renewal
+	leaseExpiry      time.Time         // Time the lease is held until, as of its last renewal
+	leaseView        *istanbul.View    // Last view recorded in the lease since it's held
+	signMu           sync.RWMutex      // Protects the signer fields
+	jail             Jail              // Jail of the misbehaving validators, if any
+	jailMu           sync.RWMutex      // Protects the jail
+	wal              WAL               // Write-ahead log of the committed blocks, if any
+	walMu            sync.RWMutex      // Protects the WAL
+	sendQuit         chan struct{}     // Closed when the engine stops, ending the retries of the sends
+	sendMu           sync.Mutex        // Protects sendQuit
 	core             istanbulCore.Engine
 	logger           log.Logger
 	db               ethdb.Database
@@ -83,6 +128,7 @@ type backend struct {
 	commitCh          chan *types.Block
 	proposedBlockHash common.Hash
 	sealMu            sync.Mutex
+	sealPosted        chan struct{} // Closed once the events of the last sealed block are posted
 	coreStarted       bool
 	coreMu            sync.RWMutex
 
@@ -95,16 +141,130 @@ type backend struct {
 
 	// event subscription for ChainHeadEvent event
 	broadcaster consensus.Broadcaster
+	// the dedicated channel to the validators, if any
+	transport Transport
 
 	recentMessages *lru.ARCCache // the cache of peer's messages
 	knownMessages  *lru.ARCCache // the cache of self messages
+	recentSigners  *lru.ARCCache // the cache of recovered message signers
+
+	verifiedHeaders *lru.ARCCache // the cache of header verification results, nil if disabled
+
+	peerBuckets *lru.ARCCache // the rate limits of the peer's messages
+	// the meter to record the messages dropped because their peer exceeds its rate
+	rateLimitedMeter metrics.Meter
+
+	// the subscribers of committed blocks
+	commitSubs   map[chan<- *types.Block]*commitSub
+	commitSubsMu sync.Mutex
 }
 
 // Address implements istanbul.Backend.Address
 func (sb *backend) Address() common.Address {
+	sb.signMu.RLock()
+	defer sb.signMu.RUnlock()
+
 	return sb.address
 }
 
+// Authorize injects a signer function to sign hashes with the given address
+// instead of the private key. The core keeps the address it's created with,
+// so it's recreated here unless the engine is already running.
+func (sb *backend) Authorize(address common.Address, signFn SignerFn) {
+	sb.coreMu.Lock()
+	defer sb.coreMu.Unlock()
+
+	sb.signMu.Lock()
+	sb.address = address
+	sb.signFn = signFn
+	sb.signMu.Unlock()
+
+	if !sb.coreStarted {
+		sb.core = istanbulCore.New(sb, sb.config)
+	}
+}
+
+// RotateKey replaces the validator key with the given one, whose address must
+// be a validator of the next block or a candidate we vote for. While the engine
+// is running, the round in flight is finished with the current key, and the new
+// one is used from the next block on.
+func (sb *backend) RotateKey(key *ecdsa.PrivateKey) error {
+	sb.coreMu.RLock()
+	defer sb.coreMu.RUnlock()
+
+	if sb.currentBlock == nil {
+		return istanbul.ErrStoppedEngine
+	}
+	address := crypto.PubkeyToAddress(key.PublicKey)
+	sb.candidatesLock.RLock()
+	candidate := sb.candidates[address]
+	sb.candidatesLock.RUnlock()
+	if _, v := sb.Validators(sb.currentBlock()).GetByAddress(address); v == nil && !candidate {
+		return errUnauthorized
+	}
+
+	sb.signMu.Lock()
+	defer sb.signMu.Unlock()
+
+	if sb.coreStarted {
+		sb.rotatedKey = key
+		return nil
+	}
+	sb.privateKey, sb.address, sb.signFn = key, address, nil
+	sb.rotatedKey = nil
+	return nil
+}
+
+// switchRotatedKey switches to the rotated key, if any, once the round in flight
+// is over.
+func (sb *backend) switchRotatedKey() {
+	sb.signMu.Lock()
+	defer sb.signMu.Unlock()
+
+	if key := sb.rotatedKey; key != nil {
+		sb.privateKey, sb.address, sb.signFn = key, crypto.PubkeyToAddress(key.PublicKey), nil
+		sb.rotatedKey = nil
+		sb.logger.Info("Switched to the rotated validator key", "address", sb.address)
+	}
+}
+
+// IsProposer returns whether the local node is the proposer of the current
+// round, false if the engine isn't running.
+func (sb *backend) IsProposer() bool {
+	sb.coreMu.RLock()
+	defer sb.coreMu.RUnlock()
+
+	if !sb.coreStarted {
+		return false
+	}
+	return sb.core.IsProposer()
+}
+
+// Pause stops proposing and voting, e.g. for a maintenance, while the blocks
+// committed by the other validators are still verified and relayed.
+func (sb *backend) Pause() {
+	sb.coreMu.RLock()
+	defer sb.coreMu.RUnlock()
+
+	sb.core.SetPaused(true)
+}
+
+// Resume resumes proposing and voting after Pause.
+func (sb *backend) Resume() {
+	sb.coreMu.RLock()
+	defer sb.coreMu.RUnlock()
+
+	sb.core.SetPaused(false)
+}
+
+// Paused returns whether proposing and voting is paused.
+func (sb *backend) Paused() bool {
+	sb.coreMu.RLock()
+	defer sb.coreMu.RUnlock()
+
+	return sb.core.Paused()
+}
+
 // Validators implements istanbul.Backend.Validators
 func (sb *backend) Validators(proposal istanbul.Proposal) istanbul.ValidatorSet {
 	return sb.getValidators(proposal.Number().Uint64(), proposal.Hash())
@@ -134,30 +294,60 @@ func (sb *backend) Gossip(valSet istanbul.ValidatorSet, payload []byte) error {
 		}
 	}
 
-	if sb.broadcaster != nil && len(targets) > 0 {
+	if len(targets) == 0 {
+		return nil
+	}
+	if sb.transport != nil {
+		for addr := range targets {
+			if sb.markPeerMessage(addr, hash) {
+				// This validator had this event, skip it
+				continue
+			}
+			if err := sb.sendTransport(addr, payload); err != nil {
+				sb.logger.Trace("Failed to send message to validator", "addr", addr, "err", err)
+			}
+		}
+		return nil
+	}
+	if sb.broadcaster != nil {
 		ps := sb.broadcaster.FindPeers(targets)
 		for addr, p := range ps {
-			ms, ok := sb.recentMessages.Get(addr)
-			var m *lru.ARCCache
-			if ok {
-				m, _ = ms.(*lru.ARCCache)
-				if _, k := m.Get(hash); k {
-					// This peer had this event, skip it
-					continue
-				}
-			} else {
-				m, _ = lru.NewARC(inmemoryMessages)
+			if sb.markPeerMessage(addr, hash) {
+				// This peer had this event, skip it
+				continue
 			}
-
-			m.Add(hash, true)
-			sb.recentMessages.Add(addr, m)
-
 			go p.Send(istanbulMsg, payload)
 		}
 	}
 	return nil
 }
 
+// Unicast implements istanbul.Backend.Unicast
+func (sb *backend) Unicast(addr common.Address, payload []byte) error {
+	if addr == sb.Address() {
+		go sb.istanbulEventMux.Post(istanbul.MessageEvent{
+			Payload: payload,
+		})
+		return nil
+	}
+
+	if sb.transport != nil {
+		sb.knownMessages.Add(istanbul.RLPHash(payload), true)
+		return sb.sendTransport(addr, payload)
+	}
+	if sb.broadcaster == nil {
+		return errUnknownPeer
+	}
+	p, ok := sb.broadcaster.FindPeers(map[common.Address]bool{addr: true})[addr]
+	if !ok {
+		return errUnknownPeer
+	}
+	sb.knownMessages.Add(istanbul.RLPHash(payload), true)
+
+	go p.Send(istanbulMsg, payload)
+	return nil
+}
+
 // Commit implements istanbul.Backend.Commit
 func (sb *backend) Commit(proposal istanbul.Proposal, seals [][]byte) error {
 	// Check if the proposal is a valid block
@@ -177,7 +367,14 @@ func (sb *backend) Commit(proposal istanbul.Proposal, seals [][]byte) error {
 	// update block's header
 	block = block.WithSeal(h)
 
+	// Log the block before handing it over, a crash before its insertion must
+	// not lose it
+	if err := sb.writeWAL(block); err != nil {
+		sb.logger.Error("Failed to write the committed block to the WAL", "number", block.NumberU64(), "err", err)
+		return err
+	}
 	sb.logger.Info("Committed", "address", sb.Address(), "hash", proposal.Hash(), "number", proposal.Number().Uint64())
+	sb.notifyCommit(block)
 	// - if the proposed and committed blocks are the same, send the proposed hash
 	//   to commit channel, which is being watched inside the engine.Seal() function.
 	// - otherwise, we try to insert the block.
@@ -196,6 +393,50 @@ func (sb *backend) Commit(proposal istanbul.Proposal, seals [][]byte) error {
 	return nil
 }
 
+// commitSub is a subscription to the committed blocks
+type commitSub struct {
+	confirmations uint64         // Number of blocks committed on top of a block before it's delivered
+	pending       []*types.Block // Committed blocks waiting for their confirmations
+}
+
+// SubscribeCommit subscribes to the blocks committed by the consensus, each
+// delivered once the given number of blocks are committed on top of it. The
+// delivery never blocks, a block is dropped for the subscriber whose channel
+// is full.
+func (sb *backend) SubscribeCommit(ch chan<- *types.Block, confirmations uint64) event.Subscription {
+	sb.commitSubsMu.Lock()
+	sb.commitSubs[ch] = &commitSub{confirmations: confirmations}
+	sb.commitSubsMu.Unlock()
+
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		<-quit
+		sb.commitSubsMu.Lock()
+		delete(sb.commitSubs, ch)
+		sb.commitSubsMu.Unlock()
+		return nil
+	})
+}
+
+// notifyCommit delivers the committed blocks with enough confirmations to the
+// subscribers
+func (sb *backend) notifyCommit(block *types.Block) {
+	sb.commitSubsMu.Lock()
+	defer sb.commitSubsMu.Unlock()
+
+	for ch, sub := range sb.commitSubs {
+		sub.pending = append(sub.pending, block)
+		for len(sub.pending) > 0 && sub.pending[0].NumberU64()+sub.confirmations <= block.NumberU64() {
+			confirmed := sub.pending[0]
+			sub.pending = sub.pending[1:]
+			select {
+			case ch <- confirmed:
+			default:
+				sb.logger.Warn("Drop committed block for slow subscriber", "number", confirmed.Number(), "hash", confirmed.Hash())
+			}
+		}
+	}
+}
+
 // EventMux implements istanbul.Backend.EventMux
 func (sb *backend) EventMux() *event.TypeMux {
 	return sb.istanbulEventMux
@@ -216,6 +457,12 @@ func (sb *backend) Verify(proposal istanbul.Proposal) (time.Duration, error) {
 		return 0, core.ErrBlacklistedHash
 	}
 
+	// check the proposal extends our chain head, a proposal built on a stale
+	// or forked parent can't be committed by us
+	if sb.currentBlock != nil && block.ParentHash() != sb.currentBlock().Hash() {
+		return 0, errInconsistentParent
+	}
+
 	// check block body
 	txnHash := types.DeriveSha(block.Transactions())
 	uncleHash := types.CalcUncleHash(block.Uncles())
@@ -225,27 +472,164 @@ func (sb *backend) Verify(proposal istanbul.Proposal) (time.Duration, error) {
 	if uncleHash != nilUncleHash {
 		return 0, errInvalidUncleHash
 	}
+	if err := sb.verifyTxOrdering(block); err != nil {
+		return 0, err
+	}
 
 	// verify the header of proposed block
 	err := sb.VerifyHeader(sb.chain, block.Header(), false)
 	// ignore errEmptyCommittedSeals error because we don't have the committed seals yet
 	if err == nil || err == errEmptyCommittedSeals {
-		return 0, nil
+		// Unlike imported blocks, a proposal must not be ahead of the local clock
+		// at all, otherwise the block period could be bypassed.
+		if block.Header().Time.Cmp(big.NewInt(sb.clock.Now().Unix())) > 0 {
+			return time.Unix(block.Header().Time.Int64(), 0).Sub(sb.clock.Now()), consensus.ErrFutureBlock
+		}
+		if err := sb.verifyRegistryValidators(block); err != nil {
+			return 0, err
+		}
+		return 0, sb.verifyState(block)
 	} else if err == consensus.ErrFutureBlock {
-		return time.Unix(block.Header().Time.Int64(), 0).Sub(now()), consensus.ErrFutureBlock
+		return time.Unix(block.Header().Time.Int64(), 0).Sub(sb.clock.Now()), consensus.ErrFutureBlock
 	}
 	return 0, err
 }
 
-// Sign implements istanbul.Backend.Sign
+// blockProcessor is implemented by the chains able to execute blocks
+type blockProcessor interface {
+	StateAt(root common.Hash) (*state.StateDB, error)
+	Processor() core.Processor
+	Validator() core.Validator
+}
+
+// verifyState executes the proposal on top of its parent if the chain is able
+// to, so that a proposal with invalid transactions or state is rejected before
+// we vote for it, instead of failing once committed.
+func (sb *backend) verifyState(block *types.Block) error {
+	bc, ok := sb.chain.(blockProcessor)
+	if !ok {
+		return nil
+	}
+	parent := sb.chain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	statedb, err := bc.StateAt(parent.Root())
+	if err != nil {
+		return err
+	}
+	receipts, _, usedGas, err := bc.Processor().Process(block, statedb, vm.Config{})
+	if err != nil {
+		return err
+	}
+	return bc.Validator().ValidateState(block, parent, statedb, receipts, usedGas)
+}
+
+// verifyTxOrdering checks that the transactions of the proposal are ordered by
+// the configured rule. With PriceNonceTxOrdering, the transactions of each
+// sender must be in nonce order, and each transaction must be priced at least
+// as high as the next transaction of every other sender already included. The
+// miner skips the stale transactions of a sender, so the first transaction of
+// a sender may be priced higher than the ones before it.
+func (sb *backend) verifyTxOrdering(block *types.Block) error {
+	if sb.config.TxOrdering != istanbul.PriceNonceTxOrdering {
+		return nil
+	}
+	signer := types.MakeSigner(sb.chain.Config(), block.Number())
+
+	// Split the transactions by sender
+	txs := block.Transactions()
+	senders := make([]common.Address, len(txs))
+	pending := make(map[common.Address]types.Transactions)
+	for i, tx := range txs {
+		from, err := types.Sender(signer, tx)
+		if err != nil {
+			return err
+		}
+		if queue := pending[from]; len(queue) > 0 && queue[len(queue)-1].Nonce() >= tx.Nonce() {
+			return errInvalidTxOrdering
+		}
+		senders[i] = from
+		pending[from] = append(pending[from], tx)
+	}
+	// Replay them against the next transaction of each sender
+	included := make(map[common.Address]bool)
+	for i, tx := range txs {
+		for from, queue := range pending {
+			if from != senders[i] && included[from] && queue[0].GasPrice().Cmp(tx.GasPrice()) > 0 {
+				return errInvalidTxOrdering
+			}
+		}
+		included[senders[i]] = true
+		if queue := pending[senders[i]][1:]; len(queue) > 0 {
+			pending[senders[i]] = queue
+		} else {
+			delete(pending, senders[i])
+		}
+	}
+	return nil
+}
+
+// Sign implements istanbul.Backend.Sign, hashing the data with the digest
+// algorithm of the sequence the core works on.
 func (sb *backend) Sign(data []byte) ([]byte, error) {
-	hashData := crypto.Keccak256(data)
-	return crypto.Sign(hashData, sb.privateKey)
+	return sb.sign(data, sb.config.DigestAt(sb.nextNumber()), nil)
+}
+
+// SignAt implements istanbul.Backend.SignAt, like Sign but recording the view
+// in the signing lease, if any.
+func (sb *backend) SignAt(view *istanbul.View, data []byte) ([]byte, error) {
+	return sb.sign(data, sb.config.DigestAt(sb.nextNumber()), view)
+}
+
+// sign signs the data hashed with the given digest algorithm, as a message of
+// the view if any.
+func (sb *backend) sign(data []byte, digest istanbul.DigestAlgorithm, view *istanbul.View) ([]byte, error) {
+	// The key may be rotated meanwhile, so the signer is taken at once
+	sb.signMu.Lock()
+	address, signFn, key := sb.address, sb.signFn, sb.privateKey
+	held := sb.holdsLease(view)
+	sb.signMu.Unlock()
+	if !held {
+		return nil, errLeaseHeld
+	}
+
+	hashData := digest.Sum(data)
+	if signFn != nil {
+		return signFn(address, hashData)
+	}
+	if key == nil {
+		return nil, errMissingSigner
+	}
+	return crypto.Sign(hashData, key)
+}
+
+// signerKey identifies a signature over some data in the signer cache
+type signerKey struct {
+	hash common.Hash
+	sig  string
+}
+
+// RecoverSigner implements istanbul.Backend.RecoverSigner, like Sign with the
+// digest algorithm of the sequence the core works on. The signers are cached,
+// so that the messages relayed by several peers are only recovered once.
+func (sb *backend) RecoverSigner(data []byte, sig []byte) (common.Address, error) {
+	key := signerKey{common.BytesToHash(sb.config.DigestAt(sb.nextNumber()).Sum(data)), string(sig)}
+	if addr, ok := sb.recentSigners.Get(key); ok {
+		return addr.(common.Address), nil
+	}
+	pubkey, err := crypto.SigToPub(key.hash.Bytes(), sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	addr := crypto.PubkeyToAddress(*pubkey)
+	sb.recentSigners.Add(key, addr)
+	return addr, nil
 }
 
 // CheckSignature implements istanbul.Backend.CheckSignature
 func (sb *backend) CheckSignature(data []byte, address common.Address, sig []byte) error {
-	signer, err := istanbul.GetSignatureAddress(data, sig)
+	signer, err := sb.RecoverSigner(data, sig)
 	if err != nil {
 		log.Error("Failed to get signer address", "err", err)
 		return err
@@ -287,11 +671,21 @@ func (sb *backend) getValidators(number uint64, hash common.Hash) istanbul.Valid
 	return snap.ValSet
 }
 
+// nextNumber returns the number of the block after the chain head, the
+// sequence the core works on.
+func (sb *backend) nextNumber() *big.Int {
+	if sb.currentBlock == nil {
+		return common.Big1
+	}
+	return new(big.Int).Add(sb.currentBlock().Number(), common.Big1)
+}
+
 func (sb *backend) LastProposal() (istanbul.Proposal, common.Address) {
 	block := sb.currentBlock()
 
+	// The blocks before the activation have no Istanbul proposer
 	var proposer common.Address
-	if block.Number().Cmp(common.Big0) > 0 {
+	if sb.config.Activated(block.Number().Uint64()) {
 		var err error
 		proposer, err = sb.Author(block.Header())
 		if err != nil {
@@ -304,6 +698,24 @@ func (sb *backend) LastProposal() (istanbul.Proposal, common.Address) {
 	return block, proposer
 }
 
+// GetProposal implements istanbul.Backend.GetProposal
+func (sb *backend) GetProposal(number uint64) (istanbul.Proposal, [][]byte) {
+	header := sb.chain.GetHeaderByNumber(number)
+	if header == nil {
+		return nil, nil
+	}
+	block := sb.chain.GetBlock(header.Hash(), number)
+	if block == nil {
+		return nil, nil
+	}
+	extra, err := types.ExtractIstanbulExtra(header)
+	if err != nil {
+		sb.logger.Error("Failed to extract committed seals", "number", number, "err", err)
+		return nil, nil
+	}
+	return block, extra.CommittedSeal
+}
+
 func (sb *backend) HasBadProposal(hash common.Hash) bool {
 	if sb.hasBadBlock == nil {
 		return false
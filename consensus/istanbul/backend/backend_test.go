@@ -19,8 +19,9 @@ package backend
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"fmt"
+	"math/big"
 	"sort"
-	"strings"
 	"testing"
 	"time"
 
@@ -111,6 +112,46 @@ func TestCheckValidatorSignature(t *testing.T) {
 	}
 }
 
+func TestRecoverSigner(t *testing.T) {
+	b := newBackend()
+	key, _ := generatePrivateKey()
+	data := []byte("Here is a string....")
+	sig, _ := crypto.Sign(crypto.Keccak256(data), key)
+
+	// A cache hit returns the same address as a cold recovery
+	want, err := istanbul.GetSignatureAddress(data, sig)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	for i := 0; i < 2; i++ {
+		addr, err := b.RecoverSigner(data, sig)
+		if err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+		if addr != want {
+			t.Errorf("address mismatch: have %v, want %v", addr.Hex(), want.Hex())
+		}
+	}
+	if b.recentSigners.Len() != 1 {
+		t.Errorf("the number of cached signers mismatch: have %v, want 1", b.recentSigners.Len())
+	}
+
+	// The same signature over other data is not a hit
+	other := []byte("Here is another string....")
+	want, _ = istanbul.GetSignatureAddress(other, sig)
+	if addr, _ := b.RecoverSigner(other, sig); addr != want {
+		t.Errorf("address mismatch: have %v, want %v", addr.Hex(), want.Hex())
+	}
+
+	// Failed recoveries are not cached
+	if _, err := b.RecoverSigner(data, []byte{0x01}); err == nil {
+		t.Errorf("error mismatch: have nil, want an error")
+	}
+	if b.recentSigners.Len() != 2 {
+		t.Errorf("the number of cached signers mismatch: have %v, want 2", b.recentSigners.Len())
+	}
+}
+
 func TestCommit(t *testing.T) {
 	backend := newBackend()
 
@@ -173,6 +214,68 @@ func TestCommit(t *testing.T) {
 	}
 }
 
+func TestSubscribeCommit(t *testing.T) {
+	chain, engine := newBlockChain(1)
+
+	ch := make(chan *types.Block, 1)
+	sub := engine.SubscribeCommit(ch, 0)
+	defer sub.Unsubscribe()
+
+	// a subscriber which never reads must not stall the consensus
+	stalled := engine.SubscribeCommit(make(chan *types.Block), 0)
+	defer stalled.Unsubscribe()
+
+	block := makeBlock(chain, engine, chain.Genesis())
+	select {
+	case committed := <-ch:
+		if committed.Hash() != block.Hash() {
+			t.Errorf("hash mismatch: have %v, want %v", committed.Hash().Hex(), block.Hash().Hex())
+		}
+	case <-time.After(time.Second):
+		t.Errorf("committed block should be delivered")
+	}
+
+	// no more deliveries after unsubscribing
+	sub.Unsubscribe()
+	engine.notifyCommit(block)
+	select {
+	case committed := <-ch:
+		t.Errorf("unexpected committed block: %v", committed.Hash().Hex())
+	default:
+	}
+}
+
+func TestSubscribeCommitConfirmations(t *testing.T) {
+	chain, engine := newBlockChain(1)
+
+	ch := make(chan *types.Block, 3)
+	sub := engine.SubscribeCommit(ch, 2)
+	defer sub.Unsubscribe()
+
+	// each block is delivered once the block two above it is committed
+	headers := makeHeaders(chain, engine, 4)
+	for i, header := range headers {
+		engine.notifyCommit(types.NewBlockWithHeader(header))
+		if i < 2 {
+			if len(ch) != 0 {
+				t.Errorf("block %d: block delivered without confirmations", i+1)
+			}
+			continue
+		}
+		select {
+		case confirmed := <-ch:
+			if want := headers[i-2].Hash(); confirmed.Hash() != want {
+				t.Errorf("block %d: hash mismatch: have %v, want %v", i+1, confirmed.Hash().Hex(), want.Hex())
+			}
+		default:
+			t.Errorf("block %d: confirmed block %d should be delivered", i+1, i-1)
+		}
+		if len(ch) != 0 {
+			t.Errorf("block %d: too many blocks delivered", i+1)
+		}
+	}
+}
+
 func TestGetProposer(t *testing.T) {
 	chain, engine := newBlockChain(1)
 	block := makeBlock(chain, engine, chain.Genesis())
@@ -184,6 +287,233 @@ func TestGetProposer(t *testing.T) {
 	}
 }
 
+func TestVerifyParent(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	block := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+
+	// a proposal on top of the chain head is accepted
+	proposal := signProposal(engine, makeBlockWithoutSeal(chain, engine, block))
+	engine.clock = fixedClock{istanbul.SystemClock, time.Unix(proposal.Time().Int64(), 0)}
+	if _, err := engine.Verify(proposal); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	// a proposal on top of a stale parent is rejected
+	stale := signProposal(engine, makeBlockWithoutSeal(chain, engine, chain.Genesis()))
+	if _, err := engine.Verify(stale); err != errInconsistentParent {
+		t.Errorf("error mismatch: have %v, want %v", err, errInconsistentParent)
+	}
+}
+
+func TestVerifyState(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	if _, err := engine.Verify(signProposal(engine, block)); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+
+	// a proposal with a transaction from an account without funds is rejected
+	key, _ := crypto.GenerateKey()
+	tx, _ := types.SignTx(types.NewTransaction(0, common.Address{}, big.NewInt(1), 21000, big.NewInt(1), nil), types.HomesteadSigner{}, key)
+	invalid := types.NewBlock(block.Header(), types.Transactions{tx}, nil, nil)
+	if _, err := engine.Verify(signProposal(engine, invalid)); err == nil {
+		t.Errorf("error mismatch: have nil, want an invalid transaction error")
+	}
+}
+
+func TestVerifyTxOrdering(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	config := *engine.config
+	config.TxOrdering = istanbul.PriceNonceTxOrdering
+	engine.config = &config
+
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	signer := types.MakeSigner(chain.Config(), block.Number())
+	alice, _ := crypto.GenerateKey()
+	bob, _ := crypto.GenerateKey()
+	tx := func(key *ecdsa.PrivateKey, nonce uint64, price int64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewTransaction(nonce, common.Address{}, big.NewInt(1), 21000, big.NewInt(price), nil), signer, key)
+		return tx
+	}
+
+	tests := []struct {
+		txs types.Transactions
+		err error
+	}{
+		{
+			// highest price first, each sender in nonce order
+			types.Transactions{tx(alice, 0, 3), tx(bob, 0, 2), tx(alice, 1, 1)},
+			nil,
+		},
+		{
+			// the same price in any order
+			types.Transactions{tx(bob, 0, 2), tx(alice, 0, 2)},
+			nil,
+		},
+		{
+			// a cheaper transaction before the first one of a sender, which
+			// may follow its skipped stale transactions
+			types.Transactions{tx(bob, 0, 2), tx(alice, 0, 3)},
+			nil,
+		},
+		{
+			// cheaper transactions before the pricier next one of a sender
+			// whose stale transaction was skipped
+			types.Transactions{tx(alice, 0, 3), tx(alice, 1, 2), tx(bob, 1, 5)},
+			nil,
+		},
+		{
+			// a cheaper transaction before the pricier next one of a sender
+			types.Transactions{tx(alice, 0, 3), tx(bob, 0, 2), tx(alice, 1, 5)},
+			errInvalidTxOrdering,
+		},
+		{
+			// the transactions of a sender out of nonce order
+			types.Transactions{tx(alice, 1, 3), tx(alice, 0, 3)},
+			errInvalidTxOrdering,
+		},
+	}
+	for i, test := range tests {
+		proposal := types.NewBlock(block.Header(), test.txs, nil, nil)
+		if err := engine.verifyTxOrdering(proposal); err != test.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.err)
+		}
+	}
+
+	// an out of order proposal is rejected before being prepared
+	proposal := signProposal(engine, types.NewBlock(block.Header(), tests[4].txs, nil, nil))
+	if _, err := engine.Verify(proposal); err != errInvalidTxOrdering {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidTxOrdering)
+	}
+	// unless the proposer orders the transactions
+	config.TxOrdering = istanbul.AnyTxOrdering
+	if err := engine.verifyTxOrdering(proposal); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	chain, engine, keys := newBlockChainWithKeys(2)
+	oldAddr := engine.Address()
+	var newKey *ecdsa.PrivateKey
+	for _, key := range keys {
+		if crypto.PubkeyToAddress(key.PublicKey) != oldAddr {
+			newKey = key
+		}
+	}
+
+	// Only a validator key is accepted
+	outsider, _ := crypto.GenerateKey()
+	if err := engine.RotateKey(outsider); err != errUnauthorized {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+	// The block in flight is sealed with the old key
+	if err := engine.RotateKey(newKey); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	block1 := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block1}); err != nil {
+		t.Fatalf("failed to insert block 1: %v", err)
+	}
+	if engine.Address() != oldAddr {
+		t.Errorf("address mismatch: have %v, want %v", engine.Address().Hex(), oldAddr.Hex())
+	}
+
+	// The next one with the new key
+	engine.NewChainHead()
+	newAddr := crypto.PubkeyToAddress(newKey.PublicKey)
+	if engine.Address() != newAddr {
+		t.Errorf("address mismatch: have %v, want %v", engine.Address().Hex(), newAddr.Hex())
+	}
+	block2 := makeBlock(chain, engine, block1)
+	if _, err := chain.InsertChain(types.Blocks{block2}); err != nil {
+		t.Fatalf("failed to insert block 2: %v", err)
+	}
+
+	for _, test := range []struct {
+		block    *types.Block
+		proposer common.Address
+	}{
+		{block1, oldAddr},
+		{block2, newAddr},
+	} {
+		if err := engine.VerifyHeader(chain, test.block.Header(), false); err != nil {
+			t.Errorf("block %d: error mismatch: have %v, want nil", test.block.NumberU64(), err)
+		}
+		if proposer, _ := engine.Author(test.block.Header()); proposer != test.proposer {
+			t.Errorf("block %d: proposer mismatch: have %v, want %v", test.block.NumberU64(), proposer.Hex(), test.proposer.Hex())
+		}
+	}
+}
+
+func TestSignWhileRotating(t *testing.T) {
+	_, engine, keys := newBlockChainWithKeys(2)
+	addrs := map[common.Address]bool{
+		crypto.PubkeyToAddress(keys[0].PublicKey): true,
+		crypto.PubkeyToAddress(keys[1].PublicKey): true,
+	}
+
+	// The key switches back and forth while the engine signs
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			engine.RotateKey(keys[i%2])
+			engine.switchRotatedKey()
+		}
+	}()
+	data := []byte("Here is a string....")
+	hash := crypto.Keccak256(data)
+	for i := 0; i < 100; i++ {
+		sig, err := engine.Sign(data)
+		if err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		pubkey, err := crypto.SigToPub(hash, sig)
+		if err != nil || !addrs[crypto.PubkeyToAddress(*pubkey)] {
+			t.Errorf("signer mismatch: have %v (%v), want a validator key", pubkey, err)
+		}
+	}
+	<-done
+}
+
+func TestUnicast(t *testing.T) {
+	b := newBackend()
+	payload := []byte("Here is a string....")
+
+	// unknown peer
+	if err := b.Unicast(getInvalidAddress(), payload); err != errUnknownPeer {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnknownPeer)
+	}
+
+	// send to self
+	sub := b.istanbulEventMux.Subscribe(istanbul.MessageEvent{})
+	defer sub.Unsubscribe()
+	if err := b.Unicast(b.Address(), payload); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	select {
+	case ev := <-sub.Chan():
+		msg, ok := ev.Data.(istanbul.MessageEvent)
+		if !ok || !bytes.Equal(msg.Payload, payload) {
+			t.Errorf("message mismatch: have %v, want %v", ev.Data, payload)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("unicast message to self should be delivered")
+	}
+}
+
+// signProposal seals the block with the engine's key, as the proposer does
+// before requesting the consensus on it.
+func signProposal(engine *backend, block *types.Block) *types.Block {
+	header := block.Header()
+	sig, _ := engine.Sign(sigHash(header).Bytes())
+	istanbul.WriteSeal(header, sig)
+	return block.WithSeal(header)
+}
+
 /**
  * SimpleBackend
  * Private key: bb047e5940b6d83354d9432db7c449ac8fca2248008aaa7271369880f9f11cc1
@@ -224,7 +554,7 @@ func (slice Keys) Len() int {
 }
 
 func (slice Keys) Less(i, j int) bool {
-	return strings.Compare(crypto.PubkeyToAddress(slice[i].PublicKey).String(), crypto.PubkeyToAddress(slice[j].PublicKey).String()) < 0
+	return bytes.Compare(crypto.PubkeyToAddress(slice[i].PublicKey).Bytes(), crypto.PubkeyToAddress(slice[j].PublicKey).Bytes()) < 0
 }
 
 func (slice Keys) Swap(i, j int) {
@@ -237,3 +567,36 @@ func newBackend() (b *backend) {
 	b.privateKey = key
 	return
 }
+
+// BenchmarkRecoverSigner verifies messages which are each relayed by several
+// peers, with and without the signer cache.
+func BenchmarkRecoverSigner(b *testing.B) {
+	const (
+		messages = 100
+		relays   = 4
+	)
+	key, _ := generatePrivateKey()
+	data := make([][]byte, messages)
+	sigs := make([][]byte, messages)
+	for i := range data {
+		data[i] = []byte(fmt.Sprintf("message %d", i))
+		sigs[i], _ = crypto.Sign(crypto.Keccak256(data[i]), key)
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			m := (i / relays) % messages
+			istanbul.GetSignatureAddress(data[m], sigs[m])
+		}
+		b.ReportMetric(1, "ecrecover/op")
+	})
+	b.Run("cached", func(b *testing.B) {
+		backend := newBackend()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m := (i / relays) % messages
+			backend.RecoverSigner(data[m], sigs[m])
+		}
+		b.ReportMetric(float64(backend.recentSigners.Len())/float64(b.N), "ecrecover/op")
+	})
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestDigest(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(1)
+	config := *istanbul.DefaultConfig
+	config.Digest = istanbul.SHA256Digest
+	config.DigestBlock = big.NewInt(1)
+	otherConfig := *istanbul.DefaultConfig
+
+	// Two nodes configured identically agree on the seals of a block
+	chain, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+	peerChain, peer := newBlockChainFromGenesis(genesis, &config, keys[0])
+	block := makeBlock(chain, engine, chain.Genesis())
+	if err := peer.VerifyHeader(peerChain, block.Header(), false); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	// but not with a node hashing with another algorithm
+	otherChain, other := newBlockChainFromGenesis(genesis, &otherConfig, keys[0])
+	if err := other.VerifyHeader(otherChain, block.Header(), false); err == nil {
+		t.Errorf("error mismatch: have nil, want error")
+	}
+	// The blocks before the activation are hashed with Keccak-256
+	if digest := config.DigestAt(common.Big0); digest != istanbul.Keccak256Digest {
+		t.Errorf("digest mismatch: have %v, want %v", digest, istanbul.Keccak256Digest)
+	}
+
+	// A node configured with an unknown algorithm doesn't start
+	engine.Stop()
+	config.Digest = istanbul.SHA256Digest + 1
+	if err := engine.Start(chain, chain.CurrentBlock, chain.HasBadBlock); err != istanbul.ErrUnknownDigest {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrUnknownDigest)
+	}
+	config.Digest = istanbul.SHA256Digest
+	if err := engine.Start(chain, chain.CurrentBlock, chain.HasBadBlock); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
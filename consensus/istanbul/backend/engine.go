@@ -19,8 +19,10 @@ package backend
 import (
 	"bytes"
 	"errors"
+	"math"
 	"math/big"
 	"math/rand"
+	"runtime"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -32,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/state"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto/sha3"
+	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
@@ -43,13 +46,17 @@ const (
 	inmemorySnapshots  = 128  // Number of recent vote snapshots to keep in memory
 	inmemoryPeers      = 40
 	inmemoryMessages   = 1024
+	inmemorySigners    = 4096 // Number of recent message signers to keep in memory
+	inmemoryHeaders    = 4096 // Number of recent header verification results to keep in memory
+
+	allowedFutureBlockTime = 15 * time.Second // Max time from current time allowed for blocks, before they're considered future blocks
 )
 
 var (
 	// errInvalidProposal is returned when a prposal is malformed.
 	errInvalidProposal = errors.New("invalid proposal")
 	// errInvalidSignature is returned when given signature is not signed by given
-	// address.
+	// address, or when no address can be recovered from it at all.
 	errInvalidSignature = errors.New("invalid signature")
 	// errUnknownBlock is returned when the list of validators is requested for a block
 	// that is not part of the local blockchain.
@@ -82,12 +89,44 @@ var (
 	errEmptyCommittedSeals = errors.New("zero committed seals")
 	// errMismatchTxhashes is returned if the TxHash in header is mismatch.
 	errMismatchTxhashes = errors.New("mismatch transactions hashes")
+	// errInvalidValidatorList is returned if the validators in the extra-data are
+	// not sorted in ascending order or contain duplicates.
+	errInvalidValidatorList = errors.New("invalid validator list")
+	// errMissingSigner is returned if there is neither a private key nor a signer
+	// function to sign with.
+	errMissingSigner = errors.New("missing signer")
+	// errSealTimeout is returned if the consensus doesn't commit the block being
+	// sealed within the maximum number of rounds.
+	errSealTimeout = errors.New("seal timeout")
+	// errHalted is returned if the consensus halted after too many round changes
+	// without a commit.
+	errHalted = errors.New("consensus halted")
+	// errObserver is returned if an observer is asked to take part in the
+	// consensus.
+	errObserver = errors.New("observer doesn't take part in the consensus")
+	// errInconsistentParent is returned if a proposal doesn't extend the current
+	// chain head.
+	errInconsistentParent = errors.New("proposal parent is not the chain head")
+	// errInvalidValidatorsRoot is returned if a checkpoint block doesn't carry
+	// the Merkle root of its validators, or another block carries one.
+	errInvalidValidatorsRoot = errors.New("invalid validators root")
+	// errInvalidRegistryValidators is returned if an epoch block doesn't carry
+	// the validators read from the registry contract.
+	errInvalidRegistryValidators = errors.New("validators mismatch the registry")
+	// errEmptyRegistry is returned if the registry contract returns no
+	// validators.
+	errEmptyRegistry = errors.New("registry has no validators")
+	// errRegistryUnavailable is returned if the registry contract can't be
+	// called because the chain doesn't give access to its state.
+	errRegistryUnavailable = errors.New("registry state unavailable")
+	// errInvalidTxOrdering is returned if the transactions of a proposal aren't
+	// ordered by the configured rule.
+	errInvalidTxOrdering = errors.New("transactions out of order")
 )
 var (
 	defaultDifficulty = big.NewInt(1)
 	nilUncleHash      = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW.
 	emptyNonce        = types.BlockNonce{}
-	now               = time.Now
 
 	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on adding a new validator
 	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on removing a validator.
@@ -96,11 +135,19 @@ var (
 	recentAddresses, _ = lru.NewARC(inmemoryAddresses)
 )
 
+const (
+	defaultSealRounds = 10 // Number of rounds after which Seal gives up the block
+)
+
 // Author retrieves the Ethereum address of the account that minted the given
 // block, which may be different from the header's coinbase if a consensus
-// engine is based on signatures.
+// engine is based on signatures. The genesis and the blocks before the
+// activation have no Istanbul seal, their author is the coinbase.
 func (sb *backend) Author(header *types.Header) (common.Address, error) {
-	return ecrecover(header)
+	if !sb.config.Activated(header.Number.Uint64()) {
+		return header.Coinbase, nil
+	}
+	return ecrecover(header, sb.config.SigSchemeAt(header.Number), sb.config.DigestAt(header.Number))
 }
 
 // VerifyHeader checks whether a header conforms to the consensus rules of a
@@ -118,35 +165,134 @@ func (sb *backend) verifyHeader(chain consensus.ChainReader, header *types.Heade
 	if header.Number == nil {
 		return errUnknownBlock
 	}
+	// The genesis and the blocks before the activation aren't sealed by Istanbul
+	if !sb.config.Activated(header.Number.Uint64()) {
+		return nil
+	}
 
 	// Don't waste time checking blocks from the future
-	if header.Time.Cmp(big.NewInt(now().Unix())) > 0 {
+	if header.Time.Cmp(big.NewInt(sb.clock.Now().Add(allowedFutureBlockTime).Unix())) > 0 {
 		return consensus.ErrFutureBlock
 	}
 
-	// Ensure that the extra data format is satisfied
-	if _, err := types.ExtractIstanbulExtra(header); err != nil {
+	// The result only depends on the header and its ancestors, which are both
+	// pinned by the key, unless the ancestors aren't known yet
+	if sb.verifiedHeaders == nil {
+		return sb.verifyHeaderFields(chain, header, parents)
+	}
+	key := verifiedKey(header)
+	if result, ok := sb.verifiedHeaders.Get(key); ok && (header.Number.Sign() == 0 || parentHeader(chain, header, parents) != nil) {
+		err, _ := result.(error)
+		return err
+	}
+	err := sb.verifyHeaderFields(chain, header, parents)
+	if err != consensus.ErrUnknownAncestor {
+		sb.verifiedHeaders.Add(key, err)
+	}
+	return err
+}
+
+// verifyHeaderFields checks the fields of a header which isn't from the future.
+func (sb *backend) verifyHeaderFields(chain consensus.ChainReader, header *types.Header, parents []*types.Header) error {
+	for _, check := range headerChecks {
+		if err := check(header); err != nil {
+			return err
+		}
+	}
+
+	return sb.verifyCascadingFields(chain, header, parents)
+}
+
+// VerifyHeaderVerbose checks a header like VerifyHeader, but instead of stopping
+// at the first failing check it runs all of them and returns every violation.
+// The fields depending on the parent headers are only reported once.
+func (sb *backend) VerifyHeaderVerbose(chain consensus.ChainReader, header *types.Header) []error {
+	if header.Number == nil {
+		return []error{errUnknownBlock}
+	}
+	if !sb.config.Activated(header.Number.Uint64()) {
+		return nil
+	}
+
+	var errs []error
+	if header.Time.Cmp(big.NewInt(sb.clock.Now().Add(allowedFutureBlockTime).Unix())) > 0 {
+		errs = append(errs, consensus.ErrFutureBlock)
+	}
+	for _, check := range headerChecks {
+		if err := check(header); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := sb.verifyCascadingFields(chain, header, nil); err != nil {
+		for _, e := range errs {
+			if e == err {
+				return errs
+			}
+		}
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// headerChecks are the checks of the header fields which don't depend on
+// other headers, in the order they are applied.
+var headerChecks = []func(header *types.Header) error{
+	verifyExtra,
+	verifyNonce,
+	verifyMixDigest,
+	verifyUncleHash,
+	verifyDifficulty,
+}
+
+// verifyExtra ensures that the extra data format is satisfied
+func verifyExtra(header *types.Header) error {
+	validators, err := istanbul.ExtractValidators(header.Extra)
+	if err != nil {
 		return errInvalidExtraDataFormat
 	}
+	// Ensure that the validators are sorted and unique. The genesis list is
+	// written by hand and sorted once the validator set is built from it.
+	for i := 1; header.Number.Sign() > 0 && i < len(validators); i++ {
+		if bytes.Compare(validators[i-1][:], validators[i][:]) >= 0 {
+			return errInvalidValidatorList
+		}
+	}
+	return nil
+}
 
-	// Ensure that the coinbase is valid
+// verifyNonce ensures that the coinbase vote is valid
+func verifyNonce(header *types.Header) error {
 	if header.Nonce != (emptyNonce) && !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
 		return errInvalidNonce
 	}
-	// Ensure that the mix digest is zero as we don't have fork protection currently
+	return nil
+}
+
+// verifyMixDigest ensures that the mix digest is zero as we don't have fork
+// protection currently
+func verifyMixDigest(header *types.Header) error {
 	if header.MixDigest != types.IstanbulDigest {
 		return errInvalidMixDigest
 	}
-	// Ensure that the block doesn't contain any uncles which are meaningless in Istanbul
+	return nil
+}
+
+// verifyUncleHash ensures that the block doesn't contain any uncles which are
+// meaningless in Istanbul
+func verifyUncleHash(header *types.Header) error {
 	if header.UncleHash != nilUncleHash {
 		return errInvalidUncleHash
 	}
-	// Ensure that the block's difficulty is meaningful (may not be correct at this point)
+	return nil
+}
+
+// verifyDifficulty ensures that the block's difficulty is meaningful (may not
+// be correct at this point)
+func verifyDifficulty(header *types.Header) error {
 	if header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0 {
 		return errInvalidDifficulty
 	}
-
-	return sb.verifyCascadingFields(chain, header, parents)
+	return nil
 }
 
 // verifyCascadingFields verifies all the header fields that are not standalone,
@@ -160,16 +306,11 @@ func (sb *backend) verifyCascadingFields(chain consensus.ChainReader, header *ty
 		return nil
 	}
 	// Ensure that the block's timestamp isn't too close to it's parent
-	var parent *types.Header
-	if len(parents) > 0 {
-		parent = parents[len(parents)-1]
-	} else {
-		parent = chain.GetHeader(header.ParentHash, number-1)
-	}
-	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+	parent := parentHeader(chain, header, parents)
+	if parent == nil {
 		return consensus.ErrUnknownAncestor
 	}
-	if parent.Time.Uint64()+sb.config.BlockPeriod > header.Time.Uint64() {
+	if parent.Time.Uint64()+sb.config.BlockPeriod > header.Time.Uint64() || parent.Time.Cmp(header.Time) >= 0 {
 		return errInvalidTimestamp
 	}
 	// Verify validators in extraData. Validators in snapshot and extraData should be the same.
@@ -181,6 +322,9 @@ func (sb *backend) verifyCascadingFields(chain consensus.ChainReader, header *ty
 	for i, validator := range snap.validators() {
 		copy(validators[i*common.AddressLength:], validator[:])
 	}
+	if err := sb.verifyValidatorsRoot(header, snap); err != nil {
+		return err
+	}
 	if err := sb.verifySigner(chain, header, parents); err != nil {
 		return err
 	}
@@ -188,27 +332,185 @@ func (sb *backend) verifyCascadingFields(chain consensus.ChainReader, header *ty
 	return sb.verifyCommittedSeals(chain, header, parents)
 }
 
+// verifyValidatorsRoot checks that the header carries the Merkle root of the
+// validators of the snapshot at its parent if it's a checkpoint block and the
+// roots are enabled, and no root otherwise.
+func (sb *backend) verifyValidatorsRoot(header *types.Header, snap *Snapshot) error {
+	extra, err := types.ExtractIstanbulExtra(header)
+	if err != nil {
+		return err
+	}
+	var root common.Hash
+	if sb.checkpointRoot(header.Number) {
+		root = istanbul.ValidatorsRoot(snap.validators())
+	}
+	if extra.ValidatorsRoot != root {
+		return errInvalidValidatorsRoot
+	}
+	return nil
+}
+
+// checkpointRoot returns whether the block with the given number carries the
+// Merkle root of its validators.
+func (sb *backend) checkpointRoot(number *big.Int) bool {
+	return sb.config.ValidatorsRootAt(number) && number.Uint64()%sb.config.Epoch == 0
+}
+
+// parentHeader retrieves the parent of a non-genesis header from the batch of
+// parents if any, or from the database. It returns nil if the parent is unknown.
+func parentHeader(chain consensus.ChainReader, header *types.Header, parents []*types.Header) *types.Header {
+	number := header.Number.Uint64()
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return nil
+	}
+	return parent
+}
+
 // VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers
 // concurrently. The method returns a quit channel to abort the operations and
 // a results channel to retrieve the async verifications (the order is that of
 // the input slice).
+//
+// The headers are verified by a pool of VerifyWorkers workers, running at most
+// that many headers ahead of the consumer, so an abort stops the pending work
+// promptly. The results channel is closed once all the headers are verified or
+// the operation is aborted.
 func (sb *backend) VerifyHeaders(chain consensus.ChainReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
-	abort := make(chan struct{})
-	results := make(chan error, len(headers))
-	go func() {
-		for i, header := range headers {
-			err := sb.verifyHeader(chain, header, headers[:i])
+	workers := sb.verifyWorkers()
+	if len(headers) < workers {
+		workers = len(headers)
+	}
+
+	// Create a task channel and spawn the verifiers
+	var (
+		inputs  = make(chan int)
+		done    = make(chan int, workers)
+		errs    = make([]error, len(headers))
+		abort   = make(chan struct{})
+		results = make(chan error, 1)
+	)
+	verify := sb.verifyHeader
+	if sb.config.FinalitySync {
+		verify = sb.verifyFinality
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for index := range inputs {
+				errs[index] = verify(chain, headers[index], headers[:index])
+				done <- index
+			}
+		}()
+	}
 
+	go func() {
+		defer close(results)
+		defer close(inputs)
+		var (
+			in, out = 0, 0
+			checked = make([]bool, len(headers))
+		)
+		for out < len(headers) {
+			// Only hand out the headers within the window of the consumer, and
+			// only deliver the results in order
+			var (
+				tasks  chan int
+				output chan error
+				result error
+			)
+			if in < len(headers) && in < out+workers {
+				tasks = inputs
+			}
+			if checked[out] {
+				output, result = results, errs[out]
+			}
 			select {
+			case tasks <- in:
+				in++
+			case index := <-done:
+				checked[index] = true
+			case output <- result:
+				out++
 			case <-abort:
 				return
-			case results <- err:
 			}
 		}
 	}()
 	return abort, results
 }
 
+// verifyWorkers returns the number of headers VerifyHeaders verifies
+// concurrently.
+func (sb *backend) verifyWorkers() int {
+	if sb.config.VerifyWorkers > 0 {
+		return int(sb.config.VerifyWorkers)
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// verifyFinality checks a header of a batch by its finality proof only: its
+// committed seals must come from distinct validators of its parent reaching the
+// commit quorum, the validator sets being those of the persisted snapshots.
+// The committed seals sign the hash of the header, proposer seal and timestamp
+// included, so unlike verifyHeader it doesn't check them on their own.
+func (sb *backend) verifyFinality(chain consensus.ChainReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	if !sb.config.Activated(header.Number.Uint64()) {
+		return nil
+	}
+	for _, check := range headerChecks {
+		if err := check(header); err != nil {
+			return err
+		}
+	}
+	if parentHeader(chain, header, parents) == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	snap, err := sb.snapshot(chain, header.Number.Uint64()-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	if err := sb.verifyValidatorsRoot(header, snap); err != nil {
+		return err
+	}
+	return sb.verifyCommittedSeals(chain, header, parents)
+}
+
+// VerifyChain verifies the canonical headers of chain from number from up to
+// and including to, their seals, committed seals and validator sets, without
+// running a node. It returns the first bad header with the reason, or nil if
+// the whole range is valid. The voting snapshots are cached in db, which can
+// be an in-memory database to leave the chain untouched, e.g. when auditing a
+// chain export.
+func VerifyChain(config *istanbul.Config, db ethdb.Database, chain consensus.ChainReader, from, to uint64) (*types.Header, error) {
+	sb := New(config, nil, db).(*backend)
+
+	var headers []*types.Header
+	for number := from; number <= to; number++ {
+		header := chain.GetHeaderByNumber(number)
+		if header == nil {
+			return nil, errUnknownBlock
+		}
+		headers = append(headers, header)
+	}
+	abort, results := sb.VerifyHeaders(chain, headers, nil)
+	defer close(abort)
+
+	for _, header := range headers {
+		if err := <-results; err != nil {
+			return header, err
+		}
+	}
+	return nil, nil
+}
+
 // VerifyUncles verifies that the given block's uncles conform to the consensus
 // rules of a given engine.
 func (sb *backend) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
@@ -233,7 +535,7 @@ func (sb *backend) verifySigner(chain consensus.ChainReader, header *types.Heade
 	}
 
 	// resolve the authorization key and check against signers
-	signer, err := ecrecover(header)
+	signer, err := ecrecover(header, sb.config.SigSchemeAt(header.Number), sb.config.DigestAt(header.Number))
 	if err != nil {
 		return err
 	}
@@ -271,26 +573,28 @@ func (sb *backend) verifyCommittedSeals(chain consensus.ChainReader, header *typ
 	validators := snap.ValSet.Copy()
 	// Check whether the committed seals are generated by parent's validators
 	validSeal := 0
-	proposalSeal := istanbulCore.PrepareCommittedSeal(header.Hash())
+	proposalSeal := istanbulCore.PrepareCommittedSeal(header.Hash(), sb.config.SigSchemeAt(header.Number))
 	// 1. Get committed seals from current header
 	for _, seal := range extra.CommittedSeal {
 		// 2. Get the original address by seal and parent block hash
-		addr, err := istanbul.GetSignatureAddress(proposalSeal, seal)
+		addr, err := sb.config.DigestAt(header.Number).SignatureAddress(proposalSeal, seal)
 		if err != nil {
 			sb.logger.Error("not a valid address", "err", err)
 			return errInvalidSignature
 		}
 		// Every validator can have only one seal. If more than one seals are signed by a
 		// validator, the validator cannot be found and errInvalidCommittedSeals is returned.
-		if validators.RemoveValidator(addr) {
-			validSeal += 1
+		_, v := validators.GetByAddress(addr)
+		if v != nil && validators.RemoveValidator(addr) {
+			validSeal += int(v.Weight())
 		} else {
 			return errInvalidCommittedSeals
 		}
 	}
 
-	// The length of validSeal should be larger than number of faulty node + 1
-	if validSeal <= 2*snap.ValSet.F() {
+	// The weight of validSeal should reach 2F+1, whatever the quorum this node
+	// commits with
+	if validSeal < sb.config.ChainQuorum(snap.ValSet) {
 		return errInvalidCommittedSeals
 	}
 
@@ -300,10 +604,10 @@ func (sb *backend) verifyCommittedSeals(chain consensus.ChainReader, header *typ
 // VerifySeal checks whether the crypto seal on a header is valid according to
 // the consensus rules of the given engine.
 func (sb *backend) VerifySeal(chain consensus.ChainReader, header *types.Header) error {
-	// get parent header and ensure the signer is in parent's validator set
+	// The genesis and the blocks before the activation have no Istanbul seal
 	number := header.Number.Uint64()
-	if number == 0 {
-		return errUnknownBlock
+	if !sb.config.Activated(number) {
+		return nil
 	}
 
 	// ensure that the difficulty equals to defaultDifficulty
@@ -320,6 +624,9 @@ func (sb *backend) Prepare(chain consensus.ChainReader, header *types.Header) er
 	header.Coinbase = common.Address{}
 	header.Nonce = emptyNonce
 	header.MixDigest = types.IstanbulDigest
+	header.UncleHash = nilUncleHash
+	// use the same difficulty for all blocks
+	header.Difficulty = defaultDifficulty
 
 	// copy the parent extra data as the header extra data
 	number := header.Number.Uint64()
@@ -327,8 +634,6 @@ func (sb *backend) Prepare(chain consensus.ChainReader, header *types.Header) er
 	if parent == nil {
 		return consensus.ErrUnknownAncestor
 	}
-	// use the same difficulty for all blocks
-	header.Difficulty = defaultDifficulty
 
 	// Assemble the voting snapshot
 	snap, err := sb.snapshot(chain, number-1, header.ParentHash, nil)
@@ -336,12 +641,12 @@ func (sb *backend) Prepare(chain consensus.ChainReader, header *types.Header) er
 		return err
 	}
 
-	// get valid candidate list
+	// get valid candidate list, the validators aren't voted with a registry
 	sb.candidatesLock.RLock()
 	var addresses []common.Address
 	var authorizes []bool
 	for address, authorize := range sb.candidates {
-		if snap.checkVote(address, authorize) {
+		if sb.config.RegistryAt(header.Number) == (common.Address{}) && snap.checkVote(address, authorize) {
 			addresses = append(addresses, address)
 			authorizes = append(authorizes, authorize)
 		}
@@ -360,17 +665,29 @@ func (sb *backend) Prepare(chain consensus.ChainReader, header *types.Header) er
 		}
 	}
 
-	// add validators in snapshot to extraData's validators section
-	extra, err := prepareExtra(header, snap.validators())
+	// add validators in snapshot to extraData's validators section, or those
+	// taking over from the registry on an epoch block
+	validators := snap.validators()
+	if sb.registryEpoch(number) {
+		if validators, err = sb.registryValidators(chain, parent); err != nil {
+			return err
+		}
+	}
+	extra, err := prepareExtra(header, validators)
 	if err != nil {
 		return err
 	}
 	header.Extra = extra
+	if sb.checkpointRoot(header.Number) {
+		if err := istanbul.WriteValidatorsRoot(header, istanbul.ValidatorsRoot(snap.validators())); err != nil {
+			return err
+		}
+	}
 
 	// set header's timestamp
 	header.Time = new(big.Int).Add(parent.Time, new(big.Int).SetUint64(sb.config.BlockPeriod))
-	if header.Time.Int64() < time.Now().Unix() {
-		header.Time = big.NewInt(time.Now().Unix())
+	if header.Time.Int64() < sb.clock.Now().Unix() {
+		header.Time = big.NewInt(sb.clock.Now().Unix())
 	}
 	return nil
 }
@@ -382,7 +699,14 @@ func (sb *backend) Prepare(chain consensus.ChainReader, header *types.Header) er
 // consensus rules that happen at finalization (e.g. block rewards).
 func (sb *backend) Finalize(chain consensus.ChainReader, header *types.Header, state *state.StateDB, txs []*types.Transaction,
 	uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
-	// No block rewards in Istanbul, so the state remains as is and uncles are dropped
+	// Istanbul has no uncles, reject them rather than dropping them silently
+	if len(uncles) != 0 {
+		return nil, errInvalidUncleHash
+	}
+	// Credit the optional block reward to the proposer
+	if reward := sb.config.BlockRewardAt(header.Number); reward != nil && reward.Sign() > 0 {
+		state.AddBalance(sb.proposerOf(header), reward)
+	}
 	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
 	header.UncleHash = nilUncleHash
 
@@ -390,9 +714,22 @@ func (sb *backend) Finalize(chain consensus.ChainReader, header *types.Header, s
 	return types.NewBlock(header, txs, nil, receipts), nil
 }
 
+// proposerOf returns the proposer of the given header. A header being
+// finalized for sealing isn't signed yet, and the local node is its proposer.
+func (sb *backend) proposerOf(header *types.Header) common.Address {
+	if proposer, err := ecrecover(header, sb.config.SigSchemeAt(header.Number), sb.config.DigestAt(header.Number)); err == nil {
+		return proposer
+	}
+	return sb.Address()
+}
+
 // Seal generates a new block for the given input block with the local miner's
 // seal place on top.
 func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, stop <-chan struct{}) (*types.Block, error) {
+	if sb.config.Observer {
+		return nil, errObserver
+	}
+
 	// update the block header timestamp and signature and propose the block to core engine
 	header := block.Header()
 	number := header.Number.Uint64()
@@ -402,7 +739,7 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, stop <-
 	if err != nil {
 		return nil, err
 	}
-	if _, v := snap.ValSet.GetByAddress(sb.address); v == nil {
+	if _, v := snap.ValSet.GetByAddress(sb.Address()); v == nil {
 		return nil, errUnauthorized
 	}
 
@@ -410,17 +747,28 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, stop <-
 	if parent == nil {
 		return nil, consensus.ErrUnknownAncestor
 	}
+	// an empty block is held back for the EmptyBlockPeriod, so an idle chain
+	// keeps advancing without a flood of empty blocks
+	if period := sb.config.EmptyBlockPeriod; period > 0 && len(block.Transactions()) == 0 {
+		if idle := new(big.Int).Add(parent.Time, new(big.Int).SetUint64(period)); idle.Cmp(header.Time) > 0 {
+			header.Time = idle
+			block = block.WithSeal(header)
+		}
+	}
 	block, err = sb.updateBlock(parent, block)
 	if err != nil {
 		return nil, err
 	}
 
 	// wait for the timestamp of header, use this to adjust the block period
-	delay := time.Unix(block.Header().Time.Int64(), 0).Sub(now())
-	select {
-	case <-time.After(delay):
-	case <-stop:
-		return nil, nil
+	if delay := time.Unix(block.Header().Time.Int64(), 0).Sub(sb.clock.Now()); delay > 0 {
+		timer := sb.clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-stop:
+			timer.Stop()
+			return nil, nil
+		}
 	}
 
 	// get the proposed block hash and clear it if the seal() is completed.
@@ -432,10 +780,41 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, stop <-
 	}
 	defer clear()
 
-	// post block into Istanbul engine
-	go sb.EventMux().Post(istanbul.RequestEvent{
-		Proposal: block,
-	})
+	// the core doesn't propose anything until it's resumed by a new block
+	sb.coreMu.RLock()
+	halted := sb.core.Halted()
+	sb.coreMu.RUnlock()
+	if halted {
+		return nil, errHalted
+	}
+
+	// post block into Istanbul engine, and abandon it after if the sealing is
+	// stopped, so the core doesn't keep proposing it. The events are posted
+	// after those of the previous block, so a block isn't abandoned after the
+	// next one is requested.
+	stopped := false
+	abandon := make(chan bool, 1)
+	defer func() { abandon <- stopped && sb.config.AbandonOnStop }()
+	prev, posted := sb.sealPosted, make(chan struct{})
+	sb.sealPosted = posted
+	go func() {
+		defer close(posted)
+		if prev != nil {
+			<-prev
+		}
+		sb.EventMux().Post(istanbul.RequestEvent{
+			Proposal: block,
+		})
+		if <-abandon {
+			sb.EventMux().Post(istanbul.AbandonRequestEvent{
+				Proposal: block,
+			})
+		}
+	}()
+
+	// give up if the block isn't committed, so the miner can retry
+	timeout := sb.clock.NewTimer(sb.sealTimeout())
+	defer timeout.Stop()
 
 	for {
 		select {
@@ -445,12 +824,29 @@ func (sb *backend) Seal(chain consensus.ChainReader, block *types.Block, stop <-
 			if block.Hash() == result.Hash() {
 				return result, nil
 			}
+		case <-timeout.C():
+			return nil, errSealTimeout
 		case <-stop:
+			stopped = true
 			return nil, nil
 		}
 	}
 }
 
+// sealTimeout returns the time the core takes to go through sealRounds
+// rounds if every round times out.
+func (sb *backend) sealTimeout() time.Duration {
+	var timeout time.Duration
+	for round := 0; round < sb.sealRounds; round++ {
+		// same as the round change timeout of the core
+		timeout += time.Duration(sb.config.RequestTimeout) * time.Millisecond
+		if round > 0 {
+			timeout += time.Duration(math.Pow(2, float64(round))) * time.Second
+		}
+	}
+	return timeout
+}
+
 // CalcDifficulty is the difficulty adjustment algorithm. It returns the difficulty
 // that a new block should have based on the previous blocks in the chain and the
 // current signer.
@@ -462,12 +858,12 @@ func (sb *backend) CalcDifficulty(chain consensus.ChainReader, time uint64, pare
 func (sb *backend) updateBlock(parent *types.Header, block *types.Block) (*types.Block, error) {
 	header := block.Header()
 	// sign the hash
-	seal, err := sb.Sign(sigHash(header).Bytes())
+	seal, err := sb.sign(sealData(header, sb.config.SigSchemeAt(header.Number)), sb.config.DigestAt(header.Number), nil)
 	if err != nil {
 		return nil, err
 	}
 
-	err = writeSeal(header, seal)
+	err = istanbul.WriteSeal(header, seal)
 	if err != nil {
 		return nil, err
 	}
@@ -489,10 +885,23 @@ func (sb *backend) APIs(chain consensus.ChainReader) []rpc.API {
 func (sb *backend) Start(chain consensus.ChainReader, currentBlock func() *types.Block, hasBadBlock func(hash common.Hash) bool) error {
 	sb.coreMu.Lock()
 	defer sb.coreMu.Unlock()
+	if sb.config.Observer {
+		return errObserver
+	}
 	if sb.coreStarted {
 		return istanbul.ErrStartedEngine
 	}
 
+	if err := sb.config.CheckDigest(); err != nil {
+		return err
+	}
+	if err := sb.openWAL(); err != nil {
+		return err
+	}
+	if err := sb.startTransport(); err != nil {
+		return err
+	}
+
 	// clear previous data
 	sb.proposedBlockHash = common.Hash{}
 	if sb.commitCh != nil {
@@ -504,7 +913,12 @@ func (sb *backend) Start(chain consensus.ChainReader, currentBlock func() *types
 	sb.currentBlock = currentBlock
 	sb.hasBadBlock = hasBadBlock
 
+	sb.replayWAL(chain)
+	sb.startLease()
+	sb.startSends()
 	if err := sb.core.Start(); err != nil {
+		sb.stopSends()
+		sb.stopLease()
 		return err
 	}
 
@@ -523,6 +937,10 @@ func (sb *backend) Stop() error {
 		return err
 	}
 	sb.coreStarted = false
+	sb.stopSends()
+	sb.stopLease()
+	// No round is in flight anymore
+	sb.switchRotatedKey()
 	return nil
 }
 
@@ -547,21 +965,34 @@ func (sb *backend) snapshot(chain consensus.ChainReader, number uint64, hash com
 				break
 			}
 		}
-		// If we're at block zero, make a snapshot
-		if number == 0 {
+		// If the epoch checkpoint of this block can be found, use that
+		if number%sb.config.Epoch == 0 {
+			if s, err := loadCheckpoint(sb.config.Epoch, sb.db, number); err == nil && s.Hash == hash {
+				log.Trace("Loaded epoch checkpoint from disk", "number", number, "hash", hash)
+				snap = s
+				break
+			}
+		}
+		// If we're at block zero or at the last block before the activation, make
+		// a snapshot of the genesis validators
+		if !sb.config.Activated(number) {
 			genesis := chain.GetHeaderByNumber(0)
-			if err := sb.VerifyHeader(chain, genesis, false); err != nil {
+			validators, err := istanbul.ExtractValidators(genesis.Extra)
+			if err != nil {
 				return nil, err
 			}
-			istanbulExtra, err := types.ExtractIstanbulExtra(genesis)
+			if number == 0 {
+				hash = genesis.Hash()
+			}
+			valSet, err := validator.NewWeightedSet(validators, sb.config.WeightsOf(validators), sb.config.ProposerPolicy)
 			if err != nil {
 				return nil, err
 			}
-			snap = newSnapshot(sb.config.Epoch, 0, genesis.Hash(), validator.NewSet(istanbulExtra.Validators, sb.config.ProposerPolicy))
+			snap = newSnapshot(sb.config.Epoch, number, hash, valSet)
 			if err := snap.store(sb.db); err != nil {
 				return nil, err
 			}
-			log.Trace("Stored genesis voting snapshot to disk")
+			log.Trace("Stored genesis voting snapshot to disk", "number", number, "hash", hash)
 			break
 		}
 		// No snapshot for this header, gather the header and move backward
@@ -587,20 +1018,44 @@ func (sb *backend) snapshot(chain consensus.ChainReader, number uint64, hash com
 	for i := 0; i < len(headers)/2; i++ {
 		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
 	}
-	snap, err := snap.apply(headers)
-	if err != nil {
-		return nil, err
+	// Apply them up to each epoch boundary in turn, to checkpoint its validators
+	applied := len(headers)
+	for len(headers) > 0 {
+		n := len(headers)
+		for i, header := range headers {
+			if header.Number.Uint64()%sb.config.Epoch == 0 {
+				n = i + 1
+				break
+			}
+		}
+		prev := snap
+		var err error
+		if snap, err = snap.apply(headers[:n], sb.config); err != nil {
+			return nil, err
+		}
+		if sb.config.RegistryAt(headers[n-1].Number) != (common.Address{}) {
+			if err := sb.applyRegistry(prev, snap, headers[n-1]); err != nil {
+				return nil, err
+			}
+		}
+		headers = headers[n:]
+		if snap.Number%sb.config.Epoch == 0 {
+			if err := snap.storeCheckpoint(sb.db); err != nil {
+				return nil, err
+			}
+			log.Trace("Stored epoch checkpoint to disk", "number", snap.Number, "hash", snap.Hash)
+		}
 	}
 	sb.recents.Add(snap.Hash, snap)
 
 	// If we've generated a new checkpoint snapshot, save to disk
-	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
-		if err = snap.store(sb.db); err != nil {
+	if snap.Number%checkpointInterval == 0 && applied > 0 {
+		if err := snap.store(sb.db); err != nil {
 			return nil, err
 		}
 		log.Trace("Stored voting snapshot to disk", "number", snap.Number, "hash", snap.Hash)
 	}
-	return snap, err
+	return snap, nil
 }
 
 // FIXME: Need to update this for Istanbul
@@ -620,71 +1075,60 @@ func sigHash(header *types.Header) (hash common.Hash) {
 	return hash
 }
 
-// ecrecover extracts the Ethereum account address from a signed header.
-func ecrecover(header *types.Header) (common.Address, error) {
-	hash := header.Hash()
-	if addr, ok := recentAddresses.Get(hash); ok {
+// verifiedKey returns the key of the verification result of a header. Unlike
+// the hash of the header, it covers the committed seals.
+func verifiedKey(header *types.Header) (hash common.Hash) {
+	hasher := sha3.NewKeccak256()
+
+	rlp.Encode(hasher, header)
+	hasher.Sum(hash[:0])
+	return hash
+}
+
+// sealData returns the data signed for the proposer seal of the header in the
+// given scheme.
+func sealData(header *types.Header, scheme istanbul.SigScheme) []byte {
+	return scheme.SigData(istanbul.SealDomain, sigHash(header).Bytes())
+}
+
+// recoveredSeal is the key of a proposer recovered from a header seal
+type recoveredSeal struct {
+	hash   common.Hash
+	scheme istanbul.SigScheme
+	digest istanbul.DigestAlgorithm
+}
+
+// ecrecover extracts the Ethereum account address from a header signed in the
+// given scheme and hashed with the given digest algorithm.
+func ecrecover(header *types.Header, scheme istanbul.SigScheme, digest istanbul.DigestAlgorithm) (common.Address, error) {
+	key := recoveredSeal{header.Hash(), scheme, digest}
+	if addr, ok := recentAddresses.Get(key); ok {
 		return addr.(common.Address), nil
 	}
 
 	// Retrieve the signature from the header extra-data
-	istanbulExtra, err := types.ExtractIstanbulExtra(header)
+	seal, err := istanbul.ReadSeal(header)
 	if err != nil {
 		return common.Address{}, err
 	}
 
-	addr, err := istanbul.GetSignatureAddress(sigHash(header).Bytes(), istanbulExtra.Seal)
+	// A seal no address can be recovered from is malformed, unlike a seal of
+	// a non-validator
+	addr, err := digest.SignatureAddress(sealData(header, scheme), seal)
 	if err != nil {
-		return addr, err
+		return addr, errInvalidSignature
 	}
-	recentAddresses.Add(hash, addr)
+	recentAddresses.Add(key, addr)
 	return addr, nil
 }
 
 // prepareExtra returns a extra-data of the given header and validators
 func prepareExtra(header *types.Header, vals []common.Address) ([]byte, error) {
-	var buf bytes.Buffer
-
-	// compensate the lack bytes if header.Extra is not enough IstanbulExtraVanity bytes.
-	if len(header.Extra) < types.IstanbulExtraVanity {
-		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity-len(header.Extra))...)
-	}
-	buf.Write(header.Extra[:types.IstanbulExtraVanity])
-
-	ist := &types.IstanbulExtra{
-		Validators:    vals,
-		Seal:          []byte{},
-		CommittedSeal: [][]byte{},
-	}
-
-	payload, err := rlp.EncodeToBytes(&ist)
-	if err != nil {
-		return nil, err
-	}
-
-	return append(buf.Bytes(), payload...), nil
-}
-
-// writeSeal writes the extra-data field of the given header with the given seals.
-// suggest to rename to writeSeal.
-func writeSeal(h *types.Header, seal []byte) error {
-	if len(seal)%types.IstanbulExtraSeal != 0 {
-		return errInvalidSignature
+	vanity := header.Extra
+	if len(vanity) > types.IstanbulExtraVanity {
+		vanity = vanity[:types.IstanbulExtraVanity]
 	}
-
-	istanbulExtra, err := types.ExtractIstanbulExtra(h)
-	if err != nil {
-		return err
-	}
-
-	istanbulExtra.Seal = seal
-	payload, err := rlp.EncodeToBytes(&istanbulExtra)
-	if err != nil {
-		return err
-	}
-
-	h.Extra = append(h.Extra[:types.IstanbulExtraVanity], payload...)
-	return nil
+	return istanbul.PrepareExtra(vanity, vals)
 }
 
 // writeCommittedSeals writes the extra-data field of a block header with given committed seals.
@@ -18,7 +18,9 @@ package backend
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
+	"fmt"
 	"math/big"
 	"reflect"
 	"testing"
@@ -28,30 +30,31 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/consensus"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	istanbulCore "github.com/ethereum/go-ethereum/consensus/istanbul/core"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/params"
-	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	lru "github.com/hashicorp/golang-lru"
 )
 
 // in this test, we can set n to 1, and it means we can process Istanbul and commit a
 // block by one node. Otherwise, if n is larger than 1, we have to generate
 // other fake events to process Istanbul.
 func newBlockChain(n int) (*core.BlockChain, *backend) {
+	chain, engine, _ := newBlockChainWithKeys(n)
+	return chain, engine
+}
+
+// newBlockChainWithKeys is like newBlockChain but also returns the validator keys.
+func newBlockChainWithKeys(n int) (*core.BlockChain, *backend, []*ecdsa.PrivateKey) {
 	genesis, nodeKeys := getGenesisAndKeys(n)
-	memDB, _ := ethdb.NewMemDatabase()
-	config := istanbul.DefaultConfig
 	// Use the first key as private key
-	b, _ := New(config, nodeKeys[0], memDB).(*backend)
-	genesis.MustCommit(memDB)
-	blockchain, err := core.NewBlockChain(memDB, nil, genesis.Config, b, vm.Config{})
-	if err != nil {
-		panic(err)
-	}
-	b.Start(blockchain, blockchain.CurrentBlock, blockchain.HasBadBlock)
+	blockchain, b := newBlockChainFromGenesis(genesis, istanbul.DefaultConfig, nodeKeys[0])
 	snap, err := b.snapshot(blockchain, 0, common.Hash{}, nil)
 	if err != nil {
 		panic(err)
@@ -69,7 +72,24 @@ func newBlockChain(n int) (*core.BlockChain, *backend) {
 			b.address = addr
 		}
 	}
+	// restart the engine, so that the core picks the proposer address up
+	b.Stop()
+	b.Start(blockchain, blockchain.CurrentBlock, blockchain.HasBadBlock)
+
+	return blockchain, b, nodeKeys
+}
 
+// newBlockChainFromGenesis creates a chain from the given genesis, with an engine
+// using the given config and private key.
+func newBlockChainFromGenesis(genesis *core.Genesis, config *istanbul.Config, key *ecdsa.PrivateKey) (*core.BlockChain, *backend) {
+	memDB, _ := ethdb.NewMemDatabase()
+	b, _ := New(config, key, memDB).(*backend)
+	genesis.MustCommit(memDB)
+	blockchain, err := core.NewBlockChain(memDB, nil, genesis.Config, b, vm.Config{})
+	if err != nil {
+		panic(err)
+	}
+	b.Start(blockchain, blockchain.CurrentBlock, blockchain.HasBadBlock)
 	return blockchain, b
 }
 
@@ -97,23 +117,15 @@ func getGenesisAndKeys(n int) (*core.Genesis, []*ecdsa.PrivateKey) {
 }
 
 func appendValidators(genesis *core.Genesis, addrs []common.Address) {
-
-	if len(genesis.ExtraData) < types.IstanbulExtraVanity {
-		genesis.ExtraData = append(genesis.ExtraData, bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity)...)
-	}
-	genesis.ExtraData = genesis.ExtraData[:types.IstanbulExtraVanity]
-
-	ist := &types.IstanbulExtra{
-		Validators:    addrs,
-		Seal:          []byte{},
-		CommittedSeal: [][]byte{},
+	vanity := genesis.ExtraData
+	if len(vanity) > types.IstanbulExtraVanity {
+		vanity = vanity[:types.IstanbulExtraVanity]
 	}
-
-	istPayload, err := rlp.EncodeToBytes(&ist)
+	extra, err := istanbul.PrepareExtra(vanity, addrs)
 	if err != nil {
 		panic("failed to encode istanbul extra")
 	}
-	genesis.ExtraData = append(genesis.ExtraData, istPayload...)
+	genesis.ExtraData = extra
 }
 
 func makeHeader(parent *types.Block, config *istanbul.Config) *types.Header {
@@ -143,6 +155,15 @@ func makeBlockWithoutSeal(chain *core.BlockChain, engine *backend, parent *types
 	return block
 }
 
+// fixedClock is a clock whose time doesn't advance, e.g. to verify blocks
+// timestamped ahead of the system time.
+type fixedClock struct {
+	istanbul.Clock
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time { return c.now }
+
 func TestPrepare(t *testing.T) {
 	chain, engine := newBlockChain(1)
 	header := makeHeader(chain.Genesis(), engine.config)
@@ -157,6 +178,133 @@ func TestPrepare(t *testing.T) {
 	}
 }
 
+func TestAuthor(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	// the genesis has no seal, its author is the coinbase
+	genesis := chain.Genesis().Header()
+	if author, err := engine.Author(genesis); err != nil || author != genesis.Coinbase {
+		t.Errorf("author mismatch: have %v (%v), want %v", author, err, genesis.Coinbase)
+	}
+	// a sealed block is authored by its proposer
+	block := makeBlock(chain, engine, chain.Genesis())
+	if author, err := engine.Author(block.Header()); err != nil || author != engine.Address() {
+		t.Errorf("author mismatch: have %v (%v), want %v", author, err, engine.Address())
+	}
+	// an unsealed block has no author
+	if _, err := engine.Author(makeBlockWithoutSeal(chain, engine, chain.Genesis()).Header()); err == nil {
+		t.Errorf("error mismatch: have nil, want an error")
+	}
+}
+
+func TestCalcDifficulty(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	if difficulty := engine.CalcDifficulty(chain, 0, chain.Genesis().Header()); difficulty.Cmp(defaultDifficulty) != 0 {
+		t.Errorf("difficulty mismatch: have %v, want %v", difficulty, defaultDifficulty)
+	}
+}
+
+func TestPrepareFixedFields(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	// the fields fixed by Istanbul are set to anything by the caller
+	header := makeHeader(chain.Genesis(), engine.config)
+	header.Difficulty = big.NewInt(12345)
+	header.Coinbase = common.StringToAddress("1234567890")
+	header.Nonce = types.EncodeNonce(1)
+	header.MixDigest = common.StringToHash("1234567890")
+	header.UncleHash = common.StringToHash("1234567890")
+	if err := engine.Prepare(chain, header); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	state, _ := chain.StateAt(chain.Genesis().Root())
+	block, _ := engine.Finalize(chain, header, state, nil, nil, nil)
+	block, err := engine.Seal(chain, block, nil)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if err := engine.VerifyHeader(chain, block.Header(), false); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
+
+func TestPrepareBlockPeriod(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	config := *engine.config
+	config.BlockPeriod = 5
+	engine.config = &config
+
+	block := makeBlock(chain, engine, chain.Genesis())
+	if block.Time().Uint64() < chain.Genesis().Time().Uint64()+config.BlockPeriod {
+		t.Errorf("timestamp mismatch: have %v, want >= %v", block.Time(), chain.Genesis().Time().Uint64()+config.BlockPeriod)
+	}
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+
+	// the next block should be at least one block period later
+	header := makeBlockWithoutSeal(chain, engine, block).Header()
+	if header.Time.Uint64() != block.Time().Uint64()+config.BlockPeriod {
+		t.Errorf("timestamp mismatch: have %v, want %v", header.Time, block.Time().Uint64()+config.BlockPeriod)
+	}
+
+	// a block violating the block period is rejected
+	header.Time = new(big.Int).Add(block.Time(), new(big.Int).SetUint64(config.BlockPeriod-1))
+	if err := engine.VerifyHeader(chain, header, false); err != errInvalidTimestamp {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidTimestamp)
+	}
+}
+
+func TestFinalizeBlockReward(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	config := *engine.config
+	config.BlockReward = big.NewInt(100)
+	config.BlockRewardBlock = big.NewInt(2)
+	engine.config = &config
+
+	// the imported blocks are finalized again with the recovered proposer,
+	// only the ones from the activation are rewarded
+	block1 := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block1}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	engine.NewChainHead()
+	block2 := makeBlock(chain, engine, block1)
+	if _, err := chain.InsertChain(types.Blocks{block2}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	for _, test := range []struct {
+		block   *types.Block
+		balance *big.Int
+	}{
+		{block1, new(big.Int)},
+		{block2, config.BlockReward},
+	} {
+		state, err := chain.StateAt(test.block.Root())
+		if err != nil {
+			t.Fatalf("failed to get state: %v", err)
+		}
+		if balance := state.GetBalance(engine.Address()); balance.Cmp(test.balance) != 0 {
+			t.Errorf("block %v: balance mismatch: have %v, want %v", test.block.Number(), balance, test.balance)
+		}
+	}
+}
+
+func TestFinalizeUncles(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	parent := chain.Genesis()
+	header := makeHeader(parent, engine.config)
+	engine.Prepare(chain, header)
+	state, _ := chain.StateAt(parent.Root())
+
+	uncles := []*types.Header{parent.Header()}
+	if _, err := engine.Finalize(chain, header, state, nil, uncles, nil); err != errInvalidUncleHash {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidUncleHash)
+	}
+	block := types.NewBlock(header, nil, uncles, nil)
+	if err := engine.VerifyUncles(chain, block); err != errInvalidUncleHash {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidUncleHash)
+	}
+}
+
 func TestSealStopChannel(t *testing.T) {
 	chain, engine := newBlockChain(4)
 	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
@@ -181,6 +329,57 @@ func TestSealStopChannel(t *testing.T) {
 	}
 }
 
+func TestSealStopAbandonsRequest(t *testing.T) {
+	chain, engine := newBlockChain(4)
+	config := *engine.config
+	config.AbandonOnStop = true
+	engine.config = &config
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+
+	// Stop the sealing once the block is proposed, the other validators being
+	// offline it can't be prepared
+	eventSub := engine.EventMux().Subscribe(istanbul.ConsensusEvent{})
+	defer eventSub.Unsubscribe()
+	stop := make(chan struct{})
+	go func() {
+		for ev := range eventSub.Chan() {
+			if ev.Data.(istanbul.ConsensusEvent).Type == istanbul.ConsensusPreprepare {
+				close(stop)
+				return
+			}
+		}
+	}()
+	finalBlock, err := engine.Seal(chain, block, stop)
+	if err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if finalBlock != nil {
+		t.Errorf("block mismatch: have %v, want nil", finalBlock)
+	}
+
+	// The core abandons the proposal and moves to the next round without it
+	deadline := time.After(2 * time.Second)
+	for {
+		engine.coreMu.RLock()
+		dump, err := engine.core.DumpRound()
+		engine.coreMu.RUnlock()
+		if err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		if dump.Round.Int64() == 1 {
+			if len(dump.Prepares) != 0 || dump.State != istanbulCore.StateAcceptRequest.String() {
+				t.Errorf("round state mismatch: have %v prepares in %q, want none in %q", len(dump.Prepares), dump.State, istanbulCore.StateAcceptRequest)
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("round mismatch: have %v, want 1", dump.Round)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
 func TestSealCommittedOtherHash(t *testing.T) {
 	chain, engine := newBlockChain(4)
 	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
@@ -222,6 +421,117 @@ func TestSealCommitted(t *testing.T) {
 	}
 }
 
+func TestSealTimeout(t *testing.T) {
+	chain, engine := newBlockChain(4)
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+
+	// nobody else responds, so the consensus never commits the block
+	clock := istanbul.NewSimulatedClock()
+	engine.clock = clock
+	engine.sealRounds = 1
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := engine.Seal(chain, block, make(chan struct{}))
+		result <- err
+	}()
+	// advance the time past the seal timeout once it's armed, without waiting
+	for deadline := time.Now().Add(5 * time.Second); clock.Timers() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("seal timeout should be armed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	clock.Run(engine.sealTimeout())
+	select {
+	case err := <-result:
+		if err != errSealTimeout {
+			t.Errorf("error mismatch: have %v, want %v", err, errSealTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("seal should time out")
+	}
+}
+
+func TestSealEmptyBlockPeriod(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	config := *engine.config
+	config.EmptyBlockPeriod = 10
+	engine.config = &config
+	parent := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{parent}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	engine.NewChainHead()
+
+	clock := istanbul.NewSimulatedClock()
+	engine.clock = clock
+
+	result := make(chan *types.Block, 1)
+	go func() {
+		block, err := engine.Seal(chain, makeBlockWithoutSeal(chain, engine, parent), nil)
+		if err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+		result <- block
+	}()
+	for deadline := time.Now().Add(5 * time.Second); clock.Timers() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("the empty block should wait")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	want := parent.Time().Uint64() + config.EmptyBlockPeriod
+
+	// No empty block before the period
+	clock.Run(time.Unix(int64(want), 0).Sub(clock.Now()) - time.Second)
+	select {
+	case <-result:
+		t.Fatalf("empty block sealed before the empty block period")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// and exactly one after
+	clock.Run(time.Second)
+	select {
+	case block := <-result:
+		if block.Time().Uint64() != want {
+			t.Errorf("timestamp mismatch: have %v, want %v", block.Time(), want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("empty block should be sealed after the empty block period")
+	}
+	select {
+	case <-result:
+		t.Errorf("more than one empty block sealed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestSealUnauthorized(t *testing.T) {
+	chain, engine := newBlockChain(4)
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+
+	// replace the key of the engine by a non-validator key
+	engine.privateKey, _ = crypto.GenerateKey()
+	engine.address = crypto.PubkeyToAddress(engine.privateKey.PublicKey)
+
+	eventSub := engine.EventMux().Subscribe(istanbul.RequestEvent{})
+	defer eventSub.Unsubscribe()
+
+	finalBlock, err := engine.Seal(chain, block, nil)
+	if err != errUnauthorized {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+	if finalBlock != nil {
+		t.Errorf("block mismatch: have %v, want nil", finalBlock)
+	}
+	select {
+	case ev := <-eventSub.Chan():
+		t.Errorf("unexpected event comes: %v", reflect.TypeOf(ev.Data))
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
 func TestVerifyHeader(t *testing.T) {
 	chain, engine := newBlockChain(1)
 
@@ -247,6 +557,18 @@ func TestVerifyHeader(t *testing.T) {
 		t.Errorf("error mismatch: have %v, want %v", err, errInvalidExtraDataFormat)
 	}
 
+	// out-of-order and duplicate validators
+	addr1 := common.HexToAddress("0x1000000000000000000000000000000000000000")
+	addr2 := common.HexToAddress("0x2000000000000000000000000000000000000000")
+	for _, vals := range [][]common.Address{{addr2, addr1}, {addr1, addr1}} {
+		header = makeBlockWithoutSeal(chain, engine, chain.Genesis()).Header()
+		header.Extra, _ = prepareExtra(header, vals)
+		err = engine.VerifyHeader(chain, header, false)
+		if err != errInvalidValidatorList {
+			t.Errorf("error mismatch: have %v, want %v", err, errInvalidValidatorList)
+		}
+	}
+
 	// non zero MixDigest
 	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
 	header = block.Header()
@@ -283,10 +605,28 @@ func TestVerifyHeader(t *testing.T) {
 		t.Errorf("error mismatch: have %v, want %v", err, errInvalidTimestamp)
 	}
 
+	// timestamp equals to the parent's
+	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	header = block.Header()
+	header.Time = new(big.Int).Set(chain.Genesis().Time())
+	err = engine.VerifyHeader(chain, header, false)
+	if err != errInvalidTimestamp {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidTimestamp)
+	}
+
 	// future block
 	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
 	header = block.Header()
-	header.Time = new(big.Int).Add(big.NewInt(now().Unix()), new(big.Int).SetUint64(10))
+	header.Time = big.NewInt(engine.clock.Now().Add(allowedFutureBlockTime).Unix() + 10)
+	err = engine.VerifyHeader(chain, header, false)
+	if err != consensus.ErrFutureBlock {
+		t.Errorf("error mismatch: have %v, want %v", err, consensus.ErrFutureBlock)
+	}
+
+	// far-future block
+	block = makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	header = block.Header()
+	header.Time = big.NewInt(engine.clock.Now().Add(24 * time.Hour).Unix())
 	err = engine.VerifyHeader(chain, header, false)
 	if err != consensus.ErrFutureBlock {
 		t.Errorf("error mismatch: have %v, want %v", err, consensus.ErrFutureBlock)
@@ -303,13 +643,42 @@ func TestVerifyHeader(t *testing.T) {
 	}
 }
 
+func TestVerifyHeaderVerbose(t *testing.T) {
+	chain, engine := newBlockChain(1)
+
+	block := makeBlock(chain, engine, chain.Genesis())
+	if errs := engine.VerifyHeaderVerbose(chain, block.Header()); len(errs) != 0 {
+		t.Errorf("errors mismatch: have %v, want none", errs)
+	}
+
+	// Violate three standalone rules at once
+	header := block.Header()
+	header.MixDigest = common.StringToHash("123456789")
+	header.UncleHash = common.StringToHash("123456789")
+	header.Difficulty = big.NewInt(2)
+	errs := engine.VerifyHeaderVerbose(chain, header)
+	want := []error{errInvalidMixDigest, errInvalidUncleHash, errInvalidDifficulty}
+	if len(errs) < len(want) {
+		t.Fatalf("the number of errors mismatch: have %v, want at least %v", errs, len(want))
+	}
+	for i, err := range want {
+		if errs[i] != err {
+			t.Errorf("error %d mismatch: have %v, want %v", i, errs[i], err)
+		}
+	}
+	// The first violation is the one VerifyHeader reports
+	if err := engine.VerifyHeader(chain, header, false); err != errs[0] {
+		t.Errorf("error mismatch: have %v, want %v", err, errs[0])
+	}
+}
+
 func TestVerifySeal(t *testing.T) {
 	chain, engine := newBlockChain(1)
 	genesis := chain.Genesis()
-	// cannot verify genesis
+	// the genesis is implicitly valid
 	err := engine.VerifySeal(chain, genesis.Header())
-	if err != errUnknownBlock {
-		t.Errorf("error mismatch: have %v, want %v", err, errUnknownBlock)
+	if err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
 	}
 
 	block := makeBlock(chain, engine, genesis)
@@ -322,6 +691,24 @@ func TestVerifySeal(t *testing.T) {
 		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorized)
 	}
 
+	// a garbage seal no signer can be recovered from
+	header = block.Header()
+	istanbul.WriteSeal(header, bytes.Repeat([]byte{0xff}, types.IstanbulExtraSeal))
+	err = engine.VerifySeal(chain, header)
+	if err != errInvalidSignature {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidSignature)
+	}
+
+	// a valid seal of a non-validator
+	header = block.Header()
+	stranger, _ := crypto.GenerateKey()
+	sig, _ := crypto.Sign(engine.config.DigestAt(header.Number).Sum(sealData(header, engine.config.SigSchemeAt(header.Number))), stranger)
+	istanbul.WriteSeal(header, sig)
+	err = engine.VerifySeal(chain, header)
+	if err != errUnauthorized {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+
 	// unauthorized users but still can get correct signer address
 	engine.privateKey, _ = crypto.GenerateKey()
 	err = engine.VerifySeal(chain, block.Header())
@@ -330,6 +717,87 @@ func TestVerifySeal(t *testing.T) {
 	}
 }
 
+func TestActivationBlock(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(1)
+	config := *istanbul.DefaultConfig
+	config.ActivationBlock = 3
+	chain, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+
+	// the blocks before the activation are mined by another engine
+	parent := chain.Genesis()
+	for i := uint64(1); i < config.ActivationBlock; i++ {
+		header := &types.Header{
+			ParentHash: parent.Hash(),
+			Number:     new(big.Int).SetUint64(i),
+			GasLimit:   core.CalcGasLimit(parent),
+			Time:       new(big.Int).Add(parent.Time(), common.Big1),
+			Difficulty: big.NewInt(131072),
+			Root:       parent.Root(),
+		}
+		block := types.NewBlock(header, nil, nil, nil)
+		if err := engine.VerifySeal(chain, block.Header()); err != nil {
+			t.Errorf("block %d: error mismatch: have %v, want nil", i, err)
+		}
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i, err)
+		}
+		parent = block
+	}
+	// the consensus starts on top of the last block before the activation
+	engine.Stop()
+	engine.Start(chain, chain.CurrentBlock, chain.HasBadBlock)
+
+	block, err := engine.Seal(chain, makeBlockWithoutSeal(chain, engine, parent), nil)
+	if block == nil {
+		t.Fatalf("the activation block should be sealed: %v", err)
+	}
+	if err := engine.VerifySeal(chain, block.Header()); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert the activation block: %v", err)
+	}
+
+	// the activation block is checked like any other sealed block
+	header := block.Header()
+	header.Difficulty = big.NewInt(131072)
+	if err := engine.VerifySeal(chain, header); err != errInvalidDifficulty {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidDifficulty)
+	}
+	header = makeBlockWithoutSeal(chain, engine, parent).Header()
+	if err := engine.VerifyHeader(chain, header, false); err == nil {
+		t.Errorf("an unsealed activation block should be rejected")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	key := engine.privateKey
+	engine.privateKey = nil
+
+	// no signer at all
+	if _, err := engine.Sign([]byte("data")); err != errMissingSigner {
+		t.Errorf("error mismatch: have %v, want %v", err, errMissingSigner)
+	}
+
+	signed := 0
+	engine.Authorize(crypto.PubkeyToAddress(key.PublicKey), func(addr common.Address, hash []byte) ([]byte, error) {
+		if addr != crypto.PubkeyToAddress(key.PublicKey) {
+			t.Errorf("address mismatch: have %v, want %v", addr.Hex(), crypto.PubkeyToAddress(key.PublicKey).Hex())
+		}
+		signed++
+		return crypto.Sign(hash, key)
+	})
+
+	block := makeBlock(chain, engine, chain.Genesis())
+	if signed == 0 {
+		t.Errorf("the signer function should be used to seal the block")
+	}
+	if err := engine.VerifySeal(chain, block.Header()); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
+
 func TestVerifyHeaders(t *testing.T) {
 	chain, engine := newBlockChain(1)
 	genesis := chain.Genesis()
@@ -351,9 +819,7 @@ func TestVerifyHeaders(t *testing.T) {
 		blocks = append(blocks, b)
 		headers = append(headers, blocks[i].Header())
 	}
-	now = func() time.Time {
-		return time.Unix(headers[size-1].Time.Int64(), 0)
-	}
+	engine.clock = fixedClock{istanbul.SystemClock, time.Unix(headers[size-1].Time.Int64(), 0)}
 	_, results := engine.VerifyHeaders(chain, headers, nil)
 	const timeoutDura = 2 * time.Second
 	timeout := time.NewTimer(timeoutDura)
@@ -380,10 +846,18 @@ OUT1:
 	abort, results := engine.VerifyHeaders(chain, headers, nil)
 	timeout = time.NewTimer(timeoutDura)
 	index = 0
+	aborted := -1
 OUT2:
 	for {
 		select {
-		case err := <-results:
+		case err, ok := <-results:
+			if !ok {
+				// at most one buffered result is delivered after the abort
+				if aborted < 0 || index > aborted+1 {
+					t.Errorf("verifyheaders should be aborted: have %v results, want at most %v", index, aborted+1)
+				}
+				break OUT2
+			}
 			if err != nil {
 				if err != errEmptyCommittedSeals && err != errInvalidCommittedSeals {
 					t.Errorf("error mismatch: have %v, want errEmptyCommittedSeals|errInvalidCommittedSeals", err)
@@ -393,12 +867,10 @@ OUT2:
 			index++
 			if index == 5 {
 				abort <- struct{}{}
-			}
-			if index >= size {
-				t.Errorf("verifyheaders should be aborted")
-				break OUT2
+				aborted = index
 			}
 		case <-timeout.C:
+			t.Errorf("results channel should be closed after abort")
 			break OUT2
 		}
 	}
@@ -431,6 +903,205 @@ OUT3:
 	}
 }
 
+// makeHeaders creates a chain of n headers on top of the genesis, with the
+// proposer seal but without committed seals.
+func makeHeaders(chain *core.BlockChain, engine *backend, n int) []*types.Header {
+	parent := chain.Genesis()
+	headers := make([]*types.Header, 0, n)
+	for i := 0; i < n; i++ {
+		block, _ := engine.updateBlock(parent.Header(), makeBlockWithoutSeal(chain, engine, parent))
+		headers = append(headers, block.Header())
+		parent = block
+	}
+	return headers
+}
+
+// verifyHeaders verifies the headers in a batch and collects the results.
+func verifyHeaders(chain *core.BlockChain, engine *backend, headers []*types.Header) []error {
+	_, results := engine.VerifyHeaders(chain, headers, nil)
+	var errs []error
+	for err := range results {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func TestVerifyHeadersCache(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	headers := makeHeaders(chain, engine, 20)
+	engine.clock = fixedClock{istanbul.SystemClock, time.Unix(headers[len(headers)-1].Time.Int64(), 0)}
+
+	// break a header in the middle, so the results differ along the chain
+	headers[5].Nonce = types.BlockNonce{0x01}
+
+	verifiedHeaders := engine.verifiedHeaders
+	engine.verifiedHeaders = nil
+	want := verifyHeaders(chain, engine, headers)
+
+	engine.verifiedHeaders = verifiedHeaders
+	for i := 0; i < 2; i++ {
+		have := verifyHeaders(chain, engine, headers)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("run %d: results mismatch: have %v, want %v", i, have, want)
+		}
+	}
+	if engine.verifiedHeaders.Len() == 0 {
+		t.Errorf("the verification results should be cached")
+	}
+	// the headers verified on their own miss their ancestors in the database
+	for i, header := range headers {
+		engine.verifiedHeaders = nil
+		want := engine.VerifyHeader(chain, header, false)
+		engine.verifiedHeaders = verifiedHeaders
+		if err := engine.VerifyHeader(chain, header, false); err != want {
+			t.Errorf("header %d: error mismatch: have %v, want %v", i, err, want)
+		}
+	}
+}
+
+// tamperedChain serves the chain with one of its headers replaced, as in an
+// altered chain export.
+type tamperedChain struct {
+	*core.BlockChain
+	header *types.Header
+}
+
+func (c *tamperedChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if number == c.header.Number.Uint64() {
+		if hash != c.header.Hash() {
+			return nil
+		}
+		return c.header
+	}
+	return c.BlockChain.GetHeader(hash, number)
+}
+
+func (c *tamperedChain) GetHeaderByNumber(number uint64) *types.Header {
+	if number == c.header.Number.Uint64() {
+		return c.header
+	}
+	return c.BlockChain.GetHeaderByNumber(number)
+}
+
+func TestVerifyChain(t *testing.T) {
+	chain, engine, keys := newBlockChainWithKeys(1)
+	config := engine.config
+
+	parent := chain.Genesis()
+	for i := 1; i <= 100; i++ {
+		// Keep the chain in the past, rather than sealing it in real time
+		header := makeBlockWithoutSeal(chain, engine, parent).Header()
+		header.Time = new(big.Int).Add(parent.Time(), new(big.Int).SetUint64(config.BlockPeriod))
+		sig, _ := crypto.Sign(config.DigestAt(header.Number).Sum(sealData(header, config.SigSchemeAt(header.Number))), keys[0])
+		istanbul.WriteSeal(header, sig)
+		committedSeal, _ := crypto.Sign(config.DigestAt(header.Number).Sum(istanbulCore.PrepareCommittedSeal(header.Hash(), config.SigSchemeAt(header.Number))), keys[0])
+		writeCommittedSeals(header, [][]byte{committedSeal})
+		block := types.NewBlockWithHeader(header)
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i, err)
+		}
+		parent = block
+	}
+
+	// The whole chain is valid, verified by a fresh engine
+	db, _ := ethdb.NewMemDatabase()
+	if bad, err := VerifyChain(config, db, chain, 1, 100); bad != nil || err != nil {
+		t.Errorf("bad block mismatch: have %v (%v), want nil", bad, err)
+	}
+
+	// A block altered in the middle no longer matches its seal
+	header := types.CopyHeader(chain.GetHeaderByNumber(50))
+	header.GasUsed++
+	db, _ = ethdb.NewMemDatabase()
+	bad, err := VerifyChain(config, db, &tamperedChain{chain, header}, 1, 100)
+	if bad == nil || bad.Number.Uint64() != 50 {
+		t.Errorf("bad block mismatch: have %v, want 50", bad)
+	}
+	if err != errUnauthorized {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+
+	// Blocks beyond the head can't be verified
+	if bad, err := VerifyChain(config, db, chain, 99, 101); bad != nil || err != errUnknownBlock {
+		t.Errorf("error mismatch: have %v (%v), want %v", bad, err, errUnknownBlock)
+	}
+}
+
+// BenchmarkVerifyHeaders verifies the same batch of headers repeatedly, with
+// and without the verification cache.
+func BenchmarkVerifyHeaders(b *testing.B) {
+	chain, engine := newBlockChain(1)
+	headers := makeHeaders(chain, engine, 1000)
+	engine.clock = fixedClock{istanbul.SystemClock, time.Unix(headers[len(headers)-1].Time.Int64(), 0)}
+
+	verifiedHeaders := engine.verifiedHeaders
+	b.Run("uncached", func(b *testing.B) {
+		engine.verifiedHeaders = nil
+		for i := 0; i < b.N; i++ {
+			verifyHeaders(chain, engine, headers)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		engine.verifiedHeaders = verifiedHeaders
+		for i := 0; i < b.N; i++ {
+			verifyHeaders(chain, engine, headers)
+		}
+	})
+}
+
+func TestVerifyHeadersWorkers(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	headers := makeHeaders(chain, engine, 1000)
+	engine.clock = fixedClock{istanbul.SystemClock, time.Unix(headers[len(headers)-1].Time.Int64(), 0)}
+
+	// break a few headers along the batch, so the results differ
+	headers[300].Nonce = types.BlockNonce{0x01}
+	headers[700].Difficulty = big.NewInt(2)
+
+	engine.verifiedHeaders = nil
+	config := *engine.config
+	engine.config = &config
+
+	config.VerifyWorkers = 1
+	want := verifyHeaders(chain, engine, headers)
+	if len(want) != len(headers) {
+		t.Fatalf("results mismatch: have %v, want %v", len(want), len(headers))
+	}
+	for _, workers := range []uint64{2, 3, 8, 64, 2000} {
+		config.VerifyWorkers = workers
+		engine.recents, _ = lru.NewARC(inmemorySnapshots)
+		if have := verifyHeaders(chain, engine, headers); !reflect.DeepEqual(have, want) {
+			t.Errorf("%d workers: results mismatch: have %v, want %v", workers, have, want)
+		}
+	}
+
+	// an empty batch is done at once
+	if have := verifyHeaders(chain, engine, nil); len(have) != 0 {
+		t.Errorf("results mismatch: have %v, want none", have)
+	}
+}
+
+// BenchmarkVerifyHeadersWorkers verifies a batch of headers with different
+// numbers of workers.
+func BenchmarkVerifyHeadersWorkers(b *testing.B) {
+	chain, engine := newBlockChain(1)
+	headers := makeHeaders(chain, engine, 1000)
+	engine.clock = fixedClock{istanbul.SystemClock, time.Unix(headers[len(headers)-1].Time.Int64(), 0)}
+
+	engine.verifiedHeaders = nil
+	config := *engine.config
+	engine.config = &config
+	for _, workers := range []uint64{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("%d", workers), func(b *testing.B) {
+			config.VerifyWorkers = workers
+			for i := 0; i < b.N; i++ {
+				engine.recents, _ = lru.NewARC(inmemorySnapshots)
+				verifyHeaders(chain, engine, headers)
+			}
+		})
+	}
+}
+
 func TestPrepareExtra(t *testing.T) {
 	validators := make([]common.Address, 4)
 	validators[0] = common.BytesToAddress(hexutil.MustDecode("0x44add0ec310f115a0e603b2d7db9f067778eaf8a"))
@@ -462,10 +1133,10 @@ func TestPrepareExtra(t *testing.T) {
 	}
 }
 
-func TestWriteSeal(t *testing.T) {
+func TestWriteCommittedSeals(t *testing.T) {
 	vanity := bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity)
 	istRawData := hexutil.MustDecode("0xf858f8549444add0ec310f115a0e603b2d7db9f067778eaf8a94294fc7e8f22b3bcdcf955dd7ff3ba2ed833f8212946beaaed781d2d2ab6350f5c4566a2c6eaac407a6948be76812f765c24641ec63dc2852b378aba2b44080c0")
-	expectedSeal := append([]byte{1, 2, 3}, bytes.Repeat([]byte{0x00}, types.IstanbulExtraSeal-3)...)
+	expectedCommittedSeal := append([]byte{1, 2, 3}, bytes.Repeat([]byte{0x00}, types.IstanbulExtraSeal-3)...)
 	expectedIstExtra := &types.IstanbulExtra{
 		Validators: []common.Address{
 			common.BytesToAddress(hexutil.MustDecode("0x44add0ec310f115a0e603b2d7db9f067778eaf8a")),
@@ -473,8 +1144,8 @@ func TestWriteSeal(t *testing.T) {
 			common.BytesToAddress(hexutil.MustDecode("0x6beaaed781d2d2ab6350f5c4566a2c6eaac407a6")),
 			common.BytesToAddress(hexutil.MustDecode("0x8be76812f765c24641ec63dc2852b378aba2b440")),
 		},
-		Seal:          expectedSeal,
-		CommittedSeal: [][]byte{},
+		Seal:          []byte{},
+		CommittedSeal: [][]byte{expectedCommittedSeal},
 	}
 	var expectedErr error
 
@@ -483,7 +1154,7 @@ func TestWriteSeal(t *testing.T) {
 	}
 
 	// normal case
-	err := writeSeal(h, expectedSeal)
+	err := writeCommittedSeals(h, [][]byte{expectedCommittedSeal})
 	if err != expectedErr {
 		t.Errorf("error mismatch: have %v, want %v", err, expectedErr)
 	}
@@ -498,52 +1169,533 @@ func TestWriteSeal(t *testing.T) {
 	}
 
 	// invalid seal
-	unexpectedSeal := append(expectedSeal, make([]byte, 1)...)
-	err = writeSeal(h, unexpectedSeal)
-	if err != errInvalidSignature {
-		t.Errorf("error mismatch: have %v, want %v", err, errInvalidSignature)
+	unexpectedCommittedSeal := append(expectedCommittedSeal, make([]byte, 1)...)
+	err = writeCommittedSeals(h, [][]byte{unexpectedCommittedSeal})
+	if err != errInvalidCommittedSeals {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidCommittedSeals)
 	}
 }
 
-func TestWriteCommittedSeals(t *testing.T) {
-	vanity := bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity)
-	istRawData := hexutil.MustDecode("0xf858f8549444add0ec310f115a0e603b2d7db9f067778eaf8a94294fc7e8f22b3bcdcf955dd7ff3ba2ed833f8212946beaaed781d2d2ab6350f5c4566a2c6eaac407a6948be76812f765c24641ec63dc2852b378aba2b44080c0")
-	expectedCommittedSeal := append([]byte{1, 2, 3}, bytes.Repeat([]byte{0x00}, types.IstanbulExtraSeal-3)...)
-	expectedIstExtra := &types.IstanbulExtra{
-		Validators: []common.Address{
-			common.BytesToAddress(hexutil.MustDecode("0x44add0ec310f115a0e603b2d7db9f067778eaf8a")),
-			common.BytesToAddress(hexutil.MustDecode("0x294fc7e8f22b3bcdcf955dd7ff3ba2ed833f8212")),
-			common.BytesToAddress(hexutil.MustDecode("0x6beaaed781d2d2ab6350f5c4566a2c6eaac407a6")),
-			common.BytesToAddress(hexutil.MustDecode("0x8be76812f765c24641ec63dc2852b378aba2b440")),
+func TestVerifyCommittedSeals(t *testing.T) {
+	chain, engine, keys := newBlockChainWithKeys(4)
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	header := block.Header()
+	sig, err := engine.Sign(sigHash(header).Bytes())
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if err := istanbul.WriteSeal(header, sig); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	// committed seals are signed over the header hash, which excludes the committed seals
+	commitSeal := func(key *ecdsa.PrivateKey) []byte {
+		seal, _ := crypto.Sign(crypto.Keccak256(istanbulCore.PrepareCommittedSeal(header.Hash(), istanbul.LegacySigScheme)), key)
+		return seal
+	}
+	stranger, _ := crypto.GenerateKey()
+
+	testCases := []struct {
+		seals       [][]byte
+		expectedErr error
+	}{
+		{
+			// 2F+1 seals
+			[][]byte{commitSeal(keys[0]), commitSeal(keys[1]), commitSeal(keys[2])},
+			nil,
+		},
+		{
+			// all validators
+			[][]byte{commitSeal(keys[0]), commitSeal(keys[1]), commitSeal(keys[2]), commitSeal(keys[3])},
+			nil,
+		},
+		{
+			// less than 2F+1 seals
+			[][]byte{commitSeal(keys[0]), commitSeal(keys[1])},
+			errInvalidCommittedSeals,
+		},
+		{
+			// duplicate seals
+			[][]byte{commitSeal(keys[0]), commitSeal(keys[1]), commitSeal(keys[1])},
+			errInvalidCommittedSeals,
+		},
+		{
+			// a seal from a non-validator
+			[][]byte{commitSeal(keys[0]), commitSeal(keys[1]), commitSeal(stranger)},
+			errInvalidCommittedSeals,
 		},
-		Seal:          []byte{},
-		CommittedSeal: [][]byte{expectedCommittedSeal},
 	}
-	var expectedErr error
+	for i, test := range testCases {
+		h := types.CopyHeader(header)
+		if err := writeCommittedSeals(h, test.seals); err != nil {
+			t.Fatalf("test %d: error mismatch: have %v, want nil", i, err)
+		}
+		// the committed seals survive the extra-data round trip
+		extra, err := types.ExtractIstanbulExtra(h)
+		if err != nil {
+			t.Fatalf("test %d: error mismatch: have %v, want nil", i, err)
+		}
+		if !reflect.DeepEqual(extra.CommittedSeal, test.seals) {
+			t.Errorf("test %d: committed seals mismatch: have %v, want %v", i, extra.CommittedSeal, test.seals)
+		}
+		if h.Hash() != header.Hash() {
+			t.Errorf("test %d: header hash mismatch: have %v, want %v", i, h.Hash().Hex(), header.Hash().Hex())
+		}
+		if err := engine.verifyCommittedSeals(chain, h, nil); err != test.expectedErr {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.expectedErr)
+		}
+	}
+}
 
-	h := &types.Header{
-		Extra: append(vanity, istRawData...),
+func TestSigScheme(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(1)
+	config := *istanbul.DefaultConfig
+	config.SigScheme = istanbul.DomainSigScheme
+	config.SigSchemeBlock = big.NewInt(1)
+	chain, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	if scheme := config.SigSchemeAt(common.Big0); scheme != istanbul.LegacySigScheme {
+		t.Errorf("scheme mismatch: have %v, want %v", scheme, istanbul.LegacySigScheme)
 	}
 
-	// normal case
-	err := writeCommittedSeals(h, [][]byte{expectedCommittedSeal})
-	if err != expectedErr {
-		t.Errorf("error mismatch: have %v, want %v", err, expectedErr)
+	sealed := func(seal []byte) *types.Header {
+		header := block.Header()
+		istanbul.WriteSeal(header, seal)
+		return header
 	}
+	header := block.Header()
+	seal, _ := engine.Sign(sealData(header, config.SigSchemeAt(header.Number)))
+	header = sealed(seal)
+	committedSeal, _ := engine.Sign(istanbulCore.PrepareCommittedSeal(header.Hash(), config.SigSchemeAt(header.Number)))
 
-	// verify istanbul extra-data
-	istExtra, err := types.ExtractIstanbulExtra(h)
+	if err := engine.verifySigner(chain, header, nil); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	writeCommittedSeals(header, [][]byte{committedSeal})
+	if err := engine.verifyCommittedSeals(chain, header, nil); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+
+	// A seal doesn't validate as a committed seal
+	writeCommittedSeals(header, [][]byte{seal})
+	if err := engine.verifyCommittedSeals(chain, header, nil); err != errInvalidCommittedSeals {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidCommittedSeals)
+	}
+	// A committed seal doesn't validate as a seal
+	if err := engine.verifySigner(chain, sealed(committedSeal), nil); err != errUnauthorized {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+	// Nor does a seal signed in another scheme
+	legacySeal, _ := engine.Sign(sealData(header, istanbul.LegacySigScheme))
+	if err := engine.verifySigner(chain, sealed(legacySeal), nil); err != errUnauthorized {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+}
+
+func TestUnanimousVerifyHeader(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(4)
+	config := *istanbul.DefaultConfig
+	config.Unanimous = true
+	chain, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+	defer engine.Stop()
+
+	// A node committing unanimously still accepts the blocks committed by 2F+1
+	header := makeBlockWithoutSeal(chain, engine, chain.Genesis()).Header()
+	sig, _ := crypto.Sign(config.DigestAt(header.Number).Sum(sealData(header, config.SigSchemeAt(header.Number))), keys[0])
+	istanbul.WriteSeal(header, sig)
+	var committedSeals [][]byte
+	for _, key := range keys[:3] {
+		committedSeal, _ := crypto.Sign(config.DigestAt(header.Number).Sum(istanbulCore.PrepareCommittedSeal(header.Hash(), config.SigSchemeAt(header.Number))), key)
+		committedSeals = append(committedSeals, committedSeal)
+	}
+	writeCommittedSeals(header, committedSeals)
+	if err := engine.verifyCommittedSeals(chain, header, nil); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	// but not by fewer
+	writeCommittedSeals(header, committedSeals[:2])
+	if err := engine.verifyCommittedSeals(chain, header, nil); err != errInvalidCommittedSeals {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidCommittedSeals)
+	}
+}
+
+func TestGetFinalityProof(t *testing.T) {
+	chain, engine, keys := newBlockChainWithKeys(4)
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+	header := block.Header()
+	sig, _ := engine.Sign(sigHash(header).Bytes())
+	istanbul.WriteSeal(header, sig)
+	var seals [][]byte
+	for _, key := range keys[:3] {
+		seal, _ := crypto.Sign(crypto.Keccak256(istanbulCore.PrepareCommittedSeal(header.Hash(), istanbul.LegacySigScheme)), key)
+		seals = append(seals, seal)
+	}
+	writeCommittedSeals(header, seals)
+	block = block.WithSeal(header)
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+
+	api := &API{chain: chain, istanbul: engine}
+	genesisNumber := rpc.BlockNumber(0)
+	if _, err := api.GetFinalityProof(&genesisNumber); err != errUnknownBlock {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnknownBlock)
+	}
+	proof, err := api.GetFinalityProof(nil)
 	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	for i, key := range keys[:3] {
+		if addr := crypto.PubkeyToAddress(key.PublicKey); proof.Validators[i] != addr {
+			t.Errorf("validator mismatch: have %v, want %v", proof.Validators[i].Hex(), addr.Hex())
+		}
+	}
+
+	// a light client verifies the proof with the validators of the parent block
+	snap, err := engine.snapshot(chain, 0, chain.Genesis().Hash(), nil)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if err := istanbulCore.VerifyFinalityProof(block.Header(), proof, snap.ValSet, istanbul.LegacySigScheme, istanbul.Keccak256Digest); err != nil {
 		t.Errorf("error mismatch: have %v, want nil", err)
 	}
-	if !reflect.DeepEqual(istExtra, expectedIstExtra) {
-		t.Errorf("extra data mismatch: have %v, want %v", istExtra, expectedIstExtra)
+}
+
+func TestValidatorsRoot(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	config := *engine.config
+	config.Epoch = 2
+	config.ValidatorsRootBlock = big.NewInt(0)
+	engine.config = &config
+
+	// only the checkpoint blocks carry the root of their validators
+	block1 := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block1}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
 	}
+	engine.NewChainHead()
+	block2 := makeBlock(chain, engine, block1)
 
-	// invalid seal
-	unexpectedCommittedSeal := append(expectedCommittedSeal, make([]byte, 1)...)
-	err = writeCommittedSeals(h, [][]byte{unexpectedCommittedSeal})
-	if err != errInvalidCommittedSeals {
+	for _, test := range []struct {
+		header *types.Header
+		root   common.Hash
+	}{
+		{block1.Header(), common.Hash{}},
+		{block2.Header(), istanbul.ValidatorsRoot([]common.Address{engine.Address()})},
+	} {
+		extra, err := types.ExtractIstanbulExtra(test.header)
+		if err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		if extra.ValidatorsRoot != test.root {
+			t.Errorf("block %v: root mismatch: have %v, want %v", test.header.Number, extra.ValidatorsRoot, test.root)
+		}
+	}
+
+	// a wrong or misplaced root is rejected
+	header := block2.Header()
+	istanbul.WriteValidatorsRoot(header, common.HexToHash("0x01"))
+	if err := engine.VerifyHeader(chain, header, false); err != errInvalidValidatorsRoot {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidValidatorsRoot)
+	}
+	header = block1.Header()
+	istanbul.WriteValidatorsRoot(header, common.HexToHash("0x01"))
+	if err := engine.VerifyHeader(chain, header, false); err != errInvalidValidatorsRoot {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidValidatorsRoot)
+	}
+
+	if _, err := chain.InsertChain(types.Blocks{block2}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+
+	// a light client verifies the membership with the root of the checkpoint
+	api := &API{chain: chain, istanbul: engine}
+	proof, err := api.GetMembershipProof(engine.Address(), nil)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	extra, _ := types.ExtractIstanbulExtra(block2.Header())
+	if !istanbul.VerifyMembershipProof(extra.ValidatorsRoot, proof) {
+		t.Errorf("membership proof rejected")
+	}
+	if _, err := api.GetMembershipProof(common.HexToAddress("0x01"), nil); err != istanbul.ErrUnauthorizedAddress {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrUnauthorizedAddress)
+	}
+	number := rpc.BlockNumber(1)
+	if _, err := api.GetMembershipProof(engine.Address(), &number); err != errInvalidValidatorsRoot {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidValidatorsRoot)
+	}
+}
+
+func TestObserver(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(4)
+	chain, engine := newBlockChainFromGenesis(genesis, istanbul.DefaultConfig, keys[0])
+
+	// makeCommittedBlock makes a block committed by the given validators
+	makeCommittedBlock := func(parent *types.Block, signers []*ecdsa.PrivateKey) *types.Block {
+		block := makeBlockWithoutSeal(chain, engine, parent)
+		header := block.Header()
+		sig, _ := engine.Sign(sigHash(header).Bytes())
+		istanbul.WriteSeal(header, sig)
+		var seals [][]byte
+		for _, key := range signers {
+			seal, _ := crypto.Sign(crypto.Keccak256(istanbulCore.PrepareCommittedSeal(header.Hash(), istanbul.LegacySigScheme)), key)
+			seals = append(seals, seal)
+		}
+		writeCommittedSeals(header, seals)
+		return block.WithSeal(header)
+	}
+
+	// The validators commit a few blocks
+	var blocks types.Blocks
+	parent := chain.Genesis()
+	for i := 0; i < 3; i++ {
+		block := makeCommittedBlock(parent, keys[:3])
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i, err)
+		}
+		blocks = append(blocks, block)
+		parent = block
+	}
+
+	observerKey, _ := crypto.GenerateKey()
+	config := *istanbul.DefaultConfig
+	config.Observer = true
+	observerChain, observer := newBlockChainFromGenesis(genesis, &config, observerKey)
+
+	if _, err := observerChain.InsertChain(blocks); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if head := observerChain.CurrentBlock(); head.Hash() != parent.Hash() {
+		t.Errorf("head mismatch: have %v, want %v", head.Hash().Hex(), parent.Hash().Hex())
+	}
+	// Blocks without enough committed seals are rejected
+	if _, err := observerChain.InsertChain(types.Blocks{makeCommittedBlock(parent, keys[:2])}); err != errInvalidCommittedSeals {
 		t.Errorf("error mismatch: have %v, want %v", err, errInvalidCommittedSeals)
 	}
+
+	// The observer never takes part in the consensus
+	if observer.coreStarted {
+		t.Errorf("the core of an observer should not be started")
+	}
+	if err := observer.Start(observerChain, observerChain.CurrentBlock, observerChain.HasBadBlock); err != errObserver {
+		t.Errorf("error mismatch: have %v, want %v", err, errObserver)
+	}
+	if _, err := observer.Seal(observerChain, makeBlockWithoutSeal(observerChain, observer, parent), nil); err != errObserver {
+		t.Errorf("error mismatch: have %v, want %v", err, errObserver)
+	}
+	if handled, err := observer.HandleMsg(engine.Address(), p2p.Msg{Code: istanbulMsg}); !handled || err != nil {
+		t.Errorf("message handling mismatch: have %v %v, want true nil", handled, err)
+	}
+	snap, err := observer.snapshot(observerChain, parent.NumberU64(), parent.Hash(), nil)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if _, v := snap.ValSet.GetByAddress(observer.Address()); v != nil || snap.ValSet.Size() != len(keys) {
+		t.Errorf("the observer should not be a validator")
+	}
+}
+
+func TestReorgRecovery(t *testing.T) {
+	chain, engine, keys := newBlockChainWithKeys(1)
+	config := engine.config
+	genesis := chain.Genesis()
+
+	block := makeBlock(chain, engine, genesis)
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	engine.NewChainHead()
+
+	// The chain is rewound and a competing block is inserted at the same
+	// height, e.g. by an operator
+	header := makeBlockWithoutSeal(chain, engine, genesis).Header()
+	header.Time = new(big.Int).Add(genesis.Time(), new(big.Int).SetUint64(config.BlockPeriod))
+	sig, _ := crypto.Sign(config.DigestAt(header.Number).Sum(sealData(header, config.SigSchemeAt(header.Number))), keys[0])
+	istanbul.WriteSeal(header, sig)
+	committedSeal, _ := crypto.Sign(config.DigestAt(header.Number).Sum(istanbulCore.PrepareCommittedSeal(header.Hash(), config.SigSchemeAt(header.Number))), keys[0])
+	writeCommittedSeals(header, [][]byte{committedSeal})
+	competing := types.NewBlockWithHeader(header)
+	if err := chain.SetHead(0); err != nil {
+		t.Fatalf("failed to rewind chain: %v", err)
+	}
+	if _, err := chain.InsertChain(types.Blocks{competing}); err != nil {
+		t.Fatalf("failed to insert competing block: %v", err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != competing.Hash() {
+		t.Fatalf("head mismatch: have %v, want %v", head.Hex(), competing.Hash().Hex())
+	}
+	engine.NewChainHead()
+
+	// The consensus recovers and commits the next block on top of it
+	next := makeBlock(chain, engine, competing)
+	if next == nil {
+		t.Fatalf("the next block should be committed")
+	}
+	if next.ParentHash() != competing.Hash() {
+		t.Errorf("parent mismatch: have %v, want %v", next.ParentHash().Hex(), competing.Hash().Hex())
+	}
+	if _, err := chain.InsertChain(types.Blocks{next}); err != nil {
+		t.Errorf("failed to insert the next block: %v", err)
+	}
+}
+
+func TestConsensusEvents(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	defer engine.Stop()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName("istanbul", &API{chain: chain, istanbul: engine}); err != nil {
+		t.Fatal(err)
+	}
+	client := rpc.DialInProc(server)
+	defer client.Close()
+
+	events := make(chan istanbul.ConsensusEvent, 16)
+	sub, err := client.Subscribe(context.Background(), "istanbul", events, "consensusEvents")
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	defer sub.Unsubscribe()
+
+	// The server only activates the subscription after replying, wait for it
+	// with probes the consensus never reports, at sequence 0
+	for active := false; !active; {
+		engine.EventMux().Post(istanbul.ConsensusEvent{Type: istanbul.ConsensusViewChanged})
+		select {
+		case <-events:
+			active = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	block := makeBlock(chain, engine, chain.Genesis())
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	engine.NewChainHead()
+
+	want := []istanbul.ConsensusEvent{
+		{Type: istanbul.ConsensusPreprepare, Sequence: 1, Digest: block.Hash()},
+		{Type: istanbul.ConsensusPrepared, Sequence: 1, Digest: block.Hash()},
+		{Type: istanbul.ConsensusCommitted, Sequence: 1, Digest: block.Hash()},
+		{Type: istanbul.ConsensusViewChanged, Sequence: 2},
+	}
+	for i := 0; i < len(want); {
+		select {
+		case have := <-events:
+			if have.Sequence == 0 {
+				continue
+			}
+			if have != want[i] {
+				t.Errorf("event %d mismatch: have %+v, want %+v", i, have, want[i])
+			}
+			i++
+		case err := <-sub.Err():
+			t.Fatalf("subscription failed: %v", err)
+		case <-time.After(time.Second):
+			t.Fatalf("event %d missing: want %+v", i, want[i])
+		}
+	}
+}
+
+func TestGetProposerAt(t *testing.T) {
+	chain, engine, keys := newBlockChainWithKeys(2)
+	config := engine.config
+
+	// The validators take turns to seal the blocks
+	parent := chain.Genesis()
+	proposers := []*ecdsa.PrivateKey{keys[0], keys[1], keys[1], keys[0]}
+	for i, key := range proposers {
+		header := makeBlockWithoutSeal(chain, engine, parent).Header()
+		header.Time = new(big.Int).Add(parent.Time(), new(big.Int).SetUint64(config.BlockPeriod))
+		sig, _ := crypto.Sign(config.DigestAt(header.Number).Sum(sealData(header, config.SigSchemeAt(header.Number))), key)
+		istanbul.WriteSeal(header, sig)
+		committedSeal, _ := crypto.Sign(config.DigestAt(header.Number).Sum(istanbulCore.PrepareCommittedSeal(header.Hash(), config.SigSchemeAt(header.Number))), key)
+		writeCommittedSeals(header, [][]byte{committedSeal})
+		block := types.NewBlockWithHeader(header)
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i+1, err)
+		}
+		parent = block
+	}
+
+	api := &API{chain: chain, istanbul: engine}
+	for i, key := range proposers {
+		number := rpc.BlockNumber(i + 1)
+		proposer, err := api.GetProposerAt(&number)
+		if err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		if want := crypto.PubkeyToAddress(key.PublicKey); proposer != want {
+			t.Errorf("block %d: proposer mismatch: have %v, want %v", number, proposer.Hex(), want.Hex())
+		}
+	}
+	if proposer, _ := api.GetProposerAt(nil); proposer != crypto.PubkeyToAddress(keys[0].PublicKey) {
+		t.Errorf("latest proposer mismatch: have %v, want %v", proposer.Hex(), crypto.PubkeyToAddress(keys[0].PublicKey).Hex())
+	}
+	unknown := rpc.BlockNumber(len(proposers) + 1)
+	if _, err := api.GetProposerAt(&unknown); err != errUnknownBlock {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnknownBlock)
+	}
+}
+
+func TestFinalitySync(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(4)
+	chain, engine := newBlockChainFromGenesis(genesis, istanbul.DefaultConfig, keys[0])
+	defer engine.Stop()
+	config := engine.config
+
+	// The blocks are committed by 2F+1 of the 4 validators
+	var blocks types.Blocks
+	parent := chain.Genesis()
+	for i := 1; i <= 10; i++ {
+		header := makeBlockWithoutSeal(chain, engine, parent).Header()
+		header.Time = new(big.Int).Add(parent.Time(), new(big.Int).SetUint64(config.BlockPeriod))
+		sig, _ := crypto.Sign(config.DigestAt(header.Number).Sum(sealData(header, config.SigSchemeAt(header.Number))), keys[0])
+		istanbul.WriteSeal(header, sig)
+		var committedSeals [][]byte
+		for _, key := range keys[:3] {
+			committedSeal, _ := crypto.Sign(config.DigestAt(header.Number).Sum(istanbulCore.PrepareCommittedSeal(header.Hash(), config.SigSchemeAt(header.Number))), key)
+			committedSeals = append(committedSeals, committedSeal)
+		}
+		writeCommittedSeals(header, committedSeals)
+		block := types.NewBlockWithHeader(header)
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i, err)
+		}
+		blocks = append(blocks, block)
+		parent = block
+	}
+
+	syncConfig := *istanbul.DefaultConfig
+	syncConfig.FinalitySync = true
+
+	// A node syncs the chain, verifying the blocks by their committed seals
+	synced, syncEngine := newBlockChainFromGenesis(genesis, &syncConfig, keys[3])
+	defer syncEngine.Stop()
+	if _, err := synced.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to sync the chain: %v", err)
+	}
+	if head := synced.CurrentBlock().Hash(); head != blocks[9].Hash() {
+		t.Errorf("head mismatch: have %v, want %v", head.Hex(), blocks[9].Hash().Hex())
+	}
+
+	// A committed seal replaced by one of a non-validator is rejected
+	stranger, _ := crypto.GenerateKey()
+	header := blocks[4].Header()
+	extra, _ := types.ExtractIstanbulExtra(header)
+	extra.CommittedSeal[2], _ = crypto.Sign(config.DigestAt(header.Number).Sum(istanbulCore.PrepareCommittedSeal(header.Hash(), config.SigSchemeAt(header.Number))), stranger)
+	writeCommittedSeals(header, extra.CommittedSeal)
+	tampered := append(append(types.Blocks{}, blocks[:4]...), types.NewBlockWithHeader(header))
+	tampered = append(tampered, blocks[5:]...)
+
+	synced, syncEngine = newBlockChainFromGenesis(genesis, &syncConfig, keys[3])
+	defer syncEngine.Stop()
+	if index, err := synced.InsertChain(tampered); index != 4 || err != errInvalidCommittedSeals {
+		t.Errorf("error mismatch: have %v at %d, want %v at 4", err, index, errInvalidCommittedSeals)
+	}
+	if head := synced.CurrentBlock().NumberU64(); head != 4 {
+		t.Errorf("head mismatch: have %v, want 4", head)
+	}
 }
@@ -33,6 +33,9 @@ const (
 var (
 	// errDecodeFailed is returned when decode message fails
 	errDecodeFailed = errors.New("fail to decode istanbul message")
+	// errUnknownPeer is returned when a message is sent to a validator which
+	// is not connected
+	errUnknownPeer = errors.New("unknown peer")
 )
 
 // Protocol implements consensus.Engine.Protocol
@@ -50,41 +53,69 @@ func (sb *backend) HandleMsg(addr common.Address, msg p2p.Msg) (bool, error) {
 	defer sb.coreMu.Unlock()
 
 	if msg.Code == istanbulMsg {
+		// Observers drop the consensus messages without relaying them
+		if sb.config.Observer {
+			return true, nil
+		}
 		if !sb.coreStarted {
 			return true, istanbul.ErrStoppedEngine
 		}
 
+		// The consensus messages are only accepted on the validator transport
+		if sb.transport != nil {
+			return true, nil
+		}
+
 		var data []byte
 		if err := msg.Decode(&data); err != nil {
 			return true, errDecodeFailed
 		}
+		sb.handleConsensusMsg(addr, data)
+		return true, nil
+	}
+	return false, nil
+}
 
-		hash := istanbul.RLPHash(data)
-
-		// Mark peer's message
-		ms, ok := sb.recentMessages.Get(addr)
-		var m *lru.ARCCache
-		if ok {
-			m, _ = ms.(*lru.ARCCache)
-		} else {
-			m, _ = lru.NewARC(inmemoryMessages)
-			sb.recentMessages.Add(addr, m)
-		}
-		m.Add(hash, true)
+// handleConsensusMsg marks the message as known by the peer, and posts it to
+// the core unless it's known already. The messages beyond the rate limit of
+// the peer are dropped before the core checks their signature. It's called
+// with coreMu held.
+func (sb *backend) handleConsensusMsg(addr common.Address, data []byte) {
+	if !sb.allowPeerMessage(addr) {
+		sb.logger.Trace("Dropped message beyond the peer rate", "addr", addr)
+		sb.rateLimitedMeter.Mark(1)
+		return
+	}
+	hash := istanbul.RLPHash(data)
+	sb.markPeerMessage(addr, hash)
 
-		// Mark self known message
-		if _, ok := sb.knownMessages.Get(hash); ok {
-			return true, nil
-		}
-		sb.knownMessages.Add(hash, true)
+	// Mark self known message
+	if _, ok := sb.knownMessages.Get(hash); ok {
+		return
+	}
+	sb.knownMessages.Add(hash, true)
 
-		go sb.istanbulEventMux.Post(istanbul.MessageEvent{
-			Payload: data,
-		})
+	go sb.istanbulEventMux.Post(istanbul.MessageEvent{
+		Payload: data,
+	})
+}
 
-		return true, nil
+// markPeerMessage marks the message as known by the peer, and reports whether
+// it was known already.
+func (sb *backend) markPeerMessage(addr common.Address, hash common.Hash) bool {
+	ms, ok := sb.recentMessages.Get(addr)
+	var m *lru.ARCCache
+	if ok {
+		m, _ = ms.(*lru.ARCCache)
+		if _, k := m.Get(hash); k {
+			return true
+		}
+	} else {
+		m, _ = lru.NewARC(inmemoryMessages)
+		sb.recentMessages.Add(addr, m)
 	}
-	return false, nil
+	m.Add(hash, true)
+	return false
 }
 
 // SetBroadcaster implements consensus.Handler.SetBroadcaster
@@ -92,12 +123,26 @@ func (sb *backend) SetBroadcaster(broadcaster consensus.Broadcaster) {
 	sb.broadcaster = broadcaster
 }
 
+// PeerConnected implements consensus.Handler.PeerConnected
+func (sb *backend) PeerConnected(addr common.Address) {
+	sb.coreMu.RLock()
+	defer sb.coreMu.RUnlock()
+	if !sb.coreStarted {
+		return
+	}
+	go sb.istanbulEventMux.Post(istanbul.ConnectionEvent{
+		Address: addr,
+	})
+}
+
 func (sb *backend) NewChainHead() error {
 	sb.coreMu.RLock()
 	defer sb.coreMu.RUnlock()
 	if !sb.coreStarted {
 		return istanbul.ErrStoppedEngine
 	}
+	// The round of the new head is over, the next one uses the rotated key
+	sb.switchRotatedKey()
 	go sb.istanbulEventMux.Post(istanbul.FinalCommittedEvent{})
 	return nil
 }
@@ -17,10 +17,13 @@
 package backend
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/metrics"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/rlp"
 	lru "github.com/hashicorp/golang-lru"
@@ -66,6 +69,61 @@ func TestIstanbulMessage(t *testing.T) {
 	}
 }
 
+func TestPeerMessageRate(t *testing.T) {
+	_, backend := newBlockChain(1)
+	defer backend.Stop()
+	config := *backend.config
+	config.PeerMessageRate = 10
+	config.PeerMessageBurst = 20
+	backend.config = &config
+	clock := istanbul.NewSimulatedClock()
+	backend.clock = clock
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+	backend.rateLimitedMeter = metrics.NewMeter()
+
+	// handle sends distinct messages from the peer, and returns how many of
+	// them reached the core
+	var sent int
+	handle := func(addr common.Address, n int) int {
+		handled := 0
+		for i := 0; i < n; i++ {
+			data := []byte(fmt.Sprintf("data%d", sent))
+			sent++
+			if _, err := backend.HandleMsg(addr, makeMsg(istanbulMsg, data)); err != nil {
+				t.Fatalf("handle message failed: %v", err)
+			}
+			if _, ok := backend.knownMessages.Get(istanbul.RLPHash(data)); ok {
+				handled++
+			}
+		}
+		return handled
+	}
+	flooder := common.StringToAddress("flooder")
+	peer := common.StringToAddress("peer")
+
+	// The flooder only gets its burst through, without affecting the others
+	if handled := handle(flooder, 100); handled != 20 {
+		t.Errorf("flooder messages mismatch: have %v, want 20", handled)
+	}
+	if handled := handle(peer, 20); handled != 20 {
+		t.Errorf("peer messages mismatch: have %v, want 20", handled)
+	}
+	if dropped := backend.rateLimitedMeter.Count(); dropped != 80 {
+		t.Errorf("dropped messages mismatch: have %v, want 80", dropped)
+	}
+
+	// and then the rate
+	clock.Run(500 * time.Millisecond)
+	if handled := handle(flooder, 100); handled != 5 {
+		t.Errorf("flooder messages mismatch: have %v, want 5", handled)
+	}
+	clock.Run(10 * time.Second)
+	if handled := handle(flooder, 100); handled != 20 {
+		t.Errorf("flooder messages mismatch: have %v, want 20", handled)
+	}
+}
+
 func makeMsg(msgcode uint64, data interface{}) p2p.Msg {
 	size, r, _ := rlp.EncodeToReader(data)
 	return p2p.Msg{Code: msgcode, Size: uint32(size), Payload: r}
@@ -0,0 +1,103 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// errNoJail is returned when jailing a validator while the engine has no jail
+// to add it to.
+var errNoJail = errors.New("no jail")
+
+// Jail tells the validators jailed for misbehaving, e.g. after evidence of
+// equivocation. A jailed validator is skipped by the proposer selection of the
+// core. The jail is local to the node, so it doesn't change the validity of the
+// blocks, but the validators should agree on it to agree on the proposers.
+type Jail interface {
+	// Jailed returns whether the validator is jailed at the given height.
+	Jailed(addr common.Address, number uint64) bool
+}
+
+// MemoryJail is a Jail keeping each validator jailed for a fixed window of
+// blocks.
+type MemoryJail struct {
+	mu     sync.RWMutex
+	window uint64
+	from   map[common.Address]uint64
+}
+
+// NewMemoryJail creates an empty jail keeping the validators for the given
+// number of blocks.
+func NewMemoryJail(window uint64) *MemoryJail {
+	return &MemoryJail{
+		window: window,
+		from:   make(map[common.Address]uint64),
+	}
+}
+
+// Jail jails the validator from the given height on, for the window.
+func (j *MemoryJail) Jail(addr common.Address, number uint64) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.from[addr] = number
+}
+
+// Jailed implements Jail.Jailed
+func (j *MemoryJail) Jailed(addr common.Address, number uint64) bool {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	from, ok := j.from[addr]
+	return ok && number >= from && number-from < j.window
+}
+
+// SetJail makes the core skip the validators in the jail when selecting the
+// proposer. It's meant to be called before the engine starts, which otherwise
+// has a MemoryJail if the config has a JailWindow.
+func (sb *backend) SetJail(jail Jail) {
+	sb.jailMu.Lock()
+	defer sb.jailMu.Unlock()
+
+	sb.jail = jail
+}
+
+// Jailed implements istanbul.Backend.Jailed
+func (sb *backend) Jailed(addr common.Address, number uint64) bool {
+	sb.jailMu.RLock()
+	defer sb.jailMu.RUnlock()
+
+	return sb.jail != nil && sb.jail.Jailed(addr, number)
+}
+
+// jailValidator jails the validator from the next block on, if the jail of the
+// engine is a MemoryJail.
+func (sb *backend) jailValidator(addr common.Address) error {
+	sb.jailMu.RLock()
+	defer sb.jailMu.RUnlock()
+
+	jail, ok := sb.jail.(*MemoryJail)
+	if !ok {
+		return errNoJail
+	}
+	jail.Jail(addr, sb.nextNumber().Uint64())
+	return nil
+}
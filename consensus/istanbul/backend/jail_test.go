@@ -0,0 +1,88 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestMemoryJail(t *testing.T) {
+	jail := NewMemoryJail(10)
+	addr := common.HexToAddress("0x1000000000000000000000000000000000000000")
+	jail.Jail(addr, 5)
+
+	tests := []struct {
+		number uint64
+		jailed bool
+	}{
+		{4, false},
+		{5, true},
+		{14, true},
+		{15, false},
+	}
+	for _, test := range tests {
+		if jailed := jail.Jailed(addr, test.number); jailed != test.jailed {
+			t.Errorf("block %d: jailed mismatch: have %v, want %v", test.number, jailed, test.jailed)
+		}
+	}
+	if jail.Jailed(common.HexToAddress("0x2000000000000000000000000000000000000000"), 5) {
+		t.Errorf("jailed mismatch: have true, want false")
+	}
+}
+
+func TestJailedSigner(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	block := makeBlock(chain, engine, chain.Genesis())
+
+	// The jail of the node doesn't change the validity of the blocks
+	jail := NewMemoryJail(1)
+	engine.SetJail(jail)
+	jail.Jail(engine.Address(), block.NumberU64())
+	if err := engine.VerifyHeader(chain, block.Header(), false); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if !engine.Jailed(engine.Address(), block.NumberU64()) {
+		t.Errorf("jailed mismatch: have false, want true")
+	}
+}
+
+func TestJailAPI(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(1)
+	config := *istanbul.DefaultConfig
+	config.JailWindow = 10
+	chain, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+	defer engine.Stop()
+
+	// the validator is jailed from the next block on
+	api := &API{chain: chain, istanbul: engine}
+	if err := api.Jail(engine.Address()); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if !engine.Jailed(engine.Address(), 1) || engine.Jailed(engine.Address(), 11) {
+		t.Errorf("jailed mismatch: want jailed at block 1 only within the window")
+	}
+
+	// without a jail window, there's no jail
+	_, engine = newBlockChain(1)
+	defer engine.Stop()
+	if err := (&API{istanbul: engine}).Jail(engine.Address()); err != errNoJail {
+		t.Errorf("error mismatch: have %v, want %v", err, errNoJail)
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/prometheus/prometheus/util/flock"
+)
+
+const (
+	// fileLeaseLockRetries is the number of attempts to lock the file of a
+	// FileLease while another instance updates it.
+	fileLeaseLockRetries = 50
+	// fileLeaseLockBackoff is the time to wait between two attempts.
+	fileLeaseLockBackoff = 10 * time.Millisecond
+)
+
+// errLeaseHeld is returned when signing while the signing lease is held by
+// another instance of the validator.
+var errLeaseHeld = errors.New("signing lease held by another instance")
+
+// Lease arbitrates the signing duties between redundant instances of a validator
+// sharing the same key. Only the holder of the lease signs, so a standby instance
+// takes over once the active one is gone, without both signing the same height.
+type Lease interface {
+	// Acquire takes or renews the lease for the holder until now+ttl. It
+	// returns false if another holder has the lease beyond now.
+	Acquire(holder string, now time.Time, ttl time.Duration) bool
+
+	// Release gives up the lease if it's held by the holder.
+	Release(holder string)
+
+	// Sign records that the holder signs a message of the view. It returns
+	// false if the lease isn't held by the holder, or recorded a later view or
+	// the same view signed by another holder, so that a new holder never signs
+	// where the previous one may already have.
+	Sign(holder string, view *istanbul.View) bool
+}
+
+// leaseState is the state shared by the instances through a lease.
+type leaseState struct {
+	Holder string         `json:"holder"`
+	Expiry time.Time      `json:"expiry"`
+	Signer string         `json:"signer"` // Holder that signed the view
+	View   *istanbul.View `json:"view"`   // Last view signed, if any
+}
+
+// acquire implements Lease.Acquire on the state.
+func (s *leaseState) acquire(holder string, now time.Time, ttl time.Duration) bool {
+	if s.Holder != "" && s.Holder != holder && now.Before(s.Expiry) {
+		return false
+	}
+	s.Holder, s.Expiry = holder, now.Add(ttl)
+	return true
+}
+
+// release implements Lease.Release on the state, returning whether it changed.
+func (s *leaseState) release(holder string) bool {
+	if s.Holder != holder {
+		return false
+	}
+	s.Holder, s.Expiry = "", time.Time{}
+	return true
+}
+
+// sign implements Lease.Sign on the state.
+func (s *leaseState) sign(holder string, view *istanbul.View) bool {
+	if s.Holder != holder {
+		return false
+	}
+	if s.View != nil {
+		if cmp := view.Cmp(s.View); cmp < 0 || cmp == 0 && s.Signer != holder {
+			return false
+		}
+	}
+	s.Signer, s.View = holder, view
+	return true
+}
+
+// MemoryLease is a Lease shared by the instances running in the same process.
+type MemoryLease struct {
+	mu    sync.Mutex
+	state leaseState
+}
+
+// NewMemoryLease creates a lease nobody holds.
+func NewMemoryLease() *MemoryLease {
+	return &MemoryLease{}
+}
+
+// Acquire implements Lease.Acquire
+func (l *MemoryLease) Acquire(holder string, now time.Time, ttl time.Duration) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.state.acquire(holder, now, ttl)
+}
+
+// Release implements Lease.Release
+func (l *MemoryLease) Release(holder string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.state.release(holder)
+}
+
+// Sign implements Lease.Sign
+func (l *MemoryLease) Sign(holder string, view *istanbul.View) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.state.sign(holder, view)
+}
+
+// FileLease is a Lease shared by the instances through a file, e.g. on storage
+// mounted by the hosts of all the instances. The file is locked while updated.
+type FileLease struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileLease creates a lease kept in the given file, which is created on the
+// first update.
+func NewFileLease(path string) *FileLease {
+	return &FileLease{path: path}
+}
+
+// Acquire implements Lease.Acquire
+func (l *FileLease) Acquire(holder string, now time.Time, ttl time.Duration) bool {
+	return l.update(func(state *leaseState) bool {
+		return state.acquire(holder, now, ttl)
+	})
+}
+
+// Release implements Lease.Release
+func (l *FileLease) Release(holder string) {
+	l.update(func(state *leaseState) bool {
+		return state.release(holder)
+	})
+}
+
+// Sign implements Lease.Sign
+func (l *FileLease) Sign(holder string, view *istanbul.View) bool {
+	return l.update(func(state *leaseState) bool {
+		return state.sign(holder, view)
+	})
+}
+
+// update applies fn to the state of the file under its lock, and writes it back
+// if fn returns true. It returns false if fn does or the file can't be updated,
+// so that an unreachable lease is never deemed held.
+func (l *FileLease) update(fn func(state *leaseState) bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var (
+		lock flock.Releaser
+		err  error
+	)
+	for i := 0; i < fileLeaseLockRetries; i++ {
+		if lock, _, err = flock.New(l.path + ".lock"); err == nil {
+			break
+		}
+		time.Sleep(fileLeaseLockBackoff)
+	}
+	if err != nil {
+		log.Warn("Failed to lock the signing lease", "path", l.path, "err", err)
+		return false
+	}
+	defer lock.Release()
+
+	var state leaseState
+	if data, err := ioutil.ReadFile(l.path); err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			log.Warn("Failed to decode the signing lease", "path", l.path, "err", err)
+			return false
+		}
+	} else if !os.IsNotExist(err) {
+		log.Warn("Failed to read the signing lease", "path", l.path, "err", err)
+		return false
+	}
+	if !fn(&state) {
+		return false
+	}
+	if err := writeLease(l.path, &state); err != nil {
+		log.Warn("Failed to write the signing lease", "path", l.path, "err", err)
+		return false
+	}
+	return true
+}
+
+// writeLease durably replaces the state in the file, through a temporary file
+// so that a crash never leaves it torn.
+func writeLease(path string, state *leaseState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// SetLease makes the engine only sign while it holds the given lease under the
+// given holder name, which must be unique among the instances. It's meant to be
+// called before the engine starts.
+func (sb *backend) SetLease(lease Lease, holder string) {
+	sb.signMu.Lock()
+	defer sb.signMu.Unlock()
+
+	sb.lease, sb.leaseHolder = lease, holder
+}
+
+// startLease takes the lease, if any, and keeps renewing it while the engine
+// runs.
+func (sb *backend) startLease() {
+	sb.signMu.Lock()
+	defer sb.signMu.Unlock()
+
+	if sb.lease == nil {
+		return
+	}
+	sb.leaseActive = true
+	sb.heartbeatLease()
+}
+
+// renewLease is the heartbeat of the lease, renewing it half way through.
+func (sb *backend) renewLease() {
+	sb.signMu.Lock()
+	defer sb.signMu.Unlock()
+
+	if sb.leaseActive {
+		sb.heartbeatLease()
+	}
+}
+
+// heartbeatLease takes or renews the lease and schedules the next heartbeat.
+// The caller must hold signMu.
+func (sb *backend) heartbeatLease() {
+	now := sb.clock.Now()
+	if !now.Before(sb.leaseExpiry) {
+		// Another instance may have signed since, the views are checked again
+		sb.leaseView = nil
+	}
+	if sb.lease.Acquire(sb.leaseHolder, now, sb.leaseTimeout()) {
+		sb.leaseExpiry = now.Add(sb.leaseTimeout())
+		sb.logger.Trace("Renewed the signing lease", "holder", sb.leaseHolder)
+	} else {
+		sb.leaseExpiry, sb.leaseView = time.Time{}, nil
+		sb.logger.Debug("Signing lease held by another instance", "holder", sb.leaseHolder)
+	}
+	sb.leaseTimer = sb.clock.AfterFunc(sb.leaseTimeout()/2, sb.renewLease)
+}
+
+// stopLease releases the lease, if any, on a clean shutdown, so that a standby
+// instance takes over without waiting for the lease to expire.
+func (sb *backend) stopLease() {
+	sb.signMu.Lock()
+	defer sb.signMu.Unlock()
+
+	if !sb.leaseActive {
+		return
+	}
+	sb.leaseActive = false
+	sb.leaseTimer.Stop()
+	sb.leaseExpiry, sb.leaseView = time.Time{}, nil
+	sb.lease.Release(sb.leaseHolder)
+}
+
+// holdsLease returns whether we may sign, a message of the view if any. The
+// lease must be held as of its last renewal, and each view is recorded in it
+// once, so that signing the messages of a view doesn't wait for the lease
+// storage. Without a lease, we always may. The caller must hold signMu.
+func (sb *backend) holdsLease(view *istanbul.View) bool {
+	if sb.lease == nil {
+		return true
+	}
+	if !sb.leaseActive || !sb.clock.Now().Before(sb.leaseExpiry) {
+		return false
+	}
+	if view == nil || (sb.leaseView != nil && view.Cmp(sb.leaseView) == 0) {
+		return true
+	}
+	if !sb.lease.Sign(sb.leaseHolder, view) {
+		return false
+	}
+	sb.leaseView = view
+	return true
+}
+
+// leaseTimeout returns the time the lease lasts without being renewed.
+func (sb *backend) leaseTimeout() time.Duration {
+	return time.Duration(sb.config.LeaseTimeout) * time.Millisecond
+}
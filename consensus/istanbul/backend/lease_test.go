@@ -0,0 +1,189 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestLease(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(1)
+	config := *istanbul.DefaultConfig
+	config.LeaseTimeout = 1000
+
+	// two instances of the same validator share the lease, each with its own
+	// clock, so that one can die while the time goes on for the other
+	lease := NewMemoryLease()
+	primaryChain, primary := newBlockChainFromGenesis(genesis, &config, keys[0])
+	standbyChain, standby := newBlockChainFromGenesis(genesis, &config, keys[0])
+	primaryClock, standbyClock := istanbul.NewSimulatedClock(), istanbul.NewSimulatedClock()
+	for _, engine := range []*backend{primary, standby} {
+		engine.Stop()
+	}
+	primary.clock, standby.clock = primaryClock, standbyClock
+	primary.SetLease(lease, "primary")
+	standby.SetLease(lease, "standby")
+	primary.Start(primaryChain, primaryChain.CurrentBlock, primaryChain.HasBadBlock)
+	standby.Start(standbyChain, standbyChain.CurrentBlock, standbyChain.HasBadBlock)
+
+	// the primary holds the lease, so only it seals the first block
+	block := makeBlock(primaryChain, primary, primaryChain.Genesis())
+	if block == nil {
+		t.Fatalf("the primary should seal the block")
+	}
+	if _, err := standby.Seal(standbyChain, makeBlockWithoutSeal(standbyChain, standby, standbyChain.Genesis()), nil); err != errLeaseHeld {
+		t.Errorf("error mismatch: have %v, want %v", err, errLeaseHeld)
+	}
+	for _, chain := range []*core.BlockChain{primaryChain, standbyChain} {
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert block: %v", err)
+		}
+	}
+	standby.NewChainHead()
+
+	// the primary hangs, the standby takes over once the lease expires
+	primary.leaseTimer.Stop()
+	primaryClock.Run(time.Duration(config.LeaseTimeout) * time.Millisecond)
+	standbyClock.Run(time.Duration(config.LeaseTimeout) * time.Millisecond)
+	if _, err := primary.Sign([]byte("data")); err != errLeaseHeld {
+		t.Errorf("error mismatch: have %v, want %v", err, errLeaseHeld)
+	}
+	if block := makeBlock(standbyChain, standby, block); block == nil {
+		t.Errorf("the standby should seal the next block")
+	}
+
+	view := &istanbul.View{Sequence: big.NewInt(2), Round: big.NewInt(1)}
+	if _, err := standby.SignAt(view, []byte("data")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+
+	// a clean shutdown hands the lease over on the next heartbeat
+	standby.Stop()
+	if _, err := standby.Sign([]byte("data")); err != errLeaseHeld {
+		t.Errorf("error mismatch: have %v, want %v", err, errLeaseHeld)
+	}
+	primary.renewLease()
+	if _, err := primary.Sign([]byte("data")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	// but not the views the standby signed
+	if _, err := primary.SignAt(view, []byte("data")); err != errLeaseHeld {
+		t.Errorf("error mismatch: have %v, want %v", err, errLeaseHeld)
+	}
+	if _, err := primary.SignAt(&istanbul.View{Sequence: big.NewInt(2), Round: big.NewInt(2)}, []byte("data")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
+
+// countingLease counts the signs recorded in the lease.
+type countingLease struct {
+	Lease
+	signs int
+}
+
+func (l *countingLease) Sign(holder string, view *istanbul.View) bool {
+	l.signs++
+	return l.Lease.Sign(holder, view)
+}
+
+func TestLeaseCache(t *testing.T) {
+	chain, engine := newBlockChain(1)
+	defer engine.Stop()
+	engine.Stop()
+	engine.clock = istanbul.NewSimulatedClock()
+	lease := &countingLease{Lease: NewMemoryLease()}
+	engine.SetLease(lease, "primary")
+	engine.Start(chain, chain.CurrentBlock, chain.HasBadBlock)
+
+	// the messages of a view are signed as of the last heartbeat
+	view := &istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(0)}
+	for i := 0; i < 3; i++ {
+		if _, err := engine.SignAt(view, []byte("data")); err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if lease.signs != 1 {
+		t.Errorf("lease signs mismatch: have %v, want %v", lease.signs, 1)
+	}
+	if _, err := engine.SignAt(&istanbul.View{Sequence: big.NewInt(1), Round: big.NewInt(1)}, []byte("data")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if lease.signs != 2 {
+		t.Errorf("lease signs mismatch: have %v, want %v", lease.signs, 2)
+	}
+}
+
+func TestLeaseView(t *testing.T) {
+	dir, err := ioutil.TempDir("", "istanbul-lease")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two file leases on the same file are the same lease
+	path := filepath.Join(dir, "lease")
+	leases := map[string][2]Lease{
+		"memory": {NewMemoryLease(), nil},
+		"file":   {NewFileLease(path), NewFileLease(path)},
+	}
+	view := func(sequence, round int64) *istanbul.View {
+		return &istanbul.View{Sequence: big.NewInt(sequence), Round: big.NewInt(round)}
+	}
+	for name, pair := range leases {
+		a, b := pair[0], pair[1]
+		if b == nil {
+			b = a
+		}
+		now := time.Unix(0, 0)
+		if !a.Acquire("a", now, time.Second) {
+			t.Fatalf("%s: a should acquire the lease", name)
+		}
+		if b.Sign("b", view(1, 0)) {
+			t.Errorf("%s: b should not sign without the lease", name)
+		}
+		// the holder signs all the messages of a view
+		for i := 0; i < 2; i++ {
+			if !a.Sign("a", view(1, 0)) {
+				t.Errorf("%s: a should sign its view", name)
+			}
+		}
+		now = now.Add(time.Second)
+		if !b.Acquire("b", now, time.Second) {
+			t.Fatalf("%s: b should take the expired lease over", name)
+		}
+		// the next holder only signs the views after
+		if b.Sign("b", view(0, 5)) || b.Sign("b", view(1, 0)) {
+			t.Errorf("%s: b should not sign the views of a", name)
+		}
+		if !b.Sign("b", view(1, 1)) {
+			t.Errorf("%s: b should sign a later view", name)
+		}
+		b.Release("b")
+		if !a.Acquire("a", now, time.Second) || a.Sign("a", view(1, 1)) {
+			t.Errorf("%s: a should not sign the views of b", name)
+		}
+	}
+}
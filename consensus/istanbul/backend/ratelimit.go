@@ -0,0 +1,71 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// tokenBucket limits the rate of the messages of a peer: every message takes
+// a token, and the tokens are refilled at a constant rate up to the burst.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // time of the last refill
+}
+
+// take refills the bucket up to now and takes a token, if any is left.
+func (b *tokenBucket) take(now time.Time, rate, burst float64) bool {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// allowPeerMessage reports whether a consensus message from the peer is within
+// its rate limit, taking a token from its bucket. The buckets of the recently
+// seen peers are kept, the others start full again. It's called with coreMu
+// held.
+func (sb *backend) allowPeerMessage(addr common.Address) bool {
+	rate := float64(sb.config.PeerMessageRate)
+	if rate == 0 {
+		return true
+	}
+	burst := float64(sb.config.PeerMessageBurst)
+	if burst < rate {
+		burst = rate
+	}
+	now := sb.clock.Now()
+
+	var bucket *tokenBucket
+	if b, ok := sb.peerBuckets.Get(addr); ok {
+		bucket = b.(*tokenBucket)
+	} else {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		sb.peerBuckets.Add(addr, bucket)
+	}
+	return bucket.take(now, rate, burst)
+}
@@ -0,0 +1,143 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+)
+
+// registryABI is the ABI of the registry contract function returning the
+// validators.
+const registryABI = `[{"constant":true,"inputs":[],"name":"getValidators","outputs":[{"name":"","type":"address[]"}],"payable":false,"stateMutability":"view","type":"function"}]`
+
+// registryGas is the gas the call to the registry contract can use.
+const registryGas = 10000000
+
+var parsedRegistryABI, _ = abi.JSON(strings.NewReader(registryABI))
+
+// From the activation of a registry contract, the validators of each epoch are
+// read from it instead of being voted in the headers: the proposer of an epoch
+// block reads them in the state of its parent and writes them in the
+// validators section of the header, the validators check them against their
+// own state before voting for it, and the set takes over from the next block.
+// The validators aren't voted anymore, the other blocks keep the validators of
+// the epoch. The headers are verified without the state, e.g. while syncing,
+// relying on the committed seals.
+
+// registryEpoch returns whether the validators of the block with the given
+// number are read from the registry contract.
+func (sb *backend) registryEpoch(number uint64) bool {
+	return sb.config.RegistryAt(new(big.Int).SetUint64(number)) != (common.Address{}) && number%sb.config.Epoch == 0
+}
+
+// registryValidators calls getValidators() on the registry contract in the
+// state of the given block.
+func (sb *backend) registryValidators(chain consensus.ChainReader, header *types.Header) ([]common.Address, error) {
+	bc, ok := chain.(blockProcessor)
+	if !ok {
+		return nil, errRegistryUnavailable
+	}
+	statedb, err := bc.StateAt(header.Root)
+	if err != nil {
+		return nil, err
+	}
+	input, err := parsedRegistryABI.Pack("getValidators")
+	if err != nil {
+		return nil, err
+	}
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash: func(n uint64) common.Hash {
+			if h := chain.GetHeaderByNumber(n); h != nil {
+				return h.Hash()
+			}
+			return common.Hash{}
+		},
+		GasPrice:    new(big.Int),
+		Coinbase:    header.Coinbase,
+		GasLimit:    header.GasLimit,
+		BlockNumber: new(big.Int).Set(header.Number),
+		Time:        new(big.Int).Set(header.Time),
+		Difficulty:  new(big.Int).Set(header.Difficulty),
+	}
+	evm := vm.NewEVM(context, statedb, chain.Config(), vm.Config{})
+	output, _, err := evm.StaticCall(vm.AccountRef(common.Address{}), sb.config.ValidatorRegistry, input, registryGas)
+	if err != nil {
+		return nil, err
+	}
+	var validators []common.Address
+	if err := parsedRegistryABI.Unpack(&validators, "getValidators", output); err != nil {
+		return nil, err
+	}
+	if len(validators) == 0 {
+		return nil, errEmptyRegistry
+	}
+	return validators, nil
+}
+
+// applyRegistry overrides the validators voted in the headers applied on top
+// of prev with those of the registry: the validators of prev, or the ones
+// carried by the last header if it's an epoch block.
+func (sb *backend) applyRegistry(prev, snap *Snapshot, last *types.Header) error {
+	snap.Votes = nil
+	snap.Tally = make(map[common.Address]Tally)
+	if !sb.registryEpoch(last.Number.Uint64()) {
+		snap.ValSet = prev.ValSet.Copy()
+		return nil
+	}
+	extra, err := types.ExtractIstanbulExtra(last)
+	if err != nil {
+		return err
+	}
+	snap.ValSet, err = validator.NewWeightedSet(extra.Validators, sb.config.WeightsOf(extra.Validators), sb.config.ProposerPolicy)
+	return err
+}
+
+// verifyRegistryValidators checks that an epoch proposal carries the
+// validators read from the registry contract in the state of its parent.
+func (sb *backend) verifyRegistryValidators(block *types.Block) error {
+	if !sb.registryEpoch(block.NumberU64()) {
+		return nil
+	}
+	parent := sb.chain.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	want, err := sb.registryValidators(sb.chain, parent)
+	if err != nil {
+		return err
+	}
+	extra, err := types.ExtractIstanbulExtra(block.Header())
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(extra.Validators, want) {
+		return errInvalidRegistryValidators
+	}
+	return nil
+}
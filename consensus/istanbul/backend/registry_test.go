@@ -0,0 +1,109 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// mockRegistryCode returns the code of a registry contract returning the
+// given validators to any call, as getValidators() does.
+func mockRegistryCode(validators []common.Address) []byte {
+	// ABI encoding of an address[]: its offset, length and padded items
+	output := make([]byte, 64+32*len(validators))
+	output[31] = 32
+	copy(output[32:64], common.LeftPadBytes(big.NewInt(int64(len(validators))).Bytes(), 32))
+	for i, validator := range validators {
+		copy(output[64+32*i+12:], validator[:])
+	}
+	size := []byte{byte(len(output) >> 8), byte(len(output))}
+
+	// CODECOPY the output appended to the code into memory and RETURN it
+	code := []byte{0x61, size[0], size[1], 0x61, 0x00, 0x0f, 0x60, 0x00, 0x39, 0x61, size[0], size[1], 0x60, 0x00, 0xf3}
+	return append(code, output...)
+}
+
+func TestValidatorRegistry(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(1)
+	registry := common.HexToAddress("0x0000000000000000000000000000000000001000")
+	self := crypto.PubkeyToAddress(keys[0].PublicKey)
+	validators := []common.Address{common.HexToAddress("0x1234"), self} // sorted
+	genesis.Alloc[registry] = core.GenesisAccount{Code: mockRegistryCode(validators), Balance: new(big.Int)}
+
+	config := *istanbul.DefaultConfig
+	config.Epoch = 2
+	config.ValidatorRegistry = registry
+	config.ValidatorRegistryBlock = big.NewInt(0)
+	chain, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+	defer engine.Stop()
+
+	// The validators aren't voted
+	engine.candidates[common.HexToAddress("0x5678")] = true
+	block := makeBlock(chain, engine, chain.Genesis())
+	if block.Coinbase() != (common.Address{}) {
+		t.Errorf("vote mismatch: have %v, want none", block.Coinbase().Hex())
+	}
+	if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	engine.NewChainHead()
+
+	// The epoch block carries the validators of the registry
+	epoch := makeBlock(chain, engine, block)
+	extra, err := types.ExtractIstanbulExtra(epoch.Header())
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if !reflect.DeepEqual(extra.Validators, validators) {
+		t.Errorf("validators mismatch: have %v, want %v", extra.Validators, validators)
+	}
+	if err := engine.verifyRegistryValidators(epoch); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	// and an epoch proposal with other validators is rejected
+	header := epoch.Header()
+	header.Extra, _ = prepareExtra(header, []common.Address{self})
+	if err := engine.verifyRegistryValidators(epoch.WithSeal(header)); err != errInvalidRegistryValidators {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidRegistryValidators)
+	}
+
+	// The registry validators take over from the epoch block
+	if _, err := chain.InsertChain(types.Blocks{epoch}); err != nil {
+		t.Fatalf("failed to insert block: %v", err)
+	}
+	snap, err := engine.snapshot(chain, 2, epoch.Hash(), nil)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if have := snap.validators(); !reflect.DeepEqual(have, validators) {
+		t.Errorf("validators mismatch: have %v, want %v", have, validators)
+	}
+	if snap, err = engine.snapshot(chain, 1, block.Hash(), nil); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if have := snap.validators(); !reflect.DeepEqual(have, []common.Address{self}) {
+		t.Errorf("validators mismatch: have %v, want %v", have, []common.Address{self})
+	}
+}
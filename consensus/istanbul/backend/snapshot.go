@@ -18,6 +18,7 @@ package backend
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/json"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -29,6 +30,7 @@ import (
 
 const (
 	dbKeySnapshotPrefix = "istanbul-snapshot"
+	dbKeyEpochPrefix    = "istanbul-epoch"
 )
 
 // Vote represents a single vote that an authorized validator made to modify the
@@ -96,6 +98,41 @@ func (s *Snapshot) store(db ethdb.Database) error {
 	return db.Put(append([]byte(dbKeySnapshotPrefix), s.Hash[:]...), blob)
 }
 
+// epochKey returns the database key of the checkpoint of the given epoch
+func epochKey(epoch uint64) []byte {
+	key := make([]byte, len(dbKeyEpochPrefix)+8)
+	copy(key, dbKeyEpochPrefix)
+	binary.BigEndian.PutUint64(key[len(dbKeyEpochPrefix):], epoch)
+	return key
+}
+
+// loadCheckpoint loads the snapshot checkpointed at the epoch boundary of the
+// given block number from the database. Only the latest checkpoint of each
+// epoch is kept, so the caller must check its hash.
+func loadCheckpoint(epoch uint64, db ethdb.Database, number uint64) (*Snapshot, error) {
+	blob, err := db.Get(epochKey(number / epoch))
+	if err != nil {
+		return nil, err
+	}
+	snap := new(Snapshot)
+	if err := json.Unmarshal(blob, snap); err != nil {
+		return nil, err
+	}
+	snap.Epoch = epoch
+
+	return snap, nil
+}
+
+// storeCheckpoint inserts the snapshot of an epoch boundary into the database,
+// keyed by its epoch number.
+func (s *Snapshot) storeCheckpoint(db ethdb.Database) error {
+	blob, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return db.Put(epochKey(s.Number/s.Epoch), blob)
+}
+
 // copy creates a deep copy of the snapshot, though not the individual votes.
 func (s *Snapshot) copy() *Snapshot {
 	cpy := &Snapshot{
@@ -159,8 +196,9 @@ func (s *Snapshot) uncast(address common.Address, authorize bool) bool {
 }
 
 // apply creates a new authorization snapshot by applying the given headers to
-// the original one.
-func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
+// the original one, recovering their proposers from seals signed in the scheme
+// and digest algorithm of config.
+func (s *Snapshot) apply(headers []*types.Header, config *istanbul.Config) (*Snapshot, error) {
 	// Allow passing in no headers for cleaner code
 	if len(headers) == 0 {
 		return s, nil
@@ -185,7 +223,7 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 			snap.Tally = make(map[common.Address]Tally)
 		}
 		// Resolve the authorization key and check against validators
-		validator, err := ecrecover(header)
+		validator, err := ecrecover(header, config.SigSchemeAt(header.Number), config.DigestAt(header.Number))
 		if err != nil {
 			return nil, err
 		}
@@ -283,21 +321,40 @@ type snapshotJSON struct {
 
 	// for validator set
 	Validators []common.Address        `json:"validators"`
+	Weights    []uint64                `json:"weights,omitempty"` // The weights of the validators, omitted if they all weigh 1
 	Policy     istanbul.ProposerPolicy `json:"policy"`
 }
 
 func (s *Snapshot) toJSONStruct() *snapshotJSON {
+	validators := s.validators()
 	return &snapshotJSON{
 		Epoch:      s.Epoch,
 		Number:     s.Number,
 		Hash:       s.Hash,
 		Votes:      s.Votes,
 		Tally:      s.Tally,
-		Validators: s.validators(),
+		Validators: validators,
+		Weights:    s.weights(validators),
 		Policy:     s.ValSet.Policy(),
 	}
 }
 
+// weights returns the weights of the given validators of the snapshot, or nil
+// if they all weigh 1.
+func (s *Snapshot) weights(validators []common.Address) []uint64 {
+	weights := make([]uint64, len(validators))
+	weighted := false
+	for i, addr := range validators {
+		_, v := s.ValSet.GetByAddress(addr)
+		weights[i] = v.Weight()
+		weighted = weighted || weights[i] != 1
+	}
+	if !weighted {
+		return nil
+	}
+	return weights
+}
+
 // Unmarshal from a json byte array
 func (s *Snapshot) UnmarshalJSON(b []byte) error {
 	var j snapshotJSON
@@ -310,7 +367,11 @@ func (s *Snapshot) UnmarshalJSON(b []byte) error {
 	s.Hash = j.Hash
 	s.Votes = j.Votes
 	s.Tally = j.Tally
-	s.ValSet = validator.NewSet(j.Validators, j.Policy)
+	valSet, err := validator.NewWeightedSet(j.Validators, j.Weights, j.Policy)
+	if err != nil {
+		return err
+	}
+	s.ValSet = valSet
 	return nil
 }
 
@@ -25,12 +25,14 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	istanbulCore "github.com/ethereum/go-ethereum/consensus/istanbul/core"
 	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
 	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
+	lru "github.com/hashicorp/golang-lru"
 )
 
 type testerVote struct {
@@ -61,7 +63,7 @@ func (ap *testerAccountPool) sign(header *types.Header, validator string) {
 	hashData := crypto.Keccak256([]byte(sigHash(header).Bytes()))
 	sig, _ := crypto.Sign(hashData, ap.accounts[validator])
 
-	writeSeal(header, sig)
+	istanbul.WriteSeal(header, sig)
 }
 
 func (ap *testerAccountPool) address(account string) common.Address {
@@ -453,3 +455,112 @@ func TestSaveAndLoad(t *testing.T) {
 		t.Errorf("validator set mismatch: have %v, want %v", snap1.ValSet, snap.ValSet)
 	}
 }
+
+func TestSaveAndLoadWeights(t *testing.T) {
+	genesis, keys := getGenesisAndKeys(2)
+	heavy := crypto.PubkeyToAddress(keys[0].PublicKey)
+	config := *istanbul.DefaultConfig
+	config.ValidatorWeights = map[common.Address]uint64{heavy: 3}
+	chain, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+	defer engine.Stop()
+
+	// The genesis validators get their weights from the config
+	snap, err := engine.snapshot(chain, 0, chain.Genesis().Hash(), nil)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if total := snap.ValSet.TotalWeight(); total != 4 {
+		t.Errorf("total weight mismatch: have %v, want 4", total)
+	}
+
+	// and keep them through the database
+	loaded, err := loadSnapshot(snap.Epoch, engine.db, snap.Hash)
+	if err != nil {
+		t.Fatalf("load snapshot failed: %v", err)
+	}
+	if _, v := loaded.ValSet.GetByAddress(heavy); v == nil || v.Weight() != 3 {
+		t.Errorf("validator weight mismatch: have %v, want 3", v)
+	}
+	if total := loaded.ValSet.TotalWeight(); total != 4 {
+		t.Errorf("total weight mismatch: have %v, want 4", total)
+	}
+}
+
+func TestEpochCheckpoint(t *testing.T) {
+	chain, engine, keys := newBlockChainWithKeys(1)
+	config := *engine.config
+	config.Epoch = 2
+	engine.config = &config
+	engine.recents, _ = lru.NewARC(inmemorySnapshots)
+
+	// seal seals the block by the given validator alone
+	seal := func(block *types.Block, key *ecdsa.PrivateKey) *types.Block {
+		header := block.Header()
+		sig, _ := crypto.Sign(config.DigestAt(header.Number).Sum(sealData(header, config.SigSchemeAt(header.Number))), key)
+		istanbul.WriteSeal(header, sig)
+		committedSeal, _ := crypto.Sign(config.DigestAt(header.Number).Sum(istanbulCore.PrepareCommittedSeal(header.Hash(), config.SigSchemeAt(header.Number))), key)
+		writeCommittedSeals(header, [][]byte{committedSeal})
+		return block.WithSeal(header)
+	}
+	newcomer, _ := crypto.GenerateKey()
+	newcomerAddr := crypto.PubkeyToAddress(newcomer.PublicKey)
+
+	// The first block votes the newcomer in, which seals the following ones
+	engine.candidates[newcomerAddr] = true
+	first := seal(makeBlockWithoutSeal(chain, engine, chain.Genesis()), keys[0])
+	delete(engine.candidates, newcomerAddr)
+	if _, err := chain.InsertChain(types.Blocks{first}); err != nil {
+		t.Fatalf("failed to insert block 1: %v", err)
+	}
+	parent := first
+	for i := 2; i <= 5; i++ {
+		block := seal(makeBlockWithoutSeal(chain, engine, parent), newcomer)
+		if _, err := chain.InsertChain(types.Blocks{block}); err != nil {
+			t.Fatalf("failed to insert block %d: %v", i, err)
+		}
+		parent = block
+	}
+
+	// The validators were checkpointed at the epoch boundaries
+	for _, number := range []uint64{2, 4} {
+		snap, err := loadCheckpoint(config.Epoch, engine.db, number)
+		if err != nil {
+			t.Fatalf("failed to load the checkpoint of block %d: %v", number, err)
+		}
+		if snap.Hash != chain.GetHeaderByNumber(number).Hash() || snap.ValSet.Size() != 2 {
+			t.Errorf("checkpoint mismatch: have block %d with %d validators, want block %d with 2", snap.Number, snap.ValSet.Size(), number)
+		}
+	}
+
+	// Resolve the historical sets from the checkpoints, not the recent snapshots
+	engine.recents, _ = lru.NewARC(inmemorySnapshots)
+	engine.verifiedHeaders = nil
+	genesis := chain.Genesis()
+	old, err := engine.snapshot(chain, 0, genesis.Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to get the validators of block 1: %v", err)
+	}
+	if old.ValSet.Size() != 1 || old.ValSet.GetByIndex(0).Address() != engine.Address() {
+		t.Errorf("validators mismatch: have %v, want [%v]", old.validators(), engine.Address())
+	}
+	fourth := chain.GetHeaderByNumber(4)
+	current, err := engine.snapshot(chain, 4, fourth.Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to get the validators of block 5: %v", err)
+	}
+	if _, v := current.ValSet.GetByAddress(newcomerAddr); v == nil || current.ValSet.Size() != 2 {
+		t.Errorf("validators mismatch: have %v, want the newcomer as well", current.validators())
+	}
+
+	// The old block is verified with the old set, which the newcomer wasn't in
+	if err := engine.VerifyHeader(chain, first.Header(), false); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	forged := seal(first, newcomer)
+	if err := engine.VerifyHeader(chain, forged.Header(), false); err != errUnauthorized {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorized)
+	}
+	if err := engine.VerifyHeader(chain, chain.GetHeaderByNumber(5), false); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+const (
+	// tcpQueueSize is the number of payloads queued for a validator while
+	// its connection is busy, the sends beyond fail transiently.
+	tcpQueueSize = 256
+	// tcpMaxPayload is the maximum size of a payload received.
+	tcpMaxPayload = 16 * 1024 * 1024
+	// tcpDialTimeout is the time to connect to a validator.
+	tcpDialTimeout = 5 * time.Second
+	// tcpHandshakeTimeout is the time the two ends of a connection have to
+	// authenticate each other.
+	tcpHandshakeTimeout = 5 * time.Second
+	// tcpWriteTimeout is the time to write a payload to a validator.
+	tcpWriteTimeout = 5 * time.Second
+)
+
+// tcpHandshakeDomain separates the signatures of the handshake nonces from the
+// other data signed with the validator keys.
+var tcpHandshakeDomain = []byte("istanbul-transport")
+
+var (
+	// errTransportBusy is returned when sending to a validator whose queue is
+	// full, it's transient.
+	errTransportBusy = transportError("transport queue full")
+	// errPeerIdentity is returned if the validator at the endpoint of another
+	// one authenticates with its own key.
+	errPeerIdentity = errors.New("validator identity mismatch")
+	// errPayloadTooLarge is returned if a payload larger than tcpMaxPayload is
+	// received.
+	errPayloadTooLarge = errors.New("payload too large")
+	// errInvalidTransportPeer is returned if an endpoint of the config isn't
+	// in the address@host:port format.
+	errInvalidTransportPeer = errors.New("invalid transport peer")
+)
+
+// transportError is a transient error of the transport.
+type transportError string
+
+func (e transportError) Error() string   { return string(e) }
+func (e transportError) Temporary() bool { return true }
+
+// TCPTransport is a Transport over TCP connections. Each end of a connection
+// proves that it holds the key of its validator by signing the random nonce of
+// the other end. The connections aren't encrypted, the consensus messages are
+// signed anyway.
+type TCPTransport struct {
+	key      *ecdsa.PrivateKey
+	listener net.Listener
+	peers    map[common.Address]string // Endpoints of the validators
+
+	mu      sync.Mutex
+	queues  map[common.Address]chan []byte
+	conns   map[net.Conn]bool // Connections accepted
+	handler func(addr common.Address, payload []byte) error
+	quit    chan struct{}
+}
+
+// ListenTCPTransport creates a transport authenticated with the key, accepting
+// the connections of the validators on the listen address, and connecting to
+// the validators at the given endpoints.
+func ListenTCPTransport(key *ecdsa.PrivateKey, listen string, peers map[common.Address]string) (*TCPTransport, error) {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return nil, err
+	}
+	t := &TCPTransport{
+		key:      key,
+		listener: listener,
+		peers:    peers,
+		queues:   make(map[common.Address]chan []byte),
+		conns:    make(map[net.Conn]bool),
+		quit:     make(chan struct{}),
+	}
+	go t.loopAccept()
+	return t, nil
+}
+
+// ParseTransportPeers parses the endpoints of the validators, in the
+// address@host:port format.
+func ParseTransportPeers(endpoints []string) (map[common.Address]string, error) {
+	peers := make(map[common.Address]string)
+	for _, endpoint := range endpoints {
+		parts := strings.SplitN(endpoint, "@", 2)
+		if len(parts) != 2 || !common.IsHexAddress(parts[0]) {
+			return nil, errInvalidTransportPeer
+		}
+		if _, _, err := net.SplitHostPort(parts[1]); err != nil {
+			return nil, errInvalidTransportPeer
+		}
+		peers[common.HexToAddress(parts[0])] = parts[1]
+	}
+	return peers, nil
+}
+
+// Addr returns the address the transport listens on.
+func (t *TCPTransport) Addr() net.Addr {
+	return t.listener.Addr()
+}
+
+// Send implements Transport.Send, queuing the payload for the connection to the
+// validator.
+func (t *TCPTransport) Send(addr common.Address, payload []byte) error {
+	endpoint, ok := t.peers[addr]
+	if !ok {
+		return errUnknownPeer
+	}
+	t.mu.Lock()
+	queue, ok := t.queues[addr]
+	if !ok {
+		queue = make(chan []byte, tcpQueueSize)
+		t.queues[addr] = queue
+		go t.loopSend(addr, endpoint, queue)
+	}
+	t.mu.Unlock()
+
+	select {
+	case queue <- payload:
+		return nil
+	default:
+		return errTransportBusy
+	}
+}
+
+// SetHandler implements Transport.SetHandler
+func (t *TCPTransport) SetHandler(handler func(addr common.Address, payload []byte) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.handler = handler
+}
+
+// Close stops listening and closes the connections.
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.quit:
+		return nil
+	default:
+	}
+	close(t.quit)
+	for conn := range t.conns {
+		conn.Close()
+	}
+	return t.listener.Close()
+}
+
+// loopSend writes the payloads queued for the validator, connecting to it when
+// needed. The payloads failing to be written are dropped, like on the p2p
+// network, and the connection is re-established for the next one.
+func (t *TCPTransport) loopSend(addr common.Address, endpoint string, queue chan []byte) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		var payload []byte
+		select {
+		case payload = <-queue:
+		case <-t.quit:
+			return
+		}
+		if conn == nil {
+			var err error
+			if conn, err = t.dial(addr, endpoint); err != nil {
+				log.Trace("Failed to connect to validator", "addr", addr, "endpoint", endpoint, "err", err)
+				continue
+			}
+		}
+		conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+		if err := writePayload(conn, payload); err != nil {
+			log.Trace("Failed to send message to validator", "addr", addr, "err", err)
+			conn.Close()
+			conn = nil
+		}
+	}
+}
+
+// dial connects to the validator at the endpoint and checks it's the one.
+func (t *TCPTransport) dial(addr common.Address, endpoint string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", endpoint, tcpDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := t.handshake(conn)
+	if err == nil && peer != addr {
+		err = errPeerIdentity
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// loopAccept serves the connections of the validators until the transport is
+// closed.
+func (t *TCPTransport) loopAccept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			select {
+			case <-t.quit:
+				return
+			default:
+			}
+			log.Debug("Failed to accept transport connection", "err", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		t.mu.Lock()
+		t.conns[conn] = true
+		t.mu.Unlock()
+		go t.serve(conn)
+	}
+}
+
+// serve authenticates the validator on the other end of the connection and
+// hands its payloads over to the handler. A peer that isn't a validator is
+// disconnected.
+func (t *TCPTransport) serve(conn net.Conn) {
+	defer func() {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+		conn.Close()
+	}()
+	addr, err := t.handshake(conn)
+	if err != nil {
+		log.Trace("Failed transport handshake", "remote", conn.RemoteAddr(), "err", err)
+		return
+	}
+	reader := bufio.NewReader(conn)
+	for {
+		payload, err := readPayload(reader)
+		if err != nil {
+			return
+		}
+		t.mu.Lock()
+		handler := t.handler
+		t.mu.Unlock()
+		if handler == nil {
+			continue
+		}
+		if err := handler(addr, payload); err == errUnauthorizedPeer {
+			log.Debug("Disconnected transport peer", "addr", addr, "err", err)
+			return
+		}
+	}
+}
+
+// handshake authenticates both ends of the connection: each sends a random
+// nonce, then its signature of the nonce of the other end. It returns the
+// address of the validator on the other end.
+func (t *TCPTransport) handshake(conn net.Conn) (common.Address, error) {
+	conn.SetDeadline(time.Now().Add(tcpHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	nonce := make([]byte, 32)
+	if _, err := rand.Read(nonce); err != nil {
+		return common.Address{}, err
+	}
+	if _, err := conn.Write(nonce); err != nil {
+		return common.Address{}, err
+	}
+	peerNonce := make([]byte, 32)
+	if _, err := io.ReadFull(conn, peerNonce); err != nil {
+		return common.Address{}, err
+	}
+	sig, err := crypto.Sign(crypto.Keccak256(tcpHandshakeDomain, peerNonce), t.key)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if _, err := conn.Write(sig); err != nil {
+		return common.Address{}, err
+	}
+	peerSig := make([]byte, 65)
+	if _, err := io.ReadFull(conn, peerSig); err != nil {
+		return common.Address{}, err
+	}
+	pubkey, err := crypto.SigToPub(crypto.Keccak256(tcpHandshakeDomain, nonce), peerSig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return crypto.PubkeyToAddress(*pubkey), nil
+}
+
+// writePayload writes the payload prefixed with its length.
+func writePayload(w io.Writer, payload []byte) error {
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readPayload reads a payload prefixed with its length.
+func readPayload(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > tcpMaxPayload {
+		return nil, errPayloadTooLarge
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
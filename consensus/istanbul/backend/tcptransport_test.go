@@ -0,0 +1,112 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// tcpMessage is a payload received on a TCPTransport
+type tcpMessage struct {
+	from    common.Address
+	payload []byte
+}
+
+func listenTCPTransport(t *testing.T, key *ecdsa.PrivateKey, peers map[common.Address]string) (*TCPTransport, chan tcpMessage) {
+	transport, err := ListenTCPTransport(key, "127.0.0.1:0", peers)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	received := make(chan tcpMessage, 10)
+	transport.SetHandler(func(addr common.Address, payload []byte) error {
+		received <- tcpMessage{addr, payload}
+		return nil
+	})
+	return transport, received
+}
+
+func TestTCPTransport(t *testing.T) {
+	aliceKey, _ := crypto.GenerateKey()
+	bobKey, _ := crypto.GenerateKey()
+	malloryKey, _ := crypto.GenerateKey()
+	alice, bob := crypto.PubkeyToAddress(aliceKey.PublicKey), crypto.PubkeyToAddress(bobKey.PublicKey)
+
+	bobTransport, received := listenTCPTransport(t, bobKey, nil)
+	defer bobTransport.Close()
+	malloryTransport, stolen := listenTCPTransport(t, malloryKey, nil)
+	defer malloryTransport.Close()
+
+	// the payloads are received authenticated as the sender
+	aliceTransport, _ := listenTCPTransport(t, aliceKey, map[common.Address]string{bob: bobTransport.Addr().String()})
+	defer aliceTransport.Close()
+	for _, payload := range [][]byte{[]byte("first"), []byte("second")} {
+		if err := aliceTransport.Send(bob, payload); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		select {
+		case msg := <-received:
+			if msg.from != alice || !bytes.Equal(msg.payload, payload) {
+				t.Errorf("message mismatch: have %x from %x, want %x from %x", msg.payload, msg.from, payload, alice)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the message should be received")
+		}
+	}
+	if err := aliceTransport.Send(alice, []byte("unknown")); err != errUnknownPeer {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnknownPeer)
+	}
+
+	// another validator can't pose as bob at the endpoint of bob
+	impostorTransport, _ := listenTCPTransport(t, aliceKey, map[common.Address]string{bob: malloryTransport.Addr().String()})
+	defer impostorTransport.Close()
+	impostorTransport.Send(bob, []byte("stolen"))
+	select {
+	case msg := <-stolen:
+		t.Errorf("message mismatch: have %s, want none", msg.payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTransportConfig(t *testing.T) {
+	if _, err := ParseTransportPeers([]string{"127.0.0.1:30303"}); err != errInvalidTransportPeer {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidTransportPeer)
+	}
+	peers, err := ParseTransportPeers([]string{"0x1000000000000000000000000000000000000000@127.0.0.1:30303"})
+	if err != nil || peers[common.HexToAddress("0x1000000000000000000000000000000000000000")] != "127.0.0.1:30303" {
+		t.Errorf("peers mismatch: have %v, %v", peers, err)
+	}
+
+	// the engine listens on the transport of the config when it starts
+	genesis, keys := getGenesisAndKeys(1)
+	config := *istanbul.DefaultConfig
+	config.TransportAddr = "127.0.0.1:0"
+	_, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+	defer engine.Stop()
+
+	transport, ok := engine.transport.(*TCPTransport)
+	if !ok {
+		t.Fatalf("transport mismatch: have %T, want *TCPTransport", engine.transport)
+	}
+	transport.Close()
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// errUnauthorizedPeer is returned when a message is received on the transport
+// from a peer which is not a validator.
+var errUnauthorizedPeer = errors.New("unauthorized peer")
+
+// Transport is a dedicated channel for the consensus messages, restricted to
+// the validators. Its connections are mutually authenticated (e.g. with TLS
+// certificates bound to the validator keys), so the messages received are
+// attributed to the validator on the other end.
+type Transport interface {
+	// Send queues the payload for the validator with the given address, it
+	// must not block. The transient failures, e.g. a connection being
+	// re-established, are retried if the error has a Temporary method
+	// returning true, like net.Error.
+	Send(addr common.Address, payload []byte) error
+
+	// SetHandler sets the handler of the payloads received from the
+	// authenticated validators.
+	SetHandler(handler func(addr common.Address, payload []byte) error)
+}
+
+// SetTransport routes the consensus messages over the given transport instead
+// of the p2p network, which drops the ones it receives from then on. It's meant
+// to be called before the engine starts, which otherwise listens on a
+// TCPTransport if the config has a TransportAddr.
+func (sb *backend) SetTransport(transport Transport) {
+	sb.coreMu.Lock()
+	defer sb.coreMu.Unlock()
+
+	sb.transport = transport
+	transport.SetHandler(sb.handleTransportMsg)
+}
+
+// startTransport listens on a TCPTransport at the TransportAddr of the config,
+// if any and unless a transport was set already. The caller must hold coreMu.
+func (sb *backend) startTransport() error {
+	if sb.config.TransportAddr == "" || sb.transport != nil {
+		return nil
+	}
+	if sb.privateKey == nil {
+		return errMissingSigner
+	}
+	peers, err := ParseTransportPeers(sb.config.TransportPeers)
+	if err != nil {
+		return err
+	}
+	transport, err := ListenTCPTransport(sb.privateKey, sb.config.TransportAddr, peers)
+	if err != nil {
+		return err
+	}
+	sb.logger.Info("Listening on the validator transport", "addr", transport.Addr())
+	sb.transport = transport
+	transport.SetHandler(sb.handleTransportMsg)
+	return nil
+}
+
+// temporary is implemented by the errors telling whether a failure is transient
+type temporary interface {
+	Temporary() bool
+}
+
+// isTemporary returns whether the error is a transient failure worth retrying
+func isTemporary(err error) bool {
+	t, ok := err.(temporary)
+	return ok && t.Temporary()
+}
+
+// startSends lets the transiently failed sends be retried until stopSends.
+func (sb *backend) startSends() {
+	sb.sendMu.Lock()
+	defer sb.sendMu.Unlock()
+
+	sb.sendQuit = make(chan struct{})
+}
+
+// stopSends ends the retries of the sends in flight.
+func (sb *backend) stopSends() {
+	sb.sendMu.Lock()
+	defer sb.sendMu.Unlock()
+
+	if sb.sendQuit != nil {
+		close(sb.sendQuit)
+		sb.sendQuit = nil
+	}
+}
+
+// sendTransport sends the payload to the validator on the transport. If it
+// fails transiently while the engine runs, the retries go on in the background
+// and nil is returned.
+func (sb *backend) sendTransport(addr common.Address, payload []byte) error {
+	send := func() error { return sb.transport.Send(addr, payload) }
+	err := send()
+	if isTemporary(err) && sb.config.SendRetries > 0 {
+		sb.sendMu.Lock()
+		quit := sb.sendQuit
+		sb.sendMu.Unlock()
+
+		if quit != nil {
+			go sb.retrySend(addr, send, quit)
+			return nil
+		}
+	}
+	return err
+}
+
+// retrySend retries a transiently failed send up to SendRetries times, backing
+// off exponentially with a random jitter, so the validators don't all retry at
+// once. It gives up when quit is closed.
+func (sb *backend) retrySend(addr common.Address, send func() error, quit chan struct{}) {
+	backoff := time.Duration(sb.config.SendRetryBackoff) * time.Millisecond
+	for retry := uint64(0); retry < sb.config.SendRetries; retry++ {
+		delay := backoff << retry
+		if delay > 0 {
+			delay += time.Duration(rand.Int63n(int64(delay)/2 + 1))
+		}
+		timer := sb.clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-quit:
+			timer.Stop()
+			return
+		}
+
+		err := send()
+		if err == nil {
+			return
+		}
+		if !isTemporary(err) {
+			sb.logger.Trace("Failed to send message to validator", "addr", addr, "err", err)
+			return
+		}
+		sb.logger.Trace("Failed to send message to validator, retry", "addr", addr, "err", err, "retry", retry+1)
+	}
+	sb.logger.Debug("Gave up sending message to validator", "addr", addr, "retries", sb.config.SendRetries)
+}
+
+// handleTransportMsg handles a payload received on the transport from the given
+// validator.
+func (sb *backend) handleTransportMsg(addr common.Address, payload []byte) error {
+	sb.coreMu.Lock()
+	defer sb.coreMu.Unlock()
+
+	if !sb.coreStarted {
+		return istanbul.ErrStoppedEngine
+	}
+	// Only the validators of the next block may talk to us
+	head := sb.currentBlock()
+	if _, val := sb.getValidators(head.NumberU64(), head.Hash()).GetByAddress(addr); val == nil {
+		return errUnauthorizedPeer
+	}
+	sb.handleConsensusMsg(addr, payload)
+	return nil
+}
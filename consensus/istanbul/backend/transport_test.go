@@ -0,0 +1,276 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// memNetwork connects in-memory transports, authenticated by their address
+type memNetwork struct {
+	mu    sync.Mutex
+	peers map[common.Address]*memTransport
+}
+
+func newMemNetwork() *memNetwork {
+	return &memNetwork{peers: make(map[common.Address]*memTransport)}
+}
+
+func (n *memNetwork) join(addr common.Address) *memTransport {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	t := &memTransport{network: n, addr: addr}
+	n.peers[addr] = t
+	return t
+}
+
+type memTransport struct {
+	network *memNetwork
+	addr    common.Address
+
+	mu       sync.Mutex
+	handler  func(addr common.Address, payload []byte) error
+	received [][]byte
+}
+
+func (t *memTransport) Send(addr common.Address, payload []byte) error {
+	t.network.mu.Lock()
+	peer := t.network.peers[addr]
+	t.network.mu.Unlock()
+	if peer == nil {
+		return errUnknownPeer
+	}
+	return peer.deliver(t.addr, payload)
+}
+
+func (t *memTransport) SetHandler(handler func(addr common.Address, payload []byte) error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.handler = handler
+}
+
+func (t *memTransport) deliver(from common.Address, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.received = append(t.received, payload)
+	if t.handler == nil {
+		return nil
+	}
+	return t.handler(from, payload)
+}
+
+func (t *memTransport) payloads() [][]byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.received
+}
+
+func TestTransport(t *testing.T) {
+	chain, engine, keys := newBlockChainWithKeys(4)
+	network := newMemNetwork()
+	engine.SetTransport(network.join(engine.Address()))
+
+	var validator *memTransport
+	for _, key := range keys {
+		if addr := crypto.PubkeyToAddress(key.PublicKey); addr != engine.Address() {
+			validator = network.join(addr)
+			break
+		}
+	}
+	outsiderKey, _ := crypto.GenerateKey()
+	outsider := network.join(crypto.PubkeyToAddress(outsiderKey.PublicKey))
+
+	sub := engine.EventMux().Subscribe(istanbul.MessageEvent{})
+	defer sub.Unsubscribe()
+	received := func() []byte {
+		select {
+		case ev := <-sub.Chan():
+			return ev.Data.(istanbul.MessageEvent).Payload
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+
+	// A non-validator can't inject messages
+	if err := outsider.Send(engine.Address(), []byte("outsider")); err != errUnauthorizedPeer {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnauthorizedPeer)
+	}
+	if payload := received(); payload != nil {
+		t.Errorf("message mismatch: have %s, want none", payload)
+	}
+	// Nor can anybody over the p2p network
+	if _, err := engine.HandleMsg(outsider.addr, makeMsg(istanbulMsg, []byte("p2p"))); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if payload := received(); payload != nil {
+		t.Errorf("message mismatch: have %s, want none", payload)
+	}
+	// A validator can
+	if err := validator.Send(engine.Address(), []byte("validator")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if payload := received(); !bytes.Equal(payload, []byte("validator")) {
+		t.Errorf("message mismatch: have %s, want validator", payload)
+	}
+
+	// The messages to the validators are sent over the transport
+	valSet := engine.Validators(chain.CurrentBlock())
+	if err := engine.Gossip(valSet, []byte("gossip")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if err := engine.Unicast(validator.addr, []byte("unicast")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if payloads := validator.payloads(); len(payloads) != 2 {
+		t.Errorf("the number of messages mismatch: have %v, want 2", len(payloads))
+	}
+	if payloads := outsider.payloads(); len(payloads) != 0 {
+		t.Errorf("the number of messages mismatch: have %v, want 0", len(payloads))
+	}
+}
+
+// errTemporary is returned by flakyTransport for the sends it fails
+var errTemporary = temporaryError{}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary failure" }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyTransport fails the first sends with a transient error
+type flakyTransport struct {
+	*memTransport
+
+	mu       sync.Mutex
+	failures int
+	attempts int
+}
+
+func (t *flakyTransport) Send(addr common.Address, payload []byte) error {
+	t.mu.Lock()
+	t.attempts++
+	fail := t.attempts <= t.failures
+	t.mu.Unlock()
+
+	if fail {
+		return errTemporary
+	}
+	return t.memTransport.Send(addr, payload)
+}
+
+func TestTransportRetry(t *testing.T) {
+	_, engine, keys := newBlockChainWithKeys(4)
+	clock := istanbul.NewSimulatedClock()
+	engine.clock = clock
+
+	network := newMemNetwork()
+	transport := &flakyTransport{memTransport: network.join(engine.Address()), failures: 1}
+	engine.SetTransport(transport)
+
+	var validator *memTransport
+	for _, key := range keys {
+		if addr := crypto.PubkeyToAddress(key.PublicKey); addr != engine.Address() {
+			validator = network.join(addr)
+			break
+		}
+	}
+
+	// The first attempt fails transiently, so the message is sent on retry
+	if err := engine.Unicast(validator.addr, []byte("unicast")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if payloads := validator.payloads(); len(payloads) != 0 {
+		t.Errorf("the number of messages mismatch: have %v, want 0", len(payloads))
+	}
+	for deadline := time.Now().Add(5 * time.Second); clock.Timers() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("retry should be scheduled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// the jitter extends the backoff by up to half
+	clock.Run(time.Duration(engine.config.SendRetryBackoff) * time.Millisecond * 3 / 2)
+	for deadline := time.Now().Add(5 * time.Second); len(validator.payloads()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("message should be delivered on retry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if payloads := validator.payloads(); len(payloads) != 1 || !bytes.Equal(payloads[0], []byte("unicast")) {
+		t.Errorf("messages mismatch: have %s, want [unicast]", payloads)
+	}
+	transport.mu.Lock()
+	if transport.attempts != 2 {
+		t.Errorf("the number of attempts mismatch: have %v, want 2", transport.attempts)
+	}
+	transport.mu.Unlock()
+
+	// A permanent failure isn't retried
+	outsiderKey, _ := crypto.GenerateKey()
+	if err := engine.Unicast(crypto.PubkeyToAddress(outsiderKey.PublicKey), []byte("unicast")); err != errUnknownPeer {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnknownPeer)
+	}
+	if timers := clock.Timers(); timers != 0 {
+		t.Errorf("the number of timers mismatch: have %v, want 0", timers)
+	}
+
+	// The retries in flight are given up when the engine stops
+	transport.mu.Lock()
+	transport.failures = transport.attempts + 1
+	transport.mu.Unlock()
+	if err := engine.Unicast(validator.addr, []byte("unicast")); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); clock.Timers() == 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("retry should be scheduled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	engine.Stop()
+	for deadline := time.Now().Add(5 * time.Second); clock.Timers() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("retry should be given up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	transport.mu.Lock()
+	if transport.attempts != 4 {
+		t.Errorf("the number of attempts mismatch: have %v, want 4", transport.attempts)
+	}
+	transport.failures = transport.attempts + 1
+	transport.mu.Unlock()
+
+	// and a stopped engine doesn't retry
+	if err := engine.Unicast(validator.addr, []byte("unicast")); err != errTemporary {
+		t.Errorf("error mismatch: have %v, want %v", err, errTemporary)
+	}
+	if timers := clock.Timers(); timers != 0 {
+		t.Errorf("the number of timers mismatch: have %v, want 0", timers)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// WAL is a write-ahead log of the committed blocks. A block is written to it
+// before it's handed over for insertion, so the blocks committed right before a
+// crash are inserted on the next start instead of being lost.
+type WAL interface {
+	// Append durably writes the block at the end of the log.
+	Append(block *types.Block) error
+
+	// Blocks returns the blocks of the log, oldest first.
+	Blocks() ([]*types.Block, error)
+
+	// Truncate drops all the blocks of the log.
+	Truncate() error
+}
+
+// FileWAL is a WAL keeping the RLP encoded blocks in a file, synced to disk on
+// every write.
+type FileWAL struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// OpenFileWAL opens the WAL in the given file, creating it if needed.
+func OpenFileWAL(path string) (*FileWAL, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileWAL{file: file}, nil
+}
+
+// Append implements WAL.Append
+func (w *FileWAL) Append(block *types.Block) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	enc, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(enc); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Blocks implements WAL.Blocks. A torn block at the end of the file, from a
+// crash in the middle of a write, is ignored: it was never handed over.
+func (w *FileWAL) Blocks() ([]*types.Block, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var blocks []*types.Block
+	stream := rlp.NewStream(w.file, 0)
+	for {
+		block := new(types.Block)
+		if err := stream.Decode(block); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return blocks, nil
+			}
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+}
+
+// Truncate implements WAL.Truncate
+func (w *FileWAL) Truncate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close closes the file of the WAL.
+func (w *FileWAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// SetWAL makes the engine write the committed blocks to the WAL before handing
+// them over for insertion, and insert the ones missing from the chain when it
+// starts. It's meant to be called before the engine starts, which otherwise
+// opens the WALFile of the config, if any.
+func (sb *backend) SetWAL(wal WAL) {
+	sb.walMu.Lock()
+	defer sb.walMu.Unlock()
+
+	sb.wal = wal
+}
+
+// openWAL opens the WAL in the file of the config, if any and unless a WAL was
+// set already.
+func (sb *backend) openWAL() error {
+	sb.walMu.Lock()
+	defer sb.walMu.Unlock()
+
+	if sb.config.WALFile == "" || sb.wal != nil {
+		return nil
+	}
+	wal, err := OpenFileWAL(sb.config.WALFile)
+	if err != nil {
+		return err
+	}
+	sb.wal = wal
+	return nil
+}
+
+// writeWAL appends the committed block to the WAL, if any. The consensus only
+// moves to the next height once the previous block is in the chain, so the
+// blocks already in the log aren't needed anymore and are dropped first.
+func (sb *backend) writeWAL(block *types.Block) error {
+	sb.walMu.Lock()
+	defer sb.walMu.Unlock()
+
+	if sb.wal == nil {
+		return nil
+	}
+	if err := sb.wal.Truncate(); err != nil {
+		return err
+	}
+	return sb.wal.Append(block)
+}
+
+// replayWAL hands the blocks of the WAL missing from the chain over for
+// insertion, e.g. after a crash between their commit and their insertion.
+func (sb *backend) replayWAL(chain consensus.ChainReader) {
+	sb.walMu.RLock()
+	defer sb.walMu.RUnlock()
+
+	if sb.wal == nil {
+		return
+	}
+	blocks, err := sb.wal.Blocks()
+	if err != nil {
+		sb.logger.Error("Failed to read the WAL", "err", err)
+		return
+	}
+	for _, block := range blocks {
+		if chain.GetHeader(block.Hash(), block.NumberU64()) != nil {
+			continue
+		}
+		sb.logger.Info("Replaying committed block from the WAL", "number", block.NumberU64(), "hash", block.Hash())
+		if sb.broadcaster != nil {
+			sb.broadcaster.Enqueue(fetcherID, block)
+		}
+	}
+}
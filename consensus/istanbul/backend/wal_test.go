@@ -0,0 +1,171 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package backend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// insertingBroadcaster inserts the enqueued blocks into the chain, like the
+// fetcher does.
+type insertingBroadcaster struct {
+	chain    *core.BlockChain
+	enqueued int
+}
+
+func (b *insertingBroadcaster) Enqueue(id string, block *types.Block) {
+	b.enqueued++
+	b.chain.InsertChain(types.Blocks{block})
+}
+
+func (b *insertingBroadcaster) FindPeers(map[common.Address]bool) map[common.Address]consensus.Peer {
+	return nil
+}
+
+func TestWALReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "istanbul-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	chain, engine := newBlockChain(1)
+	defer engine.Stop()
+
+	wal, err := OpenFileWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.SetWAL(wal)
+
+	// the block is committed, and the node crashes before inserting it
+	block := makeBlock(chain, engine, chain.Genesis())
+	if block == nil {
+		t.Fatal("failed to seal the block")
+	}
+	engine.Stop()
+	wal.Close()
+	if head := chain.CurrentBlock().NumberU64(); head != 0 {
+		t.Fatalf("head mismatch: have %v, want %v", head, 0)
+	}
+
+	// the restarted node inserts the block from the WAL
+	wal, err = OpenFileWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	broadcaster := &insertingBroadcaster{chain: chain}
+	engine.SetWAL(wal)
+	engine.SetBroadcaster(broadcaster)
+	if err := engine.Start(chain, chain.CurrentBlock, chain.HasBadBlock); err != nil {
+		t.Fatal(err)
+	}
+	if head := chain.CurrentBlock().Hash(); head != block.Hash() {
+		t.Errorf("head mismatch: have %v, want %v", head, block.Hash())
+	}
+
+	// the blocks already in the chain aren't replayed
+	engine.Stop()
+	if err := engine.Start(chain, chain.CurrentBlock, chain.HasBadBlock); err != nil {
+		t.Fatal(err)
+	}
+	if broadcaster.enqueued != 1 {
+		t.Errorf("enqueued blocks mismatch: have %v, want %v", broadcaster.enqueued, 1)
+	}
+}
+
+func TestWALFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "istanbul-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the engine opens the WAL of the config when it starts
+	genesis, keys := getGenesisAndKeys(1)
+	config := *istanbul.DefaultConfig
+	config.WALFile = filepath.Join(dir, "wal")
+	chain, engine := newBlockChainFromGenesis(genesis, &config, keys[0])
+	defer engine.Stop()
+
+	block := makeBlock(chain, engine, chain.Genesis())
+	if block == nil {
+		t.Fatal("failed to seal the block")
+	}
+	wal, err := OpenFileWAL(config.WALFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	blocks, err := wal.Blocks()
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if len(blocks) != 1 || blocks[0].Hash() != block.Hash() {
+		t.Errorf("blocks mismatch: have %v, want %v", len(blocks), 1)
+	}
+}
+
+func TestFileWALTornWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "istanbul-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wal")
+
+	chain, engine := newBlockChain(1)
+	defer engine.Stop()
+	block := makeBlockWithoutSeal(chain, engine, chain.Genesis())
+
+	wal, err := OpenFileWAL(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Close()
+	for i := 0; i < 2; i++ {
+		if err := wal.Append(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// crash in the middle of the second write
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-10); err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := wal.Blocks()
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if len(blocks) != 1 || blocks[0].Hash() != block.Hash() {
+		t.Errorf("blocks mismatch: have %v, want %v", len(blocks), 1)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of the consensus timers, which tests replace with
+// a SimulatedClock.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTimer creates a timer sending the current time on its channel after
+	// the given duration
+	NewTimer(d time.Duration) Timer
+	// AfterFunc creates a timer calling f after the given duration
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer created by a Clock
+type Timer interface {
+	// C returns the channel the time is sent on, nil for AfterFunc timers
+	C() <-chan time.Time
+	// Stop prevents the timer from firing, it returns false if the timer
+	// already fired or was stopped
+	Stop() bool
+}
+
+// SystemClock is the clock of the system
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+// SimulatedClock is a Clock whose time only advances when Run is called, firing
+// the timers in between without waiting.
+type SimulatedClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*simulatedTimer
+}
+
+// NewSimulatedClock creates a simulated clock starting at the current time of
+// the system.
+func NewSimulatedClock() *SimulatedClock {
+	return &SimulatedClock{now: time.Now()}
+}
+
+// Now implements Clock.Now
+func (c *SimulatedClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// NewTimer implements Clock.NewTimer
+func (c *SimulatedClock) NewTimer(d time.Duration) Timer {
+	return c.schedule(d, make(chan time.Time, 1), nil)
+}
+
+// AfterFunc implements Clock.AfterFunc
+func (c *SimulatedClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.schedule(d, nil, f)
+}
+
+func (c *SimulatedClock) schedule(d time.Duration, ch chan time.Time, f func()) *simulatedTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &simulatedTimer{clock: c, at: c.now.Add(d), ch: ch, f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Run advances the time by the given duration, firing the timers due in order.
+// The functions of AfterFunc timers are called before Run returns.
+func (c *SimulatedClock) Run(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			c.now = end
+			c.mu.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.at
+		c.mu.Unlock()
+
+		// The function may schedule new timers, so it's called unlocked
+		if t.f != nil {
+			t.f()
+		} else {
+			t.ch <- t.at
+		}
+	}
+}
+
+// Timers returns the number of timers waiting to fire
+func (c *SimulatedClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+type simulatedTimer struct {
+	clock *SimulatedClock
+	at    time.Time
+	ch    chan time.Time
+	f     func()
+}
+
+func (t *simulatedTimer) C() <-chan time.Time { return t.ch }
+
+func (t *simulatedTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
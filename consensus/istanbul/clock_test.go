@@ -0,0 +1,66 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSimulatedClock(t *testing.T) {
+	clock := NewSimulatedClock()
+	start := clock.Now()
+
+	var fired []int
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, 0) })
+	timer := clock.NewTimer(3 * time.Second)
+
+	if !stopped.Stop() {
+		t.Errorf("a pending timer should be stopped")
+	}
+	clock.Run(2 * time.Second)
+	if want := []int{1, 2}; !reflect.DeepEqual(fired, want) {
+		t.Errorf("fired timers mismatch: have %v, want %v", fired, want)
+	}
+	if have, want := clock.Now(), start.Add(2*time.Second); !have.Equal(want) {
+		t.Errorf("time mismatch: have %v, want %v", have, want)
+	}
+	select {
+	case <-timer.C():
+		t.Fatalf("the timer should not fire early")
+	default:
+	}
+
+	clock.Run(time.Second)
+	select {
+	case at := <-timer.C():
+		if want := start.Add(3 * time.Second); !at.Equal(want) {
+			t.Errorf("fire time mismatch: have %v, want %v", at, want)
+		}
+	default:
+		t.Fatalf("the timer should fire")
+	}
+	if timer.Stop() {
+		t.Errorf("a fired timer should not be stopped")
+	}
+	if clock.Timers() != 0 {
+		t.Errorf("pending timers mismatch: have %v, want 0", clock.Timers())
+	}
+}
@@ -16,6 +16,12 @@
 
 package istanbul
 
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
 type ProposerPolicy uint64
 
 const (
@@ -23,16 +29,272 @@ const (
 	Sticky
 )
 
+// SigScheme is the version of the scheme defining the data signed by the
+// validators, see SigData.
+type SigScheme uint64
+
+const (
+	// LegacySigScheme signs the data as is.
+	LegacySigScheme SigScheme = iota
+	// DomainSigScheme prefixes the data with the tag of the domain it's signed
+	// in, so that a signature is only valid in the domain it was made for.
+	DomainSigScheme
+)
+
+// DigestAlgorithm is the hash function of the data signed by the validators,
+// see Sum. The proposals are still identified by their Keccak-256 block hash.
+type DigestAlgorithm uint64
+
+const (
+	// Keccak256Digest hashes with Keccak-256, like the rest of Ethereum.
+	Keccak256Digest DigestAlgorithm = iota
+	// SHA256Digest hashes with SHA-256, e.g. for external systems verifying
+	// the commit proofs.
+	SHA256Digest
+)
+
+// TxOrdering is the rule the transactions of a proposal must be ordered by, so
+// that the proposer can't order them at its discretion.
+type TxOrdering uint64
+
+const (
+	// AnyTxOrdering lets the proposer order the transactions.
+	AnyTxOrdering TxOrdering = iota
+	// PriceNonceTxOrdering orders the transactions by gas price, highest first,
+	// keeping those of each sender in nonce order, like the miner does.
+	PriceNonceTxOrdering
+)
+
 type Config struct {
-	RequestTimeout uint64         `toml:",omitempty"` // The timeout for each Istanbul round in milliseconds.
-	BlockPeriod    uint64         `toml:",omitempty"` // Default minimum difference between two consecutive block's timestamps in second
-	ProposerPolicy ProposerPolicy `toml:",omitempty"` // The policy for proposer selection
-	Epoch          uint64         `toml:",omitempty"` // The number of blocks after which to checkpoint and reset the pending votes
+	RequestTimeout         uint64                    `toml:",omitempty"` // The timeout for each Istanbul round in milliseconds.
+	BlockPeriod            uint64                    `toml:",omitempty"` // Default minimum difference between two consecutive block's timestamps in second
+	ProposerPolicy         ProposerPolicy            `toml:",omitempty"` // The policy for proposer selection
+	Epoch                  uint64                    `toml:",omitempty"` // The number of blocks after which to checkpoint and reset the pending votes
+	MaxProposalSize        uint64                    `toml:",omitempty"` // The maximum size of a proposed block in bytes, 0 means no limit
+	BlockReward            *big.Int                  `toml:"-"`          // The reward in wei credited to the proposer of each block from BlockRewardBlock on, set from the chain config
+	BlockRewardBlock       *big.Int                  `toml:"-"`          // The first block rewarded with BlockReward, nil means no reward, set from the chain config
+	ValidatorOrderBlock    *big.Int                  `toml:"-"`          // The first block whose proposer is selected among the validators ordered by address bytes, nil means by checksummed hex forever, set from the chain config
+	ValidatorWeights       map[common.Address]uint64 `toml:"-"`          // The voting weights of the validators of the genesis block and the registry, the others weigh 1, set from the chain config
+	MaxBacklogSize         uint64                    `toml:",omitempty"` // The maximum number of future messages kept for each validator, 0 means no limit
+	BacklogTTL             uint64                    `toml:",omitempty"` // The time in seconds a future message is kept for, 0 means no limit
+	HeartbeatInterval      uint64                    `toml:",omitempty"` // The interval in milliseconds at which the proposer broadcasts heartbeats, 0 means disabled, must be the same on all the validators
+	HeartbeatMisses        uint64                    `toml:",omitempty"` // The number of missed heartbeats from the proposer before changing round, 0 means never, must be the same on all the validators
+	FaultTolerance         uint64                    `toml:",omitempty"` // The number of faulty validators the quorums of 2F+1 are sized for, at most the F of the validator set, must be the same on all the nodes, 0 means the F of the validator set
+	MaxRounds              uint64                    `toml:",omitempty"` // The number of consecutive round changes without a commit after which the consensus halts, 0 means no limit
+	Observer               bool                      `toml:",omitempty"` // Whether the node only verifies and imports blocks, without taking part in the consensus
+	MaxPendingRequests     uint64                    `toml:",omitempty"` // The maximum number of future block requests queued until their sequence starts, 0 means no limit
+	SigScheme              SigScheme                 `toml:"-"`          // The version of the signing scheme from SigSchemeBlock on, set from the chain config
+	SigSchemeBlock         *big.Int                  `toml:"-"`          // The first block signed in SigScheme, nil means the legacy scheme forever, set from the chain config
+	ActivationBlock        uint64                    `toml:",omitempty"` // The first block sealed by Istanbul, the genesis and the blocks before are implicitly valid
+	ProposalTimeout        uint64                    `toml:",omitempty"` // The time in milliseconds the proposer waits for its proposal to be prepared before changing round, 0 means disabled
+	EventBufferSize        uint64                    `toml:",omitempty"` // The number of consensus messages buffered while the core is busy, the messages beyond are dropped, 0 means unbuffered
+	LeaseTimeout           uint64                    `toml:",omitempty"` // The time in milliseconds the signing lease lasts without being renewed, if the engine has one
+	LeaseFile              string                    `toml:",omitempty"` // The file on storage shared by the redundant instances of the validator holding their signing lease, empty means no lease
+	LeaseHolder            string                    `toml:",omitempty"` // The name of this instance in the signing lease, unique among the instances
+	WALFile                string                    `toml:",omitempty"` // The file of the write-ahead log of the committed blocks, empty means none
+	JailWindow             uint64                    `toml:",omitempty"` // The number of blocks a validator jailed through the API is skipped by the proposer selection, 0 means no jail
+	TransportAddr          string                    `toml:",omitempty"` // The address to listen on for the consensus messages of the validators, empty means they go over the p2p network
+	TransportPeers         []string                  `toml:",omitempty"` // The endpoints of the other validators on the transport, as address@host:port
+	SendRetries            uint64                    `toml:",omitempty"` // The number of times a message failing to be sent on the transport with a transient error is retried
+	SendRetryBackoff       uint64                    `toml:",omitempty"` // The time in milliseconds before the first retry, doubled for each next one and randomly extended by up to half
+	Unanimous              bool                      `toml:",omitempty"` // Whether this node commits a proposal only with the COMMITs of all the validators instead of 2F+1, the blocks are still valid with 2F+1 seals
+	Digest                 DigestAlgorithm           `toml:"-"`          // The hash function of the data signed by the validators from DigestBlock on, set from the chain config
+	DigestBlock            *big.Int                  `toml:"-"`          // The first block signed with Digest, nil means Keccak-256 forever, set from the chain config
+	SlowThreshold          uint64                    `toml:",omitempty"` // The time in milliseconds beyond which handling a consensus event is logged as slow, 0 means never
+	EmptyBlockPeriod       uint64                    `toml:",omitempty"` // The minimum difference in seconds between the timestamps of a block and an empty block following it, 0 means the BlockPeriod
+	LogRetention           uint64                    `toml:",omitempty"` // The number of recently committed sequences whose consensus messages are kept for querying, 0 means none
+	ValidatorsRootBlock    *big.Int                  `toml:"-"`          // The first block from which the checkpoint blocks carry the Merkle root of their validators, nil means never, set from the chain config
+	VerifyWorkers          uint64                    `toml:",omitempty"` // The number of headers verified concurrently in a batch, 0 means one per CPU
+	ValidatorRegistry      common.Address            `toml:"-"`          // The contract whose getValidators() sets the validators at each epoch from ValidatorRegistryBlock on, set from the chain config
+	ValidatorRegistryBlock *big.Int                  `toml:"-"`          // The first epoch block whose validators are read from the registry, nil means the header votes forever, set from the chain config
+	TxOrdering             TxOrdering                `toml:",omitempty"` // The rule the transactions of a proposal must be ordered by, all the validators must use the same
+	LogNonValidators       bool                      `toml:",omitempty"` // Whether the messages from non-validators are logged and counted before being dropped
+	FinalitySync           bool                      `toml:",omitempty"` // Whether the headers imported in batches, as by the sync, are only verified by their committed seals
+	PeerMessageRate        uint64                    `toml:",omitempty"` // The number of consensus messages per second accepted from each peer, the messages beyond are dropped, 0 means no limit
+	PeerMessageBurst       uint64                    `toml:",omitempty"` // The number of consensus messages a peer may send at once, at least the PeerMessageRate
+	HealthWindow           uint64                    `toml:",omitempty"` // The time in seconds within which a block must be committed for the consensus to be healthy, 0 means no limit
+	HealthMaxRounds        uint64                    `toml:",omitempty"` // The number of round changes without a commit beyond which the consensus is unhealthy, 0 means no limit
+	AbandonOnStop          bool                      `toml:",omitempty"` // Whether a block whose sealing is stopped is abandoned by the consensus, changing round if it was proposed but not prepared yet
+}
+
+// MaxUnanimousValidators is the largest validator set which can be configured
+// to commit unanimously. A single validator being down halts such a set, so
+// it's only practical for a few of them.
+const MaxUnanimousValidators = 7
+
+// Activated returns whether the block with the given number is sealed by
+// Istanbul. The genesis block and the blocks before the activation block, e.g.
+// mined by another engine before the chain switched, are taken as valid as is.
+func (c *Config) Activated(number uint64) bool {
+	return number > 0 && number >= c.ActivationBlock
 }
 
 var DefaultConfig = &Config{
-	RequestTimeout: 10000,
-	BlockPeriod:    1,
-	ProposerPolicy: RoundRobin,
-	Epoch:          30000,
+	RequestTimeout:     10000,
+	BlockPeriod:        1,
+	ProposerPolicy:     RoundRobin,
+	Epoch:              30000,
+	MaxProposalSize:    10 * 1024 * 1024,
+	MaxBacklogSize:     1000,
+	HeartbeatMisses:    3,
+	MaxPendingRequests: 16,
+	LeaseTimeout:       10000,
+	SendRetries:        3,
+	SendRetryBackoff:   100,
+	SlowThreshold:      1000,
+	LogRetention:       128,
+	PeerMessageRate:    500,
+	PeerMessageBurst:   1000,
+	HealthWindow:       60,
+	HealthMaxRounds:    3,
+}
+
+// F returns the number of faulty validators tolerated by valSet, the configured
+// fault tolerance if valSet can tolerate that many. Otherwise, e.g. once the
+// validator set shrank, it's capped to the F of valSet.
+func (c *Config) F(valSet ValidatorSet) int {
+	if c.FaultTolerance > 0 && c.CheckFaultTolerance(valSet) == nil {
+		return int(c.FaultTolerance)
+	}
+	return valSet.F()
+}
+
+// SigSchemeAt returns the signing scheme of the block number, the legacy one
+// before SigSchemeBlock.
+func (c *Config) SigSchemeAt(number *big.Int) SigScheme {
+	if c.SigSchemeBlock == nil || number.Cmp(c.SigSchemeBlock) < 0 {
+		return LegacySigScheme
+	}
+	return c.SigScheme
+}
+
+// DigestAt returns the digest algorithm of the data signed for the block
+// number, Keccak-256 before DigestBlock.
+func (c *Config) DigestAt(number *big.Int) DigestAlgorithm {
+	if c.DigestBlock == nil || number.Cmp(c.DigestBlock) < 0 {
+		return Keccak256Digest
+	}
+	return c.Digest
+}
+
+// RegistryAt returns the validator registry contract of the block number, the
+// zero address before ValidatorRegistryBlock, when the validators are voted in
+// the headers.
+func (c *Config) RegistryAt(number *big.Int) common.Address {
+	if c.ValidatorRegistryBlock == nil || number.Cmp(c.ValidatorRegistryBlock) < 0 {
+		return common.Address{}
+	}
+	return c.ValidatorRegistry
+}
+
+// ValidatorsRootAt returns whether the block number carries the Merkle root of
+// its validators if it's a checkpoint block, from ValidatorsRootBlock on.
+func (c *Config) ValidatorsRootAt(number *big.Int) bool {
+	return c.ValidatorsRootBlock != nil && number.Cmp(c.ValidatorsRootBlock) >= 0
+}
+
+// BlockRewardAt returns the reward credited to the proposer of the block number,
+// nil before BlockRewardBlock.
+func (c *Config) BlockRewardAt(number *big.Int) *big.Int {
+	if c.BlockRewardBlock == nil || number.Cmp(c.BlockRewardBlock) < 0 {
+		return nil
+	}
+	return c.BlockReward
+}
+
+// ByteOrderAt returns whether the proposer of the block number is selected
+// among the validators ordered by their address bytes, from
+// ValidatorOrderBlock on, or by their checksummed hex address before.
+func (c *Config) ByteOrderAt(number *big.Int) bool {
+	return c.ValidatorOrderBlock != nil && number.Cmp(c.ValidatorOrderBlock) >= 0
+}
+
+// WeightsOf returns the voting weights of the validators, in the same order, or
+// nil if they all weigh 1.
+func (c *Config) WeightsOf(validators []common.Address) []uint64 {
+	if len(c.ValidatorWeights) == 0 {
+		return nil
+	}
+	weights := make([]uint64, len(validators))
+	for i, validator := range validators {
+		weights[i] = 1
+		if weight, ok := c.ValidatorWeights[validator]; ok {
+			weights[i] = weight
+		}
+	}
+	return weights
+}
+
+// CommitQuorum returns the voting weight of the COMMITs needed by the core to
+// commit a proposal of valSet. It's the total weight of valSet if
+// the commits are unanimous, unless valSet is too large for that, and 2F+1
+// otherwise.
+func (c *Config) CommitQuorum(valSet ValidatorSet) int {
+	if c.Unanimous && c.CheckUnanimity(valSet) == nil {
+		return int(valSet.TotalWeight())
+	}
+	return c.ChainQuorum(valSet)
+}
+
+// ChainQuorum returns the voting weight of the committed seals a block of valSet
+// needs to be valid, 2F+1. Unlike CommitQuorum it doesn't depend on whether this
+// node commits unanimously, so that all the nodes agree on the validity of the
+// blocks.
+func (c *Config) ChainQuorum(valSet ValidatorSet) int {
+	return 2*c.F(valSet) + 1
+}
+
+// CheckUnanimity returns ErrUnanimityTooLarge if the commits are configured to
+// be unanimous but valSet has more than MaxUnanimousValidators validators.
+func (c *Config) CheckUnanimity(valSet ValidatorSet) error {
+	if c.Unanimous && valSet.Size() > MaxUnanimousValidators {
+		return ErrUnanimityTooLarge
+	}
+	return nil
+}
+
+// CheckDigest returns ErrUnknownDigest if the configured digest algorithm isn't
+// supported.
+func (c *Config) CheckDigest() error {
+	if c.Digest > SHA256Digest {
+		return ErrUnknownDigest
+	}
+	return nil
+}
+
+// CheckRequestTimeout returns ErrInvalidRequestTimeout if the first round times
+// out before the block period is over.
+func (c *Config) CheckRequestTimeout() error {
+	if c.RequestTimeout <= c.BlockPeriod*1000 {
+		return ErrInvalidRequestTimeout
+	}
+	return nil
+}
+
+// CheckRegistryBlock returns ErrInvalidRegistryBlock if the validator registry
+// takes over from another block than an epoch one.
+func (c *Config) CheckRegistryBlock() error {
+	if block := c.ValidatorRegistryBlock; block != nil && (c.Epoch == 0 || block.Uint64()%c.Epoch != 0) {
+		return ErrInvalidRegistryBlock
+	}
+	return nil
+}
+
+// CheckLease returns ErrMissingLeaseHolder if the engine holds a signing lease
+// without a name in it.
+func (c *Config) CheckLease() error {
+	if c.LeaseFile != "" && c.LeaseHolder == "" {
+		return ErrMissingLeaseHolder
+	}
+	return nil
+}
+
+// CheckFaultTolerance returns ErrUnsafeFaultTolerance if the configured fault
+// tolerance can't be guaranteed by valSet, which needs a total weight of at
+// least 3F+1.
+func (c *Config) CheckFaultTolerance(valSet ValidatorSet) error {
+	if c.FaultTolerance > 0 && 3*c.FaultTolerance+1 > valSet.TotalWeight() {
+		return ErrUnsafeFaultTolerance
+	}
+	return nil
 }
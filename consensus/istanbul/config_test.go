@@ -0,0 +1,55 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		config  Config
+		timeout error
+		block   error
+	}{
+		{Config{RequestTimeout: 10000, BlockPeriod: 1, Epoch: 100}, nil, nil},
+		// the round times out before the block period is over
+		{Config{RequestTimeout: 5000, BlockPeriod: 5}, ErrInvalidRequestTimeout, nil},
+		{Config{RequestTimeout: 10000, Epoch: 100, ValidatorRegistryBlock: big.NewInt(200)}, nil, nil},
+		// the registry takes over between two epochs
+		{Config{RequestTimeout: 10000, Epoch: 100, ValidatorRegistryBlock: big.NewInt(150)}, nil, ErrInvalidRegistryBlock},
+		{Config{RequestTimeout: 10000, ValidatorRegistryBlock: big.NewInt(0)}, nil, ErrInvalidRegistryBlock},
+	}
+	for i, test := range tests {
+		if err := test.config.CheckRequestTimeout(); err != test.timeout {
+			t.Errorf("test %d: timeout error mismatch: have %v, want %v", i, err, test.timeout)
+		}
+		if err := test.config.CheckRegistryBlock(); err != test.block {
+			t.Errorf("test %d: registry error mismatch: have %v, want %v", i, err, test.block)
+		}
+	}
+}
+
+func TestCheckLease(t *testing.T) {
+	if err := (&Config{LeaseFile: "lease"}).CheckLease(); err != ErrMissingLeaseHolder {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrMissingLeaseHolder)
+	}
+	if err := (&Config{LeaseFile: "lease", LeaseHolder: "primary"}).CheckLease(); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+}
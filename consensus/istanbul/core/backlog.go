@@ -17,20 +17,30 @@
 package core
 
 import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
 
 var (
-	// msgPriority is defined for calculating processing priority to speedup consensus
-	// msgPreprepare > msgCommit > msgPrepare
+	// msgPriority is defined for calculating processing priority to speedup consensus.
+	// Within a view, msgCommit > msgPrepare > msgPreprepare, as a quorum of COMMITs
+	// completes the round without waiting for the PREPAREs.
 	msgPriority = map[uint64]int{
-		msgPreprepare: 1,
-		msgCommit:     2,
-		msgPrepare:    3,
+		msgCommit:     1,
+		msgPrepare:    2,
+		msgPreprepare: 3,
 	}
 )
 
+// backlogEntry is a future message waiting in the backlog
+type backlogEntry struct {
+	msg  *message
+	time time.Time // the time the message is stored
+}
+
 // checkMessage checks the message state
 // return errInvalidMessage if the message is invalid
 // return errFutureMessage if the message view is larger than current view
@@ -85,6 +95,11 @@ func (c *core) storeBacklog(msg *message, src istanbul.Validator) {
 
 	logger.Trace("Store future message")
 
+	view := messageView(msg)
+	if view == nil {
+		return
+	}
+
 	c.backlogsMu.Lock()
 	defer c.backlogsMu.Unlock()
 
@@ -92,24 +107,51 @@ func (c *core) storeBacklog(msg *message, src istanbul.Validator) {
 	if backlog == nil {
 		backlog = prque.New()
 	}
-	switch msg.Code {
-	case msgPreprepare:
-		var p *istanbul.Preprepare
-		err := msg.Decode(&p)
-		if err == nil {
-			backlog.Push(msg, toPriority(msg.Code, p.View))
-		}
-		// for msgRoundChange, msgPrepare and msgCommit cases
-	default:
-		var p *istanbul.Subject
-		err := msg.Decode(&p)
-		if err == nil {
-			backlog.Push(msg, toPriority(msg.Code, p.View))
-		}
+	backlog.Push(&backlogEntry{msg: msg, time: time.Now()}, toPriority(msg.Code, view))
+	if max := int(c.config.MaxBacklogSize); max > 0 && backlog.Size() > max {
+		logger.Debug("Backlog full, drop the furthest messages", "size", backlog.Size(), "max", max)
+		trimBacklog(backlog, max)
 	}
 	c.backlogs[src] = backlog
 }
 
+// handleConnectionEvent drops the backlog of a validator which (re)connected.
+// The messages it sent before being disconnected are likely stale, and those
+// it missed meanwhile are retransmitted by the round change and sync paths.
+// The sequence it was seen at is forgotten as well, so a catch-up is only
+// requested once its new messages show we're behind.
+func (c *core) handleConnectionEvent(addr common.Address) {
+	if _, src := c.valSet.GetByAddress(addr); src == nil || addr == c.Address() {
+		return
+	}
+	c.logger.Debug("Validator connected, reset its backlog", "address", addr)
+	delete(c.futureSequences, addr)
+
+	c.backlogsMu.Lock()
+	defer c.backlogsMu.Unlock()
+	for src := range c.backlogs {
+		if src.Address() == addr {
+			delete(c.backlogs, src)
+		}
+	}
+}
+
+// trimBacklog keeps the given number of messages with the highest priority,
+// which are the nearest to the current view, and drops the others.
+func trimBacklog(backlog *prque.Prque, size int) {
+	items := make([]interface{}, 0, size)
+	prios := make([]float32, 0, size)
+	for len(items) < size && !backlog.Empty() {
+		item, prio := backlog.Pop()
+		items = append(items, item)
+		prios = append(prios, prio)
+	}
+	backlog.Reset()
+	for i, item := range items {
+		backlog.Push(item, prios[i])
+	}
+}
+
 func (c *core) processBacklog() {
 	c.backlogsMu.Lock()
 	defer c.backlogsMu.Unlock()
@@ -121,29 +163,22 @@ func (c *core) processBacklog() {
 
 		logger := c.logger.New("from", src, "state", c.state)
 		isFuture := false
+		// the messages of the current view waiting for the state to move on
+		var deferred []*backlogEntry
+		var deferredPrios []float32
 
 		// We stop processing if
 		//   1. backlog is empty
-		//   2. The first message in queue is a future message
+		//   2. The first message in queue is a future message of a future view
 		for !(backlog.Empty() || isFuture) {
 			m, prio := backlog.Pop()
-			msg := m.(*message)
-			var view *istanbul.View
-			switch msg.Code {
-			case msgPreprepare:
-				var m *istanbul.Preprepare
-				err := msg.Decode(&m)
-				if err == nil {
-					view = m.View
-				}
-				// for msgRoundChange, msgPrepare and msgCommit cases
-			default:
-				var sub *istanbul.Subject
-				err := msg.Decode(&sub)
-				if err == nil {
-					view = sub.View
-				}
+			entry := m.(*backlogEntry)
+			msg := entry.msg
+			if ttl := c.config.BacklogTTL; ttl > 0 && time.Since(entry.time) > time.Duration(ttl)*time.Second {
+				logger.Trace("Drop expired backlog", "msg", msg)
+				continue
 			}
+			view := messageView(msg)
 			if view == nil {
 				logger.Debug("Nil view", "msg", msg)
 				continue
@@ -152,8 +187,15 @@ func (c *core) processBacklog() {
 			err := c.checkMessage(msg.Code, view)
 			if err != nil {
 				if err == errFutureMessage {
+					// A message of the current view waits for the state to move
+					// on, e.g. a COMMIT popped ahead of the PRE-PREPARE it needs
+					if view.Cmp(c.currentView()) == 0 {
+						deferred = append(deferred, entry)
+						deferredPrios = append(deferredPrios, prio)
+						continue
+					}
 					logger.Trace("Stop processing backlog", "msg", msg)
-					backlog.Push(msg, prio)
+					backlog.Push(entry, prio)
 					isFuture = true
 					break
 				}
@@ -167,7 +209,29 @@ func (c *core) processBacklog() {
 				msg: msg,
 			})
 		}
+		for i, entry := range deferred {
+			backlog.Push(entry, deferredPrios[i])
+		}
+	}
+}
+
+// messageView decodes the view of the given message. It returns nil if the
+// message is malformed.
+func messageView(msg *message) *istanbul.View {
+	switch msg.Code {
+	case msgPreprepare:
+		var p *istanbul.Preprepare
+		if err := msg.Decode(&p); err == nil {
+			return p.View
+		}
+		// for msgRoundChange, msgPrepare and msgCommit cases
+	default:
+		var sub *istanbul.Subject
+		if err := msg.Decode(&sub); err == nil {
+			return sub.View
+		}
 	}
+	return nil
 }
 
 func toPriority(msgCode uint64, view *istanbul.View) float32 {
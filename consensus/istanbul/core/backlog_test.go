@@ -167,6 +167,7 @@ func TestCheckMessage(t *testing.T) {
 
 func TestStoreBacklog(t *testing.T) {
 	c := &core{
+		config:     istanbul.DefaultConfig,
 		logger:     log.New("backend", "test", "id", 0),
 		backlogs:   make(map[istanbul.Validator]*prque.Prque),
 		backlogsMu: new(sync.Mutex),
@@ -187,7 +188,7 @@ func TestStoreBacklog(t *testing.T) {
 		Msg:  prepreparePayload,
 	}
 	c.storeBacklog(m, p)
-	msg := c.backlogs[p].PopItem()
+	msg := c.backlogs[p].PopItem().(*backlogEntry).msg
 	if !reflect.DeepEqual(msg, m) {
 		t.Errorf("message mismatch: have %v, want %v", msg, m)
 	}
@@ -204,7 +205,7 @@ func TestStoreBacklog(t *testing.T) {
 		Msg:  subjectPayload,
 	}
 	c.storeBacklog(m, p)
-	msg = c.backlogs[p].PopItem()
+	msg = c.backlogs[p].PopItem().(*backlogEntry).msg
 	if !reflect.DeepEqual(msg, m) {
 		t.Errorf("message mismatch: have %v, want %v", msg, m)
 	}
@@ -215,7 +216,7 @@ func TestStoreBacklog(t *testing.T) {
 		Msg:  subjectPayload,
 	}
 	c.storeBacklog(m, p)
-	msg = c.backlogs[p].PopItem()
+	msg = c.backlogs[p].PopItem().(*backlogEntry).msg
 	if !reflect.DeepEqual(msg, m) {
 		t.Errorf("message mismatch: have %v, want %v", msg, m)
 	}
@@ -226,17 +227,110 @@ func TestStoreBacklog(t *testing.T) {
 		Msg:  subjectPayload,
 	}
 	c.storeBacklog(m, p)
-	msg = c.backlogs[p].PopItem()
+	msg = c.backlogs[p].PopItem().(*backlogEntry).msg
 	if !reflect.DeepEqual(msg, m) {
 		t.Errorf("message mismatch: have %v, want %v", msg, m)
 	}
 }
 
+func TestStoreBacklogLimit(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.MaxBacklogSize = 5
+	c := &core{
+		config:     &config,
+		logger:     log.New("backend", "test", "id", 0),
+		backlogs:   make(map[istanbul.Validator]*prque.Prque),
+		backlogsMu: new(sync.Mutex),
+	}
+	p := validator.New(common.StringToAddress("12345667890"))
+	for i := 20; i > 0; i-- {
+		subject := &istanbul.Subject{
+			View: &istanbul.View{
+				Round:    big.NewInt(0),
+				Sequence: big.NewInt(int64(i)),
+			},
+			Digest: common.StringToHash("1234567890"),
+		}
+		payload, _ := Encode(subject)
+		c.storeBacklog(&message{
+			Code: msgCommit,
+			Msg:  payload,
+		}, p)
+		if size := c.backlogs[p].Size(); size > int(config.MaxBacklogSize) {
+			t.Fatalf("backlog size mismatch: have %v, want at most %v", size, config.MaxBacklogSize)
+		}
+	}
+	// the nearest messages should be kept
+	for i := 1; !c.backlogs[p].Empty(); i++ {
+		var subject *istanbul.Subject
+		c.backlogs[p].PopItem().(*backlogEntry).msg.Decode(&subject)
+		if subject.View.Sequence.Cmp(big.NewInt(int64(i))) != 0 {
+			t.Errorf("sequence mismatch: have %v, want %v", subject.View.Sequence, i)
+		}
+	}
+}
+
+func TestProcessExpiredBacklog(t *testing.T) {
+	vset := newTestValidatorSet(1)
+	backend := &testSystemBackend{
+		events: new(event.TypeMux),
+		peers:  vset,
+	}
+	config := *istanbul.DefaultConfig
+	config.BacklogTTL = 60
+	c := &core{
+		config:     &config,
+		logger:     log.New("backend", "test", "id", 0),
+		backlogs:   make(map[istanbul.Validator]*prque.Prque),
+		backlogsMu: new(sync.Mutex),
+		backend:    backend,
+		state:      StatePrepared,
+		current: newRoundState(&istanbul.View{
+			Sequence: big.NewInt(1),
+			Round:    big.NewInt(0),
+		}, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+	}
+	c.subscribeEvents()
+	defer c.unsubscribeEvents()
+
+	subject := &istanbul.Subject{
+		View: &istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		Digest: common.StringToHash("1234567890"),
+	}
+	payload, _ := Encode(subject)
+	src := vset.GetByIndex(0)
+	c.storeBacklog(&message{
+		Code: msgCommit,
+		Msg:  payload,
+	}, src)
+	// age the stored message beyond the TTL
+	entry := c.backlogs[src].PopItem().(*backlogEntry)
+	entry.time = time.Now().Add(-2 * time.Minute)
+	c.backlogs[src].Push(entry, toPriority(msgCommit, subject.View))
+
+	c.processBacklog()
+
+	if !c.backlogs[src].Empty() {
+		t.Errorf("backlog size mismatch: have %v, want 0", c.backlogs[src].Size())
+	}
+	timeout := time.NewTimer(time.Second)
+	select {
+	case ev := <-c.events.Chan():
+		t.Errorf("unexpected event comes: %v", reflect.TypeOf(ev.Data))
+	case <-timeout.C:
+		// success
+	}
+}
+
 func TestProcessFutureBacklog(t *testing.T) {
 	backend := &testSystemBackend{
 		events: new(event.TypeMux),
 	}
 	c := &core{
+		config:     istanbul.DefaultConfig,
 		logger:     log.New("backend", "test", "id", 0),
 		backlogs:   make(map[istanbul.Validator]*prque.Prque),
 		backlogsMu: new(sync.Mutex),
@@ -328,6 +422,7 @@ func testProcessBacklog(t *testing.T, msg *message) {
 		peers:  vset,
 	}
 	c := &core{
+		config:     istanbul.DefaultConfig,
 		logger:     log.New("backend", "test", "id", 0),
 		backlogs:   make(map[istanbul.Validator]*prque.Prque),
 		backlogsMu: new(sync.Mutex),
@@ -360,3 +455,137 @@ func testProcessBacklog(t *testing.T, msg *message) {
 		t.Error("unexpected timeout occurs")
 	}
 }
+
+func TestBacklogPriority(t *testing.T) {
+	c := &core{
+		config:     istanbul.DefaultConfig,
+		logger:     log.New("backend", "test", "id", 0),
+		backlogs:   make(map[istanbul.Validator]*prque.Prque),
+		backlogsMu: new(sync.Mutex),
+	}
+	p := validator.New(common.StringToAddress("12345667890"))
+	newMsg := func(code uint64, seq int64) *message {
+		v := &istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(seq),
+		}
+		var payload []byte
+		if code == msgPreprepare {
+			payload, _ = Encode(&istanbul.Preprepare{View: v, Proposal: makeBlock(seq)})
+		} else {
+			payload, _ = Encode(&istanbul.Subject{View: v, Digest: common.StringToHash("1234567890")})
+		}
+		return &message{Code: code, Msg: payload}
+	}
+	// the messages arrive interleaved
+	prepare2 := newMsg(msgPrepare, 2)
+	preprepare1 := newMsg(msgPreprepare, 1)
+	prepare1 := newMsg(msgPrepare, 1)
+	commit2 := newMsg(msgCommit, 2)
+	commit1 := newMsg(msgCommit, 1)
+	for _, m := range []*message{prepare2, preprepare1, prepare1, commit2, commit1} {
+		c.storeBacklog(m, p)
+	}
+
+	// the COMMIT completing the current sequence comes first
+	want := []*message{commit1, prepare1, preprepare1, commit2, prepare2}
+	for i, m := range want {
+		msg := c.backlogs[p].PopItem().(*backlogEntry).msg
+		if !reflect.DeepEqual(msg, m) {
+			t.Errorf("message %d mismatch: have code %v, want code %v", i, msg.Code, m.Code)
+		}
+	}
+}
+
+func TestProcessDeferredBacklog(t *testing.T) {
+	backend := &testSystemBackend{
+		events: new(event.TypeMux),
+	}
+	v := &istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}
+	c := &core{
+		config:     istanbul.DefaultConfig,
+		logger:     log.New("backend", "test", "id", 0),
+		backlogs:   make(map[istanbul.Validator]*prque.Prque),
+		backlogsMu: new(sync.Mutex),
+		backend:    backend,
+		current:    newRoundState(v, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+		state:      StateAcceptRequest,
+	}
+	c.subscribeEvents()
+	defer c.unsubscribeEvents()
+
+	p := validator.New(common.StringToAddress("12345667890"))
+	prepreparePayload, _ := Encode(&istanbul.Preprepare{View: v, Proposal: makeBlock(1)})
+	subjectPayload, _ := Encode(&istanbul.Subject{View: v, Digest: common.StringToHash("1234567890")})
+	c.storeBacklog(&message{Code: msgPreprepare, Msg: prepreparePayload}, p)
+	c.storeBacklog(&message{Code: msgCommit, Msg: subjectPayload}, p)
+
+	expect := func(code uint64) {
+		select {
+		case ev := <-c.events.Chan():
+			e, ok := ev.Data.(backlogEvent)
+			if !ok {
+				t.Fatalf("unexpected event comes: %v", reflect.TypeOf(ev.Data))
+			}
+			if e.msg.Code != code {
+				t.Errorf("message code mismatch: have %v, want %v", e.msg.Code, code)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("unexpected timeout occurs")
+		}
+	}
+	// the COMMIT popped first can't be handled yet, but doesn't hold the
+	// PRE-PREPARE back
+	c.processBacklog()
+	expect(msgPreprepare)
+	if size := c.backlogs[p].Size(); size != 1 {
+		t.Errorf("backlog size mismatch: have %v, want 1", size)
+	}
+
+	c.state = StatePreprepared
+	c.processBacklog()
+	expect(msgCommit)
+}
+
+func TestConnectionEventResetsBacklog(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	c.current = newTestRoundState(&istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}, c.valSet)
+
+	// Two validators sent messages of future sequences before one of them
+	// got disconnected
+	reconnected, other := c.valSet.GetByIndex(1), c.valSet.GetByIndex(2)
+	for i, src := range []istanbul.Validator{reconnected, other} {
+		subject, _ := Encode(&istanbul.Subject{
+			View: &istanbul.View{
+				Round:    big.NewInt(0),
+				Sequence: big.NewInt(int64(3 - i)),
+			},
+			Digest: common.StringToHash("1234567890"),
+		})
+		if err := c.handleCheckedMsg(&message{Code: msgCommit, Msg: subject, Address: src.Address()}, src); err != errFutureMessage {
+			t.Fatalf("error mismatch: have %v, want %v", err, errFutureMessage)
+		}
+	}
+	if _, ok := c.futureSequences[reconnected.Address()]; !ok {
+		t.Fatalf("the future sequence of the validator should be recorded")
+	}
+
+	c.handleEvent(istanbul.ConnectionEvent{Address: reconnected.Address()})
+
+	if backlog, ok := c.backlogs[reconnected]; ok {
+		t.Errorf("backlog size mismatch: have %v, want 0", backlog.Size())
+	}
+	if _, ok := c.futureSequences[reconnected.Address()]; ok {
+		t.Errorf("the future sequence of the reconnected validator should be forgotten")
+	}
+	if size := c.backlogs[other].Size(); size != 1 {
+		t.Errorf("backlog size mismatch: have %v, want 1", size)
+	}
+}
@@ -23,38 +23,27 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
 )
 
-func (c *core) sendCommit() {
+func (c *core) sendCommit() error {
 	sub := c.current.Subject()
-	c.broadcastCommit(sub)
+	return c.broadcastMsg(msgCommit, sub)
 }
 
-func (c *core) sendCommitForOldBlock(view *istanbul.View, digest common.Hash) {
+func (c *core) sendCommitForOldBlock(view *istanbul.View, digest common.Hash) error {
 	sub := &istanbul.Subject{
 		View:   view,
 		Digest: digest,
 	}
-	c.broadcastCommit(sub)
-}
-
-func (c *core) broadcastCommit(sub *istanbul.Subject) {
-	logger := c.logger.New("state", c.state)
-
-	encodedSubject, err := Encode(sub)
-	if err != nil {
-		logger.Error("Failed to encode", "subject", sub)
-		return
-	}
-	c.broadcast(&message{
-		Code: msgCommit,
-		Msg:  encodedSubject,
-	})
+	return c.broadcastMsg(msgCommit, sub)
 }
 
 func (c *core) handleCommit(msg *message, src istanbul.Validator) error {
+	logger := c.newMsgLogger(msgCommit, "from", src)
+
 	// Decode COMMIT message
 	var commit *istanbul.Subject
 	err := msg.Decode(&commit)
 	if err != nil {
+		logger.Error("Failed to decode COMMIT", "err", err)
 		return errFailedDecodeCommit
 	}
 
@@ -62,6 +51,9 @@ func (c *core) handleCommit(msg *message, src istanbul.Validator) error {
 		return err
 	}
 
+	// Record the evidence if the validator signed another COMMIT in this round
+	c.checkEquivocation(msg, commit.View, commit.Digest)
+
 	if err := c.verifyCommit(commit, src); err != nil {
 		return err
 	}
@@ -72,9 +64,8 @@ func (c *core) handleCommit(msg *message, src istanbul.Validator) error {
 	//
 	// If we already have a proposal, we may have chance to speed up the consensus process
 	// by committing the proposal without PREPARE messages.
-	if c.current.Commits.Size() > 2*c.valSet.F() && c.state.Cmp(StateCommitted) < 0 {
-		// Still need to call LockHash here since state can skip Prepared state and jump directly to the Committed state.
-		c.current.LockHash()
+	if c.current.Commits.Weight() >= c.config.CommitQuorum(c.valSet) && c.state.Cmp(StateCommitted) < 0 {
+		logger.Trace("Received enough COMMIT messages", "size", c.current.Commits.Size(), "weight", c.current.Commits.Weight())
 		c.commit()
 	}
 
@@ -83,19 +74,29 @@ func (c *core) handleCommit(msg *message, src istanbul.Validator) error {
 
 // verifyCommit verifies if the received COMMIT message is equivalent to our subject
 func (c *core) verifyCommit(commit *istanbul.Subject, src istanbul.Validator) error {
-	logger := c.logger.New("from", src, "state", c.state)
+	logger := c.newMsgLogger(msgCommit, "from", src)
+
+	if commit == nil || commit.View == nil || commit.View.Sequence == nil || commit.View.Round == nil || commit.Digest == (common.Hash{}) {
+		logger.Warn("Malformed subject", "got", commit)
+		return errInvalidMessage
+	}
 
 	sub := c.current.Subject()
 	if !reflect.DeepEqual(commit, sub) {
 		logger.Warn("Inconsistent subjects between commit and proposal", "expected", sub, "got", commit)
 		return errInconsistentSubject
 	}
+	// The digest must still be the hash of the proposal we'd commit
+	if hash := c.current.Proposal().Hash(); hash != commit.Digest {
+		logger.Warn("Inconsistent digest between commit and proposal", "expected", hash, "got", commit.Digest)
+		return errInconsistentSubject
+	}
 
 	return nil
 }
 
 func (c *core) acceptCommit(msg *message, src istanbul.Validator) error {
-	logger := c.logger.New("from", src, "state", c.state)
+	logger := c.newMsgLogger(msgCommit, "from", src)
 
 	// Add the COMMIT message to current round state
 	if err := c.current.Commits.Add(msg); err != nil {
@@ -25,6 +25,7 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
 	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
 )
 
 func TestHandleCommit(t *testing.T) {
@@ -225,6 +226,214 @@ OUTER:
 	}
 }
 
+func TestHandleWeightedCommit(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	// The total weight is 8, so more than 4 is needed to commit
+	heavy := int(N - 1)
+	weights := make([]uint64, N)
+	for i := range weights {
+		weights[i] = 1
+	}
+	weights[heavy] = 5
+
+	testCases := []struct {
+		voters    []int
+		committed bool
+	}{
+		{
+			// Three validators out of four, but not enough weight
+			[]int{0, 1, 2},
+			false,
+		},
+		{
+			// A single validator with enough weight
+			[]int{heavy},
+			true,
+		},
+	}
+
+	for i, test := range testCases {
+		sys := NewTestSystemWithBackend(N, F)
+		r0 := sys.backends[0].engine.(*core)
+
+		var addrs []common.Address
+		for _, backend := range sys.backends {
+			addrs = append(addrs, backend.Address())
+		}
+		valSet, err := validator.NewWeightedSet(addrs, weights, istanbul.RoundRobin)
+		if err != nil {
+			t.Fatalf("test %d: error mismatch: have %v, want nil", i, err)
+		}
+		r0.valSet = valSet
+		r0.current = newTestRoundState(
+			&istanbul.View{
+				Round:    big.NewInt(0),
+				Sequence: big.NewInt(1),
+			},
+			valSet,
+		)
+		r0.state = StatePrepared
+
+		m, _ := Encode(r0.current.Subject())
+		for _, j := range test.voters {
+			_, v := valSet.GetByAddress(addrs[j])
+			if err := r0.handleCommit(&message{
+				Code:          msgCommit,
+				Msg:           m,
+				Address:       v.Address(),
+				Signature:     []byte{},
+				CommittedSeal: v.Address().Bytes(),
+			}, v); err != nil {
+				t.Errorf("case %d: error mismatch: have %v, want nil", i, err)
+			}
+		}
+		if committed := r0.state == StateCommitted; committed != test.committed {
+			t.Errorf("case %d: committed mismatch: have %v, want %v", i, committed, test.committed)
+		}
+	}
+}
+
+func TestCommitEquivocation(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	r0 := sys.backends[0].engine.(*core)
+	r0.current = newTestRoundState(
+		&istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		r0.valSet,
+	)
+	r0.state = StatePrepared
+
+	v := r0.valSet.GetByIndex(1)
+	sub := r0.current.Subject()
+	conflicting := &istanbul.Subject{
+		View:   sub.View,
+		Digest: common.StringToHash("conflicting"),
+	}
+	for i, test := range []struct {
+		sub *istanbul.Subject
+		err error
+	}{
+		{sub, nil},
+		{conflicting, errInconsistentSubject},
+	} {
+		m, _ := Encode(test.sub)
+		if err := r0.handleCommit(&message{
+			Code:          msgCommit,
+			Msg:           m,
+			Address:       v.Address(),
+			Signature:     []byte{0x01},
+			CommittedSeal: v.Address().Bytes(),
+		}, v); err != test.err {
+			t.Errorf("case %d: error mismatch: have %v, want %v", i, err, test.err)
+		}
+	}
+
+	evidence := r0.MisbehaviorEvidence()
+	if len(evidence) != 1 {
+		t.Fatalf("the number of evidences mismatch: have %v, want 1", len(evidence))
+	}
+	if evidence[0].Validator != v.Address() || evidence[0].Type != msgNames[msgCommit] {
+		t.Errorf("evidence mismatch: have %v %v, want %v %v", evidence[0].Validator, evidence[0].Type, v.Address(), msgNames[msgCommit])
+	}
+	for i, test := range []struct {
+		payload []byte
+		digest  common.Hash
+	}{
+		{evidence[0].First, sub.Digest},
+		{evidence[0].Second, conflicting.Digest},
+	} {
+		msg := new(message)
+		if err := msg.FromPayload(test.payload, nil); err != nil {
+			t.Fatalf("case %d: failed to decode payload: %v", i, err)
+		}
+		var got *istanbul.Subject
+		if err := msg.Decode(&got); err != nil || got.Digest != test.digest {
+			t.Errorf("case %d: digest mismatch: have %v, want %v", i, got, test.digest)
+		}
+	}
+
+	// The same COMMIT again is not an equivocation
+	m, _ := Encode(sub)
+	r0.handleCommit(&message{
+		Code:          msgCommit,
+		Msg:           m,
+		Address:       v.Address(),
+		Signature:     []byte{0x01},
+		CommittedSeal: v.Address().Bytes(),
+	}, v)
+	if len(r0.MisbehaviorEvidence()) != 1 {
+		t.Errorf("the number of evidences mismatch: have %v, want 1", len(r0.MisbehaviorEvidence()))
+	}
+}
+
+func TestCommitLogFields(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	r0 := sys.backends[0].engine.(*core)
+	r0.current = newTestRoundState(
+		&istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		r0.valSet,
+	)
+	r0.state = StatePrepared
+
+	var records []*log.Record
+	r0.logger = log.New()
+	r0.logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	}))
+
+	m, _ := Encode(r0.current.Subject())
+	for i := 0; i < int(2*F+1); i++ {
+		validator := r0.valSet.GetByIndex(uint64(i))
+		r0.handleCommit(&message{
+			Code:          msgCommit,
+			Msg:           m,
+			Address:       validator.Address(),
+			Signature:     []byte{},
+			CommittedSeal: validator.Address().Bytes(),
+		}, validator)
+	}
+	if r0.state != StateCommitted {
+		t.Fatalf("state mismatch: have %v, want %v", r0.state, StateCommitted)
+	}
+
+	var found bool
+	for _, r := range records {
+		if r.Msg != "Commit proposal" {
+			continue
+		}
+		found = true
+		fields := make(map[string]interface{})
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			fields[r.Ctx[i].(string)] = r.Ctx[i+1]
+		}
+		for _, key := range []string{"view", "seq", "state", "msgType"} {
+			if _, ok := fields[key]; !ok {
+				t.Errorf("missing log field %q: have %v", key, r.Ctx)
+			}
+		}
+		if fields["msgType"] != msgNames[msgCommit] {
+			t.Errorf("msgType mismatch: have %v, want %v", fields["msgType"], msgNames[msgCommit])
+		}
+	}
+	if !found {
+		t.Errorf("commit should be logged")
+	}
+}
+
 // round is not checked for now
 func TestVerifyCommit(t *testing.T) {
 	// for log purpose
@@ -277,7 +486,7 @@ func TestVerifyCommit(t *testing.T) {
 		},
 		{
 			// malicious package(lack of sequence)
-			expected: errInconsistentSubject,
+			expected: errInvalidMessage,
 			commit: &istanbul.Subject{
 				View:   &istanbul.View{Round: big.NewInt(0), Sequence: nil},
 				Digest: newTestProposal().Hash(),
@@ -287,6 +496,29 @@ func TestVerifyCommit(t *testing.T) {
 				valSet,
 			),
 		},
+		{
+			// malicious package(lack of view)
+			expected: errInvalidMessage,
+			commit: &istanbul.Subject{
+				View:   nil,
+				Digest: newTestProposal().Hash(),
+			},
+			roundState: newTestRoundState(
+				&istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)},
+				valSet,
+			),
+		},
+		{
+			// malicious package(lack of digest)
+			expected: errInvalidMessage,
+			commit: &istanbul.Subject{
+				View: &istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)},
+			},
+			roundState: newTestRoundState(
+				&istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)},
+				valSet,
+			),
+		},
 		{
 			// wrong prepare message with same sequence but different round
 			expected: errInconsistentSubject,
@@ -323,3 +555,77 @@ func TestVerifyCommit(t *testing.T) {
 		}
 	}
 }
+
+func TestVerifyCommitDigest(t *testing.T) {
+	sys := NewTestSystemWithBackend(1, 0)
+	c := sys.backends[0].engine.(*core)
+	view := &istanbul.View{Round: big.NewInt(0), Sequence: big.NewInt(1)}
+	c.current = newTestRoundState(view, c.valSet)
+	accepted := c.current.Proposal().Hash()
+	src := c.valSet.GetByIndex(0)
+
+	// A COMMIT for another proposal of the view is rejected
+	if err := c.verifyCommit(&istanbul.Subject{View: view, Digest: makeBlock(2).Hash()}, src); err != errInconsistentSubject {
+		t.Errorf("error mismatch: have %v, want %v", err, errInconsistentSubject)
+	}
+	if err := c.verifyCommit(&istanbul.Subject{View: view, Digest: accepted}, src); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+
+	// The subject is bound to the proposal accepted with the PRE-PREPARE, so
+	// once the proposal no longer matches, nothing can be committed
+	c.current.Preprepare = &istanbul.Preprepare{View: view, Proposal: makeBlock(2)}
+	for _, digest := range []common.Hash{accepted, makeBlock(2).Hash()} {
+		if err := c.verifyCommit(&istanbul.Subject{View: view, Digest: digest}, src); err != errInconsistentSubject {
+			t.Errorf("digest %x: error mismatch: have %v, want %v", digest, err, errInconsistentSubject)
+		}
+	}
+}
+
+func TestCommitOutOfOrder(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	r0 := sys.backends[0].engine.(*core)
+	r0.current = newTestRoundState(
+		&istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		r0.valSet,
+	)
+	// The proposal hasn't been PRE-PREPARED, yet a quorum of COMMITs arrives
+	r0.state = StateAcceptRequest
+
+	m, _ := Encode(r0.current.Subject())
+	for i := 0; i < int(2*F+1); i++ {
+		validator := r0.valSet.GetByIndex(uint64(i))
+		msg := &message{
+			Code:          msgCommit,
+			Msg:           m,
+			Address:       validator.Address(),
+			Signature:     []byte{},
+			CommittedSeal: validator.Address().Bytes(),
+		}
+		// The COMMITs wait in the backlog for the PRE-PREPARE
+		if err := r0.handleCommit(msg, validator); err != errFutureMessage {
+			t.Fatalf("error mismatch: have %v, want %v", err, errFutureMessage)
+		}
+		// unless an ordering bug lets them through
+		if err := r0.acceptCommit(msg, validator); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+	}
+	r0.commit()
+
+	if r0.state != StateAcceptRequest {
+		t.Errorf("state mismatch: have %v, want %v", r0.state, StateAcceptRequest)
+	}
+	if committed := len(sys.backends[0].committedMsgs); committed != 0 {
+		t.Errorf("the number of committed proposals mismatch: have %v, want 0", committed)
+	}
+	if r0.current.IsHashLocked() {
+		t.Errorf("the proposal shouldn't be locked")
+	}
+}
@@ -36,19 +36,31 @@ import (
 func New(backend istanbul.Backend, config *istanbul.Config) Engine {
 	c := &core{
 		config:             config,
+		clock:              istanbul.SystemClock,
 		address:            backend.Address(),
 		state:              StateAcceptRequest,
 		handlerWg:          new(sync.WaitGroup),
-		logger:             log.New("address", backend.Address()),
+		stateMu:            new(sync.RWMutex),
+		replica:            -1,
+		rootLogger:         log.Root(),
+		logger:             log.New("address", backend.Address(), "replica", -1),
 		backend:            backend,
 		backlogs:           make(map[istanbul.Validator]*prque.Prque),
 		backlogsMu:         new(sync.Mutex),
 		pendingRequests:    prque.New(),
 		pendingRequestsMu:  new(sync.Mutex),
+		futureSequences:    make(map[common.Address]*big.Int),
+		votes:              make(map[voteKey]*vote),
+		syncProposals:      prque.New(),
+		syncProposalsMu:    new(sync.Mutex),
 		consensusTimestamp: time.Time{},
 		roundMeter:         metrics.NewRegisteredMeter("consensus/istanbul/core/round", nil),
 		sequenceMeter:      metrics.NewRegisteredMeter("consensus/istanbul/core/sequence", nil),
 		consensusTimer:     metrics.NewRegisteredTimer("consensus/istanbul/core/consensus", nil),
+		droppedMeter:       metrics.NewRegisteredMeter("consensus/istanbul/core/dropped", nil),
+		panicMeter:         metrics.NewRegisteredMeter("consensus/istanbul/core/panic", nil),
+		nonValidatorMeter:  metrics.NewRegisteredMeter("consensus/istanbul/core/nonvalidator", nil),
+		fastPath:           true,
 	}
 	c.validateFn = c.checkValidatorSignature
 	return c
@@ -58,15 +70,19 @@ func New(backend istanbul.Backend, config *istanbul.Config) Engine {
 
 type core struct {
 	config  *istanbul.Config
+	clock   istanbul.Clock // the source of time of the timers
 	address common.Address
 	state   State
-	logger  log.Logger
+	replica int // our index in the validator set, -1 if we aren't a validator
+
+	rootLogger log.Logger // the parent of logger, which adds our address and replica index
+	logger     log.Logger
 
 	backend               istanbul.Backend
 	events                *event.TypeMuxSubscription
 	finalCommittedSub     *event.TypeMuxSubscription
 	timeoutSub            *event.TypeMuxSubscription
-	futurePreprepareTimer *time.Timer
+	futurePreprepareTimer istanbul.Timer
 
 	valSet                istanbul.ValidatorSet
 	waitingForRoundChange bool
@@ -76,14 +92,61 @@ type core struct {
 	backlogsMu *sync.Mutex
 
 	current   *roundState
+	head      common.Hash // hash of the last proposal the current sequence extends
 	handlerWg *sync.WaitGroup
 
+	// stateMu protects the consensus state (state, current, valSet,
+	// waitingForRoundChange, roundChangeSet and the timers). It is held by
+	// the event loop while handling an event, so it must be acquired before
+	// backlogsMu, pendingRequestsMu and syncProposalsMu.
+	stateMu *sync.RWMutex
+
 	roundChangeSet   *roundChangeSet
-	roundChangeTimer *time.Timer
+	roundChangeTimer istanbul.Timer
+	// halted is set when the consensus stops changing rounds after
+	// MaxRounds round changes without a commit
+	halted bool
+	// committedAt is the time the last proposal was committed, or the core
+	// started if none was since
+	committedAt time.Time
+	// paused is set by the operator to stop proposing and voting, while the
+	// messages of the others are still handled and relayed
+	paused bool
+	// fastPath handles our own consensus messages right away, instead of
+	// waiting for the backend to deliver them back, when we're a quorum on
+	// our own
+	fastPath bool
+
+	heartbeatTimer istanbul.Timer
+	// proposalTimer bounds the time our proposal takes to be prepared
+	proposalTimer istanbul.Timer
+	// the number of heartbeat intervals without a heartbeat from the proposer
+	heartbeatMisses uint64
+
+	// the validators which voted in each of the recent sequences
+	participation []*sequenceVotes
+
+	// the first PRE-PREPARE, PREPARE and COMMIT of each validator in the current sequence
+	votes map[voteKey]*vote
+	// the evidences of equivocation
+	evidence []*Evidence
+	// the logs of the recently committed sequences
+	logs []*Log
+
+	// the level up to which the logs are written to traceOutput, 0 if not tracing
+	traceLevel log.Lvl
+	// the messages processed while tracing
+	traces *traceBuffer
 
 	pendingRequests   *prque.Prque
 	pendingRequestsMu *sync.Mutex
 
+	// the highest future sequence seen from each validator
+	futureSequences map[common.Address]*big.Int
+	syncRequested   bool
+	syncProposals   *prque.Prque
+	syncProposalsMu *sync.Mutex
+
 	consensusTimestamp time.Time
 	// the meter to record the round change rate
 	roundMeter metrics.Meter
@@ -91,19 +154,27 @@ type core struct {
 	sequenceMeter metrics.Meter
 	// the timer to record consensus duration (from accepting a preprepare to final committed stage)
 	consensusTimer metrics.Timer
+	// the meter to record the messages dropped while the event buffer is full
+	droppedMeter metrics.Meter
+	// the meter to record the events dropped because their handler panicked
+	panicMeter metrics.Meter
+	// the meter to record the messages dropped because they come from a
+	// non-validator, if configured to
+	nonValidatorMeter metrics.Meter
 }
 
 func (c *core) finalizeMessage(msg *message) ([]byte, error) {
 	var err error
-	// Add sender address
+	// Add sender address and version
 	msg.Address = c.Address()
+	msg.Version = msgVersion
 
 	// Add proof of consensus
 	msg.CommittedSeal = []byte{}
 	// Assign the CommittedSeal if it's a COMMIT message and proposal is not nil
 	if msg.Code == msgCommit && c.current.Proposal() != nil {
-		seal := PrepareCommittedSeal(c.current.Proposal().Hash())
-		msg.CommittedSeal, err = c.backend.Sign(seal)
+		seal := PrepareCommittedSeal(c.current.Proposal().Hash(), c.config.SigSchemeAt(c.current.Proposal().Number()))
+		msg.CommittedSeal, err = c.backend.SignAt(c.currentView(), seal)
 		if err != nil {
 			return nil, err
 		}
@@ -112,9 +183,10 @@ func (c *core) finalizeMessage(msg *message) ([]byte, error) {
 	// Sign message
 	data, err := msg.PayloadNoSig()
 	if err != nil {
-		return nil, err
+		c.logger.Error("Failed to marshal message", "msg", msg, "err", err)
+		return nil, errMarshalMessage
 	}
-	msg.Signature, err = c.backend.Sign(data)
+	msg.Signature, err = c.backend.SignAt(c.currentView(), c.config.SigSchemeAt(c.current.Sequence()).SigData(istanbul.MessageDomain, data))
 	if err != nil {
 		return nil, err
 	}
@@ -122,26 +194,88 @@ func (c *core) finalizeMessage(msg *message) ([]byte, error) {
 	// Convert to payload
 	payload, err := msg.Payload()
 	if err != nil {
-		return nil, err
+		c.logger.Error("Failed to marshal message", "msg", msg, "err", err)
+		return nil, errMarshalMessage
 	}
 
 	return payload, nil
 }
 
-func (c *core) broadcast(msg *message) {
+// broadcastMsg encodes the content and broadcasts it as a message of the
+// given code.
+func (c *core) broadcastMsg(code uint64, content interface{}) error {
+	payload, err := Encode(content)
+	if err != nil {
+		c.logger.Error("Failed to encode message", "code", code, "content", content, "err", err)
+		return errEncodeMessage
+	}
+	return c.broadcast(&message{
+		Code: code,
+		Msg:  payload,
+	})
+}
+
+func (c *core) broadcast(msg *message) error {
 	logger := c.logger.New("state", c.state)
 
+	// A paused validator doesn't take part in the consensus
+	if c.paused {
+		logger.Trace("Paused, skip broadcasting the message", "msg", msg)
+		return nil
+	}
+
 	payload, err := c.finalizeMessage(msg)
 	if err != nil {
 		logger.Error("Failed to finalize message", "msg", msg, "err", err)
-		return
+		return err
+	}
+
+	// A quorum of our own doesn't need anyone else's messages, so handle our
+	// PRE-PREPARE, PREPARE and COMMIT right away and only send them to others
+	switch msg.Code {
+	case msgPreprepare, msgPrepare, msgCommit:
+		if self := c.quorumOfOne(); self != nil && c.fastPath {
+			return c.handleOwnMsg(msg, self, payload)
+		}
 	}
 
 	// Broadcast payload
 	if err = c.backend.Broadcast(c.valSet, payload); err != nil {
 		logger.Error("Failed to broadcast message", "msg", msg, "err", err)
-		return
+		return err
+	}
+	return nil
+}
+
+// handleOwnMsg gossips our message to the other validators and handles it
+// without waiting for the backend to deliver it back to us.
+func (c *core) handleOwnMsg(msg *message, self istanbul.Validator, payload []byte) error {
+	logger := c.logger.New("state", c.state)
+
+	if err := c.backend.Gossip(c.valSet, payload); err != nil {
+		logger.Error("Failed to gossip message", "msg", msg, "err", err)
+		return err
+	}
+	if err := c.handleCheckedMsg(msg, self); err != nil {
+		logger.Debug("Failed to handle own message", "msg", msg, "err", err)
+	}
+	return nil
+}
+
+func (c *core) unicast(msg *message, addr common.Address) error {
+	logger := c.logger.New("state", c.state, "to", addr)
+
+	payload, err := c.finalizeMessage(msg)
+	if err != nil {
+		logger.Error("Failed to finalize message", "msg", msg, "err", err)
+		return err
+	}
+
+	if err = c.backend.Unicast(addr, payload); err != nil {
+		logger.Error("Failed to unicast message", "msg", msg, "err", err)
+		return err
 	}
+	return nil
 }
 
 func (c *core) currentView() *istanbul.View {
@@ -151,6 +285,17 @@ func (c *core) currentView() *istanbul.View {
 	}
 }
 
+// newMsgLogger returns a logger carrying the current view, sequence and state
+// and the type of the message being handled, so the lines of a round can be
+// followed end-to-end.
+func (c *core) newMsgLogger(code uint64, ctx ...interface{}) log.Logger {
+	fields := []interface{}{"msgType", msgNames[code], "state", c.state}
+	if c.current != nil {
+		fields = append(fields, "view", c.currentView(), "seq", c.current.Sequence())
+	}
+	return c.logger.New(append(fields, ctx...)...)
+}
+
 func (c *core) isProposer() bool {
 	v := c.valSet
 	if v == nil {
@@ -159,8 +304,35 @@ func (c *core) isProposer() bool {
 	return v.IsProposer(c.backend.Address())
 }
 
+// quorumOfOne returns our validator if our own voting weight is enough for
+// both the PREPARE and the COMMIT quorums of the current validator set, as
+// when we're the only validator, and nil otherwise.
+func (c *core) quorumOfOne() istanbul.Validator {
+	if c.valSet == nil {
+		return nil
+	}
+	_, self := c.valSet.GetByAddress(c.Address())
+	if self == nil {
+		return nil
+	}
+	weight := int(self.Weight())
+	if weight <= 2*c.config.F(c.valSet) || weight < c.config.CommitQuorum(c.valSet) {
+		return nil
+	}
+	return self
+}
+
 func (c *core) commit() {
+	// The PREPAREs may be skipped, but only a PRE-PREPARED proposal can be
+	// committed, whatever the order the messages arrived in
+	if c.state != StatePreprepared && c.state != StatePrepared {
+		c.newMsgLogger(msgCommit).Error("Commit out of order, ignored")
+		return
+	}
+	// Still need to lock here since state can skip Prepared state and jump directly to the Committed state.
+	c.lockPrepared()
 	c.setState(StateCommitted)
+	logger := c.newMsgLogger(msgCommit)
 
 	proposal := c.current.Proposal()
 	if proposal != nil {
@@ -170,11 +342,14 @@ func (c *core) commit() {
 			copy(committedSeals[i][:], v.CommittedSeal[:])
 		}
 
+		logger.Debug("Commit proposal", "number", proposal.Number(), "hash", proposal.Hash(), "seals", len(committedSeals))
 		if err := c.backend.Commit(proposal, committedSeals); err != nil {
+			logger.Warn("Failed to commit proposal", "number", proposal.Number(), "hash", proposal.Hash(), "err", err)
 			c.current.UnlockHash() //Unlock block when insertion fails
 			c.sendNextRoundChange()
 			return
 		}
+		c.sendConsensusEvent(istanbul.ConsensusCommitted, proposal.Hash())
 	}
 }
 
@@ -201,7 +376,7 @@ func (c *core) startNewRound(round *big.Int) {
 			c.consensusTimestamp = time.Time{}
 		}
 		logger.Trace("Catch up latest proposal", "number", lastProposal.Number().Uint64(), "hash", lastProposal.Hash())
-	} else if lastProposal.Number().Cmp(big.NewInt(c.current.Sequence().Int64()-1)) == 0 {
+	} else if lastProposal.Number().Cmp(big.NewInt(c.current.Sequence().Int64()-1)) == 0 && lastProposal.Hash() == c.head {
 		if round.Cmp(common.Big0) == 0 {
 			// same seq and round, don't need to start new round
 			return
@@ -211,7 +386,17 @@ func (c *core) startNewRound(round *big.Int) {
 		}
 		roundChange = true
 	} else {
-		logger.Warn("New sequence should be larger than current sequence", "new_seq", lastProposal.Number().Int64())
+		// The chain head moved below our sequence, e.g. it was rewound, or to
+		// another block than the one our sequence extends, e.g. after a reorg.
+		// Start over from the chain head instead of getting stuck at a
+		// sequence that can't be reached anymore, or on a proposal that
+		// doesn't extend it.
+		logger.Warn("Sequence diverged from the chain head, reset", "head", lastProposal.Number(), "hash", lastProposal.Hash(), "seq", c.current.Sequence(), "parent", c.head)
+		c.current = nil
+	}
+
+	if roundChange && c.exceedsMaxRounds(round) {
+		c.halt()
 		return
 	}
 
@@ -226,11 +411,26 @@ func (c *core) startNewRound(round *big.Int) {
 			Sequence: new(big.Int).Add(lastProposal.Number(), common.Big1),
 			Round:    new(big.Int),
 		}
-		c.valSet = c.backend.Validators(lastProposal)
+		c.head = lastProposal.Hash()
+		c.committedAt = c.clock.Now()
+		c.recordParticipation()
+		c.recordLog(lastProposal)
+		c.votes = make(map[voteKey]*vote)
+		// The validator key may have been rotated with the last sequence
+		c.address = c.backend.Address()
+		c.resume()
+		c.updateValidatorSet(c.proposerValidators(c.backend.Validators(lastProposal), newView.Sequence))
+		c.clearSyncState(newView.Sequence)
 	}
 
 	// Update logger
 	logger = logger.New("old_proposer", c.valSet.GetProposer())
+	// Keep the proposal prepared in an earlier round, if any, before clearing
+	// the ROUND CHANGE messages carrying it
+	var prepared istanbul.Proposal
+	if roundChange {
+		prepared = c.roundChangeSet.Prepared(round)
+	}
 	// Clear invalid ROUND CHANGE messages
 	c.roundChangeSet = newRoundChangeSet(c.valSet)
 	// New snapshot for new round
@@ -238,6 +438,7 @@ func (c *core) startNewRound(round *big.Int) {
 	// Calculate new proposer
 	c.valSet.CalcProposer(lastProposer, newView.Round.Uint64())
 	c.waitingForRoundChange = false
+	c.heartbeatMisses = 0
 	c.setState(StateAcceptRequest)
 	if roundChange && c.isProposer() && c.current != nil {
 		// If it is locked, propose the old proposal
@@ -247,15 +448,144 @@ func (c *core) startNewRound(round *big.Int) {
 				Proposal: c.current.Proposal(), //c.current.Proposal would be the locked proposal by previous proposer, see updateRoundState
 			}
 			c.sendPreprepare(r)
+		} else if prepared != nil {
+			// Propose again the proposal the ROUND CHANGE messages prove
+			// prepared, the validators may have committed it already
+			c.sendPreprepare(&istanbul.Request{Proposal: prepared})
 		} else if c.current.pendingRequest != nil {
 			c.sendPreprepare(c.current.pendingRequest)
 		}
 	}
 	c.newRoundChangeTimer()
+	c.sendConsensusEvent(istanbul.ConsensusViewChanged, common.Hash{})
 
 	logger.Debug("New round", "new_round", newView.Round, "new_seq", newView.Sequence, "new_proposer", c.valSet.GetProposer(), "valSet", c.valSet.List(), "size", c.valSet.Size(), "isProposer", c.isProposer())
 }
 
+// exceedsMaxRounds returns whether changing to the given round exceeds the
+// maximum number of round changes. The round is reset by every commit, so it's
+// the number of round changes since the last commit.
+func (c *core) exceedsMaxRounds(round *big.Int) bool {
+	max := c.config.MaxRounds
+	return max > 0 && round.Cmp(new(big.Int).SetUint64(max)) > 0
+}
+
+// halt stops changing rounds when the validators keep failing to agree, so that
+// an operator can step in. Only a committed proposal, e.g. one synced from a
+// peer, resumes the consensus.
+func (c *core) halt() {
+	if c.halted {
+		return
+	}
+	c.logger.Error("Too many round changes without a commit, consensus halted", "seq", c.current.Sequence(), "round", c.current.Round(), "max_rounds", c.config.MaxRounds)
+	c.halted = true
+	c.stopTimer()
+	c.stopHeartbeatTimer()
+	c.stopProposalTimer()
+}
+
+// resume resumes the halted consensus once a new sequence is started
+func (c *core) resume() {
+	if c.halted {
+		c.logger.Info("Consensus resumed")
+		c.halted = false
+		c.newHeartbeatTimer()
+	}
+}
+
+// Halted returns whether the consensus halted after too many round changes.
+func (c *core) Halted() bool {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	return c.halted
+}
+
+// SetPaused pauses or resumes the participation in the consensus.
+func (c *core) SetPaused(paused bool) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	if paused != c.paused {
+		c.logger.Info("Consensus participation changed", "paused", paused)
+	}
+	c.paused = paused
+}
+
+// Paused returns whether the participation in the consensus is paused.
+func (c *core) Paused() bool {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	return c.paused
+}
+
+// IsProposer returns whether the local node is the proposer of the current
+// round. It's safe to call it concurrently with the event loop.
+func (c *core) IsProposer() bool {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	return c.isProposer()
+}
+
+// updateValidatorSet switches to the validator set of a new sequence. The
+// quorum sizes are derived from the size of the set, so the ROUND CHANGE
+// messages collected from the old set are dropped as well.
+func (c *core) updateValidatorSet(valSet istanbul.ValidatorSet) {
+	if c.valSet != nil && c.valSet.Size() != valSet.Size() {
+		c.logger.Info("Validator set size changed", "old_size", c.valSet.Size(), "new_size", valSet.Size(), "f", c.config.F(valSet))
+	}
+	if err := c.config.CheckFaultTolerance(valSet); err != nil {
+		c.logger.Warn("Validator set is too small for the fault tolerance", "size", valSet.Size(), "fault_tolerance", c.config.FaultTolerance, "f", valSet.F())
+	}
+	if err := c.config.CheckUnanimity(valSet); err != nil {
+		c.logger.Warn("Validator set is too large for unanimity, committing with 2F+1", "size", valSet.Size(), "max", istanbul.MaxUnanimousValidators)
+	}
+	c.valSet = valSet
+	c.roundChangeSet = newRoundChangeSet(valSet)
+	c.updateReplica()
+}
+
+// updateReplica updates our index in the validator set, which tells apart the
+// logs of the validators more readily than their addresses, e.g. when running
+// several of them in a test.
+func (c *core) updateReplica() {
+	c.replica, _ = c.valSet.GetByAddress(c.address)
+	c.updateLogger()
+}
+
+// updateLogger rebuilds the logger of the core, which writes the logs up to the
+// trace level to traceOutput while tracing.
+func (c *core) updateLogger() {
+	c.logger = c.rootLogger.New("address", c.address, "replica", c.replica)
+	if c.traceLevel > 0 {
+		c.logger.SetHandler(log.LvlFilterHandler(c.traceLevel, traceOutput))
+	}
+}
+
+// proposerValidators returns a copy of valSet set up for the proposer selection
+// of the sequence: in the validator order of the sequence, and skipping the
+// validators jailed at it. valSet itself is left alone, the backend's sets are
+// shared with its snapshots.
+func (c *core) proposerValidators(valSet istanbul.ValidatorSet, sequence *big.Int) istanbul.ValidatorSet {
+	valSet = valSet.Copy()
+	if !c.config.ByteOrderAt(sequence) {
+		valSet.SortByHex()
+	}
+	valSet.SetJailed(c.jailedAt(sequence))
+	return valSet
+}
+
+// jailedAt returns the function telling the validators jailed at the given
+// sequence, which the proposer selection skips.
+func (c *core) jailedAt(sequence *big.Int) func(addr common.Address) bool {
+	number := sequence.Uint64()
+	return func(addr common.Address) bool {
+		return c.backend.Jailed(addr, number)
+	}
+}
+
 func (c *core) catchUpRound(view *istanbul.View) {
 	logger := c.logger.New("old_round", c.current.Round(), "old_seq", c.current.Sequence(), "old_proposer", c.valSet.GetProposer())
 
@@ -272,12 +602,24 @@ func (c *core) catchUpRound(view *istanbul.View) {
 	logger.Trace("Catch up round", "new_round", view.Round, "new_seq", view.Sequence, "new_proposer", c.valSet)
 }
 
+// lockPrepared locks the proposal of the round. The PREPARE and COMMIT messages
+// of the round are kept as its prepared certificate if they reach the quorum,
+// otherwise it was locked in an earlier round and keeps that certificate.
+func (c *core) lockPrepared() {
+	c.current.LockHash()
+	if c.current.GetPrepareOrCommitWeight() > 2*c.config.F(c.valSet) {
+		c.current.SetPrepared(c.current.prepareOrCommitMessages())
+	}
+}
+
 // updateRoundState updates round state by checking if locking block is necessary
 func (c *core) updateRoundState(view *istanbul.View, validatorSet istanbul.ValidatorSet, roundChange bool) {
 	// Lock only if both roundChange is true and it is locked
 	if roundChange && c.current != nil {
 		if c.current.IsHashLocked() {
+			prepared := c.current.Prepared()
 			c.current = newRoundState(view, validatorSet, c.current.GetLockedHash(), c.current.Preprepare, c.current.pendingRequest, c.backend.HasBadProposal)
+			c.current.SetPrepared(prepared)
 		} else {
 			c.current = newRoundState(view, validatorSet, common.Hash{}, nil, c.current.pendingRequest, c.backend.HasBadProposal)
 		}
@@ -289,13 +631,29 @@ func (c *core) updateRoundState(view *istanbul.View, validatorSet istanbul.Valid
 func (c *core) setState(state State) {
 	if c.state != state {
 		c.state = state
+		if state == StatePrepared {
+			c.sendConsensusEvent(istanbul.ConsensusPrepared, c.current.GetLockedHash())
+		}
 	}
 	if state == StateAcceptRequest {
+		c.processSyncProposals()
 		c.processPendingRequests()
 	}
 	c.processBacklog()
 }
 
+// currentState returns the current state and view. It's safe to call it
+// concurrently with the event loop.
+func (c *core) currentState() (State, *istanbul.View) {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	if c.current == nil {
+		return c.state, nil
+	}
+	return c.state, c.currentView()
+}
+
 func (c *core) Address() common.Address {
 	return c.address
 }
@@ -323,19 +681,43 @@ func (c *core) newRoundChangeTimer() {
 		timeout += time.Duration(math.Pow(2, float64(round))) * time.Second
 	}
 
-	c.roundChangeTimer = time.AfterFunc(timeout, func() {
-		c.sendEvent(timeoutEvent{})
+	view := c.currentView()
+	c.roundChangeTimer = c.clock.AfterFunc(timeout, func() {
+		c.sendEvent(timeoutEvent{view: view})
 	})
 }
 
 func (c *core) checkValidatorSignature(data []byte, sig []byte) (common.Address, error) {
-	return istanbul.CheckValidatorSignature(c.valSet, data, sig)
+	signer, err := c.backend.RecoverSigner(data, sig)
+	if err != nil {
+		c.logger.Error("Failed to get signer address", "err", err)
+		return common.Address{}, err
+	}
+	if _, val := c.valSet.GetByAddress(signer); val != nil {
+		return val.Address(), nil
+	}
+	return common.Address{}, istanbul.ErrUnauthorizedAddress
+}
+
+// checkMessageSignature checks the signature of a consensus message, made in
+// the message domain in the scheme of the sequence its sender works on. It's
+// the current sequence, or the next one for the validators a block ahead when
+// the scheme changes.
+func (c *core) checkMessageSignature(data []byte, sig []byte) (common.Address, error) {
+	sequence := c.current.Sequence()
+	scheme := c.config.SigSchemeAt(sequence)
+	addr, err := c.validateFn(scheme.SigData(istanbul.MessageDomain, data), sig)
+	if next := c.config.SigSchemeAt(new(big.Int).Add(sequence, common.Big1)); err != nil && next != scheme {
+		return c.validateFn(next.SigData(istanbul.MessageDomain, data), sig)
+	}
+	return addr, err
 }
 
-// PrepareCommittedSeal returns a committed seal for the given hash
-func PrepareCommittedSeal(hash common.Hash) []byte {
+// PrepareCommittedSeal returns a committed seal for the given hash, to sign in
+// the given scheme
+func PrepareCommittedSeal(hash common.Hash, scheme istanbul.SigScheme) []byte {
 	var buf bytes.Buffer
 	buf.Write(hash.Bytes())
 	buf.Write([]byte{byte(msgCommit)})
-	return buf.Bytes()
+	return scheme.SigData(istanbul.CommittedSealDomain, buf.Bytes())
 }
@@ -17,13 +17,19 @@
 package core
 
 import (
+	"fmt"
 	"math/big"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
 	elog "github.com/ethereum/go-ethereum/log"
 )
 
@@ -76,3 +82,760 @@ func TestNewRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestReproposeAfterRoundChange(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	config := *istanbul.DefaultConfig
+	config.RequestTimeout = 300
+	for _, backend := range sys.backends {
+		backend.engine.(*core).config = &config
+	}
+
+	close := sys.Run(true)
+	defer close()
+	// The test system starts in the initial round without a timer
+	for _, backend := range sys.backends {
+		c := backend.engine.(*core)
+		c.stateMu.Lock()
+		c.newRoundChangeTimer()
+		c.stateMu.Unlock()
+	}
+
+	// Only the proposer of the next round receives the proposal, so the
+	// first round can't reach a quorum and times out.
+	proposal := makeBlock(1)
+	m, _ := Encode(&istanbul.Preprepare{
+		View: &istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		Proposal: proposal,
+	})
+	payload, _ := (&message{
+		Code:      msgPreprepare,
+		Msg:       m,
+		Address:   sys.backends[0].Address(),
+		Signature: sys.backends[0].Address().Bytes(),
+		Version:   msgVersion,
+	}).Payload()
+	go sys.backends[1].EventMux().Post(istanbul.MessageEvent{
+		Payload: payload,
+	})
+
+	deadline := time.After(3 * time.Second)
+	for i, backend := range sys.backends {
+		for {
+			c := backend.engine.(*core)
+			c.stateMu.RLock()
+			committed := len(backend.committedMsgs)
+			c.stateMu.RUnlock()
+			if committed > 0 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("backend %d: the proposal should be committed after the round change", i)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		c := backend.engine.(*core)
+		c.stateMu.RLock()
+		hash := backend.committedMsgs[0].commitProposal.Hash()
+		c.stateMu.RUnlock()
+		if hash != proposal.Hash() {
+			t.Errorf("backend %d: proposal mismatch: have %v, want %v", i, hash, proposal.Hash())
+		}
+	}
+}
+
+func TestUnicast(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	target := sys.backends[2]
+
+	var subs []*event.TypeMuxSubscription
+	for _, backend := range sys.backends {
+		sub := backend.EventMux().Subscribe(istanbul.MessageEvent{})
+		defer sub.Unsubscribe()
+		subs = append(subs, sub)
+	}
+
+	c := sys.backends[0].engine.(*core)
+	c.unicast(&message{
+		Code: msgSyncRequest,
+		Msg:  []byte{},
+	}, target.Address())
+
+	select {
+	case <-subs[2].Chan():
+	case <-time.After(time.Second):
+		t.Fatalf("unicast message should reach the target")
+	}
+	<-time.After(100 * time.Millisecond)
+	for i, sub := range subs {
+		if i == 2 {
+			continue
+		}
+		select {
+		case ev := <-sub.Chan():
+			t.Errorf("backend %d: unexpected message: %v", i, ev.Data)
+		default:
+		}
+	}
+}
+
+func TestConcurrentStateAccess(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+
+	stop := sys.Run(true)
+	defer stop()
+
+	prepare := func(val istanbul.Validator) []byte {
+		subject, _ := Encode(&istanbul.Subject{
+			View: &istanbul.View{
+				Round:    big.NewInt(0),
+				Sequence: big.NewInt(1),
+			},
+			Digest: newTestProposal().Hash(),
+		})
+		payload, _ := (&message{
+			Code:    msgPrepare,
+			Msg:     subject,
+			Address: val.Address(),
+		}).Payload()
+		return payload
+	}
+
+	var wg sync.WaitGroup
+	quit := make(chan struct{})
+	run := func(fn func()) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-quit:
+					return
+				default:
+					fn()
+				}
+			}
+		}()
+	}
+	// prepares from all the validators
+	run(func() {
+		for _, val := range sys.backends[0].peers.List() {
+			for _, backend := range sys.backends {
+				backend.EventMux().Post(istanbul.MessageEvent{Payload: prepare(val)})
+			}
+		}
+	})
+	// view changes
+	run(func() {
+		for _, backend := range sys.backends {
+			backend.EventMux().Post(timeoutEvent{})
+		}
+		<-time.After(10 * time.Millisecond)
+	})
+	// state readers
+	run(func() {
+		for _, backend := range sys.backends {
+			backend.engine.(*core).currentState()
+		}
+	})
+
+	<-time.After(500 * time.Millisecond)
+	close(quit)
+	wg.Wait()
+
+	for i, backend := range sys.backends {
+		_, view := backend.engine.(*core).currentState()
+		if view == nil {
+			t.Fatalf("backend %d: current view should not be nil", i)
+		}
+		if view.Sequence.Cmp(big.NewInt(1)) != 0 {
+			t.Errorf("backend %d: sequence mismatch: have %v, want 1", i, view.Sequence)
+		}
+		if view.Round.Sign() == 0 {
+			t.Errorf("backend %d: round should be changed", i)
+		}
+	}
+}
+
+func TestBroadcastEncodeError(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	v0 := sys.backends[0]
+	c := v0.engine.(*core)
+
+	// channels cannot be RLP encoded
+	if err := c.broadcastMsg(msgPrepare, make(chan int)); err != errEncodeMessage {
+		t.Errorf("error mismatch: have %v, want %v", err, errEncodeMessage)
+	}
+	if len(v0.sentMsgs) != 0 {
+		t.Errorf("the number of sent messages mismatch: have %v, want 0", len(v0.sentMsgs))
+	}
+}
+
+func TestUpdateValidatorSet(t *testing.T) {
+	N := uint64(7)
+	F := uint64(2)
+
+	sys := NewTestSystemWithBackend(N, F)
+	v0 := sys.backends[0]
+	c := v0.engine.(*core)
+
+	// start with the first 4 validators
+	var addrs []common.Address
+	for _, val := range v0.peers.List()[:4] {
+		addrs = append(addrs, val.Address())
+	}
+	c.updateValidatorSet(validator.NewSet(addrs, istanbul.RoundRobin))
+	if c.valSet.Size() != 4 || c.valSet.F() != 1 {
+		t.Fatalf("validator set mismatch: have size %v and f %v, want 4 and 1", c.valSet.Size(), c.valSet.F())
+	}
+
+	// the set grows to 7 validators once sequence 1 is committed
+	v0.committedMsgs = append(v0.committedMsgs, testCommittedMsgs{
+		commitProposal: makeBlock(1),
+	})
+	c.startNewRound(common.Big0)
+	if c.valSet.Size() != int(N) || c.valSet.F() != int(F) {
+		t.Fatalf("validator set mismatch: have size %v and f %v, want %v and %v", c.valSet.Size(), c.valSet.F(), N, F)
+	}
+
+	proposal := makeBlock(2)
+	c.current.SetPreprepare(&istanbul.Preprepare{
+		View:     c.currentView(),
+		Proposal: proposal,
+	})
+	c.state = StatePrepared
+	m, _ := Encode(c.current.Subject())
+
+	// 2F+1 = 5 COMMIT messages are needed now
+	for i, val := range c.valSet.List()[:5] {
+		msg := &message{
+			Code:          msgCommit,
+			Msg:           m,
+			Address:       val.Address(),
+			Signature:     []byte{},
+			CommittedSeal: val.Address().Bytes(),
+		}
+		if err := c.handleCommit(msg, val); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		committed := len(v0.committedMsgs) - 1
+		if i < 4 && committed != 0 {
+			t.Fatalf("proposal should not be committed with %v COMMIT messages", i+1)
+		}
+		if i == 4 && committed != 1 {
+			t.Errorf("proposal should be committed with 5 COMMIT messages")
+		}
+	}
+}
+
+func TestReplicaIndex(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+
+	// the logs of each validator carry its index in the validator set
+	var replicas []interface{}
+	root := elog.New()
+	root.SetHandler(elog.FuncHandler(func(r *elog.Record) error {
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if r.Ctx[i] == "replica" {
+				replicas = append(replicas, r.Ctx[i+1])
+			}
+		}
+		return nil
+	}))
+	for i, backend := range sys.backends {
+		c := backend.engine.(*core)
+		index, _ := c.valSet.GetByAddress(c.Address())
+		if c.replica != index {
+			t.Errorf("backend %d: replica mismatch: have %v, want %v", i, c.replica, index)
+		}
+		c.rootLogger = root
+		c.updateReplica()
+		c.logger.Info("test")
+		if len(replicas) != i+1 || replicas[i] != index {
+			t.Errorf("backend %d: logged replica mismatch: have %v, want %v", i, replicas, index)
+		}
+	}
+
+	// the index follows the changes of the validator set
+	c := sys.backends[1].engine.(*core)
+	var addrs []common.Address
+	for _, val := range c.valSet.List() {
+		if val.Address() != sys.backends[0].Address() {
+			addrs = append(addrs, val.Address())
+		}
+	}
+	c.updateValidatorSet(validator.NewSet(addrs, istanbul.RoundRobin))
+	if index, _ := c.valSet.GetByAddress(c.Address()); c.replica != index {
+		t.Errorf("replica mismatch: have %v, want %v", c.replica, index)
+	}
+	c = sys.backends[0].engine.(*core)
+	c.updateValidatorSet(validator.NewSet(addrs, istanbul.RoundRobin))
+	if c.replica != -1 {
+		t.Errorf("replica mismatch: have %v, want -1", c.replica)
+	}
+}
+
+func TestJailedProposer(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	v0 := sys.backends[0]
+	c := v0.engine.(*core)
+
+	v0.committedMsgs = append(v0.committedMsgs, testCommittedMsgs{
+		commitProposal: makeBlock(1),
+	})
+	c.startNewRound(common.Big0)
+	jailed := c.valSet.GetProposer().Address()
+
+	// The jailed proposer is skipped at the next sequence
+	v0.jailed = map[common.Address]bool{jailed: true}
+	v0.committedMsgs = append(v0.committedMsgs, testCommittedMsgs{
+		commitProposal: makeBlock(2),
+	})
+	c.startNewRound(common.Big0)
+	if proposer := c.valSet.GetProposer().Address(); proposer == jailed {
+		t.Errorf("proposer mismatch: have jailed %v", proposer.Hex())
+	}
+	// but not in the validator set of the backend, in the order of the core
+	vals := v0.peers.Copy()
+	if !c.config.ByteOrderAt(big.NewInt(2)) {
+		vals.SortByHex()
+	}
+	vals.CalcProposer(common.Address{}, 0)
+	if proposer := vals.GetProposer().Address(); proposer != jailed {
+		t.Errorf("proposer mismatch: have %v, want %v", proposer.Hex(), jailed.Hex())
+	}
+}
+
+func TestValidatorOrder(t *testing.T) {
+	sys := NewTestSystemWithBackend(10, 3)
+	v0 := sys.backends[0]
+	c := v0.engine.(*core)
+	config := *istanbul.DefaultConfig
+	config.ValidatorOrderBlock = big.NewInt(3)
+	c.config = &config
+
+	// The validators are ordered by hex address before the activation, and
+	// by address bytes from it on
+	for _, test := range []struct {
+		number int64
+		hex    bool
+	}{
+		{1, true},
+		{2, false},
+	} {
+		v0.committedMsgs = append(v0.committedMsgs, testCommittedMsgs{
+			commitProposal: makeBlock(test.number),
+		})
+		c.startNewRound(common.Big0)
+		vals := c.valSet.List()
+		var sorted bool
+		if test.hex {
+			sorted = sort.IsSorted(istanbul.HexValidators(vals))
+		} else {
+			sorted = sort.IsSorted(istanbul.Validators(vals))
+		}
+		if !sorted {
+			t.Errorf("sequence %v: validators not sorted, hex order %v", c.current.Sequence(), test.hex)
+		}
+	}
+	// and the validator set of the backend keeps its order
+	if !sort.IsSorted(istanbul.Validators(v0.peers.List())) {
+		t.Errorf("backend validators not sorted by address bytes")
+	}
+}
+
+func TestFaultToleranceOverride(t *testing.T) {
+	N := uint64(7)
+	F := uint64(2)
+
+	sys := NewTestSystemWithBackend(N, F)
+	config := *istanbul.DefaultConfig
+	config.FaultTolerance = 1
+
+	r0 := sys.backends[0].engine.(*core)
+	r0.config = &config
+	r0.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		return common.BytesToAddress(sig), nil
+	}
+	r0.current = newTestRoundState(
+		&istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		r0.valSet,
+	)
+	r0.state = StatePrepared
+	if err := r0.config.CheckFaultTolerance(r0.valSet); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if f := r0.config.F(r0.valSet); f != int(config.FaultTolerance) {
+		t.Fatalf("F mismatch: have %v, want %v", f, config.FaultTolerance)
+	}
+	if q := r0.config.ChainQuorum(r0.valSet); q != 3 {
+		t.Errorf("quorum mismatch: have %v, want 3", q)
+	}
+
+	// 2F+1 COMMIT messages are 3 instead of 5
+	m, _ := Encode(r0.current.Subject())
+	for i := 0; i < 3; i++ {
+		if r0.state == StateCommitted {
+			t.Fatalf("committed with %d COMMIT messages", i)
+		}
+		validator := r0.valSet.GetByIndex(uint64(i))
+		if err := r0.handleCommit(&message{
+			Code:          msgCommit,
+			Msg:           m,
+			Address:       validator.Address(),
+			Signature:     []byte{},
+			CommittedSeal: validator.Address().Bytes(),
+		}, validator); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if r0.state != StateCommitted {
+		t.Errorf("state mismatch: have %v, want %v", r0.state, StateCommitted)
+	}
+
+	// 7 validators can't tolerate 3 faulty ones, the override is rejected
+	config.FaultTolerance = 3
+	if err := r0.config.CheckFaultTolerance(r0.valSet); err != istanbul.ErrUnsafeFaultTolerance {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrUnsafeFaultTolerance)
+	}
+	if err := r0.Start(); err != istanbul.ErrUnsafeFaultTolerance {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrUnsafeFaultTolerance)
+	}
+	// and capped to the F of the validators if they shrink to that
+	if f := r0.config.F(r0.valSet); f != int(F) {
+		t.Errorf("F mismatch: have %v, want %v", f, F)
+	}
+}
+
+func TestUnanimousCommit(t *testing.T) {
+	N := uint64(3)
+	F := uint64(0)
+
+	sys := NewTestSystemWithBackend(N, F)
+	config := *istanbul.DefaultConfig
+	config.Unanimous = true
+
+	r0 := sys.backends[0].engine.(*core)
+	r0.config = &config
+	r0.current = newTestRoundState(
+		&istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		r0.valSet,
+	)
+	r0.state = StatePrepared
+
+	// 2F+1 would be 1 COMMIT message, all 3 are needed
+	m, _ := Encode(r0.current.Subject())
+	for i := 0; i < int(N); i++ {
+		if r0.state == StateCommitted {
+			t.Fatalf("committed with %d COMMIT messages", i)
+		}
+		validator := r0.valSet.GetByIndex(uint64(i))
+		if err := r0.handleCommit(&message{
+			Code:          msgCommit,
+			Msg:           m,
+			Address:       validator.Address(),
+			Signature:     []byte{},
+			CommittedSeal: validator.Address().Bytes(),
+		}, validator); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if r0.state != StateCommitted {
+		t.Errorf("state mismatch: have %v, want %v", r0.state, StateCommitted)
+	}
+	if committed := len(sys.backends[0].committedMsgs); committed != 1 {
+		t.Errorf("the number of committed proposals mismatch: have %v, want 1", committed)
+	}
+
+	// Unanimity isn't practical for a large validator set
+	large := NewTestSystemWithBackend(istanbul.MaxUnanimousValidators+1, 2)
+	r0 = large.backends[0].engine.(*core)
+	r0.config = &config
+	if quorum, want := config.CommitQuorum(r0.valSet), 2*r0.valSet.F()+1; quorum != want {
+		t.Errorf("quorum mismatch: have %v, want %v", quorum, want)
+	}
+	if err := r0.Start(); err != istanbul.ErrUnanimityTooLarge {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrUnanimityTooLarge)
+	}
+}
+
+func TestStartEmptyValidatorSet(t *testing.T) {
+	sys := NewTestSystemWithBackend(1, 0)
+	backend := sys.backends[0]
+	backend.peers = validator.NewSet(nil, istanbul.RoundRobin)
+
+	r0 := backend.engine.(*core)
+	if err := r0.Start(); err != istanbul.ErrEmptyValidatorSet {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrEmptyValidatorSet)
+	}
+	if r0.events != nil {
+		t.Errorf("engine started without validators")
+	}
+}
+
+func TestSequenceReconcile(t *testing.T) {
+	sys := NewTestSystemWithBackend(1, 0)
+	backend := sys.backends[0]
+	c := backend.engine.(*core)
+
+	// Blocks imported out of band before the consensus starts
+	for i := int64(1); i <= 2; i++ {
+		backend.committedMsgs = append(backend.committedMsgs, testCommittedMsgs{
+			commitProposal: makeBlock(i),
+		})
+	}
+
+	close := sys.Run(true)
+	defer close()
+
+	waitCommit := func(number int64) {
+		deadline := time.After(2 * time.Second)
+		for {
+			c.stateMu.RLock()
+			proposal, _ := backend.LastProposal()
+			c.stateMu.RUnlock()
+			if proposal.Number().Int64() == number {
+				return
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("the number of committed request mismatch: have %v, want %v", proposal.Number(), number)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	if _, view := c.currentState(); view.Sequence.Int64() != 3 {
+		t.Errorf("sequence mismatch: have %v, want 3", view.Sequence)
+	}
+	backend.NewRequest(makeBlock(3))
+	waitCommit(3)
+
+	// The chain is rewound below the sequence of the consensus
+	c.stateMu.Lock()
+	backend.committedMsgs = backend.committedMsgs[:1]
+	c.stateMu.Unlock()
+	backend.EventMux().Post(istanbul.FinalCommittedEvent{})
+
+	backend.NewRequest(makeBlock(2))
+	waitCommit(2)
+}
+
+func TestReorgReset(t *testing.T) {
+	sys := NewTestSystemWithBackend(1, 0)
+	backend := sys.backends[0]
+	c := backend.engine.(*core)
+
+	close := sys.Run(true)
+	defer close()
+
+	waitCommit := func(hash common.Hash) {
+		deadline := time.After(2 * time.Second)
+		for {
+			c.stateMu.RLock()
+			proposal, _ := backend.LastProposal()
+			c.stateMu.RUnlock()
+			if proposal.Hash() == hash {
+				return
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("last proposal mismatch: have %v, want %v", proposal.Hash(), hash)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	block := makeBlock(1)
+	backend.NewRequest(block)
+	waitCommit(block.Hash())
+
+	// The consensus locks a proposal on top of the committed block
+	c.stateMu.Lock()
+	c.current.SetPreprepare(&istanbul.Preprepare{View: c.currentView(), Proposal: makeBlock(2)})
+	c.current.LockHash()
+	c.setState(StatePrepared)
+	c.stateMu.Unlock()
+
+	// The chain is reorganised to a competing block at the same height
+	competing := makeBlock(1)
+	header := competing.Header()
+	header.GasLimit = 1
+	competing = competing.WithSeal(header)
+	c.stateMu.Lock()
+	backend.committedMsgs[0].commitProposal = competing
+	c.stateMu.Unlock()
+	backend.EventMux().Post(istanbul.FinalCommittedEvent{})
+
+	// The consensus starts over on top of the new head, instead of staying
+	// locked on a proposal which doesn't extend it
+	deadline := time.After(2 * time.Second)
+	for {
+		c.stateMu.RLock()
+		state, locked := c.state, c.current.IsHashLocked()
+		c.stateMu.RUnlock()
+		if state == StateAcceptRequest && !locked {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("the consensus should be reset: have state %v and lock %v", state, locked)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	block = makeBlock(2)
+	backend.NewRequest(block)
+	waitCommit(block.Hash())
+}
+
+func TestPause(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	stop := sys.Run(true)
+	defer stop()
+
+	// The last validator is paused for a maintenance
+	paused := sys.backends[N-1]
+	paused.engine.SetPaused(true)
+	if !paused.engine.Paused() {
+		t.Fatalf("paused mismatch: have false, want true")
+	}
+
+	sys.backends[0].NewRequest(makeBlock(1))
+
+	// The others commit without it, and it still follows the chain
+	for _, backend := range sys.backends {
+		if committed := len(backend.waitCommitted(1, 2*time.Second)); committed != 1 {
+			t.Errorf("the number of committed proposals mismatch: have %v, want 1", committed)
+		}
+	}
+	if sent := len(paused.sentMsgs); sent != 0 {
+		t.Errorf("the number of messages sent while paused mismatch: have %v, want 0", sent)
+	}
+}
+
+func TestFastPath(t *testing.T) {
+	// A single validator commits its own proposal without waiting for its
+	// messages to be delivered back
+	sys := NewTestSystemWithBackend(1, 0)
+	backend := sys.backends[0]
+	c := backend.engine.(*core)
+	if c.quorumOfOne() == nil {
+		t.Fatalf("quorum mismatch: have nil, want the single validator")
+	}
+	if err := c.handleRequest(&istanbul.Request{Proposal: makeBlock(1)}); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if committed := len(backend.committedMsgs); committed != 1 {
+		t.Fatalf("the number of committed proposals mismatch: have %v, want 1", committed)
+	}
+	if seals := len(backend.committedMsgs[0].committedSeals); seals != 1 {
+		t.Errorf("the number of committed seals mismatch: have %v, want 1", seals)
+	}
+	if sent := len(backend.sentMsgs); sent != 0 {
+		t.Errorf("the number of broadcast messages mismatch: have %v, want 0", sent)
+	}
+	if c.state != StateCommitted {
+		t.Errorf("state mismatch: have %v, want %v", c.state, StateCommitted)
+	}
+
+	// Anything larger needs the others
+	sys = NewTestSystemWithBackend(4, 1)
+	for i, backend := range sys.backends {
+		if self := backend.engine.(*core).quorumOfOne(); self != nil {
+			t.Errorf("validator %d: quorum mismatch: have %v, want nil", i, self)
+		}
+	}
+}
+
+func BenchmarkSingleValidatorCommit(b *testing.B) {
+	b.Run("Loopback", func(b *testing.B) { benchmarkSingleValidatorCommit(b, false) })
+	b.Run("FastPath", func(b *testing.B) { benchmarkSingleValidatorCommit(b, true) })
+}
+
+func benchmarkSingleValidatorCommit(b *testing.B, fastPath bool) {
+	sys := NewTestSystemWithBackend(1, 0)
+	testLogger.SetHandler(elog.DiscardHandler())
+	defer testLogger.SetHandler(elog.StdoutHandler)
+
+	backend := sys.backends[0]
+	backend.engine.(*core).fastPath = fastPath
+	sub := backend.events.Subscribe(istanbul.FinalCommittedEvent{})
+	defer sub.Unsubscribe()
+
+	stop := sys.Run(true)
+	defer stop()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		backend.NewRequest(makeBlock(int64(i + 1)))
+		<-sub.Chan()
+	}
+}
+
+// BenchmarkConsensusThroughput measures the time to commit a proposal, one
+// after the other, and the number of messages sent and delivered for each
+// commit, as the number of validators grows.
+func BenchmarkConsensusThroughput(b *testing.B) {
+	for _, n := range []uint64{4, 7, 10, 22, 31} {
+		b.Run(fmt.Sprintf("N=%d", n), func(b *testing.B) { benchmarkConsensusThroughput(b, n) })
+	}
+}
+
+func benchmarkConsensusThroughput(b *testing.B, n uint64) {
+	sys := NewTestSystemWithBackend(n, (n-1)/3)
+	testLogger.SetHandler(elog.DiscardHandler())
+	defer testLogger.SetHandler(elog.StdoutHandler)
+
+	subs := make([]*event.TypeMuxSubscription, n)
+	for i, backend := range sys.backends {
+		subs[i] = backend.events.Subscribe(istanbul.FinalCommittedEvent{})
+		defer subs[i].Unsubscribe()
+	}
+	stop := sys.Run(true)
+	defer stop()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Whoever the proposer is, wait for all the validators to commit
+		request := makeBlock(int64(i + 1))
+		for _, backend := range sys.backends {
+			backend.NewRequest(request)
+		}
+		for _, sub := range subs {
+			<-sub.Chan()
+		}
+	}
+	b.StopTimer()
+
+	// A validator only commits once it sent all its messages for the proposal,
+	// and each of them is delivered to every validator
+	messages := 0
+	for _, backend := range sys.backends {
+		messages += len(backend.sentMsgs)
+	}
+	b.ReportMetric(float64(messages)/float64(b.N), "msgs/commit")
+	b.ReportMetric(float64(messages)*float64(n)/float64(b.N), "deliveries/commit")
+}
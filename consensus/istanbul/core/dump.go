@@ -0,0 +1,73 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// RoundDump reports the PREPARE and COMMIT messages of the current round, and
+// the validators which haven't sent them yet.
+type RoundDump struct {
+	Round           *big.Int         `json:"round"`           // Round of the current view
+	Sequence        *big.Int         `json:"sequence"`        // Sequence of the current view
+	State           string           `json:"state"`           // State of the core in the round
+	Proposer        common.Address   `json:"proposer"`        // Proposer of the round
+	Prepares        []common.Address `json:"prepares"`        // Validators which sent a PREPARE
+	Commits         []common.Address `json:"commits"`         // Validators which sent a COMMIT
+	MissingPrepares []common.Address `json:"missingPrepares"` // Validators which didn't send a PREPARE
+	MissingCommits  []common.Address `json:"missingCommits"`  // Validators which didn't send a COMMIT
+}
+
+// DumpRound implements core.Engine.DumpRound. The validators are listed in the
+// order of the validator set.
+func (c *core) DumpRound() (*RoundDump, error) {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	if c.current == nil {
+		return nil, errNoState
+	}
+	dump := &RoundDump{
+		Round:           c.current.Round(),
+		Sequence:        c.current.Sequence(),
+		State:           c.state.String(),
+		Prepares:        []common.Address{},
+		Commits:         []common.Address{},
+		MissingPrepares: []common.Address{},
+		MissingCommits:  []common.Address{},
+	}
+	if proposer := c.valSet.GetProposer(); proposer != nil {
+		dump.Proposer = proposer.Address()
+	}
+	for _, val := range c.valSet.List() {
+		addr := val.Address()
+		if c.current.Prepares.Get(addr) != nil {
+			dump.Prepares = append(dump.Prepares, addr)
+		} else {
+			dump.MissingPrepares = append(dump.MissingPrepares, addr)
+		}
+		if c.current.Commits.Get(addr) != nil {
+			dump.Commits = append(dump.Commits, addr)
+		} else {
+			dump.MissingCommits = append(dump.MissingCommits, addr)
+		}
+	}
+	return dump, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestDumpRound(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	// Nothing can be dumped before the core started
+	if _, err := New(sys.backends[0], c.config).DumpRound(); err != errNoState {
+		t.Errorf("error mismatch: have %v, want %v", err, errNoState)
+	}
+
+	c.current = newTestRoundState(&istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}, c.valSet)
+	c.state = StatePrepared
+
+	// The round is stuck at 2F COMMITs, one short of the quorum
+	subject, _ := Encode(c.current.Subject())
+	vals := c.valSet.List()
+	for _, val := range vals[:2] {
+		if err := c.current.Commits.Add(&message{Code: msgCommit, Msg: subject, Address: val.Address()}); err != nil {
+			t.Fatalf("failed to add COMMIT: %v", err)
+		}
+	}
+	for _, val := range vals[:3] {
+		if err := c.current.Prepares.Add(&message{Code: msgPrepare, Msg: subject, Address: val.Address()}); err != nil {
+			t.Fatalf("failed to add PREPARE: %v", err)
+		}
+	}
+
+	dump, err := c.DumpRound()
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if dump.Round.Cmp(big.NewInt(0)) != 0 || dump.Sequence.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("view mismatch: have %v/%v, want 1/0", dump.Sequence, dump.Round)
+	}
+	if dump.State != StatePrepared.String() {
+		t.Errorf("state mismatch: have %v, want %v", dump.State, StatePrepared)
+	}
+	if dump.Proposer != c.valSet.GetProposer().Address() {
+		t.Errorf("proposer mismatch: have %v, want %v", dump.Proposer, c.valSet.GetProposer().Address())
+	}
+	addrs := func(vals []istanbul.Validator) []common.Address {
+		result := []common.Address{}
+		for _, val := range vals {
+			result = append(result, val.Address())
+		}
+		return result
+	}
+	if want := addrs(vals[:2]); !reflect.DeepEqual(dump.Commits, want) {
+		t.Errorf("COMMITs mismatch: have %v, want %v", dump.Commits, want)
+	}
+	if want := addrs(vals[2:]); !reflect.DeepEqual(dump.MissingCommits, want) {
+		t.Errorf("missing COMMITs mismatch: have %v, want %v", dump.MissingCommits, want)
+	}
+	if want := addrs(vals[:3]); !reflect.DeepEqual(dump.Prepares, want) {
+		t.Errorf("PREPAREs mismatch: have %v, want %v", dump.Prepares, want)
+	}
+	if want := addrs(vals[3:]); !reflect.DeepEqual(dump.MissingPrepares, want) {
+		t.Errorf("missing PREPAREs mismatch: have %v, want %v", dump.MissingPrepares, want)
+	}
+}
@@ -35,6 +35,27 @@ var (
 	errOldMessage = errors.New("old message")
 	// errInvalidMessage is returned when the message is malformed.
 	errInvalidMessage = errors.New("invalid message")
+	// errInvalidSigner is returned when the message is not signed by the
+	// validator in its address field.
+	errInvalidSigner = errors.New("message not signed by its sender")
+	// errNonValidatorSender is returned when the message comes from an address
+	// which isn't in the validator set.
+	errNonValidatorSender = errors.New("message from a non-validator")
+	// errUnsupportedVersion is returned when the message is encoded in a
+	// newer version than we support.
+	errUnsupportedVersion = errors.New("unsupported message version")
+	// errInvalidSequence is returned when the proposal of the PRE-PREPARE
+	// message isn't of the sequence following the last committed one.
+	errInvalidSequence = errors.New("invalid proposal sequence")
+	// errProposalTooLarge is returned when the PRE-PREPARE message carries a
+	// proposal larger than the configured maximum size.
+	errProposalTooLarge = errors.New("proposal too large")
+	// errEncodeMessage is returned when the content of an outgoing message
+	// cannot be encoded.
+	errEncodeMessage = errors.New("failed to encode message")
+	// errMarshalMessage is returned when an outgoing message cannot be
+	// converted to its payload.
+	errMarshalMessage = errors.New("failed to marshal message")
 	// errFailedDecodePreprepare is returned when the PRE-PREPARE message is malformed.
 	errFailedDecodePreprepare = errors.New("failed to decode PRE-PREPARE")
 	// errFailedDecodePrepare is returned when the PREPARE message is malformed.
@@ -43,4 +64,36 @@ var (
 	errFailedDecodeCommit = errors.New("failed to decode COMMIT")
 	// errFailedDecodeMessageSet is returned when the message set is malformed.
 	errFailedDecodeMessageSet = errors.New("failed to decode message set")
+	// errFailedDecodeSyncRequest is returned when the SYNC REQUEST message is malformed.
+	errFailedDecodeSyncRequest = errors.New("failed to decode SYNC REQUEST")
+	// errFailedDecodeSyncResponse is returned when the SYNC RESPONSE message is malformed.
+	errFailedDecodeSyncResponse = errors.New("failed to decode SYNC RESPONSE")
+	// errFailedDecodeHeartbeat is returned when the HEARTBEAT message is malformed.
+	errFailedDecodeHeartbeat = errors.New("failed to decode HEARTBEAT")
+	// errInvalidCommittedSeals is returned when a synced proposal is not signed
+	// by enough validators.
+	errInvalidCommittedSeals = errors.New("invalid committed seals")
+	// errInvalidFinalityProof is returned when a finality proof does not match
+	// the block or its seals do not match the listed validators.
+	errInvalidFinalityProof = errors.New("invalid finality proof")
+	// errHalted is returned when the round can't be changed because the
+	// consensus halted after too many round changes.
+	errHalted = errors.New("consensus halted")
+	// errEquivocatingProposer is returned when the proposer sends another
+	// proposal for the same view.
+	errEquivocatingProposer = errors.New("proposer sent conflicting proposals")
+	// errNoState is returned when the consensus state is exported or dumped
+	// before the core started.
+	errNoState = errors.New("no consensus state")
+	// errUnsupportedStateVersion is returned when the imported consensus state
+	// is encoded in a version we don't support.
+	errUnsupportedStateVersion = errors.New("unsupported consensus state version")
+	// errInconsistentState is returned when the imported consensus state is not
+	// of the sequence following the chain head.
+	errInconsistentState = errors.New("consensus state inconsistent with the chain head")
+	// errInvalidTraceLevel is returned when the trace level isn't a log level.
+	errInvalidTraceLevel = errors.New("invalid trace level")
+	// errInvalidPreparedCertificate is returned when the prepared certificate
+	// of a ROUND CHANGE does not prove its proposal prepared.
+	errInvalidPreparedCertificate = errors.New("invalid prepared certificate")
 )
@@ -25,4 +25,12 @@ type backlogEvent struct {
 	msg *message
 }
 
-type timeoutEvent struct{}
+type timeoutEvent struct {
+	view *istanbul.View // The view the timer was armed in, nil for the current one
+}
+
+type heartbeatEvent struct{}
+
+type proposalTimeoutEvent struct {
+	view *istanbul.View
+}
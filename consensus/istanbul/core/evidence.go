@@ -0,0 +1,104 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// maxEvidence is the number of the most recent evidences kept.
+const maxEvidence = 128
+
+// Evidence proves that a validator equivocated, i.e. signed two PRE-PREPARE,
+// PREPARE or COMMIT messages with different digests for the same view, the
+// digest of a PRE-PREPARE being the hash of its proposal. The messages are
+// kept as received, so that anyone can check their signatures.
+type Evidence struct {
+	Validator common.Address `json:"validator"`
+	Type      string         `json:"type"`
+	Sequence  uint64         `json:"sequence"`
+	Round     uint64         `json:"round"`
+	First     hexutil.Bytes  `json:"first"`  // Payload of the first signed message
+	Second    hexutil.Bytes  `json:"second"` // Payload of the conflicting signed message
+}
+
+// voteKey identifies a vote of a validator in the current sequence
+type voteKey struct {
+	code    uint64
+	round   uint64
+	address common.Address
+}
+
+// vote is the first message of a validator for a vote key, with its digest
+type vote struct {
+	msg    *message
+	digest common.Hash
+}
+
+// checkEquivocation records the first PRE-PREPARE, PREPARE or COMMIT message
+// of each validator in a round, and records an evidence if the validator signs
+// another one with a different digest. It returns whether the validator
+// equivocated.
+func (c *core) checkEquivocation(msg *message, view *istanbul.View, digest common.Hash) bool {
+	if view == nil || view.Sequence == nil || view.Round == nil {
+		return false
+	}
+	if c.votes == nil {
+		c.votes = make(map[voteKey]*vote)
+	}
+	key := voteKey{msg.Code, view.Round.Uint64(), msg.Address}
+	first, ok := c.votes[key]
+	if !ok {
+		c.votes[key] = &vote{msg: msg, digest: digest}
+		return false
+	}
+	if first.digest == digest {
+		return false
+	}
+	firstPayload, err := first.msg.Payload()
+	if err != nil {
+		return false
+	}
+	secondPayload, err := msg.Payload()
+	if err != nil {
+		return false
+	}
+	c.logger.Warn("Validator equivocated", "validator", msg.Address, "msgType", msgNames[msg.Code], "view", view, "first", first.digest, "second", digest)
+
+	c.evidence = append(c.evidence, &Evidence{
+		Validator: msg.Address,
+		Type:      msgNames[msg.Code],
+		Sequence:  view.Sequence.Uint64(),
+		Round:     view.Round.Uint64(),
+		First:     firstPayload,
+		Second:    secondPayload,
+	})
+	if len(c.evidence) > maxEvidence {
+		c.evidence = c.evidence[len(c.evidence)-maxEvidence:]
+	}
+	return true
+}
+
+// MisbehaviorEvidence returns the recent evidences of equivocation.
+func (c *core) MisbehaviorEvidence() []*Evidence {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	return append([]*Evidence(nil), c.evidence...)
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// stateVersion is the version of the exported consensus state, increased on
+// each incompatible change of its encoding.
+const stateVersion uint64 = 1
+
+// versionedState is the envelope of the exported consensus state, so the
+// version can be checked before decoding the state itself.
+type versionedState struct {
+	Version uint64
+	State   rlp.RawValue
+}
+
+// exportedState is the consensus state of the current sequence. The subject
+// is the view and the digest of the PRE-PREPARE.
+type exportedState struct {
+	Round                 *big.Int
+	Sequence              *big.Int
+	State                 uint64
+	WaitingForRoundChange bool
+	LockedHash            common.Hash
+	Preprepare            []byte // the encoded PRE-PREPARE, empty if none
+	Prepares              []*message
+	Commits               []*message
+	RoundChanges          []*message
+}
+
+// ExportState implements core.Engine.ExportState
+func (c *core) ExportState() ([]byte, error) {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	if c.current == nil {
+		return nil, errNoState
+	}
+	state := &exportedState{
+		Round:                 c.current.Round(),
+		Sequence:              c.current.Sequence(),
+		State:                 uint64(c.state),
+		WaitingForRoundChange: c.waitingForRoundChange,
+		LockedHash:            c.current.GetLockedHash(),
+		Prepares:              sortedMessages(c.current.Prepares.Values()),
+		Commits:               sortedMessages(c.current.Commits.Values()),
+	}
+	if preprepare := c.current.Preprepare; preprepare != nil {
+		encoded, err := Encode(preprepare)
+		if err != nil {
+			return nil, err
+		}
+		state.Preprepare = encoded
+	}
+	if c.roundChangeSet != nil {
+		c.roundChangeSet.mu.Lock()
+		for _, rms := range c.roundChangeSet.roundChanges {
+			state.RoundChanges = append(state.RoundChanges, rms.Values()...)
+		}
+		c.roundChangeSet.mu.Unlock()
+		state.RoundChanges = sortedMessages(state.RoundChanges)
+	}
+	encoded, err := Encode(state)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(&versionedState{Version: stateVersion, State: encoded})
+}
+
+// ImportState implements core.Engine.ImportState. The state must be of the
+// sequence following the chain head, the messages are checked against its
+// validators.
+func (c *core) ImportState(data []byte) error {
+	var versioned versionedState
+	if err := rlp.DecodeBytes(data, &versioned); err != nil {
+		return err
+	}
+	if versioned.Version != stateVersion {
+		return errUnsupportedStateVersion
+	}
+	var state exportedState
+	if err := rlp.DecodeBytes(versioned.State, &state); err != nil {
+		return err
+	}
+	if state.Round == nil || state.Sequence == nil {
+		return errInconsistentState
+	}
+	var preprepare *istanbul.Preprepare
+	if len(state.Preprepare) > 0 {
+		if err := rlp.DecodeBytes(state.Preprepare, &preprepare); err != nil {
+			return err
+		}
+		if preprepare.View == nil || preprepare.View.Sequence == nil || preprepare.View.Sequence.Cmp(state.Sequence) != 0 {
+			return errInconsistentState
+		}
+	}
+
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	lastProposal, lastProposer := c.backend.LastProposal()
+	if state.Sequence.Cmp(new(big.Int).Add(lastProposal.Number(), common.Big1)) != 0 {
+		return errInconsistentState
+	}
+	valSet := c.proposerValidators(c.backend.Validators(lastProposal), state.Sequence)
+	view := &istanbul.View{Round: state.Round, Sequence: state.Sequence}
+	current := newRoundState(view, valSet, state.LockedHash, preprepare, nil, c.backend.HasBadProposal)
+	for _, msg := range state.Prepares {
+		if err := c.checkImportedMessage(msg, valSet, state.Sequence); err != nil {
+			return err
+		}
+		if err := current.Prepares.Add(msg); err != nil {
+			return err
+		}
+	}
+	for _, msg := range state.Commits {
+		if err := c.checkImportedMessage(msg, valSet, state.Sequence); err != nil {
+			return err
+		}
+		if err := current.Commits.Add(msg); err != nil {
+			return err
+		}
+	}
+	roundChangeSet := newRoundChangeSet(valSet)
+	for _, msg := range state.RoundChanges {
+		if err := c.checkImportedMessage(msg, valSet, state.Sequence); err != nil {
+			return err
+		}
+		var rc *istanbul.Subject
+		if err := msg.Decode(&rc); err != nil {
+			return err
+		}
+		if rc.View == nil || rc.View.Round == nil || rc.View.Sequence == nil || rc.View.Sequence.Cmp(state.Sequence) != 0 {
+			return errInconsistentState
+		}
+		if _, err := roundChangeSet.Add(rc.View.Round, msg); err != nil {
+			return err
+		}
+	}
+
+	c.updateValidatorSet(valSet)
+	c.valSet.CalcProposer(lastProposer, state.Round.Uint64())
+	c.roundChangeSet = roundChangeSet
+	c.current = current
+	c.state = State(state.State)
+	c.waitingForRoundChange = state.WaitingForRoundChange
+	c.newRoundChangeTimer()
+	return nil
+}
+
+// checkImportedMessage checks that the message is signed by its sender, one of
+// the validators of the imported sequence, in the scheme of that sequence.
+func (c *core) checkImportedMessage(msg *message, valSet istanbul.ValidatorSet, sequence *big.Int) error {
+	if _, v := valSet.GetByAddress(msg.Address); v == nil {
+		return istanbul.ErrUnauthorizedAddress
+	}
+	scheme := c.config.SigSchemeAt(sequence)
+	return msg.checkSignature(func(data []byte, sig []byte) (common.Address, error) {
+		return c.validateFn(scheme.SigData(istanbul.MessageDomain, data), sig)
+	})
+}
+
+// sortedMessages sorts the messages by sender, so the exported state doesn't
+// depend on the map iteration order.
+func sortedMessages(msgs []*message) []*message {
+	sort.Slice(msgs, func(i, j int) bool {
+		return bytes.Compare(msgs[i].Address[:], msgs[j].Address[:]) < 0
+	})
+	return msgs
+}
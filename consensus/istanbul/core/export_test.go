@@ -0,0 +1,128 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func newStateTestMessage(code uint64, msg []byte, addr common.Address) *message {
+	return &message{
+		Code:          code,
+		Msg:           msg,
+		Address:       addr,
+		Signature:     addr.Bytes(),
+		CommittedSeal: []byte("seal"),
+		Version:       msgVersion,
+	}
+}
+
+func TestExportState(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	view := &istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)}
+	c.current = newTestRoundState(view, c.valSet)
+	c.current.LockHash()
+	c.state = StatePrepared
+	c.waitingForRoundChange = true
+
+	// the core is mid-round, with some PREPAREs, a COMMIT and a ROUND CHANGE
+	subject, _ := Encode(c.current.Subject())
+	for _, backend := range sys.backends[1:3] {
+		if err := c.current.Prepares.Add(newStateTestMessage(msgPrepare, subject, backend.Address())); err != nil {
+			t.Fatalf("failed to add PREPARE: %v", err)
+		}
+	}
+	if err := c.current.Commits.Add(newStateTestMessage(msgCommit, subject, sys.backends[1].Address())); err != nil {
+		t.Fatalf("failed to add COMMIT: %v", err)
+	}
+	roundChange, _ := Encode(&istanbul.Subject{View: &istanbul.View{Round: big.NewInt(2), Sequence: big.NewInt(1)}})
+	if _, err := c.roundChangeSet.Add(big.NewInt(2), newStateTestMessage(msgRoundChange, roundChange, sys.backends[3].Address())); err != nil {
+		t.Fatalf("failed to add ROUND CHANGE: %v", err)
+	}
+
+	data, err := c.ExportState()
+	if err != nil {
+		t.Fatalf("failed to export state: %v", err)
+	}
+	imported := New(sys.backends[0], c.config).(*core)
+	imported.clock = istanbul.NewSimulatedClock()
+	imported.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		return common.BytesToAddress(sig), nil
+	}
+	if err := imported.ImportState(data); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+
+	if !reflect.DeepEqual(imported.currentView(), c.currentView()) {
+		t.Errorf("view mismatch: have %v, want %v", imported.currentView(), c.currentView())
+	}
+	if imported.state != c.state {
+		t.Errorf("state mismatch: have %v, want %v", imported.state, c.state)
+	}
+	if !reflect.DeepEqual(imported.current.Subject(), c.current.Subject()) {
+		t.Errorf("subject mismatch: have %v, want %v", imported.current.Subject(), c.current.Subject())
+	}
+	if imported.current.GetLockedHash() != c.current.GetLockedHash() {
+		t.Errorf("locked hash mismatch: have %v, want %v", imported.current.GetLockedHash(), c.current.GetLockedHash())
+	}
+	if !imported.waitingForRoundChange {
+		t.Errorf("waiting for round change mismatch: have %v, want true", imported.waitingForRoundChange)
+	}
+	if !reflect.DeepEqual(sortedMessages(imported.current.Prepares.Values()), sortedMessages(c.current.Prepares.Values())) {
+		t.Errorf("PREPAREs mismatch: have %v, want %v", imported.current.Prepares, c.current.Prepares)
+	}
+	if !reflect.DeepEqual(imported.current.Commits.Values(), c.current.Commits.Values()) {
+		t.Errorf("COMMITs mismatch: have %v, want %v", imported.current.Commits, c.current.Commits)
+	}
+	if weight, want := imported.roundChangeSet.roundChanges[2].Weight(), c.roundChangeSet.roundChanges[2].Weight(); weight != want {
+		t.Errorf("ROUND CHANGE weight mismatch: have %v, want %v", weight, want)
+	}
+
+	// Nor a message signed by another validator than its sender
+	forged := newStateTestMessage(msgCommit, subject, sys.backends[2].Address())
+	forged.Signature = sys.backends[3].Address().Bytes()
+	if err := c.current.Commits.Add(forged); err != nil {
+		t.Fatalf("failed to add COMMIT: %v", err)
+	}
+	data, _ = c.ExportState()
+	if err := imported.ImportState(data); err != errInvalidSigner {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidSigner)
+	}
+
+	// The state of another sequence can't be imported
+	c.current.SetSequence(big.NewInt(2))
+	data, _ = c.ExportState()
+	if err := imported.ImportState(data); err != errInconsistentState {
+		t.Errorf("error mismatch: have %v, want %v", err, errInconsistentState)
+	}
+	// Nor an unknown version
+	data, _ = rlp.EncodeToBytes(&versionedState{Version: stateVersion + 1, State: data})
+	if err := imported.ImportState(data); err != errUnsupportedStateVersion {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnsupportedStateVersion)
+	}
+	// Nothing can be exported before the core started
+	if _, err := New(sys.backends[0], c.config).ExportState(); err != errNoState {
+		t.Errorf("error mismatch: have %v, want %v", err, errNoState)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// FinalityProof proves that a block has been committed by the validators. The
+// i-th seal is the committed seal, see PrepareCommittedSeal, signed by the i-th
+// validator over the block hash.
+type FinalityProof struct {
+	Number     uint64           `json:"number"`
+	Hash       common.Hash      `json:"hash"`
+	Validators []common.Address `json:"validators"`
+	Seals      []hexutil.Bytes  `json:"seals"`
+}
+
+// NewFinalityProof builds the finality proof of a block from the committed
+// seals in its header, signed in the given scheme and digest algorithm.
+func NewFinalityProof(header *types.Header, scheme istanbul.SigScheme, digest istanbul.DigestAlgorithm) (*FinalityProof, error) {
+	extra, err := types.ExtractIstanbulExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	if len(extra.CommittedSeal) == 0 {
+		return nil, errInvalidCommittedSeals
+	}
+
+	hash := header.Hash()
+	seal := PrepareCommittedSeal(hash, scheme)
+	proof := &FinalityProof{
+		Number:     header.Number.Uint64(),
+		Hash:       hash,
+		Validators: make([]common.Address, len(extra.CommittedSeal)),
+		Seals:      make([]hexutil.Bytes, len(extra.CommittedSeal)),
+	}
+	for i, committedSeal := range extra.CommittedSeal {
+		addr, err := digest.SignatureAddress(seal, committedSeal)
+		if err != nil {
+			return nil, err
+		}
+		proof.Validators[i] = addr
+		proof.Seals[i] = committedSeal
+	}
+	return proof, nil
+}
+
+// VerifyFinalityProof checks that the proof is for the given header and that
+// the header is committed by distinct validators weighing more than 2F in the
+// given set, which must be the validator set of the parent block. The seals are
+// signed in the given scheme and digest algorithm.
+func VerifyFinalityProof(header *types.Header, proof *FinalityProof, valSet istanbul.ValidatorSet, scheme istanbul.SigScheme, digest istanbul.DigestAlgorithm) error {
+	if proof == nil || len(proof.Validators) != len(proof.Seals) {
+		return errInvalidFinalityProof
+	}
+	hash := header.Hash()
+	if proof.Hash != hash || proof.Number != header.Number.Uint64() {
+		return errInvalidFinalityProof
+	}
+
+	validators := valSet.Copy()
+	seal := PrepareCommittedSeal(hash, scheme)
+	weight := 0
+	for i, committedSeal := range proof.Seals {
+		addr, err := digest.SignatureAddress(seal, committedSeal)
+		if err != nil {
+			return err
+		}
+		if addr != proof.Validators[i] {
+			return errInvalidFinalityProof
+		}
+		// Removing the signer rejects duplicate seals as well as unknown signers
+		_, v := validators.GetByAddress(addr)
+		if v == nil || !validators.RemoveValidator(addr) {
+			return errInvalidCommittedSeals
+		}
+		weight += int(v.Weight())
+	}
+	if weight <= 2*valSet.F() {
+		return errInvalidCommittedSeals
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func makeCommittedHeader(t *testing.T, keys []*ecdsa.PrivateKey) *types.Header {
+	header := &types.Header{
+		Number:    big.NewInt(1),
+		MixDigest: types.IstanbulDigest,
+		Extra:     bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity),
+	}
+	extra := &types.IstanbulExtra{}
+	payload, _ := rlp.EncodeToBytes(extra)
+	header.Extra = append(header.Extra, payload...)
+
+	// the header hash does not cover the committed seals
+	seal := crypto.Keccak256(PrepareCommittedSeal(header.Hash(), istanbul.LegacySigScheme))
+	for _, key := range keys {
+		sig, err := crypto.Sign(seal, key)
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		extra.CommittedSeal = append(extra.CommittedSeal, sig)
+	}
+	payload, _ = rlp.EncodeToBytes(extra)
+	header.Extra = append(header.Extra[:types.IstanbulExtraVanity], payload...)
+	return header
+}
+
+func TestFinalityProof(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	var addrs []common.Address
+	for i := 0; i < 4; i++ {
+		key, _ := crypto.GenerateKey()
+		keys = append(keys, key)
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	valSet := validator.NewSet(addrs, istanbul.RoundRobin)
+	stranger, _ := crypto.GenerateKey()
+
+	testCases := []struct {
+		header      *types.Header
+		tamper      func(*FinalityProof, *types.Header) *types.Header
+		expectedErr error
+	}{
+		{
+			// 2F+1 committed seals
+			makeCommittedHeader(t, keys[:3]),
+			nil,
+			nil,
+		},
+		{
+			// less than 2F+1 committed seals
+			makeCommittedHeader(t, keys[:2]),
+			nil,
+			errInvalidCommittedSeals,
+		},
+		{
+			// a seal from a non-validator
+			makeCommittedHeader(t, []*ecdsa.PrivateKey{keys[0], keys[1], stranger}),
+			nil,
+			errInvalidCommittedSeals,
+		},
+		{
+			// duplicate seals
+			makeCommittedHeader(t, []*ecdsa.PrivateKey{keys[0], keys[1], keys[1]}),
+			nil,
+			errInvalidCommittedSeals,
+		},
+		{
+			// the proof is for another block
+			makeCommittedHeader(t, keys[:3]),
+			func(proof *FinalityProof, header *types.Header) *types.Header {
+				header = types.CopyHeader(header)
+				header.Number = big.NewInt(2)
+				return header
+			},
+			errInvalidFinalityProof,
+		},
+		{
+			// the seals do not match the listed validators
+			makeCommittedHeader(t, keys[:3]),
+			func(proof *FinalityProof, header *types.Header) *types.Header {
+				proof.Validators[0], proof.Validators[1] = proof.Validators[1], proof.Validators[0]
+				return header
+			},
+			errInvalidFinalityProof,
+		},
+		{
+			// a missing seal
+			makeCommittedHeader(t, keys[:3]),
+			func(proof *FinalityProof, header *types.Header) *types.Header {
+				proof.Seals = []hexutil.Bytes{proof.Seals[0]}
+				return header
+			},
+			errInvalidFinalityProof,
+		},
+	}
+	for i, test := range testCases {
+		proof, err := NewFinalityProof(test.header, istanbul.LegacySigScheme, istanbul.Keccak256Digest)
+		if err != nil {
+			t.Fatalf("test %d: error mismatch: have %v, want nil", i, err)
+		}
+		header := test.header
+		if test.tamper != nil {
+			header = test.tamper(proof, header)
+		}
+		if err := VerifyFinalityProof(header, proof, valSet, istanbul.LegacySigScheme, istanbul.Keccak256Digest); err != test.expectedErr {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.expectedErr)
+		}
+	}
+
+	// a block without committed seals has no proof
+	if _, err := NewFinalityProof(makeCommittedHeader(t, nil), istanbul.LegacySigScheme, istanbul.Keccak256Digest); err != errInvalidCommittedSeals {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidCommittedSeals)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build gofuzz
+
+package core
+
+import "github.com/ethereum/go-ethereum/consensus/istanbul"
+
+// Fuzz implements a go-fuzz fuzzer method to test the decoding of the
+// consensus messages received from the network. Any input may be rejected,
+// but none may panic.
+func Fuzz(data []byte) int {
+	msg := new(message)
+	if err := msg.FromPayload(data, nil); err != nil {
+		return 0
+	}
+	if err := fuzzDecode(msg); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// fuzzDecode decodes the message the same way its handler does, and touches
+// the decoded fields the handlers rely on.
+func fuzzDecode(msg *message) error {
+	switch msg.Code {
+	case msgPreprepare:
+		var preprepare *istanbul.Preprepare
+		if err := msg.Decode(&preprepare); err != nil {
+			return err
+		}
+		_ = preprepare.View.String()
+		_ = preprepare.Proposal.Number()
+		_ = preprepare.Proposal.Hash()
+	case msgPrepare, msgCommit, msgRoundChange:
+		var subject *istanbul.Subject
+		if err := msg.Decode(&subject); err != nil {
+			return err
+		}
+		_ = subject.String()
+	case msgSyncRequest:
+		var req *istanbul.SyncRequest
+		if err := msg.Decode(&req); err != nil {
+			return err
+		}
+		_ = req.String()
+	case msgSyncResponse:
+		var proposals []*istanbul.CommittedProposal
+		if err := msg.Decode(&proposals); err != nil {
+			return err
+		}
+		for _, p := range proposals {
+			_ = p.Proposal.Number()
+			_ = p.Proposal.Hash()
+		}
+	case msgHeartbeat:
+		var heartbeat *istanbul.Heartbeat
+		if err := msg.Decode(&heartbeat); err != nil {
+			return err
+		}
+		_ = heartbeat.String()
+	}
+	// Future messages are prioritized by their view in the backlog
+	if view := messageView(msg); view != nil {
+		toPriority(msg.Code, view)
+	}
+	return nil
+}
@@ -17,30 +17,75 @@
 package core
 
 import (
+	"fmt"
+	"runtime/debug"
+	"time"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/event"
+	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
 )
 
 // Start implements core.Engine.Start
 func (c *core) Start() error {
+	// Reject an empty validator set, whose F would be negative, a fault
+	// tolerance the validators can't guarantee, or unanimity they can't
+	// practically reach
+	lastProposal, _ := c.backend.LastProposal()
+	valSet := c.backend.Validators(lastProposal)
+	if valSet.Size() == 0 {
+		return istanbul.ErrEmptyValidatorSet
+	}
+	if valSet.Size() < 4 {
+		c.logger.Warn("Fewer than 4 validators, no faulty validator is tolerated", "size", valSet.Size())
+	}
+	if err := c.config.CheckFaultTolerance(valSet); err != nil {
+		return err
+	}
+	if err := c.config.CheckUnanimity(valSet); err != nil {
+		return err
+	}
+
 	// Start a new round from last sequence + 1
+	c.stateMu.Lock()
+	c.committedAt = c.clock.Now()
 	c.startNewRound(common.Big0)
+	c.newHeartbeatTimer()
+	c.stateMu.Unlock()
 
 	// Tests will handle events itself, so we have to make subscribeEvents()
 	// be able to call in test.
 	c.subscribeEvents()
-	go c.handleEvents()
+	done := make(chan struct{})
+	c.handlerWg.Add(1)
+	go c.handleEvents(c.bufferEvents(done), done)
 
 	return nil
 }
 
 // Stop implements core.Engine.Stop
 func (c *core) Stop() error {
-	c.stopTimer()
 	c.unsubscribeEvents()
 
 	// Make sure the handler goroutine exits
 	c.handlerWg.Wait()
+
+	// The handler may have armed the timers before exiting
+	c.stateMu.Lock()
+	c.stopTimer()
+	c.stopHeartbeatTimer()
+	c.stopProposalTimer()
+	// Clear the state, so the next Start begins from the chain head
+	c.current = nil
+	c.state = StateAcceptRequest
+	c.waitingForRoundChange = false
+	c.halted = false
+	c.stateMu.Unlock()
+
+	c.backlogsMu.Lock()
+	c.backlogs = make(map[istanbul.Validator]*prque.Prque)
+	c.backlogsMu.Unlock()
 	return nil
 }
 
@@ -51,12 +96,16 @@ func (c *core) subscribeEvents() {
 	c.events = c.backend.EventMux().Subscribe(
 		// external events
 		istanbul.RequestEvent{},
+		istanbul.AbandonRequestEvent{},
 		istanbul.MessageEvent{},
+		istanbul.ConnectionEvent{},
 		// internal events
 		backlogEvent{},
 	)
 	c.timeoutSub = c.backend.EventMux().Subscribe(
 		timeoutEvent{},
+		heartbeatEvent{},
+		proposalTimeoutEvent{},
 	)
 	c.finalCommittedSub = c.backend.EventMux().Subscribe(
 		istanbul.FinalCommittedEvent{},
@@ -70,100 +119,226 @@ func (c *core) unsubscribeEvents() {
 	c.finalCommittedSub.Unsubscribe()
 }
 
-func (c *core) handleEvents() {
-	// Clear state
-	defer func() {
-		c.current = nil
-		c.handlerWg.Done()
-	}()
+// bufferEvents returns the channel to handle the external events from. If the
+// event buffer is enabled, the events are moved to a buffered channel, so that
+// a burst of messages doesn't block the event mux while the core is busy. The
+// messages arriving while the buffer is full are dropped, the other events wait
+// for room until the handler is done.
+func (c *core) bufferEvents(done <-chan struct{}) <-chan *event.TypeMuxEvent {
+	size := c.config.EventBufferSize
+	if size == 0 {
+		return c.events.Chan()
+	}
+	events := c.events
+	buffer := make(chan *event.TypeMuxEvent, size)
 
 	c.handlerWg.Add(1)
+	go func() {
+		defer c.handlerWg.Done()
+		defer close(buffer)
+
+		for ev := range events.Chan() {
+			if _, ok := ev.Data.(istanbul.MessageEvent); ok {
+				select {
+				case buffer <- ev:
+				default:
+					c.droppedMeter.Mark(1)
+				}
+				continue
+			}
+			select {
+			case buffer <- ev:
+			case <-done:
+			}
+		}
+	}()
+	return buffer
+}
+
+func (c *core) handleEvents(events <-chan *event.TypeMuxEvent, done chan<- struct{}) {
+	defer c.handlerWg.Done()
+	defer close(done)
 
 	for {
 		select {
-		case event, ok := <-c.events.Chan():
+		case event, ok := <-events:
 			if !ok {
 				return
 			}
 			// A real event arrived, process interesting content
-			switch ev := event.Data.(type) {
-			case istanbul.RequestEvent:
-				r := &istanbul.Request{
-					Proposal: ev.Proposal,
-				}
-				err := c.handleRequest(r)
-				if err == errFutureMessage {
-					c.storeRequestMsg(r)
-				}
-			case istanbul.MessageEvent:
-				if err := c.handleMsg(ev.Payload); err == nil {
-					c.backend.Gossip(c.valSet, ev.Payload)
-				}
-			case backlogEvent:
-				// No need to check signature for internal messages
-				if err := c.handleCheckedMsg(ev.msg, ev.src); err == nil {
-					p, err := ev.msg.Payload()
-					if err != nil {
-						c.logger.Warn("Get message payload failed", "err", err)
-						continue
-					}
-					c.backend.Gossip(c.valSet, p)
-				}
-			}
-		case _, ok := <-c.timeoutSub.Chan():
+			c.handleEvent(event.Data)
+		case event, ok := <-c.timeoutSub.Chan():
 			if !ok {
 				return
 			}
-			c.handleTimeoutMsg()
+			c.handleEvent(event.Data)
 		case event, ok := <-c.finalCommittedSub.Chan():
 			if !ok {
 				return
 			}
-			switch event.Data.(type) {
-			case istanbul.FinalCommittedEvent:
-				c.handleFinalCommitted()
+			c.handleEvent(event.Data)
+		}
+	}
+}
+
+// handleEvent processes a single event while holding stateMu
+func (c *core) handleEvent(data interface{}) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	// A handler panicking on an unexpected input must not take the event
+	// loop down with it, the event is dropped instead
+	defer func() {
+		if r := recover(); r != nil {
+			c.panicMeter.Mark(1)
+			c.logger.Error("Consensus handler panicked", "event", fmt.Sprintf("%T", data), "data", eventData(data), "err", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	// Stop proposing and changing rounds once halted
+	if c.halted {
+		switch data.(type) {
+		case istanbul.RequestEvent, timeoutEvent, heartbeatEvent, proposalTimeoutEvent:
+			return
+		}
+	}
+	if name := handlerName(data); name != "" {
+		defer c.timeHandler(name, c.state, time.Now())
+	}
+
+	switch ev := data.(type) {
+	case istanbul.RequestEvent:
+		r := &istanbul.Request{
+			Proposal: ev.Proposal,
+		}
+		err := c.handleRequest(r)
+		if err == errFutureMessage {
+			c.storeRequestMsg(r)
+		}
+	case istanbul.AbandonRequestEvent:
+		c.handleAbandonRequest(ev.Proposal)
+	case istanbul.MessageEvent:
+		msg, err := c.handleMsg(ev.Payload)
+		// SYNC RESPONSE is only meant for us, don't gossip it
+		if err == nil && msg.Code != msgSyncResponse {
+			c.backend.Gossip(c.valSet, ev.Payload)
+		}
+	case backlogEvent:
+		// No need to check signature for internal messages
+		if err := c.handleCheckedMsg(ev.msg, ev.src); err == nil {
+			p, err := ev.msg.Payload()
+			if err != nil {
+				c.logger.Warn("Get message payload failed", "err", err)
+				return
 			}
+			c.backend.Gossip(c.valSet, p)
 		}
+	case timeoutEvent:
+		c.handleTimeoutMsg(ev.view)
+	case heartbeatEvent:
+		c.handleHeartbeatTick()
+	case proposalTimeoutEvent:
+		c.handleProposalTimeout(ev.view)
+	case istanbul.FinalCommittedEvent:
+		c.handleFinalCommitted()
+	case istanbul.ConnectionEvent:
+		c.handleConnectionEvent(ev.Address)
 	}
 }
 
+// eventData returns the content of the event to log, the payload of a message
+// as is.
+func eventData(data interface{}) interface{} {
+	switch ev := data.(type) {
+	case istanbul.MessageEvent:
+		return common.Bytes2Hex(ev.Payload)
+	case backlogEvent:
+		return ev.msg
+	}
+	return data
+}
+
 // sendEvent sends events to mux
 func (c *core) sendEvent(ev interface{}) {
 	c.backend.EventMux().Post(ev)
 }
 
-func (c *core) handleMsg(payload []byte) error {
+// sendConsensusEvent reports a step of the consensus in the current view, for
+// monitoring
+func (c *core) sendConsensusEvent(typ istanbul.ConsensusEventType, digest common.Hash) {
+	c.sendEvent(istanbul.ConsensusEvent{
+		Type:     typ,
+		Sequence: c.current.Sequence().Uint64(),
+		Round:    c.current.Round().Uint64(),
+		Digest:   digest,
+	})
+}
+
+func (c *core) handleMsg(payload []byte) (*message, error) {
 	logger := c.logger.New()
 
-	// Decode message and check its signature
+	// Decode message
 	msg := new(message)
-	if err := msg.FromPayload(payload, c.validateFn); err != nil {
+	if err := msg.FromPayload(payload, nil); err != nil {
 		logger.Error("Failed to decode message from payload", "err", err)
-		return err
+		return nil, err
 	}
 
-	// Only accept message if the address is valid
+	// Drop the messages from non-validators before checking their signature
 	_, src := c.valSet.GetByAddress(msg.Address)
 	if src == nil {
-		logger.Error("Invalid address in message", "msg", msg)
-		return istanbul.ErrUnauthorizedAddress
+		c.dropNonValidatorMsg(msg)
+		return nil, errNonValidatorSender
+	}
+
+	// Only accept the message if it's signed by its sender
+	if err := msg.checkSignature(c.checkMessageSignature); err != nil {
+		logger.Error("Failed to check message signature", "err", err)
+		return nil, err
 	}
 
-	return c.handleCheckedMsg(msg, src)
+	return msg, c.handleCheckedMsg(msg, src)
 }
 
-func (c *core) handleCheckedMsg(msg *message, src istanbul.Validator) error {
+// dropNonValidatorMsg drops a message from an address which isn't in the
+// validator set, e.g. from a removed validator or a misconfigured node. It's
+// only logged and counted if configured to, the sender isn't authenticated.
+func (c *core) dropNonValidatorMsg(msg *message) {
+	if !c.config.LogNonValidators {
+		c.logger.Trace("Dropped message from non-validator", "from", msg.Address, "code", msg.Code)
+		return
+	}
+	c.logger.Warn("Dropped message from non-validator", "from", msg.Address, "code", msg.Code)
+	c.nonValidatorMeter.Mark(1)
+}
+
+func (c *core) handleCheckedMsg(msg *message, src istanbul.Validator) (err error) {
 	logger := c.logger.New("address", c.address, "from", src)
+	logger.Trace("Handling message", "code", msgNames[msg.Code])
+
+	if c.traceLevel > 0 {
+		defer func(state State) { c.traceMessage(msg, src, state, err) }(c.state)
+	}
 
 	// Store the message if it's a future message
 	testBacklog := func(err error) error {
 		if err == errFutureMessage {
 			c.storeBacklog(msg, src)
+			if view := messageView(msg); view != nil {
+				c.checkSequenceGap(view, src)
+			}
 		}
 
 		return err
 	}
 
+	if err := c.checkReplayedMessage(msg); err != nil {
+		return err
+	}
+	if name, ok := msgNames[msg.Code]; ok {
+		defer c.timeHandler(metricName(name), c.state, time.Now())
+	}
+
 	switch msg.Code {
 	case msgPreprepare:
 		return testBacklog(c.handlePreprepare(msg, src))
@@ -173,6 +348,12 @@ func (c *core) handleCheckedMsg(msg *message, src istanbul.Validator) error {
 		return testBacklog(c.handleCommit(msg, src))
 	case msgRoundChange:
 		return testBacklog(c.handleRoundChange(msg, src))
+	case msgSyncRequest:
+		return c.handleSyncRequest(msg, src)
+	case msgSyncResponse:
+		return c.handleSyncResponse(msg, src)
+	case msgHeartbeat:
+		return c.handleHeartbeat(msg, src)
 	default:
 		logger.Error("Invalid message", "msg", msg)
 	}
@@ -180,12 +361,38 @@ func (c *core) handleCheckedMsg(msg *message, src istanbul.Validator) error {
 	return errInvalidMessage
 }
 
-func (c *core) handleTimeoutMsg() {
+// checkReplayedMessage returns errOldMessage if the message belongs to a
+// sequence which has been committed already. Old PRE-PREPARE messages are
+// left to handlePreprepare, which uses them to help the lagging proposer.
+func (c *core) checkReplayedMessage(msg *message) error {
+	switch msg.Code {
+	case msgPrepare, msgCommit, msgRoundChange:
+	default:
+		return nil
+	}
+
+	view := messageView(msg)
+	if view == nil || view.Sequence == nil {
+		return nil
+	}
+	lastProposal, _ := c.backend.LastProposal()
+	if lastProposal != nil && view.Sequence.Cmp(lastProposal.Number()) <= 0 {
+		return errOldMessage
+	}
+	return nil
+}
+
+func (c *core) handleTimeoutMsg(view *istanbul.View) {
+	// The timer may fire while the view changes, e.g. catching up with the
+	// round of the others, and the new view has a timer of its own
+	if view != nil && (c.current == nil || view.Cmp(c.currentView()) != 0) {
+		return
+	}
 	// If we're not waiting for round change yet, we can try to catch up
 	// the max round with F+1 round change message. We only need to catch up
 	// if the max round is larger than current round.
 	if !c.waitingForRoundChange {
-		maxRound := c.roundChangeSet.MaxRound(c.valSet.F() + 1)
+		maxRound := c.roundChangeSet.MaxRound(c.config.F(c.valSet) + 1)
 		if maxRound != nil && maxRound.Cmp(c.current.Round()) > 0 {
 			c.sendRoundChange(maxRound)
 			return
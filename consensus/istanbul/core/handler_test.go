@@ -17,13 +17,162 @@
 package core
 
 import (
+	"crypto/ecdsa"
 	"math/big"
+	"runtime"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/consensus/istanbul/validator"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/metrics"
 )
 
+func TestForgedSender(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+
+	// Sign the messages for real, with the keys of the validators
+	keys := make([]*ecdsa.PrivateKey, 4)
+	addrs := make([]common.Address, 4)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	outsider, _ := crypto.GenerateKey()
+
+	c.valSet = validator.NewSet(addrs, istanbul.RoundRobin)
+	c.current = newTestRoundState(&istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}, c.valSet)
+	c.state = StatePreprepared
+	c.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		pubkey, err := crypto.SigToPub(crypto.Keccak256(data), sig)
+		if err != nil {
+			return common.Address{}, err
+		}
+		return crypto.PubkeyToAddress(*pubkey), nil
+	}
+	subject, _ := Encode(c.current.Subject())
+	sign := func(code uint64, address common.Address, key *ecdsa.PrivateKey) []byte {
+		msg := &message{
+			Code:          code,
+			Msg:           subject,
+			Address:       address,
+			CommittedSeal: []byte{},
+			Version:       msgVersion,
+		}
+		data, _ := msg.PayloadNoSig()
+		msg.Signature, _ = crypto.Sign(crypto.Keccak256(c.config.SigSchemeAt(c.current.Sequence()).SigData(istanbul.MessageDomain, data)), key)
+		payload, _ := msg.Payload()
+		return payload
+	}
+
+	testCases := []struct {
+		payload []byte
+		err     error
+	}{
+		// a validator's address signed by an outsider
+		{sign(msgPrepare, addrs[1], outsider), errInvalidSigner},
+		{sign(msgCommit, addrs[1], outsider), errInvalidSigner},
+		// a validator's address signed by another validator
+		{sign(msgPrepare, addrs[1], keys[2]), errInvalidSigner},
+		{sign(msgCommit, addrs[1], keys[2]), errInvalidSigner},
+	}
+	for i, test := range testCases {
+		if _, err := c.handleMsg(test.payload); err != test.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.err)
+		}
+	}
+	if size := c.current.Prepares.Size(); size != 0 {
+		t.Errorf("the number of PREPAREs mismatch: have %v, want 0", size)
+	}
+	if size := c.current.Commits.Size(); size != 0 {
+		t.Errorf("the number of COMMITs mismatch: have %v, want 0", size)
+	}
+
+	// The validator's own signature is counted
+	if _, err := c.handleMsg(sign(msgPrepare, addrs[1], keys[1])); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if size := c.current.Prepares.Size(); size != 1 {
+		t.Errorf("the number of PREPAREs mismatch: have %v, want 1", size)
+	}
+}
+
+func TestNonValidatorSender(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	config := *istanbul.DefaultConfig
+	c.config = &config
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+	c.nonValidatorMeter = metrics.NewMeter()
+
+	c.current = newTestRoundState(&istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}, c.valSet)
+	c.state = StatePreprepared
+
+	// A non-validator signing its own messages
+	outsider, _ := crypto.GenerateKey()
+	addr := crypto.PubkeyToAddress(outsider.PublicKey)
+	sign := func(code uint64, view *istanbul.View) []byte {
+		subject, _ := Encode(&istanbul.Subject{View: view, Digest: c.current.Subject().Digest})
+		msg := &message{
+			Code:          code,
+			Msg:           subject,
+			Address:       addr,
+			CommittedSeal: []byte{},
+			Version:       msgVersion,
+		}
+		data, _ := msg.PayloadNoSig()
+		msg.Signature, _ = crypto.Sign(crypto.Keccak256(c.config.SigSchemeAt(c.current.Sequence()).SigData(istanbul.MessageDomain, data)), outsider)
+		payload, _ := msg.Payload()
+		return payload
+	}
+	payloads := [][]byte{
+		sign(msgPrepare, c.currentView()),
+		sign(msgCommit, c.currentView()),
+		sign(msgRoundChange, &istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)}),
+	}
+
+	// The messages are dropped without affecting the round
+	for i, payload := range payloads {
+		if _, err := c.handleMsg(payload); err != errNonValidatorSender {
+			t.Errorf("message %d: error mismatch: have %v, want %v", i, err, errNonValidatorSender)
+		}
+	}
+	if size := c.current.Prepares.Size(); size != 0 {
+		t.Errorf("the number of PREPAREs mismatch: have %v, want 0", size)
+	}
+	if size := c.current.Commits.Size(); size != 0 {
+		t.Errorf("the number of COMMITs mismatch: have %v, want 0", size)
+	}
+	if round := c.roundChangeSet.MaxRound(1); round != nil {
+		t.Errorf("round change mismatch: have %v, want nil", round)
+	}
+	if round := c.current.Round(); round.Sign() != 0 || c.state != StatePreprepared {
+		t.Errorf("round mismatch: have %v (%v), want 0 (%v)", round, c.state, StatePreprepared)
+	}
+	if count := c.nonValidatorMeter.Count(); count != 0 {
+		t.Errorf("dropped messages mismatch: have %v, want 0", count)
+	}
+
+	// and counted if configured to
+	config.LogNonValidators = true
+	for _, payload := range payloads {
+		c.handleMsg(payload)
+	}
+	if count := c.nonValidatorMeter.Count(); count != int64(len(payloads)) {
+		t.Errorf("dropped messages mismatch: have %v, want %v", count, len(payloads))
+	}
+}
+
 // notice: the normal case have been tested in integration tests.
 func TestHandleMsg(t *testing.T) {
 	N := uint64(4)
@@ -121,7 +270,216 @@ func TestHandleMsg(t *testing.T) {
 	}
 
 	// with malicious payload
-	if err := r0.handleMsg([]byte{1}); err == nil {
+	if _, err := r0.handleMsg([]byte{1}); err == nil {
 		t.Errorf("error mismatch: have %v, want nil", err)
 	}
 }
+
+func TestHandleReplayedMsg(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+	sys := NewTestSystemWithBackend(N, F)
+
+	v0 := sys.backends[0]
+	r0 := v0.engine.(*core)
+
+	// sequence 1 has been committed but the new round hasn't started yet
+	proposal := makeBlock(1)
+	v0.committedMsgs = append(v0.committedMsgs, testCommittedMsgs{
+		commitProposal: proposal,
+	})
+	r0.state = StateCommitted
+
+	m, _ := Encode(&istanbul.Subject{
+		View: &istanbul.View{
+			Sequence: big.NewInt(1),
+			Round:    big.NewInt(0),
+		},
+		Digest: proposal.Hash(),
+	})
+	for _, code := range []uint64{msgPrepare, msgCommit, msgRoundChange} {
+		val := v0.peers.GetByIndex(1)
+		msg := &message{
+			Code:          code,
+			Msg:           m,
+			Address:       val.Address(),
+			Signature:     []byte{},
+			CommittedSeal: []byte{},
+		}
+		if err := r0.handleCheckedMsg(msg, val); err != errOldMessage {
+			t.Errorf("error mismatch: have %v, want %v", err, errOldMessage)
+		}
+	}
+
+	if r0.state != StateCommitted {
+		t.Errorf("state mismatch: have %v, want %v", r0.state, StateCommitted)
+	}
+	if r0.current.Prepares.Size() != 0 || r0.current.Commits.Size() != 0 {
+		t.Errorf("replayed messages should be ignored: have %v prepares and %v commits", r0.current.Prepares.Size(), r0.current.Commits.Size())
+	}
+	if r0.roundChangeSet.MaxRound(1) != nil {
+		t.Errorf("replayed round change should be ignored")
+	}
+	if len(v0.committedMsgs) != 1 {
+		t.Errorf("the number of committed proposals mismatch: have %v, want 1", len(v0.committedMsgs))
+	}
+}
+
+func TestStartStop(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+	sys := NewTestSystemWithBackend(N, F)
+
+	v0 := sys.backends[0]
+	r0 := v0.engine.(*core)
+	val := v0.peers.GetByIndex(1)
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < 10; i++ {
+		if err := r0.Start(); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		if state, view := r0.currentState(); state != StateAcceptRequest || view == nil || view.Sequence.Cmp(common.Big1) != 0 {
+			t.Fatalf("state mismatch: have %v at %v, want %v at sequence 1", state, view, StateAcceptRequest)
+		}
+
+		// a future message is left in the backlog
+		m, _ := Encode(&istanbul.Subject{
+			View: &istanbul.View{
+				Sequence: big.NewInt(10),
+				Round:    big.NewInt(0),
+			},
+			Digest: common.StringToHash("1234567890"),
+		})
+		r0.storeBacklog(&message{
+			Code:    msgPrepare,
+			Msg:     m,
+			Address: val.Address(),
+		}, val)
+
+		if err := r0.Stop(); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+		if state, view := r0.currentState(); state != StateAcceptRequest || view != nil {
+			t.Errorf("state mismatch: have %v at %v, want %v without view", state, view, StateAcceptRequest)
+		}
+		if len(r0.backlogs) != 0 {
+			t.Errorf("the number of backlogs mismatch: have %v, want 0", len(r0.backlogs))
+		}
+	}
+
+	// give the exited goroutines a moment to be accounted
+	<-time.After(100 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("goroutine leak: have %v goroutines, want at most %v", after, before)
+	}
+}
+
+func TestEventBuffer(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+
+	sys := NewTestSystemWithBackend(1, 0)
+	backend := sys.backends[0]
+	c := backend.engine.(*core)
+	config := *istanbul.DefaultConfig
+	config.EventBufferSize = 10
+	c.config = &config
+	c.droppedMeter = metrics.NewMeter()
+
+	closer := sys.Run(true)
+	defer closer()
+
+	// The core is busy, so the flood of messages overflows the buffer
+	const messages = 100
+	c.stateMu.Lock()
+	posted := make(chan struct{})
+	go func() {
+		for i := 0; i < messages; i++ {
+			backend.EventMux().Post(istanbul.MessageEvent{Payload: []byte{byte(i)}})
+		}
+		close(posted)
+	}()
+	select {
+	case <-posted:
+	case <-time.After(2 * time.Second):
+		c.stateMu.Unlock()
+		t.Fatalf("posting the messages should not stall")
+	}
+	// at most one message is taken by the handler waiting for the core
+	if have, want := c.droppedMeter.Count(), int64(messages-config.EventBufferSize-1); have < want {
+		t.Errorf("the number of dropped messages mismatch: have %v, want at least %v", have, want)
+	}
+	c.stateMu.Unlock()
+
+	// The engine goes on once the core is available again
+	backend.NewRequest(makeBlock(1))
+	deadline := time.After(2 * time.Second)
+	for {
+		c.stateMu.RLock()
+		committed := len(backend.committedMsgs)
+		c.stateMu.RUnlock()
+		if committed == 1 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("the number of committed requests mismatch: have %v, want 1", committed)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestHandlerPanic(t *testing.T) {
+	defer func(enabled bool) { metrics.Enabled = enabled }(metrics.Enabled)
+	metrics.Enabled = true
+
+	sys := NewTestSystemWithBackend(1, 0)
+	backend := sys.backends[0]
+	c := backend.engine.(*core)
+	c.panicMeter = metrics.NewMeter()
+
+	// Checking the signature of the next message panics
+	c.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		c.validateFn = backend.CheckValidatorSignature
+		panic("malformed message")
+	}
+	closer := sys.Run(true)
+	defer closer()
+
+	subject, _ := Encode(&istanbul.Subject{
+		View: &istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		Digest: common.StringToHash("1234567890"),
+	})
+	payload, _ := (&message{
+		Code:      msgPrepare,
+		Msg:       subject,
+		Address:   backend.Address(),
+		Signature: backend.Address().Bytes(),
+		Version:   msgVersion,
+	}).Payload()
+	backend.EventMux().Post(istanbul.MessageEvent{Payload: payload})
+
+	// The event loop survives and goes on with the next events
+	backend.NewRequest(makeBlock(1))
+	deadline := time.After(2 * time.Second)
+	for {
+		c.stateMu.RLock()
+		committed := len(backend.committedMsgs)
+		c.stateMu.RUnlock()
+		if committed == 1 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("the number of committed requests mismatch: have %v, want 1", committed)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if count := c.panicMeter.Count(); count != 1 {
+		t.Errorf("the number of panics mismatch: have %v, want 1", count)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"time"
+)
+
+// Health is the liveness of the consensus, as reported to the probes of an
+// orchestrator.
+type Health struct {
+	Healthy     bool      `json:"healthy"`     // Whether the consensus is both committing and progressing
+	Committing  bool      `json:"committing"`  // Whether a block was committed, or the core started, within the health window
+	Progressing bool      `json:"progressing"` // Whether the consensus isn't halted nor beyond the maximum round changes without a commit
+	CommittedAt time.Time `json:"committedAt"` // Time of the last commit, or of the start of the core if none was since
+	Sequence    *big.Int  `json:"sequence"`    // Sequence of the current view
+	Round       *big.Int  `json:"round"`       // Round of the current view, the number of round changes since the last commit
+}
+
+// Health implements core.Engine.Health.
+func (c *core) Health() (*Health, error) {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	if c.current == nil {
+		return nil, errNoState
+	}
+	health := &Health{
+		Committing:  true,
+		Progressing: !c.halted,
+		CommittedAt: c.committedAt,
+		Sequence:    c.current.Sequence(),
+		Round:       c.current.Round(),
+	}
+	if window := c.config.HealthWindow; window > 0 {
+		health.Committing = c.clock.Now().Sub(c.committedAt) <= time.Duration(window)*time.Second
+	}
+	if max := c.config.HealthMaxRounds; max > 0 && health.Round.Cmp(new(big.Int).SetUint64(max)) > 0 {
+		health.Progressing = false
+	}
+	health.Healthy = health.Committing && health.Progressing
+	return health, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestHealth(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	config := *istanbul.DefaultConfig
+	config.RequestTimeout = uint64(time.Hour / time.Millisecond)
+	config.HealthWindow = 10
+	config.HealthMaxRounds = 2
+	clock := istanbul.NewSimulatedClock()
+	for _, backend := range sys.backends {
+		c := backend.engine.(*core)
+		c.config = &config
+		c.clock = clock
+	}
+	// Nothing can be reported before the core started
+	if _, err := New(sys.backends[0], &config).Health(); err != errNoState {
+		t.Errorf("error mismatch: have %v, want %v", err, errNoState)
+	}
+
+	stop := sys.Run(true)
+	defer stop()
+
+	c := sys.backends[0].engine.(*core)
+	health, err := c.Health()
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if !health.Healthy || !health.Committing || !health.Progressing {
+		t.Errorf("health mismatch: have %+v, want healthy", health)
+	}
+	if !health.CommittedAt.Equal(clock.Now()) {
+		t.Errorf("commit time mismatch: have %v, want %v", health.CommittedAt, clock.Now())
+	}
+
+	// Nothing is proposed, so the rounds keep changing
+	for round := int64(1); round <= int64(config.HealthMaxRounds)+1; round++ {
+		for _, backend := range sys.backends {
+			backend.engine.(*core).sendEvent(timeoutEvent{})
+		}
+		deadline := time.After(2 * time.Second)
+		for i, backend := range sys.backends {
+			for {
+				_, view := backend.engine.(*core).currentState()
+				if view.Round.Int64() >= round {
+					break
+				}
+				select {
+				case <-deadline:
+					t.Fatalf("backend %d: round mismatch: have %v, want %v", i, view.Round, round)
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		}
+	}
+	<-time.After(100 * time.Millisecond)
+	if health, _ = c.Health(); health.Healthy || health.Progressing || !health.Committing {
+		t.Errorf("health mismatch: have %+v, want committing but not progressing", health)
+	}
+
+	// And no block is committed within the window
+	clock.Run(time.Duration(config.HealthWindow)*time.Second + time.Millisecond)
+	if health, _ = c.Health(); health.Healthy || health.Committing {
+		t.Errorf("health mismatch: have %+v, want neither committing nor progressing", health)
+	}
+}
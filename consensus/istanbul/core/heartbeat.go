@@ -0,0 +1,101 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// newHeartbeatTimer schedules the next heartbeat tick if heartbeats are enabled
+func (c *core) newHeartbeatTimer() {
+	c.stopHeartbeatTimer()
+
+	if c.config.HeartbeatInterval == 0 {
+		return
+	}
+	interval := time.Duration(c.config.HeartbeatInterval) * time.Millisecond
+	c.heartbeatTimer = c.clock.AfterFunc(interval, func() {
+		c.sendEvent(heartbeatEvent{})
+	})
+}
+
+func (c *core) stopHeartbeatTimer() {
+	if c.heartbeatTimer != nil {
+		c.heartbeatTimer.Stop()
+	}
+}
+
+// handleHeartbeatTick sends a heartbeat if we are the proposer. Otherwise it
+// counts the interval as missed, and starts a round change once the proposer
+// has missed enough heartbeats, without waiting for the round change timeout.
+func (c *core) handleHeartbeatTick() {
+	defer c.newHeartbeatTimer()
+
+	if c.current == nil {
+		return
+	}
+	if c.isProposer() {
+		c.sendHeartbeat()
+		return
+	}
+	// The round is changing already
+	if c.waitingForRoundChange {
+		return
+	}
+
+	c.heartbeatMisses++
+	if max := c.config.HeartbeatMisses; max > 0 && c.heartbeatMisses >= max {
+		c.logger.Warn("Proposer missed heartbeats, change round", "proposer", c.valSet.GetProposer(), "misses", c.heartbeatMisses)
+		c.heartbeatMisses = 0
+		c.sendNextRoundChange()
+	}
+}
+
+func (c *core) sendHeartbeat() error {
+	return c.broadcastMsg(msgHeartbeat, &istanbul.Heartbeat{
+		View: c.currentView(),
+		Time: uint64(time.Now().UnixNano()),
+	})
+}
+
+func (c *core) handleHeartbeat(msg *message, src istanbul.Validator) error {
+	// Decode HEARTBEAT message
+	var heartbeat *istanbul.Heartbeat
+	if err := msg.Decode(&heartbeat); err != nil {
+		return errFailedDecodeHeartbeat
+	}
+	view := heartbeat.View
+	if view == nil || view.Sequence == nil || view.Round == nil {
+		return errInvalidMessage
+	}
+	// Our own heartbeat is delivered to us as well
+	if src.Address() == c.Address() {
+		return nil
+	}
+	// Only the heartbeats of the current proposer count
+	if !c.valSet.IsProposer(src.Address()) {
+		return errNotFromProposer
+	}
+	if view.Cmp(c.currentView()) != 0 {
+		return errIgnored
+	}
+
+	c.heartbeatMisses = 0
+	return nil
+}
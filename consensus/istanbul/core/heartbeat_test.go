@@ -0,0 +1,73 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestHeartbeat(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	config := *istanbul.DefaultConfig
+	config.HeartbeatInterval = 50
+	config.HeartbeatMisses = 3
+	var proposer *testSystemBackend
+	for _, backend := range sys.backends {
+		c := backend.engine.(*core)
+		c.config = &config
+		if c.isProposer() {
+			proposer = backend
+		}
+	}
+
+	stop := sys.Run(true)
+	defer stop()
+
+	// The proposer is alive, so nobody changes round
+	<-time.After(10 * time.Duration(config.HeartbeatInterval) * time.Millisecond)
+	for i, backend := range sys.backends {
+		if _, view := backend.engine.(*core).currentState(); view.Round.Sign() != 0 {
+			t.Errorf("backend %d: round mismatch: have %v, want 0", i, view.Round)
+		}
+	}
+
+	// Silence the proposer, the others change round before the request timeout
+	proposer.engine.Stop()
+	deadline := time.After(2 * time.Second)
+	for _, backend := range sys.backends {
+		if backend == proposer {
+			continue
+		}
+		for {
+			_, view := backend.engine.(*core).currentState()
+			if view.Round.Sign() > 0 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("the round should be changed after missing heartbeats")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+// Log is the record of the consensus on a committed sequence, kept for dispute
+// resolution. The messages are the signed payloads of the validators, sorted by
+// sender, so they can be checked by anyone.
+type Log struct {
+	Sequence   *big.Int
+	Round      *big.Int             // the round the proposal was committed in
+	Preprepare *istanbul.Preprepare // the PRE-PREPARE of the committed proposal
+	Prepares   [][]byte             // the PREPAREs of the round
+	Commits    [][]byte             // the COMMITs of the round, with their committed seals
+}
+
+// recordLog records the log of the current sequence if the committed proposal
+// is the one the round agreed on, before the sequence is left. Only the last
+// LogRetention sequences are kept.
+func (c *core) recordLog(committed istanbul.Proposal) {
+	retention := c.config.LogRetention
+	if retention == 0 || c.current == nil || c.current.Preprepare == nil {
+		return
+	}
+	if c.current.Proposal().Hash() != committed.Hash() {
+		return
+	}
+	log := &Log{
+		Sequence:   c.current.Sequence(),
+		Round:      c.current.Round(),
+		Preprepare: c.current.Preprepare,
+	}
+	for _, msg := range sortedMessages(c.current.Prepares.Values()) {
+		if payload, err := msg.Payload(); err == nil {
+			log.Prepares = append(log.Prepares, payload)
+		}
+	}
+	for _, msg := range sortedMessages(c.current.Commits.Values()) {
+		if payload, err := msg.Payload(); err == nil {
+			log.Commits = append(log.Commits, payload)
+		}
+	}
+	c.logs = append(c.logs, log)
+	if uint64(len(c.logs)) > retention {
+		c.logs = c.logs[uint64(len(c.logs))-retention:]
+	}
+}
+
+// LogAt implements core.Engine.LogAt
+func (c *core) LogAt(sequence uint64) (*Log, bool) {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	for i := len(c.logs) - 1; i >= 0; i-- {
+		if c.logs[i].Sequence.Uint64() == sequence {
+			return c.logs[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,93 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestLogAt(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	config := *istanbul.DefaultConfig
+	config.LogRetention = 3
+	for _, backend := range sys.backends {
+		backend.engine.(*core).config = &config
+	}
+	stop := sys.Run(true)
+	defer stop()
+
+	// The log of a sequence is recorded once the next one starts
+	c := sys.backends[0].engine.(*core)
+	for i := int64(1); i <= 5; i++ {
+		sys.backends[0].NewRequest(makeBlock(i))
+
+		deadline := time.After(2 * time.Second)
+		for {
+			if _, ok := c.LogAt(uint64(i)); ok {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("the log of sequence %d should be recorded", i)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	log, ok := c.LogAt(4)
+	if !ok {
+		t.Fatalf("the log of sequence 4 should be retained")
+	}
+	if log.Sequence.Uint64() != 4 || log.Preprepare.Proposal.Number().Uint64() != 4 {
+		t.Errorf("sequence mismatch: have %v with proposal %v, want 4", log.Sequence, log.Preprepare.Proposal.Number())
+	}
+	if len(log.Commits) < int(2*F+1) {
+		t.Errorf("the number of COMMITs mismatch: have %v, want at least %v", len(log.Commits), 2*F+1)
+	}
+	senders := make(map[string]bool)
+	for _, payload := range log.Commits {
+		msg := new(message)
+		if err := msg.FromPayload(payload, c.checkMessageSignature); err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+		if msg.Code != msgCommit {
+			t.Errorf("message code mismatch: have %v, want %v", msg.Code, msgCommit)
+		}
+		senders[msg.Address.Hex()] = true
+	}
+	if len(senders) != len(log.Commits) {
+		t.Errorf("the number of senders mismatch: have %v, want %v", len(senders), len(log.Commits))
+	}
+
+	// Only the last sequences are retained
+	for _, sequence := range []uint64{1, 2, 6} {
+		if _, ok := c.LogAt(sequence); ok {
+			t.Errorf("the log of sequence %d should not be found", sequence)
+		}
+	}
+	for _, sequence := range []uint64{3, 4, 5} {
+		if _, ok := c.LogAt(sequence); !ok {
+			t.Errorf("the log of sequence %d should be found", sequence)
+		}
+	}
+}
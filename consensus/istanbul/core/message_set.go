@@ -41,6 +41,8 @@ func newMessageSet(valSet istanbul.ValidatorSet) *messageSet {
 
 // ----------------------------------------------------------------------------
 
+// messageSet keeps the latest message of each validator. It's safe for
+// concurrent use.
 type messageSet struct {
 	view       *istanbul.View
 	valSet     istanbul.ValidatorSet
@@ -80,6 +82,20 @@ func (ms *messageSet) Size() int {
 	return len(ms.messages)
 }
 
+// Weight returns the summed voting weight of the senders
+func (ms *messageSet) Weight() int {
+	ms.messagesMu.Lock()
+	defer ms.messagesMu.Unlock()
+
+	var weight uint64
+	for addr := range ms.messages {
+		if _, v := ms.valSet.GetByAddress(addr); v != nil {
+			weight += v.Weight()
+		}
+	}
+	return int(weight)
+}
+
 func (ms *messageSet) Get(addr common.Address) *message {
 	ms.messagesMu.Lock()
 	defer ms.messagesMu.Unlock()
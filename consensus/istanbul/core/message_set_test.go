@@ -18,6 +18,7 @@ package core
 
 import (
 	"math/big"
+	"sync"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -104,3 +105,67 @@ func TestMessageSetWithSubject(t *testing.T) {
 		t.Errorf("the size of message set mismatch: have %v, want 1", ms.Size())
 	}
 }
+
+func TestMessageSetDedup(t *testing.T) {
+	valSet := newTestValidatorSet(4)
+	ms := newMessageSet(valSet)
+
+	addr := valSet.GetByIndex(0).Address()
+	first := &message{Code: msgCommit, Msg: []byte{0x01}, Address: addr}
+	second := &message{Code: msgCommit, Msg: []byte{0x02}, Address: addr}
+	for _, msg := range []*message{first, second} {
+		if err := ms.Add(msg); err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if ms.Size() != 1 {
+		t.Errorf("the size of message set mismatch: have %v, want 1", ms.Size())
+	}
+	// The latest message of the validator is kept
+	if msg := ms.Get(addr); msg != second {
+		t.Errorf("message mismatch: have %v, want %v", msg, second)
+	}
+
+	// Messages from other than the validators are rejected
+	if err := ms.Add(&message{Code: msgCommit, Address: common.StringToAddress("unknown")}); err != istanbul.ErrUnauthorizedAddress {
+		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrUnauthorizedAddress)
+	}
+	if ms.Size() != 1 {
+		t.Errorf("the size of message set mismatch: have %v, want 1", ms.Size())
+	}
+}
+
+func TestMessageSetConcurrentAdd(t *testing.T) {
+	const N = 10
+	valSet := newTestValidatorSet(N)
+	ms := newMessageSet(valSet)
+
+	// Every validator sends its message several times concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		for _, val := range valSet.List() {
+			wg.Add(1)
+			go func(addr common.Address) {
+				defer wg.Done()
+				if err := ms.Add(&message{Code: msgPrepare, Address: addr}); err != nil {
+					t.Errorf("error mismatch: have %v, want nil", err)
+				}
+				ms.Size()
+				ms.Values()
+			}(val.Address())
+		}
+	}
+	wg.Wait()
+
+	if ms.Size() != N {
+		t.Errorf("the size of message set mismatch: have %v, want %v", ms.Size(), N)
+	}
+	if values := ms.Values(); len(values) != N {
+		t.Errorf("the number of values mismatch: have %v, want %v", len(values), N)
+	}
+	for _, val := range valSet.List() {
+		if msg := ms.Get(val.Address()); msg == nil || msg.Address != val.Address() {
+			t.Errorf("message mismatch: have %v, want from %v", msg, val.Address())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// participationWindow is the number of recent sequences the participation
+	// of the validators is tracked for.
+	participationWindow = 128
+	// minParticipationRate is the fraction of the recent sequences below which
+	// a validator is reported as missing.
+	minParticipationRate = 0.5
+)
+
+// Participation reports the PREPARE and COMMIT messages a validator contributed
+// to the recent sequences.
+type Participation struct {
+	Sequences uint64  `json:"sequences"` // Number of recent sequences tracked
+	Prepares  uint64  `json:"prepares"`  // Number of sequences the validator sent a PREPARE in
+	Commits   uint64  `json:"commits"`   // Number of sequences the validator sent a COMMIT in
+	Rate      float64 `json:"rate"`      // Fraction of sequences the validator sent a PREPARE or COMMIT in
+	Missed    bool    `json:"missed"`    // Whether the rate is below the minimum participation rate
+}
+
+// sequenceVotes is the set of validators which voted in a sequence
+type sequenceVotes struct {
+	prepares map[common.Address]bool
+	commits  map[common.Address]bool
+}
+
+// recordParticipation records the validators which voted in the current round
+// state, before the sequence is left.
+func (c *core) recordParticipation() {
+	if c.current == nil {
+		return
+	}
+	votes := &sequenceVotes{
+		prepares: make(map[common.Address]bool),
+		commits:  make(map[common.Address]bool),
+	}
+	for _, msg := range c.current.Prepares.Values() {
+		votes.prepares[msg.Address] = true
+	}
+	for _, msg := range c.current.Commits.Values() {
+		votes.commits[msg.Address] = true
+	}
+	c.participation = append(c.participation, votes)
+	if len(c.participation) > participationWindow {
+		c.participation = c.participation[len(c.participation)-participationWindow:]
+	}
+}
+
+// Participation returns the participation of the current validators, and of
+// the former ones that voted in the recent sequences.
+func (c *core) Participation() map[common.Address]*Participation {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	stats := make(map[common.Address]*Participation)
+	get := func(addr common.Address) *Participation {
+		if stats[addr] == nil {
+			stats[addr] = &Participation{}
+		}
+		return stats[addr]
+	}
+	if c.valSet != nil {
+		for _, val := range c.valSet.List() {
+			get(val.Address())
+		}
+	}
+
+	participated := make(map[common.Address]uint64)
+	for _, votes := range c.participation {
+		for addr := range votes.prepares {
+			get(addr).Prepares++
+			participated[addr]++
+		}
+		for addr := range votes.commits {
+			get(addr).Commits++
+			if !votes.prepares[addr] {
+				participated[addr]++
+			}
+		}
+	}
+
+	sequences := uint64(len(c.participation))
+	for addr, stat := range stats {
+		stat.Sequences = sequences
+		if sequences > 0 {
+			stat.Rate = float64(participated[addr]) / float64(sequences)
+			stat.Missed = stat.Rate < minParticipationRate
+		}
+	}
+	return stats
+}
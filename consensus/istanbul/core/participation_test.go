@@ -0,0 +1,58 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParticipation(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	stop := sys.Run(true)
+	defer stop()
+
+	// The last validator never votes
+	silent := sys.backends[N-1]
+	silent.engine.Stop()
+
+	sys.backends[0].NewRequest(makeBlock(1))
+	<-time.After(1 * time.Second)
+
+	stats := sys.backends[0].engine.Participation()
+	if len(stats) != int(N) {
+		t.Fatalf("the number of validators mismatch: have %v, want %v", len(stats), N)
+	}
+	for _, backend := range sys.backends {
+		stat := stats[backend.Address()]
+		if stat.Sequences != 1 {
+			t.Errorf("sequences mismatch: have %v, want 1", stat.Sequences)
+		}
+		if backend == silent {
+			if stat.Prepares != 0 || stat.Commits != 0 || stat.Rate != 0 || !stat.Missed {
+				t.Errorf("participation mismatch: have %+v, want 0%% and missed", stat)
+			}
+			continue
+		}
+		if stat.Rate != 1 || stat.Missed {
+			t.Errorf("participation mismatch: have %+v, want 100%%", stat)
+		}
+	}
+}
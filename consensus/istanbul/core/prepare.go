@@ -19,29 +19,22 @@ package core
 import (
 	"reflect"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
 )
 
-func (c *core) sendPrepare() {
-	logger := c.logger.New("state", c.state)
-
-	sub := c.current.Subject()
-	encodedSubject, err := Encode(sub)
-	if err != nil {
-		logger.Error("Failed to encode", "subject", sub)
-		return
-	}
-	c.broadcast(&message{
-		Code: msgPrepare,
-		Msg:  encodedSubject,
-	})
+func (c *core) sendPrepare() error {
+	return c.broadcastMsg(msgPrepare, c.current.Subject())
 }
 
 func (c *core) handlePrepare(msg *message, src istanbul.Validator) error {
+	logger := c.newMsgLogger(msgPrepare, "from", src)
+
 	// Decode PREPARE message
 	var prepare *istanbul.Subject
 	err := msg.Decode(&prepare)
 	if err != nil {
+		logger.Error("Failed to decode PREPARE", "err", err)
 		return errFailedDecodePrepare
 	}
 
@@ -49,6 +42,9 @@ func (c *core) handlePrepare(msg *message, src istanbul.Validator) error {
 		return err
 	}
 
+	// Record the evidence if the validator signed another PREPARE in this round
+	c.checkEquivocation(msg, prepare.View, prepare.Digest)
+
 	// If it is locked, it can only process on the locked block.
 	// Passing verifyPrepare and checkMessage implies it is processing on the locked block since it was verified in the Preprepared state.
 	if err := c.verifyPrepare(prepare, src); err != nil {
@@ -59,11 +55,12 @@ func (c *core) handlePrepare(msg *message, src istanbul.Validator) error {
 
 	// Change to Prepared state if we've received enough PREPARE messages or it is locked
 	// and we are in earlier state before Prepared state.
-	if ((c.current.IsHashLocked() && prepare.Digest == c.current.GetLockedHash()) || c.current.GetPrepareOrCommitSize() > 2*c.valSet.F()) &&
+	if ((c.current.IsHashLocked() && prepare.Digest == c.current.GetLockedHash()) || c.current.GetPrepareOrCommitWeight() > 2*c.config.F(c.valSet)) &&
 		c.state.Cmp(StatePrepared) < 0 {
-		c.current.LockHash()
+		logger.Trace("Received enough PREPARE messages", "size", c.current.GetPrepareOrCommitSize(), "weight", c.current.GetPrepareOrCommitWeight())
+		c.lockPrepared()
 		c.setState(StatePrepared)
-		c.sendCommit()
+		return c.sendCommit()
 	}
 
 	return nil
@@ -71,7 +68,12 @@ func (c *core) handlePrepare(msg *message, src istanbul.Validator) error {
 
 // verifyPrepare verifies if the received PREPARE message is equivalent to our subject
 func (c *core) verifyPrepare(prepare *istanbul.Subject, src istanbul.Validator) error {
-	logger := c.logger.New("from", src, "state", c.state)
+	logger := c.newMsgLogger(msgPrepare, "from", src)
+
+	if prepare == nil || prepare.View == nil || prepare.View.Sequence == nil || prepare.View.Round == nil || prepare.Digest == (common.Hash{}) {
+		logger.Warn("Malformed subject", "got", prepare)
+		return errInvalidMessage
+	}
 
 	sub := c.current.Subject()
 	if !reflect.DeepEqual(prepare, sub) {
@@ -83,7 +85,7 @@ func (c *core) verifyPrepare(prepare *istanbul.Subject, src istanbul.Validator)
 }
 
 func (c *core) acceptPrepare(msg *message, src istanbul.Validator) error {
-	logger := c.logger.New("from", src, "state", c.state)
+	logger := c.newMsgLogger(msgPrepare, "from", src)
 
 	// Add the PREPARE message to current round state
 	if err := c.current.Prepares.Add(msg); err != nil {
@@ -311,7 +311,7 @@ func TestVerifyPrepare(t *testing.T) {
 		},
 		{
 			// malicious package(lack of sequence)
-			expected: errInconsistentSubject,
+			expected: errInvalidMessage,
 			prepare: &istanbul.Subject{
 				View:   &istanbul.View{Round: big.NewInt(0), Sequence: nil},
 				Digest: newTestProposal().Hash(),
@@ -321,6 +321,29 @@ func TestVerifyPrepare(t *testing.T) {
 				valSet,
 			),
 		},
+		{
+			// malicious package(lack of view)
+			expected: errInvalidMessage,
+			prepare: &istanbul.Subject{
+				View:   nil,
+				Digest: newTestProposal().Hash(),
+			},
+			roundState: newTestRoundState(
+				&istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)},
+				valSet,
+			),
+		},
+		{
+			// malicious package(lack of digest)
+			expected: errInvalidMessage,
+			prepare: &istanbul.Subject{
+				View: &istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)},
+			},
+			roundState: newTestRoundState(
+				&istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)},
+				valSet,
+			),
+		},
 		{
 			// wrong PREPARE message with same sequence but different round
 			expected: errInconsistentSubject,
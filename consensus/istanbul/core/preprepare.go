@@ -23,30 +23,63 @@ import (
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
 )
 
-func (c *core) sendPreprepare(request *istanbul.Request) {
-	logger := c.logger.New("state", c.state)
-
+func (c *core) sendPreprepare(request *istanbul.Request) error {
 	// If I'm the proposer and I have the same sequence with the proposal
 	if c.current.Sequence().Cmp(request.Proposal.Number()) == 0 && c.isProposer() {
-		curView := c.currentView()
-		preprepare, err := Encode(&istanbul.Preprepare{
-			View:     curView,
+		if err := c.broadcastMsg(msgPreprepare, &istanbul.Preprepare{
+			View:     c.currentView(),
 			Proposal: request.Proposal,
-		})
-		if err != nil {
-			logger.Error("Failed to encode", "view", curView)
-			return
+		}); err != nil {
+			return err
 		}
+		c.newProposalTimer()
+	}
+	return nil
+}
+
+// newProposalTimer schedules the proposal timeout of the current view, if it's
+// enabled
+func (c *core) newProposalTimer() {
+	c.stopProposalTimer()
+
+	if c.config.ProposalTimeout == 0 {
+		return
+	}
+	view := c.currentView()
+	timeout := time.Duration(c.config.ProposalTimeout) * time.Millisecond
+	c.proposalTimer = c.clock.AfterFunc(timeout, func() {
+		c.sendEvent(proposalTimeoutEvent{view: view})
+	})
+}
+
+func (c *core) stopProposalTimer() {
+	if c.proposalTimer != nil {
+		c.proposalTimer.Stop()
+	}
+}
 
-		c.broadcast(&message{
-			Code: msgPreprepare,
-			Msg:  preprepare,
-		})
+// handleProposalTimeout abandons our proposal if it's still not prepared, e.g.
+// because the other validators reject it, and starts a round change without
+// waiting for the round change timeout.
+func (c *core) handleProposalTimeout(view *istanbul.View) {
+	if c.current == nil || c.waitingForRoundChange || view.Cmp(c.currentView()) != 0 {
+		return
 	}
+	if c.state.Cmp(StatePrepared) >= 0 {
+		return
+	}
+	c.logger.Warn("Proposal not prepared in time, change round", "view", view, "prepares", c.current.Prepares.Size(), "timeout", c.config.ProposalTimeout)
+	c.sendNextRoundChange()
 }
 
 func (c *core) handlePreprepare(msg *message, src istanbul.Validator) error {
-	logger := c.logger.New("from", src, "state", c.state)
+	logger := c.newMsgLogger(msgPreprepare, "from", src)
+
+	// Reject oversized proposals before decoding them
+	if max := c.config.MaxProposalSize; max > 0 && uint64(len(msg.Msg)) > max {
+		logger.Warn("Proposal too large", "size", len(msg.Msg), "max", max)
+		return errProposalTooLarge
+	}
 
 	// Decode PRE-PREPARE
 	var preprepare *istanbul.Preprepare
@@ -60,15 +93,14 @@ func (c *core) handlePreprepare(msg *message, src istanbul.Validator) error {
 	if err := c.checkMessage(msgPreprepare, preprepare.View); err != nil {
 		if err == errOldMessage {
 			// Get validator set for the given proposal
-			valSet := c.backend.ParentValidators(preprepare.Proposal).Copy()
+			valSet := c.proposerValidators(c.backend.ParentValidators(preprepare.Proposal), preprepare.Proposal.Number())
 			previousProposer := c.backend.GetProposer(preprepare.Proposal.Number().Uint64() - 1)
 			valSet.CalcProposer(previousProposer, preprepare.View.Round.Uint64())
 			// Broadcast COMMIT if it is an existing block
 			// 1. The proposer needs to be a proposer matches the given (Sequence + Round)
 			// 2. The given block must exist
 			if valSet.IsProposer(src.Address()) && c.backend.HasPropsal(preprepare.Proposal.Hash(), preprepare.Proposal.Number()) {
-				c.sendCommitForOldBlock(preprepare.View, preprepare.Proposal.Hash())
-				return nil
+				return c.sendCommitForOldBlock(preprepare.View, preprepare.Proposal.Hash())
 			}
 		}
 		return err
@@ -80,13 +112,34 @@ func (c *core) handlePreprepare(msg *message, src istanbul.Validator) error {
 		return errNotFromProposer
 	}
 
+	// A proposer sending another proposal for the view tries to split the
+	// validators: keep the evidence, refuse the proposal and change round,
+	// unless enough validators already prepared one
+	if c.checkEquivocation(msg, preprepare.View, preprepare.Proposal.Hash()) {
+		logger.Warn("Proposer sent conflicting proposals", "hash", preprepare.Proposal.Hash())
+		if c.state.Cmp(StatePrepared) < 0 {
+			c.sendNextRoundChange()
+		}
+		return errEquivocatingProposer
+	}
+
+	// The view is the current one, so its sequence follows the last committed
+	// one: a proposal skipping or repeating a sequence is refused like an
+	// invalid one. The PRE-PREPAREs of the following sequences are stored as
+	// future messages above, and processed once we catch up.
+	if number := preprepare.Proposal.Number(); number == nil || number.Cmp(preprepare.View.Sequence) != 0 {
+		logger.Warn("Invalid proposal sequence", "number", number, "sequence", preprepare.View.Sequence)
+		c.sendNextRoundChange()
+		return errInvalidSequence
+	}
+
 	// Verify the proposal we received
 	if duration, err := c.backend.Verify(preprepare.Proposal); err != nil {
 		logger.Warn("Failed to verify proposal", "err", err, "duration", duration)
 		// if it's a future block, we will handle it again after the duration
 		if err == consensus.ErrFutureBlock {
 			c.stopFuturePreprepareTimer()
-			c.futurePreprepareTimer = time.AfterFunc(duration, func() {
+			c.futurePreprepareTimer = c.clock.AfterFunc(duration, func() {
 				c.sendEvent(backlogEvent{
 					src: src,
 					msg: msg,
@@ -106,7 +159,7 @@ func (c *core) handlePreprepare(msg *message, src istanbul.Validator) error {
 				// Broadcast COMMIT and enters Prepared state directly
 				c.acceptPreprepare(preprepare)
 				c.setState(StatePrepared)
-				c.sendCommit()
+				return c.sendCommit()
 			} else {
 				// Send round change
 				c.sendNextRoundChange()
@@ -117,7 +170,7 @@ func (c *core) handlePreprepare(msg *message, src istanbul.Validator) error {
 			//   2. we have no locked proposal
 			c.acceptPreprepare(preprepare)
 			c.setState(StatePreprepared)
-			c.sendPrepare()
+			return c.sendPrepare()
 		}
 	}
 
@@ -127,4 +180,12 @@ func (c *core) handlePreprepare(msg *message, src istanbul.Validator) error {
 func (c *core) acceptPreprepare(preprepare *istanbul.Preprepare) {
 	c.consensusTimestamp = time.Now()
 	c.current.SetPreprepare(preprepare)
+	// Keep the proposal as our pending request if we have none, so that we can
+	// propose it again if we become the proposer after a round change.
+	if c.current.pendingRequest == nil {
+		c.current.pendingRequest = &istanbul.Request{
+			Proposal: preprepare.Proposal,
+		}
+	}
+	c.sendConsensusEvent(istanbul.ConsensusPreprepare, preprepare.Proposal.Hash())
 }
@@ -17,9 +17,11 @@
 package core
 
 import (
+	"errors"
 	"math/big"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
 )
@@ -296,3 +298,314 @@ func TestHandlePreprepareWithLock(t *testing.T) {
 		}
 	}
 }
+
+func TestHandlePreprepareTooLarge(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	v0 := sys.backends[0]
+	c := v0.engine.(*core)
+
+	preprepare, _ := Encode(newTestPreprepare(c.currentView()))
+	config := *c.config
+	config.MaxProposalSize = uint64(len(preprepare))
+	c.config = &config
+
+	proposer := c.valSet.GetProposer()
+	testCases := []struct {
+		payload     []byte
+		expectedErr error
+	}{
+		{
+			// oversized garbage is rejected before decoding
+			make([]byte, len(preprepare)+1),
+			errProposalTooLarge,
+		},
+		{
+			// garbage within the limit fails to decode
+			make([]byte, len(preprepare)),
+			errFailedDecodePreprepare,
+		},
+	}
+	for _, test := range testCases {
+		err := c.handlePreprepare(&message{
+			Code:    msgPreprepare,
+			Msg:     test.payload,
+			Address: proposer.Address(),
+		}, proposer)
+		if err != test.expectedErr {
+			t.Errorf("error mismatch: have %v, want %v", err, test.expectedErr)
+		}
+	}
+}
+
+func TestHandlePreprepareInvalidProposal(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	// The proposal contains an invalid transaction, nobody can execute it
+	for _, backend := range sys.backends {
+		backend.verifyErr = errors.New("invalid transaction")
+	}
+
+	close := sys.Run(true)
+	defer close()
+
+	sys.backends[0].NewRequest(makeBlock(1))
+	<-time.After(500 * time.Millisecond)
+
+	for i, backend := range sys.backends {
+		c := backend.engine.(*core)
+		c.stateMu.RLock()
+		if len(backend.committedMsgs) != 0 {
+			t.Errorf("backend %d: the number of committed requests mismatch: have %v, want 0", i, len(backend.committedMsgs))
+		}
+		for _, payload := range backend.sentMsgs {
+			msg := new(message)
+			if err := msg.FromPayload(payload, nil); err != nil {
+				t.Fatalf("failed to decode the sent message: %v", err)
+			}
+			if msg.Code == msgPrepare || msg.Code == msgCommit {
+				t.Errorf("backend %d: message code mismatch: have %v, want no PREPARE nor COMMIT", i, msg.Code)
+			}
+		}
+		c.stateMu.RUnlock()
+	}
+}
+
+func TestProposalTimeout(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	clock := istanbul.NewSimulatedClock()
+	config := *istanbul.DefaultConfig
+	config.ProposalTimeout = 1000
+	var proposer *core
+	for _, backend := range sys.backends {
+		c := backend.engine.(*core)
+		c.config = &config
+		c.clock = clock
+		if c.isProposer() {
+			proposer = c
+		}
+	}
+
+	close := sys.Run(true)
+	defer close()
+
+	// The other validators are silent, the proposal never gets prepared
+	for _, backend := range sys.backends {
+		if backend.engine != proposer {
+			backend.engine.Stop()
+		}
+	}
+	proposer.backend.(*testSystemBackend).NewRequest(makeBlock(1))
+	deadline := time.After(2 * time.Second)
+	for {
+		if state, _ := proposer.currentState(); state == StatePreprepared {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("the proposal should be preprepared")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	waiting := func() bool {
+		proposer.stateMu.RLock()
+		defer proposer.stateMu.RUnlock()
+		return proposer.waitingForRoundChange
+	}
+
+	timeout := time.Duration(config.ProposalTimeout) * time.Millisecond
+	clock.Run(timeout - time.Millisecond)
+	<-time.After(50 * time.Millisecond)
+	if waiting() {
+		t.Fatalf("the proposal should not be abandoned before the timeout")
+	}
+	clock.Run(time.Millisecond)
+	for !waiting() {
+		select {
+		case <-deadline:
+			t.Fatalf("the proposal should be abandoned after the timeout")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if _, view := proposer.currentState(); view.Round.Int64() != 1 {
+		t.Errorf("round mismatch: have %v, want 1", view.Round)
+	}
+}
+
+func TestEquivocatingProposer(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	var proposer *testSystemBackend
+	for _, backend := range sys.backends {
+		if backend.engine.(*core).isProposer() {
+			proposer = backend
+		}
+	}
+
+	close := sys.Run(true)
+	defer close()
+
+	// The proposer signs two proposals for the same view, and the validators
+	// receive them in different orders
+	first := makeBlock(1)
+	header := first.Header()
+	header.GasLimit = 1
+	second := first.WithSeal(header)
+	preprepare := func(proposal istanbul.Proposal) []byte {
+		m, _ := Encode(&istanbul.Preprepare{
+			View: &istanbul.View{
+				Round:    big.NewInt(0),
+				Sequence: big.NewInt(1),
+			},
+			Proposal: proposal,
+		})
+		payload, _ := (&message{
+			Code:      msgPreprepare,
+			Msg:       m,
+			Address:   proposer.Address(),
+			Signature: proposer.Address().Bytes(),
+			Version:   msgVersion,
+		}).Payload()
+		return payload
+	}
+	var replicas []*testSystemBackend
+	for _, backend := range sys.backends {
+		if backend != proposer {
+			replicas = append(replicas, backend)
+		}
+	}
+	for i, backend := range replicas {
+		payloads := [][]byte{preprepare(first), preprepare(second)}
+		if i%2 == 1 {
+			payloads[0], payloads[1] = payloads[1], payloads[0]
+		}
+		go func(backend *testSystemBackend) {
+			for _, payload := range payloads {
+				backend.EventMux().Post(istanbul.MessageEvent{Payload: payload})
+			}
+		}(backend)
+	}
+
+	// The validators change round instead of splitting, and all commit the
+	// same proposal in the next round
+	deadline := time.After(3 * time.Second)
+	for i, backend := range replicas {
+		c := backend.engine.(*core)
+		for {
+			c.stateMu.RLock()
+			committed := len(backend.committedMsgs)
+			c.stateMu.RUnlock()
+			if committed > 0 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("replica %d: a proposal should be committed after the round change", i)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		c.stateMu.RLock()
+		hash := backend.committedMsgs[0].commitProposal.Hash()
+		c.stateMu.RUnlock()
+		if want := replicas[0].committedMsgs[0].commitProposal.Hash(); hash != want {
+			t.Errorf("replica %d: proposal mismatch: have %v, want %v", i, hash, want)
+		}
+
+		evidence := c.MisbehaviorEvidence()
+		if len(evidence) != 1 {
+			t.Fatalf("replica %d: the number of evidences mismatch: have %v, want 1", i, len(evidence))
+		}
+		if evidence[0].Validator != proposer.Address() || evidence[0].Type != msgNames[msgPreprepare] || evidence[0].Round != 0 {
+			t.Errorf("replica %d: evidence mismatch: have %v %v %v, want %v %v 0", i, evidence[0].Validator, evidence[0].Type, evidence[0].Round, proposer.Address(), msgNames[msgPreprepare])
+		}
+	}
+}
+
+func TestHandlePreprepareInvalidSequence(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	preprepare := func(view *istanbul.View, proposal istanbul.Proposal) []byte {
+		m, _ := Encode(&istanbul.Preprepare{View: view, Proposal: proposal})
+		return m
+	}
+	testCases := []struct {
+		proposal    istanbul.Proposal
+		expectedErr error
+	}{
+		{
+			// the proposal skips a sequence
+			makeBlock(2),
+			errInvalidSequence,
+		},
+		{
+			// the proposal repeats the committed sequence
+			makeBlock(0),
+			errInvalidSequence,
+		},
+		{
+			makeBlock(1),
+			nil,
+		},
+	}
+	for i, test := range testCases {
+		sys := NewTestSystemWithBackend(N, F)
+		close := sys.Run(false)
+		v1 := sys.backends[1]
+		c := v1.engine.(*core)
+		proposer := c.valSet.GetProposer()
+
+		err := c.handlePreprepare(&message{
+			Code:    msgPreprepare,
+			Msg:     preprepare(c.currentView(), test.proposal),
+			Address: proposer.Address(),
+		}, proposer)
+		if err != test.expectedErr {
+			t.Errorf("case %d: error mismatch: have %v, want %v", i, err, test.expectedErr)
+		}
+		close()
+		if test.expectedErr == nil {
+			if c.state != StatePreprepared {
+				t.Errorf("case %d: state mismatch: have %v, want %v", i, c.state, StatePreprepared)
+			}
+			continue
+		}
+		// the proposal is refused, and the validator moves to the next round
+		if c.state != StateAcceptRequest || c.current.Proposal() != nil {
+			t.Errorf("case %d: state mismatch: have %v, want %v", i, c.state, StateAcceptRequest)
+		}
+		if len(v1.sentMsgs) != 1 {
+			t.Fatalf("case %d: the number of sent messages mismatch: have %v, want 1", i, len(v1.sentMsgs))
+		}
+		msg := new(message)
+		if err := msg.FromPayload(v1.sentMsgs[0], nil); err != nil {
+			t.Fatalf("case %d: failed to decode the sent message: %v", i, err)
+		}
+		if msg.Code != msgRoundChange {
+			t.Errorf("case %d: message code mismatch: have %v, want %v", i, msg.Code, msgRoundChange)
+		}
+	}
+
+	// The PRE-PREPAREs of the following sequences are still stored until we
+	// catch up
+	sys := NewTestSystemWithBackend(N, F)
+	c := sys.backends[1].engine.(*core)
+	proposer := c.valSet.GetProposer()
+	err := c.handlePreprepare(&message{
+		Code:    msgPreprepare,
+		Msg:     preprepare(&istanbul.View{Round: big.NewInt(0), Sequence: big.NewInt(2)}, makeBlock(2)),
+		Address: proposer.Address(),
+	}, proposer)
+	if err != errFutureMessage {
+		t.Errorf("error mismatch: have %v, want %v", err, errFutureMessage)
+	}
+}
@@ -16,7 +16,10 @@
 
 package core
 
-import "github.com/ethereum/go-ethereum/consensus/istanbul"
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
 
 func (c *core) handleRequest(request *istanbul.Request) error {
 	logger := c.logger.New("state", c.state, "seq", c.current.sequence)
@@ -34,7 +37,7 @@ func (c *core) handleRequest(request *istanbul.Request) error {
 
 	c.current.pendingRequest = request
 	if c.state == StateAcceptRequest {
-		c.sendPreprepare(request)
+		return c.sendPreprepare(request)
 	}
 	return nil
 }
@@ -66,6 +69,58 @@ func (c *core) storeRequestMsg(request *istanbul.Request) {
 	defer c.pendingRequestsMu.Unlock()
 
 	c.pendingRequests.Push(request, float32(-request.Proposal.Number().Int64()))
+	if max := int(c.config.MaxPendingRequests); max > 0 && c.pendingRequests.Size() > max {
+		logger.Debug("Pending requests full, drop the furthest requests", "size", c.pendingRequests.Size(), "max", max)
+		trimBacklog(c.pendingRequests, max)
+	}
+}
+
+// handleAbandonRequest drops the request of the proposal. If we already
+// proposed it in the current round and it's not prepared yet, the round is
+// changed like on a proposal timeout, since proposing another one in the same
+// view would be an equivocation. We wait for the next round in the accept
+// request state and without the proposal, so the next request is proposed
+// afresh.
+func (c *core) handleAbandonRequest(proposal istanbul.Proposal) {
+	if proposal == nil || c.current == nil {
+		return
+	}
+	hash := proposal.Hash()
+	logger := c.logger.New("state", c.state, "number", proposal.Number(), "hash", hash)
+
+	c.dropPendingRequest(hash)
+	if r := c.current.pendingRequest; r != nil && r.Proposal.Hash() == hash {
+		c.current.pendingRequest = nil
+	}
+	if c.waitingForRoundChange || c.state != StatePreprepared || c.current.IsHashLocked() || !c.isProposer() {
+		return
+	}
+	if p := c.current.Preprepare; p == nil || p.Proposal.Hash() != hash {
+		return
+	}
+	logger.Debug("Abandon the proposal of the round, change round")
+	c.stopProposalTimer()
+	c.sendNextRoundChange()
+	c.setState(StateAcceptRequest)
+}
+
+// dropPendingRequest drops the future requests of the proposal with the given
+// hash.
+func (c *core) dropPendingRequest(hash common.Hash) {
+	c.pendingRequestsMu.Lock()
+	defer c.pendingRequestsMu.Unlock()
+
+	var kept []*istanbul.Request
+	var prios []float32
+	for !c.pendingRequests.Empty() {
+		m, prio := c.pendingRequests.Pop()
+		if r, ok := m.(*istanbul.Request); ok && r.Proposal.Hash() != hash {
+			kept, prios = append(kept, r), append(prios, prio)
+		}
+	}
+	for i, r := range kept {
+		c.pendingRequests.Push(r, prios[i])
+	}
 }
 
 func (c *core) processPendingRequests() {
@@ -25,6 +25,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"gopkg.in/karalabe/cookiejar.v2/collections/prque"
@@ -85,6 +86,7 @@ func TestStoreRequestMsg(t *testing.T) {
 		events: new(event.TypeMux),
 	}
 	c := &core{
+		config:  istanbul.DefaultConfig,
 		logger:  log.New("backend", "test", "id", 0),
 		backend: backend,
 		state:   StateAcceptRequest,
@@ -136,3 +138,148 @@ func TestStoreRequestMsg(t *testing.T) {
 		t.Error("unexpected timeout occurs")
 	}
 }
+
+func TestStoreRequestMsgLimit(t *testing.T) {
+	config := *istanbul.DefaultConfig
+	config.MaxPendingRequests = 2
+	c := &core{
+		config: &config,
+		logger: log.New("backend", "test", "id", 0),
+		current: newRoundState(&istanbul.View{
+			Sequence: big.NewInt(0),
+			Round:    big.NewInt(0),
+		}, newTestValidatorSet(4), common.Hash{}, nil, nil, nil),
+		pendingRequests:   prque.New(),
+		pendingRequestsMu: new(sync.Mutex),
+	}
+	for _, number := range []int64{3, 1, 2} {
+		c.storeRequestMsg(&istanbul.Request{Proposal: makeBlock(number)})
+	}
+	if c.pendingRequests.Size() != 2 {
+		t.Fatalf("the size of pending requests mismatch: have %v, want 2", c.pendingRequests.Size())
+	}
+	// The furthest request is dropped
+	for _, want := range []int64{1, 2} {
+		m, _ := c.pendingRequests.Pop()
+		if have := m.(*istanbul.Request).Proposal.Number().Int64(); have != want {
+			t.Errorf("the number of request mismatch: have %v, want %v", have, want)
+		}
+	}
+}
+
+func TestBackToBackRequests(t *testing.T) {
+	sys := NewTestSystemWithBackend(1, 0)
+	close := sys.Run(true)
+	defer close()
+
+	// The second request arrives while the first one is being committed
+	backend := sys.backends[0]
+	backend.NewRequest(makeBlock(1))
+	backend.NewRequest(makeBlock(2))
+
+	c := backend.engine.(*core)
+	deadline := time.After(2 * time.Second)
+	for {
+		c.stateMu.RLock()
+		committed := len(backend.committedMsgs)
+		c.stateMu.RUnlock()
+		if committed == 2 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("the number of committed requests mismatch: have %v, want 2", committed)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	for i, msg := range backend.committedMsgs {
+		if have, want := msg.commitProposal.Number().Int64(), int64(i+1); have != want {
+			t.Errorf("the number of committed request mismatch: have %v, want %v", have, want)
+		}
+	}
+}
+
+func TestAbandonRequest(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	close := sys.Run(true)
+	defer close()
+
+	// The other validators don't vote, so the proposal can't be prepared
+	proposer := sys.backends[0]
+	for _, backend := range sys.backends[1:] {
+		backend.engine.SetPaused(true)
+	}
+	abandoned := makeBlock(1)
+	proposer.NewRequest(abandoned)
+
+	c := proposer.engine.(*core)
+	// waitState waits until the proposer is in the given state of the round
+	waitState := func(state State, round int64) {
+		deadline := time.After(2 * time.Second)
+		for {
+			have, view := c.currentState()
+			if have == state && view.Round.Int64() == round {
+				return
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("state mismatch: have %v in round %v, want %v in round %v", have, view.Round, state, round)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	waitState(StatePreprepared, 0)
+
+	// The proposer can't propose another block in the round, so it changes
+	// round without the abandoned one
+	proposer.events.Post(istanbul.AbandonRequestEvent{Proposal: abandoned})
+	waitState(StateAcceptRequest, 1)
+	c.stateMu.RLock()
+	if c.current.Preprepare != nil || c.current.pendingRequest != nil || c.current.Prepares.Size() != 0 {
+		t.Errorf("round state mismatch: have {Preprepare: %v, pendingRequest: %v, Prepares: %v}, want empty", c.current.Preprepare, c.current.pendingRequest, c.current.Prepares.Size())
+	}
+	c.stateMu.RUnlock()
+
+	// The next block is sealed by all the validators and committed in the
+	// next round
+	next := types.NewBlockWithHeader(&types.Header{
+		Difficulty: big.NewInt(0),
+		Number:     big.NewInt(1),
+		Time:       big.NewInt(1),
+	})
+	for _, backend := range sys.backends[1:] {
+		backend.engine.SetPaused(false)
+	}
+	for _, backend := range sys.backends {
+		backend.NewRequest(next)
+	}
+	for _, backend := range sys.backends[1:] {
+		backend.engine.(*core).sendEvent(timeoutEvent{})
+	}
+	deadline := time.After(3 * time.Second)
+	for i, backend := range sys.backends {
+		c := backend.engine.(*core)
+		for {
+			c.stateMu.RLock()
+			committed := len(backend.committedMsgs)
+			c.stateMu.RUnlock()
+			if committed > 0 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("backend %d: the next block should be committed", i)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		c.stateMu.RLock()
+		hash := backend.committedMsgs[0].commitProposal.Hash()
+		c.stateMu.RUnlock()
+		if hash != next.Hash() {
+			t.Errorf("backend %d: proposal mismatch: have %v, want %v", i, hash, next.Hash())
+		}
+		if evidence := backend.engine.MisbehaviorEvidence(); len(evidence) != 0 {
+			t.Errorf("backend %d: the number of evidences mismatch: have %v, want 0", i, len(evidence))
+		}
+	}
+}
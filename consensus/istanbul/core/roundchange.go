@@ -25,19 +25,28 @@ import (
 )
 
 // sendNextRoundChange sends the ROUND CHANGE message with current round + 1
-func (c *core) sendNextRoundChange() {
+func (c *core) sendNextRoundChange() error {
 	cv := c.currentView()
-	c.sendRoundChange(new(big.Int).Add(cv.Round, common.Big1))
+	return c.sendRoundChange(new(big.Int).Add(cv.Round, common.Big1))
 }
 
-// sendRoundChange sends the ROUND CHANGE message with the given round
-func (c *core) sendRoundChange(round *big.Int) {
+// sendRoundChange sends the ROUND CHANGE message with the given round. The
+// round is caught up even if the message fails to be sent, so the round change
+// timer retries it.
+func (c *core) sendRoundChange(round *big.Int) error {
 	logger := c.logger.New("state", c.state)
 
+	if c.exceedsMaxRounds(round) {
+		c.halt()
+	}
+	if c.halted {
+		return errHalted
+	}
+
 	cv := c.currentView()
 	if cv.Round.Cmp(round) >= 0 {
 		logger.Error("Cannot send out the round change", "current round", cv.Round, "target round", round)
-		return
+		return errInvalidMessage
 	}
 
 	c.catchUpRound(&istanbul.View{
@@ -48,57 +57,83 @@ func (c *core) sendRoundChange(round *big.Int) {
 
 	// Now we have the new round number and sequence number
 	cv = c.currentView()
-	rc := &istanbul.Subject{
+	rc := &istanbul.RoundChange{
 		View:   cv,
 		Digest: common.Hash{},
 	}
-
-	payload, err := Encode(rc)
-	if err != nil {
-		logger.Error("Failed to encode ROUND CHANGE", "rc", rc, "err", err)
-		return
+	// Carry the prepared certificate of the locked proposal, so the proposer
+	// of the new round proposes it again
+	if prepared := c.current.Prepared(); c.current.IsHashLocked() && prepared != nil {
+		for _, m := range prepared {
+			payload, err := m.Payload()
+			if err != nil {
+				logger.Error("Failed to encode prepared certificate", "err", err)
+				return err
+			}
+			rc.Prepares = append(rc.Prepares, payload)
+		}
+		rc.Digest = c.current.GetLockedHash()
+		rc.Proposal = c.current.Proposal()
 	}
-
-	c.broadcast(&message{
-		Code: msgRoundChange,
-		Msg:  payload,
-	})
+	return c.broadcastMsg(msgRoundChange, rc)
 }
 
 func (c *core) handleRoundChange(msg *message, src istanbul.Validator) error {
 	logger := c.logger.New("state", c.state, "from", src.Address().Hex())
 
 	// Decode ROUND CHANGE message
-	var rc *istanbul.Subject
+	var rc *istanbul.RoundChange
 	if err := msg.Decode(&rc); err != nil {
 		logger.Error("Failed to decode ROUND CHANGE", "err", err)
 		return errInvalidMessage
 	}
 
+	if c.halted {
+		return errHalted
+	}
+
 	if err := c.checkMessage(msgRoundChange, rc.View); err != nil {
 		return err
 	}
 
+	var preparedRound *big.Int
+	if rc.Proposal != nil {
+		var err error
+		if preparedRound, err = c.verifyPreparedCertificate(rc); err != nil {
+			logger.Warn("Invalid prepared certificate", "from", src, "msg", msg, "err", err)
+			return errInvalidPreparedCertificate
+		}
+	}
+
 	cv := c.currentView()
 	roundView := rc.View
 
-	// Add the ROUND CHANGE message to its message set and return how many
-	// messages we've got with the same round number and sequence number.
+	// Add the ROUND CHANGE message to its message set and return the voting
+	// weight we've got with the same round number and sequence number.
 	num, err := c.roundChangeSet.Add(roundView.Round, msg)
 	if err != nil {
 		logger.Warn("Failed to add round change message", "from", src, "msg", msg, "err", err)
 		return err
 	}
+	if preparedRound != nil {
+		c.roundChangeSet.AddPrepared(roundView.Round, preparedRound, rc.Proposal)
+	}
+
+	// The certificates are formed by the message which makes the weight reach
+	// the threshold, as a single message can carry more than one vote.
+	reached := func(threshold int) bool {
+		return num >= threshold && num-int(src.Weight()) < threshold
+	}
 
 	// Once we received f+1 ROUND CHANGE messages, those messages form a weak certificate.
 	// If our round number is smaller than the certificate's round number, we would
 	// try to catch up the round number.
-	if c.waitingForRoundChange && num == c.valSet.F()+1 {
+	if c.waitingForRoundChange && reached(c.config.F(c.valSet)+1) {
 		if cv.Round.Cmp(roundView.Round) < 0 {
 			c.sendRoundChange(roundView.Round)
 		}
 		return nil
-	} else if num == 2*c.valSet.F()+1 && (c.waitingForRoundChange || cv.Round.Cmp(roundView.Round) < 0) {
+	} else if reached(2*c.config.F(c.valSet)+1) && (c.waitingForRoundChange || cv.Round.Cmp(roundView.Round) < 0) {
 		// We've received 2f+1 ROUND CHANGE messages, start a new round immediately.
 		c.startNewRound(roundView.Round)
 		return nil
@@ -109,12 +144,70 @@ func (c *core) handleRoundChange(msg *message, src istanbul.Validator) error {
 	return nil
 }
 
+// verifyPreparedCertificate checks that the PREPARE and COMMIT messages of the
+// ROUND CHANGE prove its proposal prepared in an earlier round of the sequence,
+// and returns that round.
+func (c *core) verifyPreparedCertificate(rc *istanbul.RoundChange) (*big.Int, error) {
+	if rc.Proposal.Hash() != rc.Digest || rc.Proposal.Number().Cmp(rc.View.Sequence) != 0 {
+		return nil, errInconsistentSubject
+	}
+	// Each validator votes once, so a larger certificate is rejected before
+	// recovering any of its signatures
+	if len(rc.Prepares) > c.valSet.Size() {
+		return nil, errInvalidPreparedCertificate
+	}
+	var (
+		round   *big.Int
+		senders = make(map[common.Address]bool)
+		weight  int
+	)
+	for _, payload := range rc.Prepares {
+		m := new(message)
+		if err := m.FromPayload(payload, nil); err != nil {
+			return nil, err
+		}
+		if m.Code != msgPrepare && m.Code != msgCommit {
+			return nil, errInvalidMessage
+		}
+		_, v := c.valSet.GetByAddress(m.Address)
+		if v == nil {
+			return nil, istanbul.ErrUnauthorizedAddress
+		}
+		if senders[m.Address] {
+			return nil, errInvalidMessage
+		}
+		var sub *istanbul.Subject
+		if err := m.Decode(&sub); err != nil {
+			return nil, err
+		}
+		if sub.Digest != rc.Digest || sub.View.Sequence.Cmp(rc.View.Sequence) != 0 || sub.View.Round.Cmp(rc.View.Round) >= 0 {
+			return nil, errInconsistentSubject
+		}
+		// All the messages prepare the proposal in the same round
+		if round == nil {
+			round = sub.View.Round
+		} else if round.Cmp(sub.View.Round) != 0 {
+			return nil, errInconsistentSubject
+		}
+		if err := m.checkSignature(c.validateFn); err != nil {
+			return nil, err
+		}
+		senders[m.Address] = true
+		weight += int(v.Weight())
+	}
+	if weight <= 2*c.config.F(c.valSet) {
+		return nil, errInvalidPreparedCertificate
+	}
+	return round, nil
+}
+
 // ----------------------------------------------------------------------------
 
 func newRoundChangeSet(valSet istanbul.ValidatorSet) *roundChangeSet {
 	return &roundChangeSet{
 		validatorSet: valSet,
 		roundChanges: make(map[uint64]*messageSet),
+		prepared:     make(map[uint64]*preparedProposal),
 		mu:           new(sync.Mutex),
 	}
 }
@@ -122,10 +215,19 @@ func newRoundChangeSet(valSet istanbul.ValidatorSet) *roundChangeSet {
 type roundChangeSet struct {
 	validatorSet istanbul.ValidatorSet
 	roundChanges map[uint64]*messageSet
+	prepared     map[uint64]*preparedProposal
 	mu           *sync.Mutex
 }
 
-// Add adds the round and message into round change set
+// preparedProposal is a proposal proven prepared by a ROUND CHANGE message, and
+// the round it was prepared in.
+type preparedProposal struct {
+	round    *big.Int
+	proposal istanbul.Proposal
+}
+
+// Add adds the round and message into round change set, and returns the voting
+// weight of the round
 func (rcs *roundChangeSet) Add(r *big.Int, msg *message) (int, error) {
 	rcs.mu.Lock()
 	defer rcs.mu.Unlock()
@@ -138,7 +240,7 @@ func (rcs *roundChangeSet) Add(r *big.Int, msg *message) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return rcs.roundChanges[round].Size(), nil
+	return rcs.roundChanges[round].Weight(), nil
 }
 
 // Clear deletes the messages with smaller round
@@ -151,16 +253,45 @@ func (rcs *roundChangeSet) Clear(round *big.Int) {
 			delete(rcs.roundChanges, k)
 		}
 	}
+	for k := range rcs.prepared {
+		if rcs.roundChanges[k] == nil {
+			delete(rcs.prepared, k)
+		}
+	}
+}
+
+// AddPrepared records the proposal prepared in the given round, carried by a
+// ROUND CHANGE message to the target round. The proposal prepared in the highest
+// round is kept.
+func (rcs *roundChangeSet) AddPrepared(target *big.Int, round *big.Int, proposal istanbul.Proposal) {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+
+	if p := rcs.prepared[target.Uint64()]; p == nil || p.round.Cmp(round) < 0 {
+		rcs.prepared[target.Uint64()] = &preparedProposal{round: round, proposal: proposal}
+	}
+}
+
+// Prepared returns the proposal prepared in the highest round carried by the
+// ROUND CHANGE messages to the given round, nil if none.
+func (rcs *roundChangeSet) Prepared(target *big.Int) istanbul.Proposal {
+	rcs.mu.Lock()
+	defer rcs.mu.Unlock()
+
+	if p := rcs.prepared[target.Uint64()]; p != nil {
+		return p.proposal
+	}
+	return nil
 }
 
-// MaxRound returns the max round which the number of messages is equal or larger than num
-func (rcs *roundChangeSet) MaxRound(num int) *big.Int {
+// MaxRound returns the max round which the voting weight is equal or larger than weight
+func (rcs *roundChangeSet) MaxRound(weight int) *big.Int {
 	rcs.mu.Lock()
 	defer rcs.mu.Unlock()
 
 	var maxRound *big.Int
 	for k, rms := range rcs.roundChanges {
-		if rms.Size() < num {
+		if rms.Weight() < weight {
 			continue
 		}
 		r := big.NewInt(int64(k))
@@ -19,6 +19,7 @@ package core
 import (
 	"math/big"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
@@ -90,3 +91,432 @@ func TestRoundChangeSet(t *testing.T) {
 		t.Errorf("the change messages mismatch: have %v, want nil", rc.roundChanges[view.Round.Uint64()])
 	}
 }
+
+func TestRoundChangeTimeout(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	clock := istanbul.NewSimulatedClock()
+	for _, backend := range sys.backends {
+		backend.engine.(*core).clock = clock
+	}
+
+	stop := sys.Run(true)
+	defer stop()
+	// The test system starts in the initial round without a timer
+	for _, backend := range sys.backends {
+		c := backend.engine.(*core)
+		c.stateMu.Lock()
+		c.newRoundChangeTimer()
+		c.stateMu.Unlock()
+	}
+
+	// Nothing is proposed, the round times out as soon as the time is advanced
+	clock.Run(time.Duration(istanbul.DefaultConfig.RequestTimeout) * time.Millisecond)
+	deadline := time.After(2 * time.Second)
+	for i, backend := range sys.backends {
+		for {
+			_, view := backend.engine.(*core).currentState()
+			if view.Round.Int64() == 1 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("backend %d: round mismatch: have %v, want 1", i, view.Round)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
+
+func TestIsProposer(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	clock := istanbul.NewSimulatedClock()
+	for _, backend := range sys.backends {
+		backend.engine.(*core).clock = clock
+	}
+	proposer := func() *testSystemBackend {
+		var proposers []*testSystemBackend
+		for _, backend := range sys.backends {
+			if backend.engine.IsProposer() {
+				proposers = append(proposers, backend)
+			}
+		}
+		if len(proposers) != 1 {
+			t.Fatalf("the number of proposers mismatch: have %v, want 1", len(proposers))
+		}
+		return proposers[0]
+	}
+
+	stop := sys.Run(true)
+	defer stop()
+
+	first := proposer()
+	for _, backend := range sys.backends {
+		c := backend.engine.(*core)
+		c.stateMu.Lock()
+		c.newRoundChangeTimer()
+		c.stateMu.Unlock()
+	}
+	clock.Run(time.Duration(istanbul.DefaultConfig.RequestTimeout) * time.Millisecond)
+	// The round change is over once the new round accepts requests, with its
+	// proposer calculated
+	deadline := time.After(2 * time.Second)
+	for _, backend := range sys.backends {
+		c := backend.engine.(*core)
+		for {
+			c.stateMu.RLock()
+			waiting, state, round := c.waitingForRoundChange, c.state, c.current.Round().Int64()
+			c.stateMu.RUnlock()
+			if !waiting && state == StateAcceptRequest && round == 1 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("the round should be changed: have waiting %v, state %v, round %v", waiting, state, round)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	// The proposer of the new round takes over
+	if second := proposer(); second == first {
+		t.Errorf("the proposer should change with the round")
+	}
+}
+
+func TestStaleTimeout(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	stale := c.currentView()
+	stop := sys.Run(false)
+	defer stop()
+
+	// The core catches up with the round of the others before its timer of
+	// the previous round is handled
+	c.catchUpRound(&istanbul.View{Sequence: stale.Sequence, Round: big.NewInt(1)})
+	defer c.stopTimer()
+
+	c.handleTimeoutMsg(stale)
+	if round := c.current.Round().Int64(); round != 1 {
+		t.Errorf("round mismatch: have %v, want 1", round)
+	}
+	// while the timeout of the new round changes it
+	c.handleTimeoutMsg(c.currentView())
+	if round := c.current.Round().Int64(); round != 2 {
+		t.Errorf("round mismatch: have %v, want 2", round)
+	}
+}
+
+func TestMaxRounds(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+	maxRounds := int64(2)
+
+	sys := NewTestSystemWithBackend(N, F)
+	config := *istanbul.DefaultConfig
+	config.MaxRounds = uint64(maxRounds)
+	for _, backend := range sys.backends {
+		backend.engine.(*core).config = &config
+	}
+
+	stop := sys.Run(true)
+	defer stop()
+
+	// waitRound waits until all the backends are in the given round
+	waitRound := func(round int64) {
+		deadline := time.After(2 * time.Second)
+		for i, backend := range sys.backends {
+			for {
+				_, view := backend.engine.(*core).currentState()
+				if view.Round.Int64() == round {
+					break
+				}
+				select {
+				case <-deadline:
+					t.Fatalf("backend %d: round mismatch: have %v, want %v", i, view.Round, round)
+				case <-time.After(10 * time.Millisecond):
+				}
+			}
+		}
+	}
+
+	// Nothing is proposed, so every round times out
+	for round := int64(1); round <= maxRounds+1; round++ {
+		for _, backend := range sys.backends {
+			backend.engine.(*core).sendEvent(timeoutEvent{})
+		}
+		if round <= maxRounds {
+			waitRound(round)
+		}
+	}
+	<-time.After(100 * time.Millisecond)
+
+	for i, backend := range sys.backends {
+		c := backend.engine.(*core)
+		if !c.Halted() {
+			t.Errorf("backend %d: the consensus should be halted", i)
+		}
+		if _, view := c.currentState(); view.Round.Int64() != maxRounds {
+			t.Errorf("backend %d: round mismatch: have %v, want %v", i, view.Round, maxRounds)
+		}
+	}
+
+	// A committed proposal resumes the consensus
+	for _, backend := range sys.backends {
+		backend.committedMsgs = append(backend.committedMsgs, testCommittedMsgs{
+			commitProposal: makeBlock(1),
+		})
+		backend.engine.(*core).sendEvent(istanbul.FinalCommittedEvent{})
+	}
+	<-time.After(100 * time.Millisecond)
+	for i, backend := range sys.backends {
+		c := backend.engine.(*core)
+		if c.Halted() {
+			t.Errorf("backend %d: the consensus should be resumed", i)
+		}
+		if _, view := c.currentState(); view.Sequence.Int64() != 2 || view.Round.Sign() != 0 {
+			t.Errorf("view mismatch: have %v, want {Round: 0, Sequence: 2}", view)
+		}
+	}
+}
+
+func TestPreparedCertificateAcrossRoundChange(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	for _, backend := range sys.backends {
+		config := *istanbul.DefaultConfig
+		backend.engine.(*core).config = &config
+	}
+
+	stop := sys.Run(true)
+	defer stop()
+
+	payload := func(code uint64, from *testSystemBackend, content interface{}) []byte {
+		m, _ := Encode(content)
+		payload, _ := (&message{
+			Code:      code,
+			Msg:       m,
+			Address:   from.Address(),
+			Signature: from.Address().Bytes(),
+			Version:   msgVersion,
+		}).Payload()
+		return payload
+	}
+
+	// Only the validator receives the proposal of the first round and 2F
+	// PREPAREs, so it's the only one to prepare it before the round changes.
+	validator := sys.backends[2]
+	proposal := makeBlock(1)
+	view := &istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}
+	msgs := [][]byte{payload(msgPreprepare, sys.backends[0], &istanbul.Preprepare{View: view, Proposal: proposal})}
+	for _, from := range []*testSystemBackend{sys.backends[0], sys.backends[3]} {
+		msgs = append(msgs, payload(msgPrepare, from, &istanbul.Subject{View: view, Digest: proposal.Hash()}))
+	}
+	for _, msg := range msgs {
+		validator.EventMux().Post(istanbul.MessageEvent{Payload: msg})
+	}
+
+	c := validator.engine.(*core)
+	deadline := time.After(3 * time.Second)
+	for {
+		c.stateMu.RLock()
+		prepared := c.current.IsHashLocked() && c.current.Prepared() != nil
+		c.stateMu.RUnlock()
+		if prepared {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("the validator should prepare the proposal")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	// The ROUND CHANGE of the validator reaches the others before they time
+	// out, so its certificate is part of any quorum of the new round.
+	c.sendEvent(timeoutEvent{})
+	for i, backend := range sys.backends {
+		if backend == validator {
+			continue
+		}
+		c := backend.engine.(*core)
+		for {
+			c.stateMu.RLock()
+			prepared := c.roundChangeSet.Prepared(big.NewInt(1))
+			c.stateMu.RUnlock()
+			if prepared != nil {
+				if prepared.Hash() != proposal.Hash() {
+					t.Fatalf("backend %d: prepared proposal mismatch: have %v, want %v", i, prepared.Hash(), proposal.Hash())
+				}
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("backend %d: the prepared certificate should be received", i)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		c.sendEvent(timeoutEvent{})
+	}
+
+	// The proposer of the new round proposes the prepared proposal again
+	for i, backend := range sys.backends {
+		c := backend.engine.(*core)
+		for {
+			c.stateMu.RLock()
+			committed := len(backend.committedMsgs)
+			c.stateMu.RUnlock()
+			if committed > 0 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatalf("backend %d: the proposal should be committed after the round change", i)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		c.stateMu.RLock()
+		hash := backend.committedMsgs[0].commitProposal.Hash()
+		c.stateMu.RUnlock()
+		if hash != proposal.Hash() {
+			t.Errorf("backend %d: proposal mismatch: have %v, want %v", i, hash, proposal.Hash())
+		}
+	}
+}
+
+func TestHandleRoundChangePreparedCertificate(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	c := sys.backends[0].engine.(*core)
+
+	proposal := makeBlock(1)
+	view := &istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}
+	prepare := func(from *testSystemBackend, view *istanbul.View, digest common.Hash) []byte {
+		m, _ := Encode(&istanbul.Subject{View: view, Digest: digest})
+		payload, _ := (&message{
+			Code:      msgPrepare,
+			Msg:       m,
+			Address:   from.Address(),
+			Signature: from.Address().Bytes(),
+			Version:   msgVersion,
+		}).Payload()
+		return payload
+	}
+	valid := [][]byte{
+		prepare(sys.backends[1], view, proposal.Hash()),
+		prepare(sys.backends[2], view, proposal.Hash()),
+		prepare(sys.backends[3], view, proposal.Hash()),
+	}
+
+	testCases := []struct {
+		prepares [][]byte
+		proposal istanbul.Proposal
+		err      error
+	}{
+		{
+			// a quorum of PREPAREs, the ROUND CHANGE of a future round is
+			// kept but not gossiped
+			valid,
+			proposal,
+			errIgnored,
+		},
+		{
+			// the PREPAREs of 2F validators only
+			valid[:2],
+			proposal,
+			errInvalidPreparedCertificate,
+		},
+		{
+			// the same validator twice
+			[][]byte{valid[0], valid[1], valid[1]},
+			proposal,
+			errInvalidPreparedCertificate,
+		},
+		{
+			// more PREPAREs than validators
+			append(valid, valid[0], valid[1]),
+			proposal,
+			errInvalidPreparedCertificate,
+		},
+		{
+			// the PREPAREs of another proposal
+			valid,
+			makeBlock(2),
+			errInvalidPreparedCertificate,
+		},
+		{
+			// a PREPARE of the round the ROUND CHANGE is for
+			[][]byte{valid[0], valid[1], prepare(sys.backends[3], &istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)}, proposal.Hash())},
+			proposal,
+			errInvalidPreparedCertificate,
+		},
+	}
+	for i, test := range testCases {
+		c.roundChangeSet = newRoundChangeSet(c.valSet)
+		rc := &istanbul.RoundChange{
+			View:     &istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)},
+			Digest:   test.proposal.Hash(),
+			Proposal: test.proposal,
+			Prepares: test.prepares,
+		}
+		m, _ := Encode(rc)
+		msg := &message{
+			Code:    msgRoundChange,
+			Msg:     m,
+			Address: sys.backends[1].Address(),
+		}
+		_, src := c.valSet.GetByAddress(msg.Address)
+		if err := c.handleRoundChange(msg, src); err != test.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.err)
+		}
+		prepared := c.roundChangeSet.Prepared(big.NewInt(1))
+		if test.err == errIgnored && (prepared == nil || prepared.Hash() != proposal.Hash()) {
+			t.Errorf("test %d: prepared proposal mismatch: have %v, want %v", i, prepared, proposal.Hash())
+		} else if test.err != errIgnored && prepared != nil {
+			t.Errorf("test %d: prepared proposal mismatch: have %v, want nil", i, prepared.Hash())
+		}
+	}
+
+	// The signatures are only recovered for the distinct validators of a
+	// certificate no larger than the validator set
+	validateFn, recovered := c.validateFn, 0
+	c.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		recovered++
+		return validateFn(data, sig)
+	}
+	for _, test := range []struct {
+		prepares  [][]byte
+		recovered int
+	}{
+		{append(valid, valid[0], valid[1]), 0},
+		{[][]byte{valid[0], valid[0], valid[1]}, 1},
+	} {
+		recovered = 0
+		rc := &istanbul.RoundChange{
+			View:     &istanbul.View{Round: big.NewInt(1), Sequence: big.NewInt(1)},
+			Digest:   proposal.Hash(),
+			Proposal: proposal,
+			Prepares: test.prepares,
+		}
+		if _, err := c.verifyPreparedCertificate(rc); err == nil {
+			t.Errorf("error mismatch: have nil, want an error")
+		}
+		if recovered != test.recovered {
+			t.Errorf("recovered signatures mismatch: have %v, want %v", recovered, test.recovered)
+		}
+	}
+}
@@ -30,10 +30,15 @@ import (
 // lockedHash and preprepare are for round change when lock exists,
 // we need to keep a reference of preprepare in order to propose locked proposal when there is a lock and itself is the proposer
 func newRoundState(view *istanbul.View, validatorSet istanbul.ValidatorSet, lockedHash common.Hash, preprepare *istanbul.Preprepare, pendingRequest *istanbul.Request, hasBadProposal func(hash common.Hash) bool) *roundState {
+	var digest common.Hash
+	if preprepare != nil {
+		digest = preprepare.Proposal.Hash()
+	}
 	return &roundState{
 		round:          view.Round,
 		sequence:       view.Sequence,
 		Preprepare:     preprepare,
+		digest:         digest,
 		Prepares:       newMessageSet(validatorSet),
 		Commits:        newMessageSet(validatorSet),
 		lockedHash:     lockedHash,
@@ -52,6 +57,12 @@ type roundState struct {
 	Commits        *messageSet
 	lockedHash     common.Hash
 	pendingRequest *istanbul.Request
+	// prepared holds the PREPARE and COMMIT messages the locked proposal was
+	// prepared with, its prepared certificate, nil if none
+	prepared []*message
+	// digest is the hash of the proposal when the PRE-PREPARE was accepted,
+	// it's the digest of the subject the PREPAREs and COMMITs must match
+	digest common.Hash
 
 	mu             *sync.RWMutex
 	hasBadProposal func(hash common.Hash) bool
@@ -72,6 +83,25 @@ func (s *roundState) GetPrepareOrCommitSize() int {
 	return result
 }
 
+// GetPrepareOrCommitWeight returns the summed voting weight of the validators
+// which sent a PREPARE or a COMMIT message.
+func (s *roundState) GetPrepareOrCommitWeight() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := s.Prepares.Weight() + s.Commits.Weight()
+
+	// find duplicate one
+	for _, m := range s.Prepares.Values() {
+		if s.Commits.Get(m.Address) != nil {
+			if _, v := s.Commits.valSet.GetByAddress(m.Address); v != nil {
+				result -= int(v.Weight())
+			}
+		}
+	}
+	return result
+}
+
 func (s *roundState) Subject() *istanbul.Subject {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -85,7 +115,7 @@ func (s *roundState) Subject() *istanbul.Subject {
 			Round:    new(big.Int).Set(s.round),
 			Sequence: new(big.Int).Set(s.sequence),
 		},
-		Digest: s.Preprepare.Proposal.Hash(),
+		Digest: s.digest,
 	}
 }
 
@@ -94,6 +124,7 @@ func (s *roundState) SetPreprepare(preprepare *istanbul.Preprepare) {
 	defer s.mu.Unlock()
 
 	s.Preprepare = preprepare
+	s.digest = preprepare.Proposal.Hash()
 }
 
 func (s *roundState) Proposal() istanbul.Proposal {
@@ -149,6 +180,39 @@ func (s *roundState) UnlockHash() {
 	defer s.mu.Unlock()
 
 	s.lockedHash = common.Hash{}
+	s.prepared = nil
+}
+
+// SetPrepared sets the prepared certificate of the locked proposal.
+func (s *roundState) SetPrepared(prepared []*message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prepared = prepared
+}
+
+// Prepared returns the prepared certificate of the locked proposal, nil if
+// none.
+func (s *roundState) Prepared() []*message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.prepared
+}
+
+// prepareOrCommitMessages returns the PREPARE or COMMIT message of each
+// validator which sent one, the PREPARE if it sent both.
+func (s *roundState) prepareOrCommitMessages() []*message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	msgs := s.Prepares.Values()
+	for _, m := range s.Commits.Values() {
+		if s.Prepares.Get(m.Address) == nil {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs
 }
 
 func (s *roundState) IsHashLocked() bool {
@@ -188,6 +252,9 @@ func (s *roundState) DecodeRLP(stream *rlp.Stream) error {
 	s.round = ss.Round
 	s.sequence = ss.Sequence
 	s.Preprepare = ss.Preprepare
+	if s.Preprepare != nil {
+		s.digest = s.Preprepare.Proposal.Hash()
+	}
 	s.Prepares = ss.Prepares
 	s.Commits = ss.Commits
 	s.lockedHash = ss.lockedHash
@@ -26,10 +26,12 @@ import (
 )
 
 func newTestRoundState(view *istanbul.View, validatorSet istanbul.ValidatorSet) *roundState {
+	preprepare := newTestPreprepare(view)
 	return &roundState{
 		round:      view.Round,
 		sequence:   view.Sequence,
-		Preprepare: newTestPreprepare(view),
+		Preprepare: preprepare,
+		digest:     preprepare.Proposal.Hash(),
 		Prepares:   newMessageSet(validatorSet),
 		Commits:    newMessageSet(validatorSet),
 		mu:         new(sync.RWMutex),
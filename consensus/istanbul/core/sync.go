@@ -0,0 +1,251 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+const (
+	// maxSyncProposals is the maximum number of committed proposals returned
+	// in a single SYNC RESPONSE message.
+	maxSyncProposals = 64
+	// maxSyncQueue is the maximum number of synced proposals queued until
+	// their sequence starts, the proposals beyond are dropped.
+	maxSyncQueue = 4 * maxSyncProposals
+)
+
+// checkSequenceGap records the sequence of a future message from src. A gap of
+// one sequence is expected while the others are finalizing the previous
+// proposal. If f+1 validators are further ahead, at least one honest validator
+// has committed proposals we are missing, so we ask for them.
+func (c *core) checkSequenceGap(view *istanbul.View, src istanbul.Validator) {
+	if src.Address() == c.Address() {
+		return
+	}
+	next := new(big.Int).Add(c.current.Sequence(), common.Big1)
+	if view.Sequence.Cmp(next) <= 0 {
+		return
+	}
+	if seq, ok := c.futureSequences[src.Address()]; !ok || seq.Cmp(view.Sequence) < 0 {
+		c.futureSequences[src.Address()] = new(big.Int).Set(view.Sequence)
+	}
+	if c.syncRequested || c.futureSequencesWeight() <= c.config.F(c.valSet) {
+		return
+	}
+
+	// Proposals below the highest observed sequence have been committed
+	var target *big.Int
+	for _, seq := range c.futureSequences {
+		if target == nil || target.Cmp(seq) < 0 {
+			target = seq
+		}
+	}
+	c.sendSyncRequest(new(big.Int).Sub(target, common.Big1))
+}
+
+// futureSequencesWeight returns the voting weight of the validators which are
+// ahead of us.
+func (c *core) futureSequencesWeight() int {
+	var weight uint64
+	for addr := range c.futureSequences {
+		if _, v := c.valSet.GetByAddress(addr); v != nil {
+			weight += v.Weight()
+		}
+	}
+	return int(weight)
+}
+
+// clearSyncState drops the sync bookkeeping which is outdated once we move to
+// the given sequence.
+func (c *core) clearSyncState(sequence *big.Int) {
+	c.syncRequested = false
+	for addr, seq := range c.futureSequences {
+		if seq.Cmp(sequence) <= 0 {
+			delete(c.futureSequences, addr)
+		}
+	}
+}
+
+// sendSyncRequest asks the other validators for the committed proposals from
+// our current sequence up to the given sequence.
+func (c *core) sendSyncRequest(to *big.Int) {
+	logger := c.logger.New("state", c.state)
+
+	req := &istanbul.SyncRequest{
+		From: new(big.Int).Set(c.current.Sequence()),
+		To:   new(big.Int).Set(to),
+	}
+
+	logger.Debug("Request committed proposals", "from", req.From, "to", req.To)
+	// Ask again on the next future message if the request isn't sent out
+	c.syncRequested = c.broadcastMsg(msgSyncRequest, req) == nil
+}
+
+func (c *core) handleSyncRequest(msg *message, src istanbul.Validator) error {
+	logger := c.logger.New("from", src, "state", c.state)
+
+	// Decode SYNC REQUEST message
+	var req *istanbul.SyncRequest
+	if err := msg.Decode(&req); err != nil {
+		return errFailedDecodeSyncRequest
+	}
+	if req.From == nil || req.To == nil || req.From.Sign() < 0 || req.From.Cmp(req.To) > 0 {
+		return errInvalidMessage
+	}
+	// Our own request is delivered to us as well
+	if src.Address() == c.Address() {
+		return nil
+	}
+
+	to := new(big.Int).Add(req.From, big.NewInt(maxSyncProposals-1))
+	if to.Cmp(req.To) > 0 {
+		to = req.To
+	}
+	var proposals []*istanbul.CommittedProposal
+	for number := new(big.Int).Set(req.From); number.Cmp(to) <= 0; number.Add(number, common.Big1) {
+		proposal, seals := c.backend.GetProposal(number.Uint64())
+		if proposal == nil {
+			break
+		}
+		proposals = append(proposals, &istanbul.CommittedProposal{
+			Proposal:       proposal,
+			CommittedSeals: seals,
+		})
+	}
+	if len(proposals) == 0 {
+		return nil
+	}
+
+	payload, err := Encode(proposals)
+	if err != nil {
+		logger.Error("Failed to encode SYNC RESPONSE", "err", err)
+		return errEncodeMessage
+	}
+	logger.Trace("Reply committed proposals", "from", req.From, "count", len(proposals))
+	return c.unicast(&message{
+		Code: msgSyncResponse,
+		Msg:  payload,
+	}, src.Address())
+}
+
+func (c *core) handleSyncResponse(msg *message, src istanbul.Validator) error {
+	// Decode SYNC RESPONSE message
+	var proposals []*istanbul.CommittedProposal
+	if err := msg.Decode(&proposals); err != nil {
+		return errFailedDecodeSyncResponse
+	}
+
+	// Only the proposals a response could carry from the current sequence on
+	// are kept, the ones further ahead can't be verified yet
+	window := new(big.Int).Add(c.current.Sequence(), big.NewInt(maxSyncProposals))
+	for _, p := range proposals {
+		if p.Proposal == nil || p.Proposal.Number() == nil {
+			return errInvalidMessage
+		}
+		if p.Proposal.Number().Cmp(c.current.Sequence()) >= 0 && p.Proposal.Number().Cmp(window) < 0 {
+			c.storeSyncProposal(p)
+		}
+	}
+	c.processSyncProposals()
+	return nil
+}
+
+func (c *core) storeSyncProposal(p *istanbul.CommittedProposal) {
+	c.syncProposalsMu.Lock()
+	defer c.syncProposalsMu.Unlock()
+
+	if c.syncProposals.Size() >= maxSyncQueue {
+		c.logger.Debug("Drop synced proposal, queue full", "number", p.Proposal.Number())
+		return
+	}
+	c.syncProposals.Push(p, float32(-p.Proposal.Number().Int64()))
+}
+
+// processSyncProposals commits the synced proposal of the current sequence,
+// if any. The following proposals are processed once the new round starts, so
+// nothing is committed until the last commit is final.
+func (c *core) processSyncProposals() {
+	if c.state == StateCommitted {
+		return
+	}
+	lastProposal, _ := c.backend.LastProposal()
+
+	c.syncProposalsMu.Lock()
+	var proposal *istanbul.CommittedProposal
+	for !c.syncProposals.Empty() {
+		m, prio := c.syncProposals.Pop()
+		p := m.(*istanbul.CommittedProposal)
+		// Another response may have carried the proposals committed already
+		if lastProposal != nil && p.Proposal.Number().Cmp(lastProposal.Number()) <= 0 {
+			continue
+		}
+		if cmp := p.Proposal.Number().Cmp(c.current.Sequence()); cmp < 0 {
+			continue
+		} else if cmp > 0 {
+			c.syncProposals.Push(m, prio)
+			break
+		}
+		if err := c.verifySyncProposal(p); err != nil {
+			c.logger.Warn("Skip invalid synced proposal", "number", p.Proposal.Number(), "hash", p.Proposal.Hash(), "err", err)
+			continue
+		}
+		proposal = p
+		break
+	}
+	c.syncProposalsMu.Unlock()
+
+	if proposal == nil {
+		return
+	}
+	c.logger.Debug("Commit synced proposal", "number", proposal.Proposal.Number(), "hash", proposal.Proposal.Hash())
+	if err := c.backend.Commit(proposal.Proposal, proposal.CommittedSeals); err != nil {
+		c.logger.Warn("Failed to commit synced proposal", "number", proposal.Proposal.Number(), "err", err)
+		return
+	}
+	c.setState(StateCommitted)
+}
+
+// verifySyncProposal verifies the synced proposal against the chain and
+// checks that it was committed by distinct validators weighing more than 2F,
+// like the headers are, whatever the quorum this node commits with.
+func (c *core) verifySyncProposal(p *istanbul.CommittedProposal) error {
+	if _, err := c.backend.Verify(p.Proposal); err != nil {
+		return err
+	}
+
+	seal := PrepareCommittedSeal(p.Proposal.Hash(), c.config.SigSchemeAt(p.Proposal.Number()))
+	signers := make(map[common.Address]bool)
+	weight := 0
+	for _, committedSeal := range p.CommittedSeals {
+		addr, err := c.validateFn(seal, committedSeal)
+		if err != nil {
+			return err
+		}
+		if _, v := c.valSet.GetByAddress(addr); v != nil && !signers[addr] {
+			weight += int(v.Weight())
+		}
+		signers[addr] = true
+	}
+	if weight < c.config.ChainQuorum(c.valSet) {
+		return errInvalidCommittedSeals
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+)
+
+func TestSyncProposals(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+	behind := 3
+
+	sys := NewTestSystemWithBackend(N, F)
+
+	// The proposer of the next sequence must be up to date to propose it
+	c := sys.backends[0].engine.(*core)
+	vals := c.proposerValidators(sys.backends[0].peers, big.NewInt(int64(behind+1)))
+	vals.CalcProposer(common.Address{}, 0)
+	var proposer, lagging *testSystemBackend
+	for _, backend := range sys.backends {
+		if backend.Address() == vals.GetProposer().Address() {
+			proposer = backend
+		} else {
+			lagging = backend
+		}
+	}
+
+	// All validators have committed the first proposals except the lagging one
+	var seals [][]byte
+	for _, val := range vals.List() {
+		seals = append(seals, val.Address().Bytes())
+	}
+	for _, backend := range sys.backends {
+		if backend == lagging {
+			continue
+		}
+		for i := 1; i <= behind; i++ {
+			backend.committedMsgs = append(backend.committedMsgs, testCommittedMsgs{
+				commitProposal: makeBlock(int64(i)),
+				committedSeals: seals,
+			})
+		}
+	}
+	// The committed seals of the test backend are validator addresses
+	lagging.engine.(*core).validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		return common.BytesToAddress(sig), nil
+	}
+
+	close := sys.Run(true)
+	defer close()
+
+	proposer.NewRequest(makeBlock(int64(behind + 1)))
+
+	for i, backend := range sys.backends {
+		if committed := backend.waitCommitted(behind+1, 5*time.Second); len(committed) != behind+1 {
+			t.Fatalf("backend %d: the number of committed proposals mismatch: have %v, want %v", i, len(committed), behind+1)
+		}
+	}
+	for i, msg := range lagging.committed() {
+		if msg.commitProposal.Number().Cmp(big.NewInt(int64(i+1))) != 0 {
+			t.Errorf("proposal number mismatch: have %v, want %v", msg.commitProposal.Number(), i+1)
+		}
+	}
+}
+
+func TestHandleSyncResponse(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	backend := sys.backends[0]
+	c := backend.engine.(*core)
+	c.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		return common.BytesToAddress(sig), nil
+	}
+	val := backend.peers.GetByIndex(1)
+
+	testCases := []struct {
+		seals     [][]byte
+		committed int
+	}{
+		{
+			// not enough distinct committed seals
+			[][]byte{val.Address().Bytes(), val.Address().Bytes(), val.Address().Bytes()},
+			0,
+		},
+		{
+			// 2F+1 committed seals
+			[][]byte{
+				backend.peers.GetByIndex(0).Address().Bytes(),
+				backend.peers.GetByIndex(1).Address().Bytes(),
+				backend.peers.GetByIndex(2).Address().Bytes(),
+			},
+			1,
+		},
+	}
+	for _, test := range testCases {
+		m, _ := Encode([]*istanbul.CommittedProposal{{
+			Proposal:       makeBlock(1),
+			CommittedSeals: test.seals,
+		}})
+		err := c.handleSyncResponse(&message{
+			Code:    msgSyncResponse,
+			Msg:     m,
+			Address: val.Address(),
+		}, val)
+		if err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+		if len(backend.committedMsgs) != test.committed {
+			t.Errorf("the number of committed proposals mismatch: have %v, want %v", len(backend.committedMsgs), test.committed)
+		}
+	}
+}
+
+func TestHandleDuplicateSyncResponse(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	backend := sys.backends[0]
+	c := backend.engine.(*core)
+	c.validateFn = func(data []byte, sig []byte) (common.Address, error) {
+		return common.BytesToAddress(sig), nil
+	}
+	var seals [][]byte
+	for _, val := range backend.peers.List() {
+		seals = append(seals, val.Address().Bytes())
+	}
+	m, _ := Encode([]*istanbul.CommittedProposal{
+		{Proposal: makeBlock(1), CommittedSeals: seals},
+		{Proposal: makeBlock(2), CommittedSeals: seals},
+	})
+
+	// Two validators answer the same SYNC REQUEST before the first proposal
+	// is final, it's committed once
+	for _, val := range backend.peers.List()[1:3] {
+		err := c.handleSyncResponse(&message{
+			Code:    msgSyncResponse,
+			Msg:     m,
+			Address: val.Address(),
+		}, val)
+		if err != nil {
+			t.Errorf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if committed := len(backend.committed()); committed != 1 {
+		t.Fatalf("the number of committed proposals mismatch: have %v, want 1", committed)
+	}
+
+	// The next ones are committed once each as the previous become final
+	c.handleFinalCommitted()
+	c.handleFinalCommitted()
+	committed := backend.committed()
+	if len(committed) != 2 {
+		t.Fatalf("the number of committed proposals mismatch: have %v, want 2", len(committed))
+	}
+	for i, msg := range committed {
+		if msg.commitProposal.Number().Cmp(big.NewInt(int64(i+1))) != 0 {
+			t.Errorf("proposal number mismatch: have %v, want %v", msg.commitProposal.Number(), i+1)
+		}
+	}
+}
+
+func TestHandleInvalidSyncRequest(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	val := sys.backends[0].peers.GetByIndex(1)
+
+	testCases := []*istanbul.SyncRequest{
+		// missing bound
+		{From: big.NewInt(1)},
+		// inverted range
+		{From: big.NewInt(5), To: big.NewInt(2)},
+	}
+	for _, test := range testCases {
+		m, _ := Encode(test)
+		err := c.handleSyncRequest(&message{
+			Code:    msgSyncRequest,
+			Msg:     m,
+			Address: val.Address(),
+		}, val)
+		if err != errInvalidMessage {
+			t.Errorf("error mismatch: have %v, want %v", err, errInvalidMessage)
+		}
+	}
+}
+
+func TestSyncProposalsWindow(t *testing.T) {
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	val := sys.backends[0].peers.GetByIndex(1)
+
+	seq := c.current.Sequence().Int64()
+	var proposals []*istanbul.CommittedProposal
+	for _, number := range []int64{seq + 1, seq + maxSyncProposals - 1, seq + maxSyncProposals, seq + 10*maxSyncProposals} {
+		proposals = append(proposals, &istanbul.CommittedProposal{Proposal: makeBlock(number)})
+	}
+	m, _ := Encode(proposals)
+	err := c.handleSyncResponse(&message{
+		Code:    msgSyncResponse,
+		Msg:     m,
+		Address: val.Address(),
+	}, val)
+	if err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	// Only the proposals within the window of the current sequence are queued
+	if size := c.syncProposals.Size(); size != 2 {
+		t.Errorf("the number of queued proposals mismatch: have %v, want %v", size, 2)
+	}
+	for i := 0; i < 2*maxSyncQueue; i++ {
+		c.storeSyncProposal(&istanbul.CommittedProposal{Proposal: makeBlock(seq + int64(i) + 1)})
+	}
+	if size := c.syncProposals.Size(); size != maxSyncQueue {
+		t.Errorf("the number of queued proposals mismatch: have %v, want %v", size, maxSyncQueue)
+	}
+}
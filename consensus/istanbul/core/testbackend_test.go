@@ -19,6 +19,7 @@ package core
 import (
 	"crypto/ecdsa"
 	"math/big"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -41,7 +42,11 @@ type testSystemBackend struct {
 	events *event.TypeMux
 
 	committedMsgs []testCommittedMsgs
-	sentMsgs      [][]byte // store the message when Send is called by core
+	committedMu   sync.RWMutex            // protects committedMsgs while the core runs
+	sentMsgs      [][]byte                // store the message when Send is called by core
+	verifyErr     error                   // the error returned when verifying proposals
+	jailed        map[common.Address]bool // the validators jailed at every height
+	commitDelay   time.Duration           // the time committing a proposal takes
 
 	address common.Address
 	db      ethdb.Database
@@ -69,13 +74,18 @@ func (self *testSystemBackend) EventMux() *event.TypeMux {
 	return self.events
 }
 
-func (self *testSystemBackend) Send(message []byte, target common.Address) error {
-	testLogger.Info("enqueuing a message...", "address", self.Address())
-	self.sentMsgs = append(self.sentMsgs, message)
-	self.sys.queuedMessage <- istanbul.MessageEvent{
-		Payload: message,
+func (self *testSystemBackend) Unicast(target common.Address, message []byte) error {
+	testLogger.Info("sending a message...", "address", self.Address(), "to", target)
+	for _, backend := range self.sys.backends {
+		if backend.Address() == target {
+			self.sentMsgs = append(self.sentMsgs, message)
+			go backend.EventMux().Post(istanbul.MessageEvent{
+				Payload: message,
+			})
+			return nil
+		}
 	}
-	return nil
+	return istanbul.ErrUnauthorizedAddress
 }
 
 func (self *testSystemBackend) Broadcast(valSet istanbul.ValidatorSet, message []byte) error {
@@ -94,10 +104,13 @@ func (self *testSystemBackend) Gossip(valSet istanbul.ValidatorSet, message []by
 
 func (self *testSystemBackend) Commit(proposal istanbul.Proposal, seals [][]byte) error {
 	testLogger.Info("commit message", "address", self.Address())
+	time.Sleep(self.commitDelay)
+	self.committedMu.Lock()
 	self.committedMsgs = append(self.committedMsgs, testCommittedMsgs{
 		commitProposal: proposal,
 		committedSeals: seals,
 	})
+	self.committedMu.Unlock()
 
 	// fake new head events
 	go self.events.Post(istanbul.FinalCommittedEvent{})
@@ -105,22 +118,31 @@ func (self *testSystemBackend) Commit(proposal istanbul.Proposal, seals [][]byte
 }
 
 func (self *testSystemBackend) Verify(proposal istanbul.Proposal) (time.Duration, error) {
-	return 0, nil
+	return 0, self.verifyErr
 }
 
+// Sign returns the address of the backend as the signature of any data, so the
+// sender of a message can be checked against it.
 func (self *testSystemBackend) Sign(data []byte) ([]byte, error) {
-	testLogger.Warn("not sign any data")
-	return data, nil
+	return self.address.Bytes(), nil
+}
+
+func (self *testSystemBackend) SignAt(view *istanbul.View, data []byte) ([]byte, error) {
+	return self.Sign(data)
 }
 
 func (self *testSystemBackend) CheckSignature([]byte, common.Address, []byte) error {
 	return nil
 }
 
-func (self *testSystemBackend) CheckValidatorSignature(data []byte, sig []byte) (common.Address, error) {
+func (self *testSystemBackend) RecoverSigner(data []byte, sig []byte) (common.Address, error) {
 	return common.Address{}, nil
 }
 
+func (self *testSystemBackend) CheckValidatorSignature(data []byte, sig []byte) (common.Address, error) {
+	return common.BytesToAddress(sig), nil
+}
+
 func (self *testSystemBackend) Hash(b interface{}) common.Hash {
 	return common.StringToHash("Test")
 }
@@ -131,11 +153,18 @@ func (self *testSystemBackend) NewRequest(request istanbul.Proposal) {
 	})
 }
 
+func (self *testSystemBackend) Jailed(addr common.Address, number uint64) bool {
+	return self.jailed[addr]
+}
+
 func (self *testSystemBackend) HasBadProposal(hash common.Hash) bool {
 	return false
 }
 
 func (self *testSystemBackend) LastProposal() (istanbul.Proposal, common.Address) {
+	self.committedMu.RLock()
+	defer self.committedMu.RUnlock()
+
 	l := len(self.committedMsgs)
 	if l > 0 {
 		return self.committedMsgs[l-1].commitProposal, common.Address{}
@@ -152,6 +181,40 @@ func (self *testSystemBackend) GetProposer(number uint64) common.Address {
 	return common.Address{}
 }
 
+func (self *testSystemBackend) GetProposal(number uint64) (istanbul.Proposal, [][]byte) {
+	self.committedMu.RLock()
+	defer self.committedMu.RUnlock()
+
+	for _, msg := range self.committedMsgs {
+		if msg.commitProposal.Number().Uint64() == number {
+			return msg.commitProposal, msg.committedSeals
+		}
+	}
+	return nil, nil
+}
+
+// committed returns a copy of the committed proposals, safe to call while the
+// core runs.
+func (self *testSystemBackend) committed() []testCommittedMsgs {
+	self.committedMu.RLock()
+	defer self.committedMu.RUnlock()
+
+	return append([]testCommittedMsgs(nil), self.committedMsgs...)
+}
+
+// waitCommitted waits until the backend has committed n proposals, and
+// returns them.
+func (self *testSystemBackend) waitCommitted(n int, timeout time.Duration) []testCommittedMsgs {
+	deadline := time.Now().Add(timeout)
+	for {
+		committed := self.committed()
+		if len(committed) >= n || time.Now().After(deadline) {
+			return committed
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 func (self *testSystemBackend) ParentValidators(proposal istanbul.Proposal) istanbul.ValidatorSet {
 	return self.peers
 }
@@ -212,8 +275,11 @@ func NewTestSystemWithBackend(n, f uint64) *testSystem {
 		}, vset, common.Hash{}, nil, nil, func(hash common.Hash) bool {
 			return false
 		})
+		core.head = makeBlock(0).Hash()
 		core.valSet = vset
-		core.logger = testLogger
+		core.roundChangeSet = newRoundChangeSet(vset)
+		core.rootLogger = testLogger
+		core.updateReplica()
 		core.validateFn = backend.CheckValidatorSignature
 
 		backend.engine = core
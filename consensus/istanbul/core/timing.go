@@ -0,0 +1,69 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// handlerName returns the name the handler of the event is timed under. It's
+// empty for the messages, which handleCheckedMsg times by their code.
+func handlerName(data interface{}) string {
+	switch data.(type) {
+	case istanbul.RequestEvent:
+		return "request"
+	case istanbul.AbandonRequestEvent:
+		return "abandon_request"
+	case timeoutEvent:
+		return "timeout"
+	case heartbeatEvent:
+		return "heartbeat"
+	case proposalTimeoutEvent:
+		return "proposal_timeout"
+	case istanbul.FinalCommittedEvent:
+		return "final_committed"
+	case istanbul.ConnectionEvent:
+		return "connection"
+	}
+	return ""
+}
+
+// metricName turns a message or state name into a metric name component
+func metricName(name string) string {
+	return strings.Replace(strings.ToLower(name), " ", "_", -1)
+}
+
+// timeHandler records the time the named handler took since start, and the
+// state transition it made from the given state if any, e.g. to catch lock
+// contention or a slow backend. The handlers slower than SlowThreshold
+// are logged as well.
+func (c *core) timeHandler(name string, from State, start time.Time) {
+	elapsed := time.Since(start)
+	metrics.GetOrRegisterTimer("consensus/istanbul/core/handler/"+name, nil).Update(elapsed)
+
+	to := c.state
+	if to != from {
+		metrics.GetOrRegisterTimer("consensus/istanbul/core/transition/"+metricName(from.String())+"/"+metricName(to.String()), nil).Update(elapsed)
+	}
+	if threshold := time.Duration(c.config.SlowThreshold) * time.Millisecond; threshold > 0 && elapsed > threshold {
+		c.logger.Warn("Slow consensus handler", "handler", name, "from", from, "to", to, "elapsed", elapsed)
+	}
+}
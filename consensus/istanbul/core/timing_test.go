@@ -0,0 +1,84 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestSlowCommit(t *testing.T) {
+	N := uint64(4)
+	F := uint64(1)
+
+	sys := NewTestSystemWithBackend(N, F)
+	config := *istanbul.DefaultConfig
+	config.SlowThreshold = 10
+	sys.backends[0].commitDelay = 2 * time.Duration(config.SlowThreshold) * time.Millisecond
+
+	r0 := sys.backends[0].engine.(*core)
+	r0.config = &config
+	r0.current = newTestRoundState(
+		&istanbul.View{
+			Round:    big.NewInt(0),
+			Sequence: big.NewInt(1),
+		},
+		r0.valSet,
+	)
+	r0.state = StatePrepared
+
+	var slow []*log.Record
+	r0.logger = log.New()
+	r0.logger.SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Msg == "Slow consensus handler" {
+			slow = append(slow, r)
+		}
+		return nil
+	}))
+
+	// Only the COMMIT reaching the quorum commits the proposal, slowly
+	m, _ := Encode(r0.current.Subject())
+	for i := 0; i < int(2*F+1); i++ {
+		validator := r0.valSet.GetByIndex(uint64(i))
+		if err := r0.handleCheckedMsg(&message{
+			Code:          msgCommit,
+			Msg:           m,
+			Address:       validator.Address(),
+			Signature:     []byte{},
+			CommittedSeal: validator.Address().Bytes(),
+		}, validator); err != nil {
+			t.Fatalf("error mismatch: have %v, want nil", err)
+		}
+	}
+	if r0.state != StateCommitted {
+		t.Fatalf("state mismatch: have %v, want %v", r0.state, StateCommitted)
+	}
+	if len(slow) != 1 {
+		t.Fatalf("the number of slow handler warnings mismatch: have %v, want 1", len(slow))
+	}
+	fields := make(map[string]interface{})
+	for i := 0; i+1 < len(slow[0].Ctx); i += 2 {
+		fields[slow[0].Ctx[i].(string)] = slow[0].Ctx[i+1]
+	}
+	if fields["handler"] != "commit" || fields["from"] != StatePrepared || fields["to"] != StateCommitted {
+		t.Errorf("warning mismatch: have %v, want the commit from %v to %v", slow[0].Ctx, StatePrepared, StateCommitted)
+	}
+}
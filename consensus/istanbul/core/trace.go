@@ -0,0 +1,124 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// traceBufferSize is the number of processed messages kept while tracing.
+const traceBufferSize = 1024
+
+// traceOutput is where the consensus logs go while tracing, the standard error
+// like the default output of the node.
+var traceOutput = log.StreamHandler(os.Stderr, log.TerminalFormat(false))
+
+// TracedMessage is a consensus message processed while tracing.
+type TracedMessage struct {
+	Time     time.Time      `json:"time"`
+	Code     string         `json:"code"`
+	From     common.Address `json:"from"`
+	Round    *big.Int       `json:"round"`           // Round of the message, nil if it has no view
+	Sequence *big.Int       `json:"sequence"`        // Sequence of the message, nil if it has no view
+	State    string         `json:"state"`           // State of the core when the message arrived
+	Err      string         `json:"error,omitempty"` // Error the message was rejected with
+}
+
+// traceBuffer is a ring buffer of the last processed messages.
+type traceBuffer struct {
+	msgs []*TracedMessage
+	next int // index of the oldest message once the buffer is full
+}
+
+func newTraceBuffer(size int) *traceBuffer {
+	return &traceBuffer{msgs: make([]*TracedMessage, 0, size)}
+}
+
+// add adds the message, overwriting the oldest one if the buffer is full.
+func (b *traceBuffer) add(msg *TracedMessage) {
+	if len(b.msgs) < cap(b.msgs) {
+		b.msgs = append(b.msgs, msg)
+		return
+	}
+	b.msgs[b.next] = msg
+	b.next = (b.next + 1) % len(b.msgs)
+}
+
+// last returns up to the n last messages, oldest first.
+func (b *traceBuffer) last(n int) []*TracedMessage {
+	if n > len(b.msgs) {
+		n = len(b.msgs)
+	}
+	msgs := make([]*TracedMessage, 0, n)
+	for i := len(b.msgs) - n; i < len(b.msgs); i++ {
+		msgs = append(msgs, b.msgs[(b.next+i)%len(b.msgs)])
+	}
+	return msgs
+}
+
+// SetTraceLevel implements core.Engine.SetTraceLevel
+func (c *core) SetTraceLevel(level int) error {
+	if level < 0 || level > int(log.LvlTrace) {
+		return errInvalidTraceLevel
+	}
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.traceLevel = log.Lvl(level)
+	if level > 0 && c.traces == nil {
+		c.traces = newTraceBuffer(traceBufferSize)
+	}
+	c.updateLogger()
+	return nil
+}
+
+// TracedMessages implements core.Engine.TracedMessages
+func (c *core) TracedMessages(n int) []*TracedMessage {
+	c.stateMu.RLock()
+	defer c.stateMu.RUnlock()
+
+	if c.traces == nil || n <= 0 {
+		return []*TracedMessage{}
+	}
+	return c.traces.last(n)
+}
+
+// traceMessage records the processed message while tracing.
+func (c *core) traceMessage(msg *message, src istanbul.Validator, state State, err error) {
+	traced := &TracedMessage{
+		Time:  time.Now(),
+		Code:  msgNames[msg.Code],
+		From:  src.Address(),
+		State: state.String(),
+	}
+	switch msg.Code {
+	case msgPreprepare, msgPrepare, msgCommit, msgRoundChange:
+		if view := messageView(msg); view != nil {
+			traced.Round, traced.Sequence = view.Round, view.Sequence
+		}
+	}
+	if err != nil {
+		traced.Err = err.Error()
+	}
+	c.traces.add(traced)
+}
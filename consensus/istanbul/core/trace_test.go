@@ -0,0 +1,140 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+func TestTracing(t *testing.T) {
+	var records []*log.Record
+	defer func(output log.Handler) { traceOutput = output }(traceOutput)
+	traceOutput = log.FuncHandler(func(r *log.Record) error {
+		records = append(records, r)
+		return nil
+	})
+
+	sys := NewTestSystemWithBackend(4, 1)
+	c := sys.backends[0].engine.(*core)
+	c.current = newTestRoundState(&istanbul.View{
+		Round:    big.NewInt(0),
+		Sequence: big.NewInt(1),
+	}, c.valSet)
+	c.state = StatePreprepared
+
+	prepare := func(i int, round int64) []byte {
+		subject, _ := Encode(&istanbul.Subject{
+			View:   &istanbul.View{Round: big.NewInt(round), Sequence: big.NewInt(1)},
+			Digest: c.current.Subject().Digest,
+		})
+		addr := sys.backends[i].Address()
+		payload, _ := (&message{
+			Code:      msgPrepare,
+			Msg:       subject,
+			Address:   addr,
+			Signature: addr.Bytes(),
+			Version:   msgVersion,
+		}).Payload()
+		return payload
+	}
+
+	// The messages aren't traced by default
+	if _, err := c.handleMsg(prepare(1, 0)); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if msgs := c.TracedMessages(10); len(msgs) != 0 {
+		t.Errorf("traced messages mismatch: have %v, want 0", len(msgs))
+	}
+	if len(records) != 0 {
+		t.Errorf("trace records mismatch: have %v, want 0", len(records))
+	}
+
+	if err := c.SetTraceLevel(int(log.LvlTrace) + 1); err != errInvalidTraceLevel {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidTraceLevel)
+	}
+	if err := c.SetTraceLevel(int(log.LvlTrace)); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+
+	// but the following ones are, the future one with its error
+	c.handleMsg(prepare(2, 0))
+	c.handleMsg(prepare(2, 1))
+	msgs := c.TracedMessages(10)
+	if len(msgs) != 2 {
+		t.Fatalf("traced messages mismatch: have %v, want 2", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Code != "PREPARE" || msg.From != sys.backends[2].Address() {
+			t.Errorf("message %d mismatch: have %v from %v, want PREPARE from %v", i, msg.Code, msg.From.Hex(), sys.backends[2].Address().Hex())
+		}
+		if msg.Sequence.Cmp(big.NewInt(1)) != 0 || msg.Round.Cmp(big.NewInt(int64(i))) != 0 {
+			t.Errorf("message %d view mismatch: have %v/%v, want 1/%v", i, msg.Sequence, msg.Round, i)
+		}
+		if msg.State != StatePreprepared.String() {
+			t.Errorf("message %d state mismatch: have %v, want %v", i, msg.State, StatePreprepared)
+		}
+	}
+	if msgs[0].Err != "" || msgs[1].Err != errFutureMessage.Error() {
+		t.Errorf("errors mismatch: have %q and %q, want none and %q", msgs[0].Err, msgs[1].Err, errFutureMessage)
+	}
+	if msgs := c.TracedMessages(1); len(msgs) != 1 || msgs[0].Err == "" {
+		t.Errorf("last traced message mismatch: have %v", msgs)
+	}
+	if len(records) == 0 {
+		t.Errorf("trace records mismatch: have 0, want some")
+	}
+
+	// Disabling the tracing stops both
+	if err := c.SetTraceLevel(0); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	n := len(records)
+	c.handleMsg(prepare(1, 0))
+	if msgs := c.TracedMessages(10); len(msgs) != 2 {
+		t.Errorf("traced messages mismatch: have %v, want 2", len(msgs))
+	}
+	if len(records) != n {
+		t.Errorf("trace records mismatch: have %v, want %v", len(records), n)
+	}
+}
+
+func TestTraceBuffer(t *testing.T) {
+	b := newTraceBuffer(3)
+	if msgs := b.last(3); len(msgs) != 0 {
+		t.Errorf("messages mismatch: have %v, want 0", len(msgs))
+	}
+	for i := 0; i < 5; i++ {
+		b.add(&TracedMessage{Sequence: big.NewInt(int64(i))})
+	}
+	// the oldest messages are overwritten
+	msgs := b.last(10)
+	if len(msgs) != 3 {
+		t.Fatalf("messages mismatch: have %v, want 3", len(msgs))
+	}
+	for i, msg := range msgs {
+		if msg.Sequence.Int64() != int64(i+2) {
+			t.Errorf("message %d mismatch: have %v, want %v", i, msg.Sequence, i+2)
+		}
+	}
+	if msgs := b.last(1); len(msgs) != 1 || msgs[0].Sequence.Int64() != 4 {
+		t.Errorf("last message mismatch: have %v", msgs)
+	}
+}
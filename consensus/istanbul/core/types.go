@@ -27,6 +27,40 @@ import (
 type Engine interface {
 	Start() error
 	Stop() error
+	// Participation returns the participation of the validators in the
+	// recent sequences.
+	Participation() map[common.Address]*Participation
+	// MisbehaviorEvidence returns the recent evidences of equivocation.
+	MisbehaviorEvidence() []*Evidence
+	// Halted returns whether the consensus halted after too many round changes.
+	Halted() bool
+	// SetPaused pauses or resumes proposing and voting. The messages of the
+	// other validators are still handled, so the chain keeps being followed.
+	SetPaused(paused bool)
+	// Paused returns whether proposing and voting is paused.
+	Paused() bool
+	// IsProposer returns whether the local node is the proposer of the current
+	// round.
+	IsProposer() bool
+	// DumpRound returns the messages of the current round and the validators
+	// they're missing from, to debug a stuck round.
+	DumpRound() (*RoundDump, error)
+	// Health returns whether the consensus committed recently and isn't stuck
+	// changing rounds, for the liveness probes of an orchestrator.
+	Health() (*Health, error)
+	// ExportState returns the versioned RLP encoding of the consensus state
+	// of the current sequence, for operational snapshots and debugging.
+	ExportState() ([]byte, error)
+	// ImportState replaces the consensus state with an exported one.
+	ImportState(data []byte) error
+	// LogAt returns the log of the given committed sequence, if it's retained.
+	LogAt(sequence uint64) (*Log, bool)
+	// SetTraceLevel writes the consensus logs up to the given level regardless
+	// of the node verbosity, and records the processed messages. 0 stops it.
+	SetTraceLevel(level int) error
+	// TracedMessages returns up to the n last messages processed while tracing,
+	// oldest first.
+	TracedMessages(n int) []*TracedMessage
 }
 
 type State uint64
@@ -71,24 +105,53 @@ const (
 	msgPrepare
 	msgCommit
 	msgRoundChange
+	msgSyncRequest
+	msgSyncResponse
+	msgHeartbeat
 	msgAll
 )
 
+var msgNames = map[uint64]string{
+	msgPreprepare:   "PRE-PREPARE",
+	msgPrepare:      "PREPARE",
+	msgCommit:       "COMMIT",
+	msgRoundChange:  "ROUND CHANGE",
+	msgSyncRequest:  "SYNC REQUEST",
+	msgSyncResponse: "SYNC RESPONSE",
+	msgHeartbeat:    "HEARTBEAT",
+}
+
+const (
+	// msgVersionLegacy is the version of the messages encoded without a
+	// version field.
+	msgVersionLegacy uint64 = 1
+	// msgVersion is the version of the messages we send, and the highest
+	// version we are able to decode.
+	msgVersion = msgVersionLegacy
+)
+
 type message struct {
 	Code          uint64
 	Msg           []byte
 	Address       common.Address
 	Signature     []byte
 	CommittedSeal []byte
+	Version       uint64
 }
 
 // ==============================================
 //
 // define the functions that needs to be provided for rlp Encoder/Decoder.
 
-// EncodeRLP serializes m into the Ethereum RLP format.
+// EncodeRLP serializes m into the Ethereum RLP format. The version is appended
+// only after the legacy version, so legacy messages can be decoded by nodes
+// which don't know about versions.
 func (m *message) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, []interface{}{m.Code, m.Msg, m.Address, m.Signature, m.CommittedSeal})
+	fields := []interface{}{m.Code, m.Msg, m.Address, m.Signature, m.CommittedSeal}
+	if m.Version > msgVersionLegacy {
+		fields = append(fields, m.Version)
+	}
+	return rlp.Encode(w, fields)
 }
 
 // DecodeRLP implements rlp.Decoder, and load the consensus fields from a RLP stream.
@@ -99,12 +162,20 @@ func (m *message) DecodeRLP(s *rlp.Stream) error {
 		Address       common.Address
 		Signature     []byte
 		CommittedSeal []byte
+		Rest          []rlp.RawValue `rlp:"tail"`
 	}
 
 	if err := s.Decode(&msg); err != nil {
 		return err
 	}
-	m.Code, m.Msg, m.Address, m.Signature, m.CommittedSeal = msg.Code, msg.Msg, msg.Address, msg.Signature, msg.CommittedSeal
+	version := msgVersionLegacy
+	// Fields after the version are added by newer minor versions, skip them
+	if len(msg.Rest) > 0 {
+		if err := rlp.DecodeBytes(msg.Rest[0], &version); err != nil {
+			return err
+		}
+	}
+	m.Code, m.Msg, m.Address, m.Signature, m.CommittedSeal, m.Version = msg.Code, msg.Msg, msg.Address, msg.Signature, msg.CommittedSeal, version
 	return nil
 }
 
@@ -118,18 +189,28 @@ func (m *message) FromPayload(b []byte, validateFn func([]byte, []byte) (common.
 	if err != nil {
 		return err
 	}
+	if m.Version > msgVersion {
+		return errUnsupportedVersion
+	}
 
 	// Validate message (on a message without Signature)
 	if validateFn != nil {
-		var payload []byte
-		payload, err = m.PayloadNoSig()
-		if err != nil {
-			return err
-		}
+		return m.checkSignature(validateFn)
+	}
+	return nil
+}
 
-		_, err = validateFn(payload, m.Signature)
+// checkSignature checks that the message is signed by the validator it claims
+// to come from.
+func (m *message) checkSignature(validateFn func([]byte, []byte) (common.Address, error)) error {
+	payload, err := m.PayloadNoSig()
+	if err != nil {
+		return err
+	}
+	signer, err := validateFn(payload, m.Signature)
+	if err == nil && signer != m.Address {
+		err = errInvalidSigner
 	}
-	// Still return the message even the err is not nil
 	return err
 }
 
@@ -144,6 +225,7 @@ func (m *message) PayloadNoSig() ([]byte, error) {
 		Address:       m.Address,
 		Signature:     []byte{},
 		CommittedSeal: m.CommittedSeal,
+		Version:       m.Version,
 	})
 }
 
@@ -17,12 +17,15 @@
 package core
 
 import (
+	"bytes"
 	"math/big"
+	"math/rand"
 	"reflect"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/consensus/istanbul"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 func testPreprepare(t *testing.T) {
@@ -130,6 +133,7 @@ func testSubjectWithSignature(t *testing.T) {
 		Address:       common.HexToAddress("0x1234567890"),
 		Signature:     expectedSig,
 		CommittedSeal: []byte{},
+		Version:       msgVersion,
 	}
 
 	msgPayload, err := m.Payload()
@@ -141,7 +145,7 @@ func testSubjectWithSignature(t *testing.T) {
 	// 2.1 Test normal validate func
 	decodedMsg := new(message)
 	err = decodedMsg.FromPayload(msgPayload, func(data []byte, sig []byte) (common.Address, error) {
-		return common.Address{}, nil
+		return m.Address, nil
 	})
 	if err != nil {
 		t.Errorf("error mismatch: have %v, want nil", err)
@@ -170,6 +174,15 @@ func testSubjectWithSignature(t *testing.T) {
 	if err != istanbul.ErrUnauthorizedAddress {
 		t.Errorf("error mismatch: have %v, want %v", err, istanbul.ErrUnauthorizedAddress)
 	}
+
+	// 2.4 Test a signature of another validator
+	decodedMsg = new(message)
+	err = decodedMsg.FromPayload(msgPayload, func(data []byte, sig []byte) (common.Address, error) {
+		return common.HexToAddress("0x0987654321"), nil
+	})
+	if err != errInvalidSigner {
+		t.Errorf("error mismatch: have %v, want %v", err, errInvalidSigner)
+	}
 }
 
 func TestMessageEncodeDecode(t *testing.T) {
@@ -177,3 +190,169 @@ func TestMessageEncodeDecode(t *testing.T) {
 	testSubject(t)
 	testSubjectWithSignature(t)
 }
+
+func TestMessageVersion(t *testing.T) {
+	subjectPayload, _ := Encode(&istanbul.Subject{
+		View: &istanbul.View{
+			Round:    big.NewInt(1),
+			Sequence: big.NewInt(2),
+		},
+		Digest: common.StringToHash("1234567890"),
+	})
+	address := common.HexToAddress("0x1234567890")
+
+	// A legacy message, encoded without the version field
+	legacyPayload, _ := rlp.EncodeToBytes([]interface{}{msgPrepare, subjectPayload, address, []byte{0x01}, []byte{}})
+	decodedMsg := new(message)
+	if err := decodedMsg.FromPayload(legacyPayload, nil); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if decodedMsg.Version != msgVersionLegacy {
+		t.Errorf("version mismatch: have %v, want %v", decodedMsg.Version, msgVersionLegacy)
+	}
+	if decodedMsg.Code != msgPrepare || decodedMsg.Address != address {
+		t.Errorf("message mismatch: have %v, want {Code: %v, Address: %v}", decodedMsg, msgPrepare, address.String())
+	}
+	// The legacy layout is kept, so older nodes can decode our messages
+	payload, _ := decodedMsg.Payload()
+	if !bytes.Equal(payload, legacyPayload) {
+		t.Errorf("payload mismatch: have %x, want %x", payload, legacyPayload)
+	}
+
+	// A message with an explicit version and a field unknown to us
+	payload, _ = rlp.EncodeToBytes([]interface{}{msgPrepare, subjectPayload, address, []byte{0x01}, []byte{}, msgVersion, []byte{0x02}})
+	decodedMsg = new(message)
+	if err := decodedMsg.FromPayload(payload, nil); err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if decodedMsg.Version != msgVersion {
+		t.Errorf("version mismatch: have %v, want %v", decodedMsg.Version, msgVersion)
+	}
+
+	// A message encoded in a version newer than ours
+	m := &message{
+		Code:          msgPrepare,
+		Msg:           subjectPayload,
+		Address:       address,
+		Signature:     []byte{0x01},
+		CommittedSeal: []byte{},
+		Version:       msgVersion + 1,
+	}
+	payload, _ = m.Payload()
+	decodedMsg = new(message)
+	if err := decodedMsg.FromPayload(payload, nil); err != errUnsupportedVersion {
+		t.Errorf("error mismatch: have %v, want %v", err, errUnsupportedVersion)
+	}
+	if decodedMsg.Version != msgVersion+1 {
+		t.Errorf("version mismatch: have %v, want %v", decodedMsg.Version, msgVersion+1)
+	}
+}
+
+// decodeByCode decodes the message into the type its handler expects.
+func decodeByCode(msg *message) error {
+	switch msg.Code {
+	case msgPreprepare:
+		var preprepare *istanbul.Preprepare
+		if err := msg.Decode(&preprepare); err != nil {
+			return err
+		}
+		_ = preprepare.View.String()
+		_ = preprepare.Proposal.Hash()
+	case msgSyncRequest:
+		var req *istanbul.SyncRequest
+		if err := msg.Decode(&req); err != nil {
+			return err
+		}
+		_ = req.String()
+	case msgSyncResponse:
+		var proposals []*istanbul.CommittedProposal
+		if err := msg.Decode(&proposals); err != nil {
+			return err
+		}
+		for _, p := range proposals {
+			_ = p.Proposal.Hash()
+		}
+	case msgHeartbeat:
+		var heartbeat *istanbul.Heartbeat
+		if err := msg.Decode(&heartbeat); err != nil {
+			return err
+		}
+		_ = heartbeat.String()
+	default:
+		var subject *istanbul.Subject
+		if err := msg.Decode(&subject); err != nil {
+			return err
+		}
+		_ = subject.String()
+	}
+	if view := messageView(msg); view != nil {
+		toPriority(msg.Code, view)
+	}
+	return nil
+}
+
+func TestMessageDecodeMalformed(t *testing.T) {
+	view := &istanbul.View{
+		Round:    big.NewInt(1),
+		Sequence: big.NewInt(2),
+	}
+	subject := &istanbul.Subject{View: view, Digest: common.StringToHash("1234567890")}
+	msgs := map[uint64]interface{}{
+		msgPreprepare:   &istanbul.Preprepare{View: view, Proposal: makeBlock(2)},
+		msgPrepare:      subject,
+		msgCommit:       subject,
+		msgRoundChange:  subject,
+		msgSyncRequest:  &istanbul.SyncRequest{From: big.NewInt(1), To: big.NewInt(2)},
+		msgSyncResponse: []*istanbul.CommittedProposal{{Proposal: makeBlock(1), CommittedSeals: [][]byte{{0x01}}}},
+		msgHeartbeat:    &istanbul.Heartbeat{View: view, Time: 1},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	for code, v := range msgs {
+		payload, _ := Encode(v)
+		m := &message{
+			Code:          code,
+			Msg:           payload,
+			Address:       common.HexToAddress("0x1234567890"),
+			Signature:     []byte{0x01},
+			CommittedSeal: []byte{0x02},
+			Version:       msgVersion,
+		}
+		msgPayload, _ := m.Payload()
+
+		// The untouched message must round trip
+		decodedMsg := new(message)
+		if err := decodedMsg.FromPayload(msgPayload, nil); err != nil {
+			t.Errorf("code %d: error mismatch: have %v, want nil", code, err)
+			continue
+		}
+		if err := decodeByCode(decodedMsg); err != nil {
+			t.Errorf("code %d: error mismatch: have %v, want nil", code, err)
+		}
+		// Truncated messages must be rejected
+		for i := 0; i < len(msgPayload); i++ {
+			if err := new(message).FromPayload(msgPayload[:i], nil); err == nil {
+				t.Errorf("code %d: error mismatch: have nil, want an error on %d of %d bytes", code, i, len(msgPayload))
+			}
+		}
+		// Corrupted messages may be rejected, but must never panic
+		for i := 0; i < 1000; i++ {
+			corrupted := common.CopyBytes(msgPayload)
+			for j := r.Intn(4); j >= 0; j-- {
+				corrupted[r.Intn(len(corrupted))] = byte(r.Intn(256))
+			}
+			msg := new(message)
+			if err := msg.FromPayload(corrupted, nil); err == nil {
+				decodeByCode(msg)
+			}
+		}
+		// So must corrupted inner messages in an intact envelope
+		for i := 0; i < 1000; i++ {
+			inner := common.CopyBytes(payload)
+			for j := r.Intn(4); j >= 0; j-- {
+				inner[r.Intn(len(inner))] = byte(r.Intn(256))
+			}
+			decodeByCode(&message{Code: code, Msg: inner})
+		}
+	}
+}
@@ -26,4 +26,40 @@ var (
 	ErrStoppedEngine = errors.New("stopped engine")
 	// ErrStartedEngine is returned if the engine is already started
 	ErrStartedEngine = errors.New("started engine")
+	// ErrUnsafeFaultTolerance is returned if the configured fault tolerance
+	// exceeds the number of faulty validators the validator set tolerates.
+	ErrUnsafeFaultTolerance = errors.New("unsafe fault tolerance")
+	// ErrUnanimityTooLarge is returned if unanimous commits are configured for
+	// a validator set larger than MaxUnanimousValidators.
+	ErrUnanimityTooLarge = errors.New("validator set too large for unanimity")
+	// ErrInvalidWeights is returned if a validator set is created with another
+	// number of weights than of validators, or with a zero weight.
+	ErrInvalidWeights = errors.New("invalid validator weights")
+	// ErrUnknownDigest is returned if the configured digest algorithm isn't
+	// supported.
+	ErrUnknownDigest = errors.New("unknown digest algorithm")
+	// ErrInvalidRequestTimeout is returned if the rounds time out before the
+	// block period is over, so that no proposal could ever be committed.
+	ErrInvalidRequestTimeout = errors.New("request timeout not longer than the block period")
+	// ErrInvalidRegistryBlock is returned if the validator registry takes over
+	// from another block than an epoch one.
+	ErrInvalidRegistryBlock = errors.New("validator registry block not an epoch block")
+	// ErrMissingLeaseHolder is returned if the engine holds a signing lease
+	// without a name in it.
+	ErrMissingLeaseHolder = errors.New("signing lease without a holder")
+	// ErrInvalidExtraVanity is returned if the vanity is longer than
+	// IstanbulExtraVanity bytes, or the extra-data is shorter.
+	ErrInvalidExtraVanity = errors.New("invalid extra-data vanity")
+	// ErrInvalidExtraSeal is returned if the seal or a committed seal of the
+	// extra-data is not IstanbulExtraSeal bytes long.
+	ErrInvalidExtraSeal = errors.New("invalid extra-data seal")
+	// ErrEmptyValidatorSet is returned if the engine is started without any
+	// validator, e.g. after all of them were removed.
+	ErrEmptyValidatorSet = errors.New("empty validator set")
+)
+
+var (
+	// errInvalidRoundChange is returned if a ROUND CHANGE carries a partial
+	// prepared certificate.
+	errInvalidRoundChange = errors.New("invalid round change")
 )
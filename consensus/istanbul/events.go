@@ -16,16 +16,86 @@
 
 package istanbul
 
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
 // RequestEvent is posted to propose a proposal
 type RequestEvent struct {
 	Proposal Proposal
 }
 
+// AbandonRequestEvent is posted when the sealing of a proposal is stopped, to
+// abandon its request
+type AbandonRequestEvent struct {
+	Proposal Proposal
+}
+
 // MessageEvent is posted for Istanbul engine communication
 type MessageEvent struct {
 	Payload []byte
 }
 
+// ConnectionEvent is posted when a peer connects, including when it reconnects
+// after being disconnected
+type ConnectionEvent struct {
+	Address common.Address
+}
+
 // FinalCommittedEvent is posted when a proposal is committed
 type FinalCommittedEvent struct {
 }
+
+// ConsensusEventType is the step of the consensus a ConsensusEvent reports
+type ConsensusEventType int
+
+const (
+	// ConsensusPreprepare is reported when the proposal of the view is accepted
+	ConsensusPreprepare ConsensusEventType = iota
+	// ConsensusPrepared is reported when enough validators prepared the proposal
+	ConsensusPrepared
+	// ConsensusCommitted is reported when the proposal is committed
+	ConsensusCommitted
+	// ConsensusViewChanged is reported when a new round or sequence starts
+	ConsensusViewChanged
+)
+
+func (t ConsensusEventType) String() string {
+	switch t {
+	case ConsensusPreprepare:
+		return "preprepare"
+	case ConsensusPrepared:
+		return "prepared"
+	case ConsensusCommitted:
+		return "committed"
+	case ConsensusViewChanged:
+		return "viewChanged"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (t ConsensusEventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (t *ConsensusEventType) UnmarshalText(input []byte) error {
+	for typ := ConsensusPreprepare; typ <= ConsensusViewChanged; typ++ {
+		if typ.String() == string(input) {
+			*t = typ
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown consensus event type %q", input)
+}
+
+// ConsensusEvent is posted when the consensus makes a step, for monitoring
+type ConsensusEvent struct {
+	Type     ConsensusEventType `json:"type"`
+	Sequence uint64             `json:"sequence"`
+	Round    uint64             `json:"round"`
+	Digest   common.Hash        `json:"digest"` // Hash of the proposal, zero for a view change
+}
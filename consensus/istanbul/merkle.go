@@ -0,0 +1,150 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// The validators root is the root of a binary Merkle tree over the validator
+// addresses sorted in ascending order:
+//
+//   - a leaf is the Keccak-256 hash of the 20 bytes of an address,
+//   - a node is the Keccak-256 hash of the concatenation of its two children,
+//     the smaller one first, so that a proof doesn't need the leaf index,
+//   - the last node of a level without a sibling moves up as is,
+//   - the root of an empty set is the zero hash.
+
+// MembershipProof proves that a validator belongs to the set committed to by a
+// validators root. Path holds the siblings of the nodes from the leaf of the
+// validator up to the root.
+type MembershipProof struct {
+	Validator common.Address `json:"validator"`
+	Path      []common.Hash  `json:"path"`
+}
+
+// ValidatorsRoot returns the Merkle root of the validators.
+func ValidatorsRoot(validators []common.Address) common.Hash {
+	level := merkleLeaves(validators)
+	if len(level) == 0 {
+		return common.Hash{}
+	}
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return level[0]
+}
+
+// NewMembershipProof builds the proof that the validator belongs to the set
+// of validators. It returns ErrUnauthorizedAddress if it doesn't.
+func NewMembershipProof(validators []common.Address, validator common.Address) (*MembershipProof, error) {
+	level := merkleLeaves(validators)
+	leaf := crypto.Keccak256Hash(validator[:])
+	index := -1
+	for i, hash := range level {
+		if hash == leaf {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return nil, ErrUnauthorizedAddress
+	}
+
+	proof := &MembershipProof{Validator: validator}
+	for len(level) > 1 {
+		if sibling := index ^ 1; sibling < len(level) {
+			proof.Path = append(proof.Path, level[sibling])
+		}
+		level = merkleParents(level)
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMembershipProof checks that the validator of the proof belongs to the
+// set committed to by the root.
+func VerifyMembershipProof(root common.Hash, proof *MembershipProof) bool {
+	if proof == nil {
+		return false
+	}
+	hash := crypto.Keccak256Hash(proof.Validator[:])
+	for _, sibling := range proof.Path {
+		hash = merkleNode(hash, sibling)
+	}
+	return hash == root
+}
+
+// WriteValidatorsRoot embeds the Merkle root of the validators into the
+// extra-data of the header, which must already carry the Istanbul fields.
+func WriteValidatorsRoot(header *types.Header, root common.Hash) error {
+	if len(header.Extra) < types.IstanbulExtraVanity {
+		return ErrInvalidExtraVanity
+	}
+	istanbulExtra, err := types.ExtractIstanbulExtra(header)
+	if err != nil {
+		return err
+	}
+	istanbulExtra.ValidatorsRoot = root
+	payload, err := rlp.EncodeToBytes(&istanbulExtra)
+	if err != nil {
+		return err
+	}
+	header.Extra = append(header.Extra[:types.IstanbulExtraVanity], payload...)
+	return nil
+}
+
+// merkleLeaves returns the leaves of the validators, sorted by address.
+func merkleLeaves(validators []common.Address) []common.Hash {
+	sorted := make([]common.Address, len(validators))
+	copy(sorted, validators)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i][:], sorted[j][:]) < 0
+	})
+	leaves := make([]common.Hash, len(sorted))
+	for i, addr := range sorted {
+		leaves[i] = crypto.Keccak256Hash(addr[:])
+	}
+	return leaves
+}
+
+// merkleParents returns the level of the tree above the given one.
+func merkleParents(level []common.Hash) []common.Hash {
+	parents := make([]common.Hash, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			parents = append(parents, level[i])
+		} else {
+			parents = append(parents, merkleNode(level[i], level[i+1]))
+		}
+	}
+	return parents
+}
+
+// merkleNode returns the parent of the two nodes.
+func merkleNode(a, b common.Hash) common.Hash {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	return crypto.Keccak256Hash(a[:], b[:])
+}
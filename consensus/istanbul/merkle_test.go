@@ -0,0 +1,90 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func testAddresses(n int) []common.Address {
+	addrs := make([]common.Address, n)
+	for i := range addrs {
+		addrs[i] = common.BytesToAddress(crypto.Keccak256([]byte{byte(i)}))
+	}
+	return addrs
+}
+
+func TestValidatorsRoot(t *testing.T) {
+	if root := ValidatorsRoot(nil); root != (common.Hash{}) {
+		t.Errorf("empty root mismatch: have %v, want %v", root, common.Hash{})
+	}
+
+	// a, b and c sorted in ascending order: root = node(node(a, b), c)
+	a := common.HexToAddress("0x01")
+	b := common.HexToAddress("0x02")
+	c := common.HexToAddress("0x03")
+	leaf := func(addr common.Address) common.Hash { return crypto.Keccak256Hash(addr[:]) }
+
+	if root, want := ValidatorsRoot([]common.Address{a}), leaf(a); root != want {
+		t.Errorf("single root mismatch: have %v, want %v", root, want)
+	}
+	want := merkleNode(merkleNode(leaf(a), leaf(b)), leaf(c))
+	if root := ValidatorsRoot([]common.Address{a, b, c}); root != want {
+		t.Errorf("root mismatch: have %v, want %v", root, want)
+	}
+	// the order of the validators doesn't matter
+	if root := ValidatorsRoot([]common.Address{c, a, b}); root != want {
+		t.Errorf("unsorted root mismatch: have %v, want %v", root, want)
+	}
+	// but the set does
+	if root := ValidatorsRoot([]common.Address{a, b}); root == want {
+		t.Errorf("root of another set matches")
+	}
+}
+
+func TestMembershipProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		validators := testAddresses(n)
+		root := ValidatorsRoot(validators)
+		for _, validator := range validators {
+			proof, err := NewMembershipProof(validators, validator)
+			if err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+			if !VerifyMembershipProof(root, proof) {
+				t.Errorf("%d validators: proof of %v rejected", n, validator.Hex())
+			}
+			// the proof is only valid for its validator and root
+			forged := &MembershipProof{Validator: common.HexToAddress("0x01"), Path: proof.Path}
+			if VerifyMembershipProof(root, forged) {
+				t.Errorf("%d validators: proof of a non validator accepted", n)
+			}
+			if VerifyMembershipProof(ValidatorsRoot(testAddresses(n+1)), proof) {
+				t.Errorf("%d validators: proof accepted for another set", n)
+			}
+		}
+		if _, err := NewMembershipProof(validators, common.HexToAddress("0x01")); err != ErrUnauthorizedAddress {
+			t.Errorf("error mismatch: have %v, want %v", err, ErrUnauthorizedAddress)
+		}
+	}
+	if VerifyMembershipProof(common.Hash{}, nil) {
+		t.Errorf("nil proof accepted")
+	}
+}
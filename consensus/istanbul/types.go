@@ -129,10 +129,13 @@ func (b *Subject) EncodeRLP(w io.Writer) error {
 }
 
 // DecodeRLP implements rlp.Decoder, and load the consensus fields from a RLP stream.
+// The fields after the digest, e.g. the prepared certificate of a RoundChange,
+// are skipped.
 func (b *Subject) DecodeRLP(s *rlp.Stream) error {
 	var subject struct {
 		View   *View
 		Digest common.Hash
+		Rest   []rlp.RawValue `rlp:"tail"`
 	}
 
 	if err := s.Decode(&subject); err != nil {
@@ -145,3 +148,145 @@ func (b *Subject) DecodeRLP(s *rlp.Stream) error {
 func (b *Subject) String() string {
 	return fmt.Sprintf("{View: %v, Digest: %v}", b.View, b.Digest.String())
 }
+
+// RoundChange is the content of a ROUND CHANGE message. A validator which
+// prepared a proposal in an earlier round of the sequence carries its prepared
+// certificate: the proposal, and the PREPARE and COMMIT messages it was prepared
+// with, a COMMIT implying a PREPARE. The proposer of the new round proposes it
+// again rather than a new one.
+type RoundChange struct {
+	View     *View
+	Digest   common.Hash // hash of the prepared proposal, zero if none
+	Proposal Proposal    // prepared proposal, nil if none
+	Prepares [][]byte    // payloads of the PREPARE and COMMIT messages of the prepared proposal
+}
+
+// EncodeRLP serializes b into the Ethereum RLP format. A ROUND CHANGE without
+// prepared certificate is encoded like a Subject.
+func (b *RoundChange) EncodeRLP(w io.Writer) error {
+	if b.Proposal == nil {
+		return rlp.Encode(w, []interface{}{b.View, b.Digest})
+	}
+	return rlp.Encode(w, []interface{}{b.View, b.Digest, b.Proposal, b.Prepares})
+}
+
+// DecodeRLP implements rlp.Decoder, and load the consensus fields from a RLP stream.
+func (b *RoundChange) DecodeRLP(s *rlp.Stream) error {
+	var rc struct {
+		View   *View
+		Digest common.Hash
+		Rest   []rlp.RawValue `rlp:"tail"`
+	}
+
+	if err := s.Decode(&rc); err != nil {
+		return err
+	}
+	b.View, b.Digest, b.Proposal, b.Prepares = rc.View, rc.Digest, nil, nil
+	if len(rc.Rest) == 0 {
+		return nil
+	}
+	if len(rc.Rest) != 2 {
+		return errInvalidRoundChange
+	}
+	var proposal *types.Block
+	if err := rlp.DecodeBytes(rc.Rest[0], &proposal); err != nil {
+		return err
+	}
+	if err := rlp.DecodeBytes(rc.Rest[1], &b.Prepares); err != nil {
+		return err
+	}
+	b.Proposal = proposal
+	return nil
+}
+
+func (b *RoundChange) String() string {
+	return fmt.Sprintf("{View: %v, Digest: %v, Prepares: %d}", b.View, b.Digest.String(), len(b.Prepares))
+}
+
+// SyncRequest asks for the committed proposals from sequence From up to and
+// including sequence To.
+type SyncRequest struct {
+	From *big.Int
+	To   *big.Int
+}
+
+// EncodeRLP serializes b into the Ethereum RLP format.
+func (b *SyncRequest) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, []interface{}{b.From, b.To})
+}
+
+// DecodeRLP implements rlp.Decoder, and load the consensus fields from a RLP stream.
+func (b *SyncRequest) DecodeRLP(s *rlp.Stream) error {
+	var req struct {
+		From *big.Int
+		To   *big.Int
+	}
+
+	if err := s.Decode(&req); err != nil {
+		return err
+	}
+	b.From, b.To = req.From, req.To
+	return nil
+}
+
+func (b *SyncRequest) String() string {
+	return fmt.Sprintf("{From: %v, To: %v}", b.From, b.To)
+}
+
+// Heartbeat is broadcast periodically by the proposer of View to show it's
+// alive. Time makes every heartbeat distinct, so it isn't dropped as a known
+// message.
+type Heartbeat struct {
+	View *View
+	Time uint64
+}
+
+// EncodeRLP serializes b into the Ethereum RLP format.
+func (b *Heartbeat) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, []interface{}{b.View, b.Time})
+}
+
+// DecodeRLP implements rlp.Decoder, and load the consensus fields from a RLP stream.
+func (b *Heartbeat) DecodeRLP(s *rlp.Stream) error {
+	var heartbeat struct {
+		View *View
+		Time uint64
+	}
+
+	if err := s.Decode(&heartbeat); err != nil {
+		return err
+	}
+	b.View, b.Time = heartbeat.View, heartbeat.Time
+	return nil
+}
+
+func (b *Heartbeat) String() string {
+	return fmt.Sprintf("{View: %v, Time: %v}", b.View, b.Time)
+}
+
+// CommittedProposal is a proposal together with the committed seals which
+// prove that it was committed by the validators.
+type CommittedProposal struct {
+	Proposal       Proposal
+	CommittedSeals [][]byte
+}
+
+// EncodeRLP serializes b into the Ethereum RLP format.
+func (b *CommittedProposal) EncodeRLP(w io.Writer) error {
+	return rlp.Encode(w, []interface{}{b.Proposal, b.CommittedSeals})
+}
+
+// DecodeRLP implements rlp.Decoder, and load the consensus fields from a RLP stream.
+func (b *CommittedProposal) DecodeRLP(s *rlp.Stream) error {
+	var committed struct {
+		Proposal       *types.Block
+		CommittedSeals [][]byte
+	}
+
+	if err := s.Decode(&committed); err != nil {
+		return err
+	}
+	b.Proposal, b.CommittedSeals = committed.Proposal, committed.CommittedSeals
+
+	return nil
+}
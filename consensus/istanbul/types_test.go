@@ -18,7 +18,12 @@ package istanbul
 
 import (
 	"math/big"
+	"reflect"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 func TestViewCompare(t *testing.T) {
@@ -69,3 +74,79 @@ func TestViewCompare(t *testing.T) {
 		t.Errorf("source(%v) should be smaller than target(%v): have %v, want %v", srvView, tarView, r, -1)
 	}
 }
+
+func TestRLPRoundTrip(t *testing.T) {
+	view := &View{
+		Round:    big.NewInt(1),
+		Sequence: big.NewInt(2),
+	}
+	block := types.NewBlockWithHeader(&types.Header{
+		Number:     big.NewInt(2),
+		Difficulty: big.NewInt(1),
+		Time:       big.NewInt(1),
+		Extra:      []byte{0x01},
+	})
+
+	testCases := []struct {
+		in  interface{}
+		out interface{}
+	}{
+		{view, new(View)},
+		{&Subject{View: view, Digest: common.StringToHash("1234567890")}, new(Subject)},
+		{&SyncRequest{From: big.NewInt(1), To: big.NewInt(10)}, new(SyncRequest)},
+		{&Heartbeat{View: view, Time: 1234567890}, new(Heartbeat)},
+	}
+	for i, test := range testCases {
+		enc, err := rlp.EncodeToBytes(test.in)
+		if err != nil {
+			t.Errorf("test %d: error mismatch: have %v, want nil", i, err)
+			continue
+		}
+		if err := rlp.DecodeBytes(enc, test.out); err != nil {
+			t.Errorf("test %d: error mismatch: have %v, want nil", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.in, test.out) {
+			t.Errorf("test %d: message mismatch: have %v, want %v", i, test.out, test.in)
+		}
+		// A truncated encoding must fail rather than decode partially
+		if err := rlp.DecodeBytes(enc[:len(enc)-1], reflect.New(reflect.TypeOf(test.out).Elem()).Interface()); err == nil {
+			t.Errorf("test %d: error mismatch: have nil, want an error on truncated input", i)
+		}
+	}
+
+	// Proposals are decoded as blocks, so only compare what they commit to
+	enc, err := rlp.EncodeToBytes(&Preprepare{View: view, Proposal: block})
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	var preprepare Preprepare
+	if err := rlp.DecodeBytes(enc, &preprepare); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if !reflect.DeepEqual(preprepare.View, view) {
+		t.Errorf("view mismatch: have %v, want %v", preprepare.View, view)
+	}
+	if preprepare.Proposal.Hash() != block.Hash() {
+		t.Errorf("proposal hash mismatch: have %v, want %v", preprepare.Proposal.Hash(), block.Hash())
+	}
+
+	seals := [][]byte{{0x01}, {0x02, 0x03}}
+	enc, err = rlp.EncodeToBytes([]*CommittedProposal{{Proposal: block, CommittedSeals: seals}})
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	var committed []*CommittedProposal
+	if err := rlp.DecodeBytes(enc, &committed); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if len(committed) != 1 {
+		t.Fatalf("proposal count mismatch: have %v, want 1", len(committed))
+	}
+	if committed[0].Proposal.Hash() != block.Hash() {
+		t.Errorf("proposal hash mismatch: have %v, want %v", committed[0].Proposal.Hash(), block.Hash())
+	}
+	if !reflect.DeepEqual(committed[0].CommittedSeals, seals) {
+		t.Errorf("committed seals mismatch: have %x, want %x", committed[0].CommittedSeals, seals)
+	}
+}
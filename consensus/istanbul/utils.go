@@ -17,7 +17,10 @@
 package istanbul
 
 import (
+	"crypto/sha256"
+
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/sha3"
 	"github.com/ethereum/go-ethereum/log"
@@ -31,10 +34,42 @@ func RLPHash(v interface{}) (h common.Hash) {
 	return h
 }
 
-// GetSignatureAddress gets the signer address from the signature
+// The domains of the data signed by the validators
+var (
+	SealDomain          = []byte("istanbul seal")           // The proposer seal of a header
+	MessageDomain       = []byte("istanbul message")        // A consensus message
+	CommittedSealDomain = []byte("istanbul committed seal") // The committed seal of a COMMIT message
+)
+
+// SigData returns the data to sign, or to check the signature of, in the given
+// domain according to the scheme.
+func (s SigScheme) SigData(domain []byte, data []byte) []byte {
+	if s == LegacySigScheme {
+		return data
+	}
+	return append(append(make([]byte, 0, len(domain)+len(data)), domain...), data...)
+}
+
+// Sum returns the hash of the data to sign, or to check the signature of.
+func (d DigestAlgorithm) Sum(data []byte) []byte {
+	if d == SHA256Digest {
+		hash := sha256.Sum256(data)
+		return hash[:]
+	}
+	return crypto.Keccak256(data)
+}
+
+// GetSignatureAddress gets the signer address from the signature of the data
+// hashed with Keccak-256
 func GetSignatureAddress(data []byte, sig []byte) (common.Address, error) {
-	// 1. Keccak data
-	hashData := crypto.Keccak256(data)
+	return Keccak256Digest.SignatureAddress(data, sig)
+}
+
+// SignatureAddress gets the signer address from the signature of the data
+// hashed with the algorithm
+func (d DigestAlgorithm) SignatureAddress(data []byte, sig []byte) (common.Address, error) {
+	// 1. Hash data
+	hashData := d.Sum(data)
 	// 2. Recover public key
 	pubkey, err := crypto.SigToPub(hashData, sig)
 	if err != nil {
@@ -58,3 +93,85 @@ func CheckValidatorSignature(valSet ValidatorSet, data []byte, sig []byte) (comm
 
 	return common.Address{}, ErrUnauthorizedAddress
 }
+
+// PrepareExtra returns the extra-data embedding the given vanity and validators,
+// e.g. for a genesis block. The vanity is padded to IstanbulExtraVanity bytes,
+// and the seals are left empty.
+func PrepareExtra(vanity []byte, validators []common.Address) ([]byte, error) {
+	if len(vanity) > types.IstanbulExtraVanity {
+		return nil, ErrInvalidExtraVanity
+	}
+	extra := make([]byte, types.IstanbulExtraVanity)
+	copy(extra, vanity)
+
+	payload, err := rlp.EncodeToBytes(&types.IstanbulExtra{
+		Validators:    validators,
+		Seal:          []byte{},
+		CommittedSeal: [][]byte{},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(extra, payload...), nil
+}
+
+// ReadSeal returns the proposer seal embedded in the extra-data of the header.
+// It returns ErrInvalidExtraVanity if the extra-data is too short, and
+// ErrInvalidExtraSeal if the header isn't sealed or the seal is malformed.
+func ReadSeal(header *types.Header) ([]byte, error) {
+	if len(header.Extra) < types.IstanbulExtraVanity {
+		return nil, ErrInvalidExtraVanity
+	}
+	istanbulExtra, err := types.ExtractIstanbulExtra(header)
+	if err != nil {
+		return nil, err
+	}
+	if len(istanbulExtra.Seal) != types.IstanbulExtraSeal {
+		return nil, ErrInvalidExtraSeal
+	}
+	return istanbulExtra.Seal, nil
+}
+
+// WriteSeal embeds the proposer seal into the extra-data of the header, which
+// must already carry the Istanbul fields. The seal must be IstanbulExtraSeal
+// bytes long.
+func WriteSeal(header *types.Header, seal []byte) error {
+	if len(seal) != types.IstanbulExtraSeal {
+		return ErrInvalidExtraSeal
+	}
+	if len(header.Extra) < types.IstanbulExtraVanity {
+		return ErrInvalidExtraVanity
+	}
+	istanbulExtra, err := types.ExtractIstanbulExtra(header)
+	if err != nil {
+		return err
+	}
+	istanbulExtra.Seal = seal
+	payload, err := rlp.EncodeToBytes(&istanbulExtra)
+	if err != nil {
+		return err
+	}
+	header.Extra = append(header.Extra[:types.IstanbulExtraVanity], payload...)
+	return nil
+}
+
+// ExtractValidators returns the validators embedded in the extra-data. The seal
+// and the committed seals must be empty or IstanbulExtraSeal bytes long.
+func ExtractValidators(extra []byte) ([]common.Address, error) {
+	if len(extra) < types.IstanbulExtraVanity {
+		return nil, ErrInvalidExtraVanity
+	}
+	var istanbulExtra *types.IstanbulExtra
+	if err := rlp.DecodeBytes(extra[types.IstanbulExtraVanity:], &istanbulExtra); err != nil {
+		return nil, err
+	}
+	if len(istanbulExtra.Seal) != 0 && len(istanbulExtra.Seal) != types.IstanbulExtraSeal {
+		return nil, ErrInvalidExtraSeal
+	}
+	for _, seal := range istanbulExtra.CommittedSeal {
+		if len(seal) != types.IstanbulExtraSeal {
+			return nil, ErrInvalidExtraSeal
+		}
+	}
+	return istanbulExtra.Validators, nil
+}
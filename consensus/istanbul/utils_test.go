@@ -0,0 +1,258 @@
+// Copyright 2017 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package istanbul
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func TestPrepareExtra(t *testing.T) {
+	validators := []common.Address{
+		common.HexToAddress("0x44add0ec310f115a0e603b2d7db9f067778eaf8a"),
+		common.HexToAddress("0x294fc7e8f22b3bcdcf955dd7ff3ba2ed833f8212"),
+	}
+
+	testCases := []struct {
+		vanity []byte
+		err    error
+	}{
+		{nil, nil},
+		{[]byte("vanity"), nil},
+		{bytes.Repeat([]byte{0x01}, types.IstanbulExtraVanity), nil},
+		{bytes.Repeat([]byte{0x01}, types.IstanbulExtraVanity+1), ErrInvalidExtraVanity},
+	}
+	for i, test := range testCases {
+		extra, err := PrepareExtra(test.vanity, validators)
+		if err != test.err {
+			t.Errorf("case %d: error mismatch: have %v, want %v", i, err, test.err)
+		}
+		if err != nil {
+			continue
+		}
+		if !bytes.HasPrefix(extra, test.vanity) || len(extra) < types.IstanbulExtraVanity {
+			t.Errorf("case %d: vanity mismatch: have %x, want %x", i, extra, test.vanity)
+		}
+		// The validators survive the round trip
+		vals, err := ExtractValidators(extra)
+		if err != nil {
+			t.Errorf("case %d: error mismatch: have %v, want nil", i, err)
+		}
+		if !reflect.DeepEqual(vals, validators) {
+			t.Errorf("case %d: validators mismatch: have %v, want %v", i, vals, validators)
+		}
+		// It's the same extra-data as decoded from a header
+		istanbulExtra, err := types.ExtractIstanbulExtra(&types.Header{Extra: extra})
+		if err != nil || !reflect.DeepEqual(istanbulExtra.Validators, validators) {
+			t.Errorf("case %d: extra mismatch: have %v %v, want %v", i, istanbulExtra, err, validators)
+		}
+	}
+}
+
+func TestExtractValidators(t *testing.T) {
+	vanity := bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity)
+	validators := []common.Address{common.HexToAddress("0x44add0ec310f115a0e603b2d7db9f067778eaf8a")}
+	encode := func(seal []byte, committedSeals [][]byte) []byte {
+		payload, _ := rlp.EncodeToBytes(&types.IstanbulExtra{
+			Validators:    validators,
+			Seal:          seal,
+			CommittedSeal: committedSeals,
+		})
+		return append(vanity, payload...)
+	}
+	seal := bytes.Repeat([]byte{0x01}, types.IstanbulExtraSeal)
+
+	testCases := []struct {
+		extra []byte
+		err   error
+	}{
+		{
+			// sealed and committed
+			encode(seal, [][]byte{seal, seal}),
+			nil,
+		},
+		{
+			// too short for the vanity
+			vanity[:types.IstanbulExtraVanity-1],
+			ErrInvalidExtraVanity,
+		},
+		{
+			// short seal
+			encode(seal[1:], nil),
+			ErrInvalidExtraSeal,
+		},
+		{
+			// long committed seal
+			encode(seal, [][]byte{append(seal, 0x01)}),
+			ErrInvalidExtraSeal,
+		},
+	}
+	for i, test := range testCases {
+		vals, err := ExtractValidators(test.extra)
+		if err != test.err {
+			t.Errorf("case %d: error mismatch: have %v, want %v", i, err, test.err)
+		}
+		if err == nil && !reflect.DeepEqual(vals, validators) {
+			t.Errorf("case %d: validators mismatch: have %v, want %v", i, vals, validators)
+		}
+	}
+
+	// undecodable payload
+	if _, err := ExtractValidators(append(vanity, 0x01, 0x02)); err == nil {
+		t.Errorf("error mismatch: have nil, want an error")
+	}
+}
+
+func TestSigData(t *testing.T) {
+	data := crypto.Keccak256([]byte("data"))
+	if have := LegacySigScheme.SigData(SealDomain, data); !bytes.Equal(have, data) {
+		t.Errorf("data mismatch: have %x, want %x", have, data)
+	}
+
+	key, _ := crypto.GenerateKey()
+	signer := crypto.PubkeyToAddress(key.PublicKey)
+	domains := [][]byte{SealDomain, MessageDomain, CommittedSealDomain}
+	for _, signed := range domains {
+		sig, _ := crypto.Sign(crypto.Keccak256(DomainSigScheme.SigData(signed, data)), key)
+		for _, checked := range domains {
+			addr, err := GetSignatureAddress(DomainSigScheme.SigData(checked, data), sig)
+			if err != nil {
+				t.Fatalf("error mismatch: have %v, want nil", err)
+			}
+			// The signature is only valid in the domain it was made for
+			if valid := addr == signer; valid != bytes.Equal(signed, checked) {
+				t.Errorf("signature validity mismatch: signed in %q, checked in %q, have %v", signed, checked, valid)
+			}
+		}
+	}
+}
+
+func TestWriteSeal(t *testing.T) {
+	vanity := bytes.Repeat([]byte{0x00}, types.IstanbulExtraVanity)
+	istRawData := hexutil.MustDecode("0xf858f8549444add0ec310f115a0e603b2d7db9f067778eaf8a94294fc7e8f22b3bcdcf955dd7ff3ba2ed833f8212946beaaed781d2d2ab6350f5c4566a2c6eaac407a6948be76812f765c24641ec63dc2852b378aba2b44080c0")
+	expectedSeal := append([]byte{1, 2, 3}, bytes.Repeat([]byte{0x00}, types.IstanbulExtraSeal-3)...)
+	expectedIstExtra := &types.IstanbulExtra{
+		Validators: []common.Address{
+			common.BytesToAddress(hexutil.MustDecode("0x44add0ec310f115a0e603b2d7db9f067778eaf8a")),
+			common.BytesToAddress(hexutil.MustDecode("0x294fc7e8f22b3bcdcf955dd7ff3ba2ed833f8212")),
+			common.BytesToAddress(hexutil.MustDecode("0x6beaaed781d2d2ab6350f5c4566a2c6eaac407a6")),
+			common.BytesToAddress(hexutil.MustDecode("0x8be76812f765c24641ec63dc2852b378aba2b440")),
+		},
+		Seal:          expectedSeal,
+		CommittedSeal: [][]byte{},
+	}
+	var expectedErr error
+
+	h := &types.Header{
+		Extra: append(vanity, istRawData...),
+	}
+
+	// normal case
+	err := WriteSeal(h, expectedSeal)
+	if err != expectedErr {
+		t.Errorf("error mismatch: have %v, want %v", err, expectedErr)
+	}
+
+	// verify istanbul extra-data
+	istExtra, err := types.ExtractIstanbulExtra(h)
+	if err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if !reflect.DeepEqual(istExtra, expectedIstExtra) {
+		t.Errorf("extra data mismatch: have %v, want %v", istExtra, expectedIstExtra)
+	}
+
+	// read the seal back
+	seal, err := ReadSeal(h)
+	if err != nil {
+		t.Errorf("error mismatch: have %v, want nil", err)
+	}
+	if !bytes.Equal(seal, expectedSeal) {
+		t.Errorf("seal mismatch: have %v, want %v", seal, expectedSeal)
+	}
+
+	// invalid seal
+	unexpectedSeal := append(expectedSeal, make([]byte, 1)...)
+	err = WriteSeal(h, unexpectedSeal)
+	if err != ErrInvalidExtraSeal {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrInvalidExtraSeal)
+	}
+}
+
+func TestReadSeal(t *testing.T) {
+	extra, _ := PrepareExtra(nil, []common.Address{common.StringToAddress("1")})
+
+	testCases := []struct {
+		extra    []byte
+		readErr  error
+		writeErr error
+	}{
+		{
+			// short extra-data
+			nil,
+			ErrInvalidExtraVanity,
+			ErrInvalidExtraVanity,
+		},
+		{
+			// vanity without the istanbul fields
+			make([]byte, types.IstanbulExtraVanity),
+			io.EOF,
+			io.EOF,
+		},
+		{
+			// not sealed yet
+			extra,
+			ErrInvalidExtraSeal,
+			nil,
+		},
+	}
+	for i, test := range testCases {
+		h := &types.Header{Extra: test.extra}
+		if _, err := ReadSeal(h); err != test.readErr {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.readErr)
+		}
+		if err := WriteSeal(h, make([]byte, types.IstanbulExtraSeal)); err != test.writeErr {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.writeErr)
+		}
+	}
+}
+
+func TestDigestSum(t *testing.T) {
+	data := []byte("istanbul")
+	sha := sha256.Sum256(data)
+	tests := []struct {
+		digest DigestAlgorithm
+		want   []byte
+	}{
+		{Keccak256Digest, crypto.Keccak256(data)},
+		{SHA256Digest, sha[:]},
+	}
+	for _, test := range tests {
+		if sum := test.digest.Sum(data); !bytes.Equal(sum, test.want) {
+			t.Errorf("digest %d: sum mismatch: have %x, want %x", test.digest, sum, test.want)
+		}
+	}
+}
@@ -17,6 +17,7 @@
 package istanbul
 
 import (
+	"bytes"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -26,12 +27,18 @@ type Validator interface {
 	// Address returns address
 	Address() common.Address
 
+	// Weight returns the voting weight
+	Weight() uint64
+
 	// String representation of Validator
 	String() string
 }
 
 // ----------------------------------------------------------------------------
 
+// Validators sorts the validators by their address bytes in ascending order.
+// From Config.ValidatorOrderBlock on, the proposer selection picks validators
+// by their index in this order, so it must be the same on all the nodes.
 type Validators []Validator
 
 func (slice Validators) Len() int {
@@ -39,13 +46,30 @@ func (slice Validators) Len() int {
 }
 
 func (slice Validators) Less(i, j int) bool {
-	return strings.Compare(slice[i].String(), slice[j].String()) < 0
+	return bytes.Compare(slice[i].Address().Bytes(), slice[j].Address().Bytes()) < 0
 }
 
 func (slice Validators) Swap(i, j int) {
 	slice[i], slice[j] = slice[j], slice[i]
 }
 
+// HexValidators sorts the validators by their checksummed hex address, whose
+// mixed case doesn't follow the byte order. It's the order of the proposer
+// selection before Config.ValidatorOrderBlock.
+type HexValidators []Validator
+
+func (slice HexValidators) Len() int {
+	return len(slice)
+}
+
+func (slice HexValidators) Less(i, j int) bool {
+	return strings.Compare(slice[i].String(), slice[j].String()) < 0
+}
+
+func (slice HexValidators) Swap(i, j int) {
+	slice[i], slice[j] = slice[j], slice[i]
+}
+
 // ----------------------------------------------------------------------------
 
 type ValidatorSet interface {
@@ -69,10 +93,18 @@ type ValidatorSet interface {
 	RemoveValidator(address common.Address) bool
 	// Copy validator set
 	Copy() ValidatorSet
-	// Get the maximum number of faulty nodes
+	// Get the total voting weight
+	TotalWeight() uint64
+	// Get the maximum voting weight of faulty nodes
 	F() int
 	// Get proposer policy
 	Policy() ProposerPolicy
+	// Exclude the validators jailed returns true for from the proposer
+	// selection, the next in line is picked instead. It isn't copied.
+	SetJailed(jailed func(addr common.Address) bool)
+	// Sort the validators by their checksummed hex address instead of their
+	// address bytes, see HexValidators. It isn't kept by Copy.
+	SortByHex()
 }
 
 // ----------------------------------------------------------------------------
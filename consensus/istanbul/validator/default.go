@@ -28,12 +28,17 @@ import (
 
 type defaultValidator struct {
 	address common.Address
+	weight  uint64
 }
 
 func (val *defaultValidator) Address() common.Address {
 	return val.address
 }
 
+func (val *defaultValidator) Weight() uint64 {
+	return val.weight
+}
+
 func (val *defaultValidator) String() string {
 	return val.Address().String()
 }
@@ -47,16 +52,35 @@ type defaultSet struct {
 	proposer    istanbul.Validator
 	validatorMu sync.RWMutex
 	selector    istanbul.ProposalSelector
+	// jailed tells the validators skipped by the proposer selection
+	jailed func(addr common.Address) bool
 }
 
 func newDefaultSet(addrs []common.Address, policy istanbul.ProposerPolicy) *defaultSet {
+	valSet, _ := newWeightedSet(addrs, nil, policy)
+	return valSet
+}
+
+// newWeightedSet creates a validator set. All the validators weigh 1 if no
+// weights are given, otherwise each one needs a positive weight.
+func newWeightedSet(addrs []common.Address, weights []uint64, policy istanbul.ProposerPolicy) (*defaultSet, error) {
+	if weights != nil && len(weights) != len(addrs) {
+		return nil, istanbul.ErrInvalidWeights
+	}
 	valSet := &defaultSet{}
 
 	valSet.policy = policy
 	// init validators
 	valSet.validators = make([]istanbul.Validator, len(addrs))
 	for i, addr := range addrs {
-		valSet.validators[i] = New(addr)
+		if weights != nil {
+			if weights[i] == 0 {
+				return nil, istanbul.ErrInvalidWeights
+			}
+			valSet.validators[i] = NewWeighted(addr, weights[i])
+		} else {
+			valSet.validators[i] = New(addr)
+		}
 	}
 	// sort validator
 	sort.Sort(valSet.validators)
@@ -69,7 +93,7 @@ func newDefaultSet(addrs []common.Address, policy istanbul.ProposerPolicy) *defa
 		valSet.selector = stickyProposer
 	}
 
-	return valSet
+	return valSet, nil
 }
 
 func (valSet *defaultSet) Size() int {
@@ -114,7 +138,31 @@ func (valSet *defaultSet) IsProposer(address common.Address) bool {
 func (valSet *defaultSet) CalcProposer(lastProposer common.Address, round uint64) {
 	valSet.validatorMu.RLock()
 	defer valSet.validatorMu.RUnlock()
-	valSet.proposer = valSet.selector(valSet, lastProposer, round)
+	valSet.proposer = valSet.skipJailed(valSet.selector(valSet, lastProposer, round))
+}
+
+// skipJailed returns the first validator which isn't jailed, starting from the
+// proposer. The proposer is kept if all the validators are jailed, rather than
+// halting the chain.
+func (valSet *defaultSet) skipJailed(proposer istanbul.Validator) istanbul.Validator {
+	if valSet.jailed == nil || proposer == nil {
+		return proposer
+	}
+	idx, _ := valSet.GetByAddress(proposer.Address())
+	for i := range valSet.validators {
+		val := valSet.validators[(idx+i)%len(valSet.validators)]
+		if !valSet.jailed(val.Address()) {
+			return val
+		}
+	}
+	return proposer
+}
+
+func (valSet *defaultSet) SetJailed(jailed func(addr common.Address) bool) {
+	valSet.validatorMu.Lock()
+	defer valSet.validatorMu.Unlock()
+
+	valSet.jailed = jailed
 }
 
 func calcSeed(valSet istanbul.ValidatorSet, proposer common.Address, round uint64) uint64 {
@@ -172,6 +220,13 @@ func (valSet *defaultSet) AddValidator(address common.Address) bool {
 	return true
 }
 
+func (valSet *defaultSet) SortByHex() {
+	valSet.validatorMu.Lock()
+	defer valSet.validatorMu.Unlock()
+
+	sort.Sort(istanbul.HexValidators(valSet.validators))
+}
+
 func (valSet *defaultSet) RemoveValidator(address common.Address) bool {
 	valSet.validatorMu.Lock()
 	defer valSet.validatorMu.Unlock()
@@ -190,12 +245,28 @@ func (valSet *defaultSet) Copy() istanbul.ValidatorSet {
 	defer valSet.validatorMu.RUnlock()
 
 	addresses := make([]common.Address, 0, len(valSet.validators))
+	weights := make([]uint64, 0, len(valSet.validators))
 	for _, v := range valSet.validators {
 		addresses = append(addresses, v.Address())
+		weights = append(weights, v.Weight())
+	}
+	copied, _ := newWeightedSet(addresses, weights, valSet.policy)
+	return copied
+}
+
+func (valSet *defaultSet) TotalWeight() uint64 {
+	valSet.validatorMu.RLock()
+	defer valSet.validatorMu.RUnlock()
+
+	var total uint64
+	for _, v := range valSet.validators {
+		total += v.Weight()
 	}
-	return NewSet(addresses, valSet.policy)
+	return total
 }
 
-func (valSet *defaultSet) F() int { return int(math.Ceil(float64(valSet.Size())/3)) - 1 }
+// F returns the maximum voting weight of faulty nodes. It's the maximum number
+// of faulty nodes if all the validators weigh 1.
+func (valSet *defaultSet) F() int { return int(math.Ceil(float64(valSet.TotalWeight())/3)) - 1 }
 
 func (valSet *defaultSet) Policy() istanbul.ProposerPolicy { return valSet.policy }
@@ -17,6 +17,8 @@
 package validator
 
 import (
+	"bytes"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
@@ -37,6 +39,10 @@ func TestValidatorSet(t *testing.T) {
 	testEmptyValSet(t)
 	testStickyProposer(t)
 	testAddAndRemoveValidator(t)
+	testProposerOrdering(t)
+	testWeightedValSet(t)
+	testJailedProposer(t)
+	testSortByHex(t)
 }
 
 func testNewValidatorSet(t *testing.T) {
@@ -64,7 +70,7 @@ func testNewValidatorSet(t *testing.T) {
 	for i := 0; i < ValCnt-1; i++ {
 		val := valSet.GetByIndex(uint64(i))
 		nextVal := valSet.GetByIndex(uint64(i + 1))
-		if strings.Compare(val.String(), nextVal.String()) >= 0 {
+		if bytes.Compare(val.Address().Bytes(), nextVal.Address().Bytes()) >= 0 {
 			t.Errorf("validator set is not sorted in ascending order")
 		}
 	}
@@ -206,3 +212,138 @@ func testStickyProposer(t *testing.T) {
 		t.Errorf("proposer mismatch: have %v, want %v", val, val2)
 	}
 }
+
+func testJailedProposer(t *testing.T) {
+	var addrs []common.Address
+	for i := 0; i < 3; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	valSet := newDefaultSet(addrs, istanbul.RoundRobin)
+	jailed := make(map[common.Address]bool)
+	valSet.SetJailed(func(addr common.Address) bool { return jailed[addr] })
+
+	// The turn of a jailed validator goes to the next in line
+	jailed[valSet.GetByIndex(0).Address()] = true
+	valSet.CalcProposer(common.Address{}, 0)
+	if val, want := valSet.GetProposer(), valSet.GetByIndex(1); val != want {
+		t.Errorf("proposer mismatch: have %v, want %v", val, want)
+	}
+	// wrapping around the set
+	jailed[valSet.GetByIndex(2).Address()] = true
+	valSet.CalcProposer(common.Address{}, 2)
+	if val, want := valSet.GetProposer(), valSet.GetByIndex(1); val != want {
+		t.Errorf("proposer mismatch: have %v, want %v", val, want)
+	}
+	// The others keep their turn
+	valSet.CalcProposer(common.Address{}, 1)
+	if val, want := valSet.GetProposer(), valSet.GetByIndex(1); val != want {
+		t.Errorf("proposer mismatch: have %v, want %v", val, want)
+	}
+	// The proposer is kept if all the validators are jailed
+	jailed[valSet.GetByIndex(1).Address()] = true
+	valSet.CalcProposer(common.Address{}, 2)
+	if val, want := valSet.GetProposer(), valSet.GetByIndex(2); val != want {
+		t.Errorf("proposer mismatch: have %v, want %v", val, want)
+	}
+}
+
+func testProposerOrdering(t *testing.T) {
+	const ValCnt = 10
+
+	var addrs []common.Address
+	for i := 0; i < ValCnt; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+
+	for _, policy := range []istanbul.ProposerPolicy{istanbul.RoundRobin, istanbul.Sticky} {
+		// Every node builds the set from a differently ordered list
+		var valSets []istanbul.ValidatorSet
+		for i := 0; i < ValCnt; i++ {
+			shuffled := make([]common.Address, len(addrs))
+			for j, k := range rand.Perm(len(addrs)) {
+				shuffled[j] = addrs[k]
+			}
+			valSets = append(valSets, NewSet(shuffled, policy))
+		}
+
+		for _, lastProposer := range append([]common.Address{{}}, addrs...) {
+			for round := uint64(0); round < ValCnt; round++ {
+				var proposer istanbul.Validator
+				for _, valSet := range valSets {
+					valSet.CalcProposer(lastProposer, round)
+					if proposer == nil {
+						proposer = valSet.GetProposer()
+					} else if val := valSet.GetProposer(); val.Address() != proposer.Address() {
+						t.Fatalf("proposer mismatch: have %v, want %v", val, proposer)
+					}
+				}
+			}
+		}
+	}
+}
+
+func testWeightedValSet(t *testing.T) {
+	addr1 := common.BytesToAddress(common.Hex2Bytes(testAddress))
+	addr2 := common.BytesToAddress(common.Hex2Bytes(testAddress2))
+
+	// Equal weights by default
+	valSet := NewSet([]common.Address{addr1, addr2}, istanbul.RoundRobin)
+	if total := valSet.TotalWeight(); total != 2 {
+		t.Errorf("total weight mismatch: have %v, want 2", total)
+	}
+
+	valSet, err := NewWeightedSet([]common.Address{addr2, addr1}, []uint64{5, 1}, istanbul.RoundRobin)
+	if err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if _, val := valSet.GetByAddress(addr2); val == nil || val.Weight() != 5 {
+		t.Errorf("validator weight mismatch: have %v, want 5", val)
+	}
+	if total := valSet.TotalWeight(); total != 6 {
+		t.Errorf("total weight mismatch: have %v, want 6", total)
+	}
+	// ceil(6/3)-1 although there are only 2 validators
+	if f := valSet.F(); f != 1 {
+		t.Errorf("F mismatch: have %v, want 1", f)
+	}
+	if copied := valSet.Copy(); copied.TotalWeight() != 6 {
+		t.Errorf("copied total weight mismatch: have %v, want 6", copied.TotalWeight())
+	}
+
+	// Each validator needs a positive weight
+	for _, weights := range [][]uint64{{5}, {5, 1, 1}, {5, 0}} {
+		if _, err := NewWeightedSet([]common.Address{addr2, addr1}, weights, istanbul.RoundRobin); err != istanbul.ErrInvalidWeights {
+			t.Errorf("weights %v: error mismatch: have %v, want %v", weights, err, istanbul.ErrInvalidWeights)
+		}
+	}
+}
+
+func testSortByHex(t *testing.T) {
+	const ValCnt = 10
+
+	var addrs []common.Address
+	for i := 0; i < ValCnt; i++ {
+		key, _ := crypto.GenerateKey()
+		addrs = append(addrs, crypto.PubkeyToAddress(key.PublicKey))
+	}
+	valSet := NewSet(addrs, istanbul.RoundRobin)
+	valSet.SortByHex()
+	for i := 0; i < ValCnt-1; i++ {
+		val := valSet.GetByIndex(uint64(i))
+		nextVal := valSet.GetByIndex(uint64(i + 1))
+		if strings.Compare(val.String(), nextVal.String()) >= 0 {
+			t.Errorf("validator set is not sorted by hex address")
+		}
+	}
+	// the copy is sorted by address bytes again
+	copied := valSet.Copy()
+	for i := 0; i < ValCnt-1; i++ {
+		val := copied.GetByIndex(uint64(i))
+		nextVal := copied.GetByIndex(uint64(i + 1))
+		if bytes.Compare(val.Address().Bytes(), nextVal.Address().Bytes()) >= 0 {
+			t.Errorf("validator set is not sorted by address bytes")
+		}
+	}
+}
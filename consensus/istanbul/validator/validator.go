@@ -22,8 +22,14 @@ import (
 )
 
 func New(addr common.Address) istanbul.Validator {
+	return NewWeighted(addr, 1)
+}
+
+// NewWeighted creates a validator with the given voting weight.
+func NewWeighted(addr common.Address, weight uint64) istanbul.Validator {
 	return &defaultValidator{
 		address: addr,
+		weight:  weight,
 	}
 }
 
@@ -31,6 +37,18 @@ func NewSet(addrs []common.Address, policy istanbul.ProposerPolicy) istanbul.Val
 	return newDefaultSet(addrs, policy)
 }
 
+// NewWeightedSet creates a validator set in which the i-th validator has the
+// i-th voting weight. The quorums are computed over the summed weights. It
+// returns istanbul.ErrInvalidWeights unless there is a positive weight for each
+// validator.
+func NewWeightedSet(addrs []common.Address, weights []uint64, policy istanbul.ProposerPolicy) (istanbul.ValidatorSet, error) {
+	valSet, err := newWeightedSet(addrs, weights, policy)
+	if err != nil {
+		return nil, err
+	}
+	return valSet, nil
+}
+
 func ExtractValidators(extraData []byte) []common.Address {
 	// get the validator addresses
 	addrs := make([]common.Address, (len(extraData) / common.AddressLength))
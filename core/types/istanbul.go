@@ -36,19 +36,31 @@ var (
 	ErrInvalidIstanbulHeaderExtra = errors.New("invalid istanbul header extra-data")
 )
 
+// IstanbulExtra is the RLP encoded part of the header extra-data, following the
+// 32 bytes of vanity. Seal is the proposer's signature over the header without
+// the committed seals, and CommittedSeal holds the commit signatures of at least
+// 2F+1 validators over the header hash, which proves the finality of the block.
+// ValidatorsRoot is the Merkle root of the validators, see
+// istanbul.ValidatorsRoot. It's only encoded when set, so that the extra-data
+// without it is the same as before it existed.
 type IstanbulExtra struct {
-	Validators    []common.Address
-	Seal          []byte
-	CommittedSeal [][]byte
+	Validators     []common.Address
+	Seal           []byte
+	CommittedSeal  [][]byte
+	ValidatorsRoot common.Hash
 }
 
 // EncodeRLP serializes ist into the Ethereum RLP format.
 func (ist *IstanbulExtra) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, []interface{}{
+	fields := []interface{}{
 		ist.Validators,
 		ist.Seal,
 		ist.CommittedSeal,
-	})
+	}
+	if ist.ValidatorsRoot != (common.Hash{}) {
+		fields = append(fields, ist.ValidatorsRoot)
+	}
+	return rlp.Encode(w, fields)
 }
 
 // DecodeRLP implements rlp.Decoder, and load the istanbul fields from a RLP stream.
@@ -57,11 +69,19 @@ func (ist *IstanbulExtra) DecodeRLP(s *rlp.Stream) error {
 		Validators    []common.Address
 		Seal          []byte
 		CommittedSeal [][]byte
+		Rest          []common.Hash `rlp:"tail"`
 	}
 	if err := s.Decode(&istanbulExtra); err != nil {
 		return err
 	}
+	if len(istanbulExtra.Rest) > 1 {
+		return ErrInvalidIstanbulHeaderExtra
+	}
 	ist.Validators, ist.Seal, ist.CommittedSeal = istanbulExtra.Validators, istanbulExtra.Seal, istanbulExtra.CommittedSeal
+	ist.ValidatorsRoot = common.Hash{}
+	if len(istanbulExtra.Rest) == 1 {
+		ist.ValidatorsRoot = istanbulExtra.Rest[0]
+	}
 	return nil
 }
 
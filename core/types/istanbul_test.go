@@ -23,6 +23,7 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rlp"
 )
 
 func TestHeaderHash(t *testing.T) {
@@ -86,3 +87,45 @@ func TestExtractToIstanbul(t *testing.T) {
 		}
 	}
 }
+
+func TestIstanbulExtraValidatorsRoot(t *testing.T) {
+	extra := &IstanbulExtra{
+		Validators:    []common.Address{common.HexToAddress("0x44add0ec310f115a0e603b2d7db9f067778eaf8a")},
+		Seal:          []byte{},
+		CommittedSeal: [][]byte{},
+	}
+	// without a root, the encoding is the same as before the root existed
+	legacy, err := rlp.EncodeToBytes([]interface{}{extra.Validators, extra.Seal, extra.CommittedSeal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(enc, legacy) {
+		t.Errorf("encoding mismatch: have %x, want %x", enc, legacy)
+	}
+
+	// with a root, it round trips
+	extra.ValidatorsRoot = common.HexToHash("0x01")
+	if enc, err = rlp.EncodeToBytes(extra); err != nil {
+		t.Fatal(err)
+	}
+	var decoded *IstanbulExtra
+	if err := rlp.DecodeBytes(enc, &decoded); err != nil {
+		t.Fatalf("error mismatch: have %v, want nil", err)
+	}
+	if !reflect.DeepEqual(decoded, extra) {
+		t.Errorf("extra mismatch: have %v, want %v", decoded, extra)
+	}
+
+	// and anything after it is rejected
+	enc, err = rlp.EncodeToBytes([]interface{}{extra.Validators, extra.Seal, extra.CommittedSeal, extra.ValidatorsRoot, extra.ValidatorsRoot})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rlp.DecodeBytes(enc, &decoded); err != ErrInvalidIstanbulHeaderExtra {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrInvalidIstanbulHeaderExtra)
+	}
+}
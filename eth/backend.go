@@ -122,13 +122,17 @@ func New(ctx *node.ServiceContext, config *Config) (*Ethereum, error) {
 	}
 	log.Info("Initialised chain configuration", "config", chainConfig)
 
+	engine, err := CreateConsensusEngine(ctx, config, chainConfig, chainDb)
+	if err != nil {
+		return nil, err
+	}
 	eth := &Ethereum{
 		config:         config,
 		chainDb:        chainDb,
 		chainConfig:    chainConfig,
 		eventMux:       ctx.EventMux,
 		accountManager: ctx.AccountManager,
-		engine:         CreateConsensusEngine(ctx, config, chainConfig, chainDb),
+		engine:         engine,
 		shutdownChan:   make(chan bool),
 		stopDbUpgrade:  stopDbUpgrade,
 		networkId:      config.NetworkId,
@@ -218,19 +222,58 @@ func CreateDB(ctx *node.ServiceContext, config *Config, name string) (ethdb.Data
 	return db, nil
 }
 
-// CreateConsensusEngine creates the required type of consensus engine instance for an Ethereum service
-func CreateConsensusEngine(ctx *node.ServiceContext, config *Config, chainConfig *params.ChainConfig, db ethdb.Database) consensus.Engine {
+// CreateConsensusEngine creates the required type of consensus engine instance for an Ethereum service,
+// or an error if its config is invalid
+func CreateConsensusEngine(ctx *node.ServiceContext, config *Config, chainConfig *params.ChainConfig, db ethdb.Database) (consensus.Engine, error) {
 	// If proof-of-authority is requested, set it up
 	if chainConfig.Clique != nil {
-		return clique.New(chainConfig.Clique, db)
+		return clique.New(chainConfig.Clique, db), nil
 	}
 	// If Istanbul is requested, set it up
 	if chainConfig.Istanbul != nil {
-		if chainConfig.Istanbul.Epoch != 0 {
-			config.Istanbul.Epoch = chainConfig.Istanbul.Epoch
+		istanbulConfig, err := params.NewIstanbulConfig(*chainConfig.Istanbul)
+		if err != nil {
+			return nil, err
+		}
+		if istanbulConfig.Epoch != 0 {
+			config.Istanbul.Epoch = istanbulConfig.Epoch
+		}
+		if istanbulConfig.BlockPeriod != 0 {
+			config.Istanbul.BlockPeriod = istanbulConfig.BlockPeriod
+		}
+		if istanbulConfig.RequestTimeout != 0 {
+			config.Istanbul.RequestTimeout = istanbulConfig.RequestTimeout
+		}
+		config.Istanbul.ProposerPolicy = istanbul.ProposerPolicy(istanbulConfig.ProposerPolicy)
+		config.Istanbul.ActivationBlock = istanbulConfig.ActivationBlock
+		config.Istanbul.SigScheme = istanbul.SigScheme(istanbulConfig.SigScheme)
+		config.Istanbul.SigSchemeBlock = istanbulConfig.SigSchemeBlock
+		config.Istanbul.Digest = istanbul.DigestAlgorithm(istanbulConfig.Digest)
+		config.Istanbul.DigestBlock = istanbulConfig.DigestBlock
+		config.Istanbul.ValidatorRegistry = istanbulConfig.ValidatorRegistry
+		config.Istanbul.ValidatorRegistryBlock = istanbulConfig.ValidatorRegistryBlock
+		config.Istanbul.ValidatorsRootBlock = istanbulConfig.ValidatorsRootBlock
+		config.Istanbul.BlockReward = istanbulConfig.BlockReward
+		config.Istanbul.BlockRewardBlock = istanbulConfig.BlockRewardBlock
+		config.Istanbul.ValidatorOrderBlock = istanbulConfig.ValidatorOrderBlock
+		config.Istanbul.ValidatorWeights = istanbulConfig.ValidatorWeights
+		// Check the settings the node may have overridden as well
+		if err := config.Istanbul.CheckRequestTimeout(); err != nil {
+			return nil, err
+		}
+		if err := config.Istanbul.CheckRegistryBlock(); err != nil {
+			return nil, err
+		}
+		if err := config.Istanbul.CheckLease(); err != nil {
+			return nil, err
+		}
+		if config.Istanbul.LeaseFile != "" {
+			config.Istanbul.LeaseFile = ctx.ResolvePath(config.Istanbul.LeaseFile)
+		}
+		if config.Istanbul.WALFile != "" {
+			config.Istanbul.WALFile = ctx.ResolvePath(config.Istanbul.WALFile)
 		}
-		config.Istanbul.ProposerPolicy = istanbul.ProposerPolicy(chainConfig.Istanbul.ProposerPolicy)
-		return istanbulBackend.New(&config.Istanbul, ctx.NodeKey(), db)
+		return istanbulBackend.New(&config.Istanbul, ctx.NodeKey(), db), nil
 	}
 
 	// Otherwise assume proof-of-work
@@ -238,13 +281,13 @@ func CreateConsensusEngine(ctx *node.ServiceContext, config *Config, chainConfig
 	switch {
 	case ethConfig.PowMode == ethash.ModeFake:
 		log.Warn("Ethash used in fake mode")
-		return ethash.NewFaker()
+		return ethash.NewFaker(), nil
 	case ethConfig.PowMode == ethash.ModeTest:
 		log.Warn("Ethash used in test mode")
-		return ethash.NewTester()
+		return ethash.NewTester(), nil
 	case ethConfig.PowMode == ethash.ModeShared:
 		log.Warn("Ethash used in shared mode")
-		return ethash.NewShared()
+		return ethash.NewShared(), nil
 	default:
 		engine := ethash.New(ethash.Config{
 			CacheDir:       ctx.ResolvePath(ethConfig.CacheDir),
@@ -255,7 +298,7 @@ func CreateConsensusEngine(ctx *node.ServiceContext, config *Config, chainConfig
 			DatasetsOnDisk: ethConfig.DatasetsOnDisk,
 		})
 		engine.SetThreads(-1) // Disable CPU mining
-		return engine
+		return engine, nil
 	}
 }
 
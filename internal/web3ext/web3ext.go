@@ -660,6 +660,34 @@ web3._extend({
 			call: 'istanbul_getValidatorsAtHash',
 			params: 1
 		}),
+		new web3._extend.Method({
+			name: 'getProposerAt',
+			call: 'istanbul_getProposerAt',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'getFinalityProof',
+			call: 'istanbul_getFinalityProof',
+			params: 1,
+			inputFormatter: [null]
+		}),
+		new web3._extend.Method({
+			name: 'getMembershipProof',
+			call: 'istanbul_getMembershipProof',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
+		new web3._extend.Method({
+			name: 'setTraceLevel',
+			call: 'istanbul_setTraceLevel',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'tracedMessages',
+			call: 'istanbul_tracedMessages',
+			params: 1
+		}),
 		new web3._extend.Method({
 			name: 'propose',
 			call: 'istanbul_propose',
@@ -669,6 +697,39 @@ web3._extend({
 			name: 'discard',
 			call: 'istanbul_discard',
 			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'jail',
+			call: 'istanbul_jail',
+			params: 1
+		}),
+		new web3._extend.Method({
+			name: 'dumpRound',
+			call: 'istanbul_dumpRound'
+		}),
+		new web3._extend.Method({
+			name: 'health',
+			call: 'istanbul_health'
+		}),
+		new web3._extend.Method({
+			name: 'getParticipation',
+			call: 'istanbul_getParticipation'
+		}),
+		new web3._extend.Method({
+			name: 'getMisbehaviorEvidence',
+			call: 'istanbul_getMisbehaviorEvidence'
+		}),
+		new web3._extend.Method({
+			name: 'isProposer',
+			call: 'istanbul_isProposer'
+		}),
+		new web3._extend.Method({
+			name: 'pause',
+			call: 'istanbul_pause'
+		}),
+		new web3._extend.Method({
+			name: 'resume',
+			call: 'istanbul_resume'
 		})
 	],
 	properties:
@@ -677,6 +738,10 @@ web3._extend({
 			name: 'candidates',
 			getter: 'istanbul_candidates'
 		}),
+		new web3._extend.Property({
+			name: 'paused',
+			getter: 'istanbul_paused'
+		}),
 	]
 });
 `
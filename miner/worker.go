@@ -266,6 +266,10 @@ func (self *worker) update() {
 
 		// Handle ChainSideEvent
 		case ev := <-self.chainSideCh:
+			// Istanbul blocks have no uncles, Finalize would reject them
+			if self.config.Istanbul != nil {
+				continue
+			}
 			self.uncleMu.Lock()
 			self.possibleUncles[ev.Block.Hash()] = ev.Block
 			self.uncleMu.Unlock()
@@ -286,6 +290,15 @@ func (self *worker) update() {
 				if self.config.Clique != nil && self.config.Clique.Period == 0 {
 					self.commitNewWork()
 				}
+				// Istanbul may hold an empty block back while idle, replace it
+				if self.config.Istanbul != nil {
+					self.currentMu.Lock()
+					idle := self.current.tcount == 0
+					self.currentMu.Unlock()
+					if idle {
+						self.commitNewWork()
+					}
+				}
 			}
 
 		// System stopped
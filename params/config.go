@@ -17,6 +17,7 @@
 package params
 
 import (
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
@@ -164,8 +165,89 @@ func (c *CliqueConfig) String() string {
 
 // IstanbulConfig is the consensus engine configs for Istanbul based sealing.
 type IstanbulConfig struct {
-	Epoch          uint64 `json:"epoch"`  // Epoch length to reset votes and checkpoint
-	ProposerPolicy uint64 `json:"policy"` // The policy for proposer selection
+	Epoch           uint64 `json:"epoch"`                     // Epoch length to reset votes and checkpoint
+	ProposerPolicy  uint64 `json:"policy"`                    // The policy for proposer selection
+	ActivationBlock uint64 `json:"activationBlock,omitempty"` // The first block sealed by Istanbul, 0 means the first block after the genesis
+	BlockPeriod     uint64 `json:"period,omitempty"`          // Minimum difference in seconds between the timestamps of two consecutive blocks, 0 means the node setting
+	RequestTimeout  uint64 `json:"requestTimeout,omitempty"`  // Timeout in milliseconds of the first round, 0 means the node setting
+
+	SigScheme      uint64   `json:"sigScheme,omitempty"`      // The version of the signing scheme from SigSchemeBlock on, see istanbul.SigScheme
+	SigSchemeBlock *big.Int `json:"sigSchemeBlock,omitempty"` // The first block signed in SigScheme, nil means the legacy scheme forever
+	Digest         uint64   `json:"digest,omitempty"`         // The hash function of the signed data from DigestBlock on, see istanbul.DigestAlgorithm
+	DigestBlock    *big.Int `json:"digestBlock,omitempty"`    // The first block signed with Digest, nil means Keccak-256 forever
+
+	ValidatorRegistry      common.Address `json:"validatorRegistry,omitempty"`      // The contract whose getValidators() sets the validators at each epoch from ValidatorRegistryBlock on
+	ValidatorRegistryBlock *big.Int       `json:"validatorRegistryBlock,omitempty"` // The first epoch block whose validators are read from ValidatorRegistry, nil means the header votes forever
+	ValidatorsRootBlock    *big.Int       `json:"validatorsRootBlock,omitempty"`    // The first block from which the checkpoint blocks carry the Merkle root of their validators, nil means never
+
+	BlockReward      *big.Int `json:"blockReward,omitempty"`      // The reward in wei credited to the proposer of each block from BlockRewardBlock on
+	BlockRewardBlock *big.Int `json:"blockRewardBlock,omitempty"` // The first block rewarded with BlockReward, nil means no reward
+
+	ValidatorOrderBlock *big.Int `json:"validatorOrderBlock,omitempty"` // The first block whose proposer is selected among the validators ordered by address bytes, nil means by checksummed hex forever
+
+	ValidatorWeights map[common.Address]uint64 `json:"validatorWeights,omitempty"` // The voting weights of the validators of the genesis block and the registry, the others weigh 1
+}
+
+// The proposer policies of Istanbul, see istanbul.ProposerPolicy.
+const (
+	IstanbulRoundRobinPolicy = 0
+	IstanbulStickyPolicy     = 1
+)
+
+// The signing schemes of Istanbul, see istanbul.SigScheme.
+const (
+	IstanbulLegacySigScheme = 0
+	IstanbulDomainSigScheme = 1
+)
+
+// The digest algorithms of Istanbul, see istanbul.DigestAlgorithm.
+const (
+	IstanbulKeccak256Digest = 0
+	IstanbulSHA256Digest    = 1
+)
+
+var (
+	// errIstanbulPolicy is returned if the proposer policy is unknown.
+	errIstanbulPolicy = errors.New("unknown istanbul proposer policy")
+	// errIstanbulTimeout is returned if the round times out before the block
+	// period is over, so no proposal could ever be committed.
+	errIstanbulTimeout = errors.New("istanbul request timeout not longer than the block period")
+	// errIstanbulSigScheme is returned if the signing scheme is unknown.
+	errIstanbulSigScheme = errors.New("unknown istanbul signing scheme")
+	// errIstanbulDigest is returned if the digest algorithm is unknown.
+	errIstanbulDigest = errors.New("unknown istanbul digest algorithm")
+	// errIstanbulRegistryBlock is returned if the validator registry takes over
+	// from another block than an epoch one, or without a registry contract.
+	errIstanbulRegistryBlock = errors.New("istanbul validator registry block not an epoch block with a registry")
+	// errIstanbulWeight is returned if a validator weighs nothing.
+	errIstanbulWeight = errors.New("istanbul validator weight is zero")
+)
+
+// NewIstanbulConfig returns a copy of the Istanbul config, or an error if the
+// fields are inconsistent. The unset fields are left to the node settings, the
+// engine checks them again once merged.
+func NewIstanbulConfig(config IstanbulConfig) (*IstanbulConfig, error) {
+	if config.ProposerPolicy != IstanbulRoundRobinPolicy && config.ProposerPolicy != IstanbulStickyPolicy {
+		return nil, errIstanbulPolicy
+	}
+	if config.SigScheme != IstanbulLegacySigScheme && config.SigScheme != IstanbulDomainSigScheme {
+		return nil, errIstanbulSigScheme
+	}
+	if config.Digest != IstanbulKeccak256Digest && config.Digest != IstanbulSHA256Digest {
+		return nil, errIstanbulDigest
+	}
+	if block := config.ValidatorRegistryBlock; block != nil && (config.ValidatorRegistry == (common.Address{}) || config.Epoch != 0 && block.Uint64()%config.Epoch != 0) {
+		return nil, errIstanbulRegistryBlock
+	}
+	for _, weight := range config.ValidatorWeights {
+		if weight == 0 {
+			return nil, errIstanbulWeight
+		}
+	}
+	if config.RequestTimeout != 0 && config.RequestTimeout <= config.BlockPeriod*1000 {
+		return nil, errIstanbulTimeout
+	}
+	return &config, nil
 }
 
 // String implements the stringer interface, returning the consensus engine details.
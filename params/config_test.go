@@ -20,6 +20,8 @@ import (
 	"math/big"
 	"reflect"
 	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
 )
 
 func TestCheckCompatible(t *testing.T) {
@@ -79,3 +81,70 @@ func TestCheckCompatible(t *testing.T) {
 		}
 	}
 }
+
+func TestNewIstanbulConfig(t *testing.T) {
+	tests := []struct {
+		config  IstanbulConfig
+		want    *IstanbulConfig
+		wantErr error
+	}{
+		{
+			// the unset fields are left to the node settings
+			config: IstanbulConfig{},
+			want:   &IstanbulConfig{},
+		},
+		{
+			config: IstanbulConfig{Epoch: 100, ProposerPolicy: IstanbulStickyPolicy, ActivationBlock: 50, BlockPeriod: 5, RequestTimeout: 6000},
+			want:   &IstanbulConfig{Epoch: 100, ProposerPolicy: IstanbulStickyPolicy, ActivationBlock: 50, BlockPeriod: 5, RequestTimeout: 6000},
+		},
+		{
+			config:  IstanbulConfig{ProposerPolicy: 2},
+			wantErr: errIstanbulPolicy,
+		},
+		{
+			config:  IstanbulConfig{SigScheme: 2, SigSchemeBlock: big.NewInt(10)},
+			wantErr: errIstanbulSigScheme,
+		},
+		{
+			config:  IstanbulConfig{Digest: 2, DigestBlock: big.NewInt(10)},
+			wantErr: errIstanbulDigest,
+		},
+		{
+			// the registry takes over at an epoch block
+			config:  IstanbulConfig{Epoch: 100, ValidatorRegistry: common.HexToAddress("0x1000"), ValidatorRegistryBlock: big.NewInt(150)},
+			wantErr: errIstanbulRegistryBlock,
+		},
+		{
+			config:  IstanbulConfig{ValidatorRegistryBlock: big.NewInt(0)},
+			wantErr: errIstanbulRegistryBlock,
+		},
+		{
+			// the epoch of the node is checked by the engine
+			config: IstanbulConfig{ValidatorRegistry: common.HexToAddress("0x1000"), ValidatorRegistryBlock: big.NewInt(150)},
+			want:   &IstanbulConfig{ValidatorRegistry: common.HexToAddress("0x1000"), ValidatorRegistryBlock: big.NewInt(150)},
+		},
+		{
+			config:  IstanbulConfig{ValidatorWeights: map[common.Address]uint64{common.HexToAddress("0x1000"): 0}},
+			wantErr: errIstanbulWeight,
+		},
+		{
+			// the round times out before the block period is over
+			config:  IstanbulConfig{BlockPeriod: 5, RequestTimeout: 5000},
+			wantErr: errIstanbulTimeout,
+		},
+		{
+			// the timeout of the node is checked by the engine
+			config: IstanbulConfig{BlockPeriod: 15},
+			want:   &IstanbulConfig{BlockPeriod: 15},
+		},
+	}
+	for i, test := range tests {
+		config, err := NewIstanbulConfig(test.config)
+		if err != test.wantErr {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, test.wantErr)
+		}
+		if !reflect.DeepEqual(config, test.want) {
+			t.Errorf("test %d: config mismatch: have %+v, want %+v", i, config, test.want)
+		}
+	}
+}